@@ -0,0 +1,279 @@
+// a1111.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// --- Automatic1111 相容 API ---
+// POST /sdapi/v1/txt2img 接受 stable-diffusion-webui 的請求格式，建立一般的任務並等待完成，
+// 以 base64 回傳圖片 (images)，parameters 為原本的請求，info 為 JSON 字串 (seed、all_seeds、infotexts 等)。
+// batch_size × n_iter 對應 num_images (同一個任務，最多 8 張)；seed 為 -1 時隨機。
+// override_settings 只支援 sd_model_checkpoint (GET /sdapi/v1/sd-models 列出的名稱)，其他設定忽略；
+// sampler、hires fix、restore_faces 等 Z-Image 沒有對應的欄位也忽略。
+// 以 --api-auth 設定帳號密碼的 client 把 API key 當作密碼即可 (Authorization: Basic)
+
+// txt2img 的請求，只列出有使用或需要回傳的欄位
+type a1111Txt2ImgRequest struct {
+	Prompt           string                 `json:"prompt"`
+	NegativePrompt   string                 `json:"negative_prompt"`
+	Seed             *int64                 `json:"seed"` // -1 或沒有設定代表隨機
+	Steps            int                    `json:"steps"`
+	CfgScale         float64                `json:"cfg_scale"`
+	Width            int                    `json:"width"`
+	Height           int                    `json:"height"`
+	BatchSize        int                    `json:"batch_size"`
+	NIter            int                    `json:"n_iter"`
+	SamplerName      string                 `json:"sampler_name,omitempty"` // 不使用，只記錄在 infotexts
+	SendImages       *bool                  `json:"send_images,omitempty"`  // false 時不回傳圖片 (仍會生成並保存)
+	OverrideSettings map[string]interface{} `json:"override_settings,omitempty"`
+}
+
+type a1111Txt2ImgResponse struct {
+	Images     []string        `json:"images"`
+	Parameters json.RawMessage `json:"parameters"`
+	Info       string          `json:"info"`
+}
+
+// info 欄位的內容 (stable-diffusion-webui 的 Processed.js() 中 client 常用的部分)
+type a1111Info struct {
+	Prompt         string   `json:"prompt"`
+	AllPrompts     []string `json:"all_prompts"`
+	NegativePrompt string   `json:"negative_prompt"`
+	Seed           int64    `json:"seed"`
+	AllSeeds       []int64  `json:"all_seeds"`
+	Subseed        int64    `json:"subseed"`
+	Width          int      `json:"width"`
+	Height         int      `json:"height"`
+	SamplerName    string   `json:"sampler_name"`
+	CfgScale       float64  `json:"cfg_scale"`
+	Steps          int      `json:"steps"`
+	BatchSize      int      `json:"batch_size"`
+	SDModelName    string   `json:"sd_model_name"`
+	JobTimestamp   string   `json:"job_timestamp"`
+	Infotexts      []string `json:"infotexts"`
+	TaskID         uint     `json:"task_id"` // 這個伺服器的任務 ID
+}
+
+// GET /sdapi/v1/sd-models 的項目
+type a1111Model struct {
+	Title     string  `json:"title"`
+	ModelName string  `json:"model_name"`
+	Hash      *string `json:"hash"`
+	SHA256    *string `json:"sha256"`
+	Filename  string  `json:"filename"`
+	Config    *string `json:"config"`
+}
+
+// 錯誤格式與 stable-diffusion-webui 的 API 相同
+type a1111Error struct {
+	Error  string `json:"error"`
+	Detail string `json:"detail"`
+	Body   string `json:"body"`
+	Errors string `json:"errors"`
+}
+
+func writeA1111Error(w http.ResponseWriter, status int, errType, detail string) {
+	writeJSON(w, status, a1111Error{Error: errType, Detail: detail, Errors: detail})
+}
+
+func writeA1111TaskError(w http.ResponseWriter, err error) {
+	switch errorCode(err) {
+	case "invalid_request", "not_found", "conflict":
+		writeA1111Error(w, http.StatusUnprocessableEntity, "ValidationError", err.Error())
+	case "prompt_rejected":
+		writeA1111Error(w, http.StatusUnprocessableEntity, "PromptRejected", err.Error())
+	case "quota_exceeded":
+		writeA1111Error(w, http.StatusTooManyRequests, "QuotaExceeded", err.Error())
+	default:
+		writeA1111Error(w, http.StatusInternalServerError, "RuntimeError", err.Error())
+	}
+}
+
+func (req a1111Txt2ImgRequest) task(a *App, identity *Identity) (Task, error) {
+	params := GenerationParams{
+		NegativePrompt: req.NegativePrompt,
+		Width:          req.Width,
+		Height:         req.Height,
+		Steps:          req.Steps,
+		GuidanceScale:  req.CfgScale,
+	}
+	if req.Seed != nil && *req.Seed >= 0 {
+		params.Seed = req.Seed
+	}
+	n := max(req.BatchSize, 1) * max(req.NIter, 1)
+	if n > maxNumImages {
+		return Task{}, invalidField("batch_size", "batch_size × n_iter must not exceed %d", maxNumImages)
+	}
+	params.NumImages = n
+	for key, value := range req.OverrideSettings {
+		if key != "sd_model_checkpoint" {
+			continue
+		}
+		name, ok := value.(string)
+		if !ok {
+			return Task{}, invalidField("override_settings", "sd_model_checkpoint must be a string")
+		}
+		// A1111 的 title 可能帶 [hash]
+		name = strings.TrimSpace(strings.Split(name, " [")[0])
+		if _, err := a.Config.Models.Lookup(name); err != nil {
+			return Task{}, invalidField("override_settings", "unknown sd_model_checkpoint %q", name)
+		}
+		params.Model = name
+	}
+	return Task{
+		Prompt:           strings.TrimSpace(req.Prompt),
+		GenerationParams: params,
+		CreatedBy:        identity.keyName(),
+		UserID:           identity.userID(),
+	}, nil
+}
+
+// A1111 的 infotext：prompt、Negative prompt 與一行以逗號分隔的參數
+func a1111Infotext(task *Task, seed int64, sampler string) string {
+	var b strings.Builder
+	b.WriteString(task.Prompt)
+	if task.NegativePrompt != "" {
+		b.WriteString("\nNegative prompt: " + task.NegativePrompt)
+	}
+	parts := []string{}
+	if task.Steps > 0 {
+		parts = append(parts, fmt.Sprintf("Steps: %d", task.Steps))
+	}
+	if sampler != "" {
+		parts = append(parts, "Sampler: "+sampler)
+	}
+	if task.GuidanceScale > 0 {
+		parts = append(parts, fmt.Sprintf("CFG scale: %g", task.GuidanceScale))
+	}
+	parts = append(parts, fmt.Sprintf("Seed: %d", seed))
+	if task.Width > 0 && task.Height > 0 {
+		parts = append(parts, fmt.Sprintf("Size: %dx%d", task.Width, task.Height))
+	}
+	if task.Model != "" {
+		parts = append(parts, "Model: "+task.Model)
+	}
+	b.WriteString("\n" + strings.Join(parts, ", "))
+	return b.String()
+}
+
+// POST /sdapi/v1/txt2img
+func (a *App) apiA1111Txt2Img(w http.ResponseWriter, r *http.Request) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		writeA1111Error(w, http.StatusUnprocessableEntity, "ValidationError", "invalid JSON body")
+		return
+	}
+	var req a1111Txt2ImgRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		writeA1111Error(w, http.StatusUnprocessableEntity, "ValidationError", err.Error())
+		return
+	}
+	task, err := req.task(a, identityFromRequest(r))
+	if err != nil {
+		writeA1111TaskError(w, err)
+		return
+	}
+	created, err := a.createTask(r.Context(), task)
+	if err != nil {
+		writeA1111TaskError(w, err)
+		return
+	}
+	done, err := a.waitForCaller(r, created.ID)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			writeA1111Error(w, http.StatusGatewayTimeout, "TimeoutError",
+				fmt.Sprintf("task %d did not finish within %s; it keeps running and can be fetched from /api/tasks/%d", created.ID, syncTaskTimeout, created.ID))
+			return
+		}
+		if r.Context().Err() == nil {
+			writeA1111TaskError(w, err)
+		}
+		return
+	}
+	switch {
+	case done.Status == StatusCancelled:
+		writeA1111Error(w, http.StatusInternalServerError, "RuntimeError", fmt.Sprintf("task %d was cancelled", done.ID))
+		return
+	case done.Status != StatusCompleted:
+		msg := done.ErrorMessage
+		if msg == "" {
+			msg = done.FailReason
+		}
+		writeA1111Error(w, http.StatusInternalServerError, "RuntimeError", fmt.Sprintf("task %d failed: %s", done.ID, msg))
+		return
+	case done.Quarantined:
+		writeA1111Error(w, http.StatusUnprocessableEntity, "ContentFiltered", "the generated image was blocked by the content filter")
+		return
+	}
+	if err := a.loadTaskImages(done); err != nil {
+		writeA1111Error(w, http.StatusInternalServerError, "RuntimeError", err.Error())
+		return
+	}
+
+	send := req.SendImages == nil || *req.SendImages
+	resp := a1111Txt2ImgResponse{Images: []string{}, Parameters: raw}
+	info := a1111Info{
+		Prompt:         done.Prompt,
+		NegativePrompt: done.NegativePrompt,
+		Subseed:        -1,
+		Width:          done.Width,
+		Height:         done.Height,
+		SamplerName:    req.SamplerName,
+		CfgScale:       done.GuidanceScale,
+		Steps:          done.Steps,
+		BatchSize:      done.imageCount(),
+		SDModelName:    done.Model,
+		JobTimestamp:   done.CreatedAt.Format("20060102150405"),
+		TaskID:         done.ID,
+	}
+	for index := 0; index < done.imageCount(); index++ {
+		img, ok := done.image(index)
+		if !ok || img.ImagePath == "" {
+			continue
+		}
+		// 生成後端沒有回報時為 -1
+		var seed int64 = -1
+		if img.Seed != nil {
+			seed = *img.Seed
+		} else if index == 0 && done.UsedSeed != nil {
+			seed = *done.UsedSeed
+		}
+		info.AllPrompts = append(info.AllPrompts, done.Prompt)
+		info.AllSeeds = append(info.AllSeeds, seed)
+		info.Infotexts = append(info.Infotexts, a1111Infotext(done, seed, req.SamplerName))
+		if send {
+			content, err := a.imageBase64(r.Context(), done, img.ImagePath)
+			if err != nil {
+				writeA1111Error(w, http.StatusInternalServerError, "RuntimeError", err.Error())
+				return
+			}
+			resp.Images = append(resp.Images, content)
+		}
+	}
+	if len(info.AllSeeds) > 0 {
+		info.Seed = info.AllSeeds[0]
+	}
+	infoJSON, _ := json.Marshal(info)
+	resp.Info = string(infoJSON)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GET /sdapi/v1/sd-models：GET /api/models 的模型，以 A1111 的格式列出
+func (a *App) apiA1111Models(w http.ResponseWriter, r *http.Request) {
+	models, err := a.Config.Models.List()
+	if err != nil {
+		writeA1111Error(w, http.StatusInternalServerError, "RuntimeError", err.Error())
+		return
+	}
+	list := []a1111Model{}
+	for _, m := range models {
+		list = append(list, a1111Model{Title: m.Name, ModelName: m.Name, Filename: m.Name})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
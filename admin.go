@@ -0,0 +1,317 @@
+// admin.go
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- 管理 API (/api/admin/*) ---
+
+// 由管理員強制結束任務時使用的 cause
+var errAdminFailed = errors.New("failed by admin")
+
+// 以環境變數 ADMIN_TOKEN 或 admin 角色的使用者 (見 rbac.go) 保護；
+// 一般使用者的 API key 回 403，兩者都沒有設定時管理 API 停用
+func (a *App) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := tokenFromRequest(r)
+		if a.Config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.Config.AdminToken)) == 1 {
+			next(w, r.WithContext(context.WithValue(r.Context(), adminKey{}, true)))
+			return
+		}
+		if identity, ok := a.resolveIdentity(r); ok {
+			identity, err := a.withUserRole(identity)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if err := identity.checkRole(roleAdmin); err != nil {
+				writeTaskError(w, err)
+				return
+			}
+			// 稽核紀錄的執行者為該使用者
+			ctx := context.WithValue(r.Context(), adminKey{}, true)
+			next(w, r.WithContext(context.WithValue(ctx, identityKey{}, identity)))
+			return
+		}
+		if a.Config.AdminToken == "" {
+			writeError(w, http.StatusForbidden, "admin API is disabled")
+			return
+		}
+		if token != "" {
+			a.audit(r.Context(), "auth.failed", "", "", r.Method+" "+r.URL.Path)
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="mcpzimage-admin"`)
+		writeError(w, http.StatusUnauthorized, "invalid or missing admin token")
+	}
+}
+
+// GET /api/admin/stats
+// 各狀態任務數、排隊中與排程中的任務數、worker 狀態與平均生成時間
+func (a *App) apiAdminStats(w http.ResponseWriter, r *http.Request) {
+	counts, err := a.queueCounts(time.Now())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	stats := map[string]interface{}{
+		"queue":                  a.queueStatus(),
+		"counts":                 counts.Counts,
+		"ready":                  counts.Ready,
+		"scheduled":              counts.Scheduled,
+		"avg_generation_seconds": a.genDurations.average().Seconds(),
+	}
+	if counts.OldestPendingAt != nil {
+		stats["oldest_pending_at"] = counts.OldestPendingAt
+	}
+
+	a.workerStateMutex.Lock()
+	workers := make(map[string]workerState, len(a.workerStates))
+	for id, state := range a.workerStates {
+		workers[strconv.Itoa(id)] = *state
+	}
+	a.workerStateMutex.Unlock()
+	stats["workers"] = workers
+	stats["websocket"] = a.Hub.stats()
+	stats["disk"] = a.checkDisk()
+	if nodes, err := a.workerNodes(); err == nil {
+		stats["nodes"] = nodes
+	}
+	if a.GPUs.enabled() {
+		stats["gpus"], _ = a.GPUs.snapshot()
+	}
+	if lanes := a.laneSnapshot(); len(lanes) > 0 {
+		stats["model_lanes"] = lanes
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// 從資料庫統計的佇列狀態，admin API 與 status 子命令共用
+type queueCounts struct {
+	Counts          map[string]int64 `json:"counts"` // 各狀態任務數
+	Ready           int64            `json:"ready"`  // 可以立即執行的 Pending / Retrying 任務
+	Scheduled       int64            `json:"scheduled"`
+	OldestPendingAt *time.Time       `json:"oldest_pending_at,omitempty"`
+}
+
+func (a *App) queueCounts(now time.Time) (*queueCounts, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	if err := a.DB.Model(&Task{}).Select("status, count(*) as count").Group("status").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	c := &queueCounts{Counts: make(map[string]int64)}
+	for _, row := range rows {
+		c.Counts[row.Status] = row.Count
+	}
+	a.readyTasks(now).Model(&Task{}).Count(&c.Ready)
+	a.DB.Model(&Task{}).Where("status IN ? AND scheduled_at > ?", queuedStatuses, now).Count(&c.Scheduled)
+	var oldest Task
+	if err := a.readyTasks(now).Order("created_at asc").First(&oldest).Error; err == nil {
+		c.OldestPendingAt = &oldest.CreatedAt
+	}
+	return c, nil
+}
+
+// 使用者用量
+type userUsage struct {
+	UserID      uint    `json:"user_id"`
+	Name        string  `json:"name"`
+	Total       int64   `json:"total"`
+	Completed   int64   `json:"completed"`
+	Failed      int64   `json:"failed"`
+	Cancelled   int64   `json:"cancelled"`
+	Pending     int64   `json:"pending"`
+	GPUSeconds  float64 `json:"gpu_seconds"` // 以下為這些任務的用量，每日統計見 /api/admin/usage/daily
+	PixelSteps  int64   `json:"pixel_steps"`
+	WallSeconds float64 `json:"wall_seconds"`
+}
+
+// GET /api/admin/usage?since=2025-01-01T00:00:00Z
+func (a *App) apiAdminUsage(w http.ResponseWriter, r *http.Request) {
+	query := a.DB.Model(&Task{}).Select(`user_id,
+		count(*) as total,
+		sum(case when status = ? then 1 else 0 end) as completed,
+		sum(case when status = ? then 1 else 0 end) as failed,
+		sum(case when status = ? then 1 else 0 end) as cancelled,
+		sum(case when status in ? then 1 else 0 end) as pending,
+		coalesce(sum(gpu_seconds), 0) as gpu_seconds,
+		coalesce(sum(pixel_steps), 0) as pixel_steps,
+		coalesce(sum(wall_seconds), 0) as wall_seconds`,
+		StatusCompleted, StatusFailed, StatusCancelled, []TaskStatus{StatusPending, StatusRetrying, StatusProcessing}).
+		Group("user_id").Order("total desc")
+	if s := r.URL.Query().Get("since"); s != "" {
+		since, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid since")
+			return
+		}
+		query = query.Where("created_at >= ?", since)
+	}
+	var usage []userUsage
+	if err := query.Scan(&usage).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ids := make([]uint, 0, len(usage))
+	for _, u := range usage {
+		ids = append(ids, u.UserID)
+	}
+	var users []User
+	a.DB.Where("id IN ?", ids).Find(&users)
+	names := make(map[uint]string, len(users))
+	for _, u := range users {
+		names[u.ID] = u.Name
+	}
+	for i := range usage {
+		usage[i].Name = names[usage[i].UserID]
+	}
+	if usage == nil {
+		usage = []userUsage{}
+	}
+	writeJSON(w, http.StatusOK, usage)
+}
+
+// POST /api/admin/tasks/recover?older_than=5m
+// 把卡在 Processing、但不在本伺服器執行中的任務放回佇列 (例如 worker panic 或資料庫寫回失敗)。
+// 剛被認領的任務還沒登記到 runningTasks，older_than (預設 1m) 內更新過的任務不處理；
+// 若有其他 process (例如 -mcp stdio) 共用資料庫，請把 older_than 設得比生成時間長
+func (a *App) apiAdminRecoverTasks(w http.ResponseWriter, r *http.Request) {
+	olderThan := time.Minute
+	if s := r.URL.Query().Get("older_than"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, "invalid older_than")
+			return
+		}
+		olderThan = d
+	}
+
+	var stuck []Task
+	// 租約還有效的任務由其他 process 執行中，不處理
+	now := time.Now()
+	err := a.DB.Where("status = ? AND updated_at < ? AND (lease_expires_at IS NULL OR lease_expires_at < ?)", StatusProcessing, now.Add(-olderThan), now).
+		Find(&stuck).Error
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.runningMutex.Lock()
+	var ids []uint
+	for _, task := range stuck {
+		if _, running := a.runningTasks[task.ID]; !running {
+			ids = append(ids, task.ID)
+		}
+	}
+	a.runningMutex.Unlock()
+
+	recovered := []uint{}
+	for _, id := range ids {
+		ok, err := a.transitionTask(r.Context(), id, StatusProcessing, StatusRetrying, "recovered", nil)
+		if err == nil && ok {
+			recovered = append(recovered, id)
+			var task Task
+			if a.DB.First(&task, id).Error == nil {
+				a.publishTask(r.Context(), EventTaskUpdated, task)
+			}
+		}
+	}
+	if len(recovered) > 0 {
+		slog.Warn("Admin requeued stuck tasks", "task_ids", recovered)
+		a.audit(r.Context(), "tasks.recovered", "task", "", fmt.Sprint(recovered))
+		a.signalDispatch(recovered...)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"recovered": recovered})
+}
+
+// POST /api/admin/tasks/{id}/fail，body 可帶 {"reason": "..."}
+// 排隊中的任務直接標記為 Failed；Processing 任務中止生成後由 worker 標記
+func (a *App) apiAdminFailTask(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid task id")
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+	cause := errAdminFailed
+	if req.Reason != "" {
+		cause = fmt.Errorf("%w: %s", errAdminFailed, req.Reason)
+	}
+
+	task, err := a.failTask(uint(id), cause)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		writeError(w, http.StatusNotFound, "task not found")
+	case errors.Is(err, errTaskNotCancellable):
+		writeTaskError(w, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		slog.Warn("Admin failed task", "task_id", task.ID, "reason", cause.Error())
+		a.auditTask(r.Context(), "task.failed", task, cause.Error())
+		writeJSON(w, http.StatusOK, task)
+	}
+}
+
+// 強制結束任務，與 cancelTask 相同的流程，但結果為 Failed
+func (a *App) failTask(id uint, cause error) (*Task, error) {
+	var task Task
+	if err := a.DB.First(&task, id).Error; err != nil {
+		return nil, err
+	}
+	switch task.Status {
+	case StatusPending, StatusRetrying:
+		ok, err := a.transitionTask(context.Background(), task.ID, task.Status, StatusFailed, "admin", map[string]interface{}{"fail_reason": "admin", "error_message": cause.Error()})
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			// 剛好被 worker 認領，改走 Processing 的流程
+			return a.failTask(id, cause)
+		}
+		now := time.Now()
+		task.Status, task.FailReason, task.ErrorMessage, task.FinishedAt = StatusFailed, "admin", cause.Error(), &now
+		a.publishTask(context.Background(), EventTaskFinished, task)
+		a.notifyQueuePositions()
+		return &task, nil
+
+	case StatusProcessing:
+		a.runningMutex.Lock()
+		cancel, ok := a.runningTasks[task.ID]
+		a.runningMutex.Unlock()
+		if !ok {
+			// 不在本伺服器執行 (卡住的任務或在其他 process 執行)，直接標記
+			err := a.markRemoteTask(&task, StatusFailed, "admin", map[string]interface{}{"fail_reason": "admin", "error_message": cause.Error()})
+			if err != nil {
+				return nil, err
+			}
+			a.publishTask(context.Background(), EventTaskFinished, task)
+			return &task, nil
+		}
+		cancel(cause)
+		return &task, nil
+	}
+	return nil, errTaskNotCancellable
+}
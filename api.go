@@ -0,0 +1,363 @@
+// api.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// REST 建立任務的請求格式
+type createTaskRequest struct {
+	Prompt      string     `json:"prompt"`
+	ScheduledAt *time.Time `json:"scheduled_at"` // RFC3339，指定後到時間才執行
+	ExpiresAt   *time.Time `json:"expires_at"`   // RFC3339，到時間還沒開始執行就放棄，預設為 TASK_EXPIRY
+	CallbackURL string     `json:"callback_url"` // 完成或失敗時的 webhook
+	Force       bool       `json:"force"`        // 略過生成結果快取，也可以用 ?force=true
+	Enhance     bool       `json:"enhance"`      // 先以 LLM 擴寫 prompt，見 enhance.go
+	Tags        []string   `json:"tags"`         // 標籤，見 tags.go
+	templateRef            // 以範本產生 prompt (與 prompt 擇一)
+	GenerationParams
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// REST 的錯誤回應，所有 API 格式一致 (見 openapi.go 的 ErrorResponse)：
+// code 供程式判斷，message 給人看，details 為選填的補充資料 (例如參數名稱)
+type apiError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// 帶有錯誤代碼的錯誤，errorCode 直接採用 Code (例如 WebSocket 的 invalid_message)；
+// 含參數的訊息以 newCodedError 建立，才能依語系翻譯 (見 i18n.go)
+type codedError struct {
+	Code    string
+	Message string
+	message message
+}
+
+func newCodedError(code, format string, args ...interface{}) *codedError {
+	return &codedError{Code: code, Message: fmt.Sprintf(format, args...), message: newMessage(format, args...)}
+}
+
+func (e *codedError) Error() string {
+	return e.Message
+}
+
+func (e *codedError) localMessage() message {
+	if e.message.Format == "" {
+		return message{Format: e.Message}
+	}
+	return e.message
+}
+
+// 依 HTTP 狀態碼決定的錯誤代碼
+func statusErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request"
+	case http.StatusUnauthorized:
+		return "unauthorized"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusConflict:
+		return "conflict"
+	case http.StatusRequestEntityTooLarge:
+		return "payload_too_large"
+	case http.StatusUnprocessableEntity:
+		return "unprocessable"
+	case http.StatusTooManyRequests:
+		return "rate_limited"
+	case http.StatusServiceUnavailable:
+		return "unavailable"
+	}
+	return "internal_error"
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{Code: statusErrorCode(status), Message: message{Format: msg}.localize(responseLocale(w))})
+}
+
+// 含參數的錯誤訊息，依語系翻譯
+func writeErrorf(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, apiError{Code: statusErrorCode(status), Message: newMessage(format, args...).localize(responseLocale(w))})
+}
+
+// 建立任務失敗時依錯誤類型回應：參數錯誤 400、不存在 404、狀態不允許 409、內容過大 413、prompt 被拒絕 422、超過配額 429、其他 500
+func writeTaskError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch errorCode(err) {
+	case "invalid_request":
+		status = http.StatusBadRequest
+	case "forbidden":
+		status = http.StatusForbidden
+	case "not_found":
+		status = http.StatusNotFound
+	case "conflict":
+		status = http.StatusConflict
+	case "payload_too_large":
+		status = http.StatusRequestEntityTooLarge
+	case "prompt_rejected":
+		status = http.StatusUnprocessableEntity
+	case "quota_exceeded":
+		status = http.StatusTooManyRequests
+	case "unavailable":
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, apiError{Code: errorCode(err), Message: localizeError(responseLocale(w), err), Details: errorDetails(err)})
+}
+
+// 從路徑 {id} 取出任務並回傳，找不到時直接寫出錯誤
+func (a *App) loadTaskFromPath(w http.ResponseWriter, r *http.Request) (*Task, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid task id")
+		return nil, false
+	}
+	task, err := a.findTask(identityFromRequest(r), uint(id))
+	if err != nil {
+		writeTaskError(w, err)
+		return nil, false
+	}
+	return task, true
+}
+
+// 只能存取自己的任務，別人的任務一律視為不存在 (REST 與 gRPC 共用)
+func (a *App) findTask(identity *Identity, id uint) (*Task, error) {
+	var task Task
+	if err := identity.taskScope(a.DB).First(&task, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &codedError{Code: "not_found", Message: "task not found"}
+		}
+		return nil, err
+	}
+	return &task, nil
+}
+
+// 單一任務的完整資料：佇列位置、時間軸、所有圖片與標籤
+func (a *App) loadTaskDetail(task *Task) error {
+	a.fillQueueInfo(task)
+	timeline, err := a.taskTimeline(task)
+	if err != nil {
+		return err
+	}
+	task.Timeline = timeline
+	if err := a.loadTaskImages(task); err != nil {
+		return err
+	}
+	return a.loadTaskTags(task)
+}
+
+// POST /api/tasks
+func (a *App) apiCreateTask(w http.ResponseWriter, r *http.Request) {
+	var req createTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	req.Prompt = strings.TrimSpace(req.Prompt)
+	if err := a.applyTemplate(identityFromRequest(r), req.templateRef, &req.Prompt, &req.GenerationParams); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	task, err := a.createTask(r.Context(), Task{
+		Prompt:           req.Prompt,
+		GenerationParams: req.GenerationParams,
+		ScheduledAt:      req.ScheduledAt,
+		ExpiresAt:        req.ExpiresAt,
+		CallbackURL:      strings.TrimSpace(req.CallbackURL),
+		CreatedBy:        identityFromRequest(r).keyName(),
+		UserID:           identityFromRequest(r).userID(),
+		Force:            req.Force || r.URL.Query().Get("force") == "true",
+		Enhance:          req.Enhance,
+		Tags:             req.Tags,
+	})
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, task)
+}
+
+// GET /api/tasks?limit=20&tag=a,b&collection=1
+func (a *App) apiListTasks(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		if n > 100 {
+			n = 100
+		}
+		limit = n
+	}
+	tags, collectionID, err := taskFilterFromRequest(r)
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	tasks, err := a.Tasks.ListHistory(r.Context(), TaskHistoryFilter{
+		UserID:       identityFromRequest(r).userID(),
+		WorkspaceID:  identityFromRequest(r).workspaceID(),
+		Tags:         tags,
+		CollectionID: collectionID,
+		Limit:        limit,
+	})
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+// GET /api/tasks/{id}
+func (a *App) apiGetTask(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadTaskFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := a.loadTaskDetail(task); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+// 等待任務時查詢資料庫的間隔 (任務可能由其他 process 的 worker 執行，不依賴 Hub 的通知)
+const (
+	taskWaitPollInterval = 500 * time.Millisecond
+	defaultTaskWait      = 30 * time.Second
+	maxTaskWait          = 5 * time.Minute
+)
+
+// 等到任務結束；ctx 結束時回傳 ctx 的錯誤
+func (a *App) waitForTask(ctx context.Context, id uint) (*Task, error) {
+	ticker := time.NewTicker(taskWaitPollInterval)
+	defer ticker.Stop()
+	for {
+		var task Task
+		if err := a.DB.First(&task, id).Error; err != nil {
+			return nil, err
+		}
+		if task.Status.finished() {
+			return &task, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// GET /api/tasks/{id}/wait?timeout=60s：等到任務結束 (Completed、Failed、Cancelled) 才回應 200 與最後的紀錄；
+// timeout (預設 30s，最多 5m，也接受秒數) 到了仍未結束時回 202 與目前的紀錄，呼叫端可以再次等待。
+// 呼叫端中斷連線不影響任務
+func (a *App) apiWaitTask(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadTaskFromPath(w, r)
+	if !ok {
+		return
+	}
+	timeout := defaultTaskWait
+	if s := r.URL.Query().Get("timeout"); s != "" {
+		d, err := time.ParseDuration(s)
+		if n, convErr := strconv.Atoi(s); convErr == nil {
+			d, err = time.Duration(n)*time.Second, nil
+		}
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, "invalid timeout")
+			return
+		}
+		timeout = min(d, maxTaskWait)
+	}
+	status := http.StatusOK
+	if !task.Status.finished() {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		done, err := a.waitForTask(ctx, task.ID)
+		cancel()
+		switch {
+		case err == nil:
+			task = done
+		case r.Context().Err() != nil:
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			status = http.StatusAccepted
+			if err := a.DB.First(task, task.ID).Error; err != nil {
+				writeTaskError(w, err)
+				return
+			}
+		default:
+			writeTaskError(w, err)
+			return
+		}
+	}
+	if err := a.loadTaskDetail(task); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, status, task)
+}
+
+// DELETE /api/tasks/{id}：移到垃圾桶，?permanent=true 時直接永久刪除
+func (a *App) apiDeleteTask(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadTaskFromPath(w, r)
+	if !ok {
+		return
+	}
+	// 正在執行中的任務不能刪除，否則 worker 完成後會寫回已刪除的資料
+	if task.Status == StatusProcessing {
+		writeTaskError(w, errTaskProcessing)
+		return
+	}
+	var err error
+	if r.URL.Query().Get("permanent") == "true" {
+		err = a.purgeTask(r.Context(), task)
+	} else {
+		err = a.trashTask(r.Context(), task)
+	}
+	if errors.Is(err, errTaskProcessing) {
+		writeTaskError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /api/tasks/{id}/cancel
+func (a *App) apiCancelTask(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadTaskFromPath(w, r)
+	if !ok {
+		return
+	}
+	task, err := a.cancelTask(task.ID, identityFromRequest(r))
+	if err != nil {
+		if errors.Is(err, errTaskNotCancellable) {
+			writeTaskError(w, err)
+		} else {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	a.auditTask(r.Context(), "task.cancelled", task, "")
+	writeJSON(w, http.StatusAccepted, task)
+}
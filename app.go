@@ -0,0 +1,265 @@
+// app.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/asccclass/sherryserver"
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+// --- 應用程式 ---
+
+// 整個服務的狀態，取代原本的 package 全域變數；HTTP handler、worker、MCP 都是 App 的 method
+type App struct {
+	Config     *Config
+	DB         *gorm.DB
+	Hub        *Hub
+	Events     *EventBus    // 任務生命週期事件 (見 eventbus.go)
+	Tasks      *TaskService // 任務的建立、認領與狀態轉換 (見 taskservice.go)
+	Generator  Generator
+	Upscaler   Upscaler // 未設定 UPSCALER 時為 nil
+	Storage    Storage
+	GPUs       *gpuScheduler // 每個 worker 使用的 GPU 與剩餘 VRAM (見 gpu.go)
+	Safety     SafetyChecker // 生成後的內容安全檢查，未設定 SAFETY_CHECK 時為 nil (見 safety.go)
+	Moderation *promptFilter // 建立任務時的禁用詞檢查，未設定 PROMPT_DENYLIST 時為 nil (見 moderation.go)
+	Queue      TaskQueue     // 通知 worker 的佇列，QUEUE_BACKEND=db 時為 nil (見 taskqueue.go)
+
+	// Worker Pool
+	workerCtx        context.Context // 結束代表 worker 不再認領新任務
+	stopWorkers      context.CancelFunc
+	workerWG         sync.WaitGroup
+	dispatch         chan struct{} // 有新任務時通知 worker 立即醒來；buffer 1 讓多次通知合併成一次
+	genDurations     durationStats // 最近的生成時間，用來估算 ETA
+	queuePaused      atomic.Bool   // 暫停時 worker 不認領新任務，與 queueState.Paused 相同
+	queueState       QueueState
+	queueMutex       sync.Mutex
+	diskLow          atomic.Bool // 圖片目錄可用空間不足，佇列已自動暫停 (見 diskguard.go)
+	diskGuardMutex   sync.Mutex
+	runningTasks     map[uint]context.CancelCauseFunc // 執行中任務的 cancel function，取消時用來 kill python process
+	runningMutex     sync.Mutex
+	taskLogs         taskLogStore // 執行中任務的生成後端輸出 (見 tasklogs.go)
+	warmup           warmupState  // 啟動暖機的狀態 (見 warmup.go)
+	workerStates     map[int]*workerState
+	workerStateMutex sync.Mutex
+	workerCancels    map[int]context.CancelFunc // 執行中的 worker，cancel 後做完手上的任務就結束
+	workerStopping   map[int]bool               // 已經 cancel 但還在執行任務的 worker
+	workersStarted   bool
+	workerPoolMutex  sync.Mutex
+	lanes            modelLanes // 每個模型執行中的任務數 (見 modellanes.go)
+	reloadMutex      sync.Mutex // 同一時間只重新載入一次設定 (見 reload.go)
+	stopHeartbeat    func()     // 停止心跳並等待刪除心跳紀錄
+
+	// 驗證與配額
+	envAPIKeys    map[string]*Identity // env 設定的 key (雜湊 -> 呼叫者)，啟動時載入
+	authMutex     sync.RWMutex
+	dbAPIKeys     atomic.Bool  // 資料庫中有 API key，見 hasDBAPIKeys
+	dbAPIKeysAt   atomic.Int64 // 上次查詢資料庫的時間 (UnixNano)
+	userResolvers []userResolver
+	oidc          oidcDiscovery // OIDC 登入服務的端點 (見 oauth.go)
+	quotaMutex    sync.Mutex    // 配額檢查與建立任務需一起完成
+
+	upgrader websocket.Upgrader // CheckOrigin 依 ALLOWED_ORIGINS
+
+	// MCP SSE sessions
+	mcpSessions map[string]chan []byte
+	mcpMutex    sync.Mutex
+}
+
+// 連線資料庫並選擇生成與儲存後端；背景工作要等 Run / RunMCPStdio 才啟動
+func NewApp(cfg *Config) (*App, error) {
+	// 初始化資料庫 (SQLite / PostgreSQL / MySQL)
+	conn, err := openDatabase(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("connect database: %w", err)
+	}
+	// 套用尚未執行的 migration (見 migrations.go)
+	if err := migrateOnStartup(conn); err != nil {
+		return nil, fmt.Errorf("migrate database: %w", err)
+	}
+
+	// 偵測 GPU (nvidia-smi 或 GPU_DEVICES)
+	gpus, err := newGPUScheduler(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("init gpu: %w", err)
+	}
+
+	// 選擇生成後端 (python / http / mock)
+	gen, err := newGenerator(cfg, gpus.deviceIDs())
+	if err != nil {
+		return nil, fmt.Errorf("init generator: %w", err)
+	}
+
+	// 選擇放大後處理 (python / resize)，未設定時不提供
+	upscaler, err := newUpscaler(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("init upscaler: %w", err)
+	}
+
+	// 選擇圖片儲存後端 (local / s3)
+	store, err := newStorageFromEnv(cfg.ImageDir)
+	if err != nil {
+		return nil, fmt.Errorf("init storage: %w", err)
+	}
+
+	// 內容安全檢查 (script / http)，未設定時不檢查
+	safety, err := newSafetyChecker(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("init safety check: %w", err)
+	}
+
+	// prompt 禁用詞清單，未設定時不檢查
+	moderation, err := newPromptFilter(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("init prompt denylist: %w", err)
+	}
+
+	// 任務佇列 (redis)，預設只使用資料庫
+	queue, err := newTaskQueue(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("init task queue: %w", err)
+	}
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	a := &App{
+		Config:         cfg,
+		DB:             conn,
+		Hub:            newHub(cfg.PresenceBroadcast),
+		Events:         newEventBus(),
+		Tasks:          newTaskService(&gormTaskRepository{db: conn, writer: newDBWriter(cfg.DBDriver)}, cfg.WorkerID, cfg.WorkerLease),
+		Generator:      gen,
+		Upscaler:       upscaler,
+		Storage:        store,
+		GPUs:           gpus,
+		Safety:         safety,
+		Moderation:     moderation,
+		Queue:          queue,
+		workerCtx:      workerCtx,
+		stopWorkers:    stopWorkers,
+		dispatch:       make(chan struct{}, 1),
+		runningTasks:   make(map[uint]context.CancelCauseFunc),
+		workerStates:   make(map[int]*workerState),
+		workerCancels:  make(map[int]context.CancelFunc),
+		lanes:          modelLanes{running: make(map[string]int), waiting: make(map[string][]uint)},
+		workerStopping: make(map[int]bool),
+		envAPIKeys:     make(map[string]*Identity),
+		mcpSessions:    make(map[string]chan []byte),
+		upgrader:       websocket.Upgrader{CheckOrigin: cfg.CORS.checkOrigin},
+		stopHeartbeat:  func() {},
+	}
+	a.subscribeTaskEvents()
+	// 瀏覽器登入的 session cookie (見 oauth.go)
+	if cfg.Login.enabled() {
+		a.registerUserResolver(a.sessionIdentity)
+	}
+	return a, nil
+}
+
+// 啟動 worker 與 WebSocket 廣播
+func (a *App) startBackground() {
+	// 常駐的生成後端先開始載入模型，失敗時第一個任務會再試一次
+	if g, ok := a.Generator.(lifecycleGenerator); ok && a.Config.WorkerCount > 0 {
+		if err := g.Start(); err != nil {
+			slog.Error("Failed to start generator", "error", err)
+		}
+	}
+	if a.Config.StartPaused {
+		now := time.Now()
+		a.queueState = QueueState{Paused: true, Reason: "QUEUE_PAUSED", PausedAt: &now}
+		a.queuePaused.Store(true)
+		slog.Warn("Queue paused at startup (QUEUE_PAUSED)")
+	}
+	// 心跳與租約 (見 distributed.go)，沒有 worker 時也負責回收其他 process 過期的任務
+	a.startHeartbeat()
+	// 啟動背景 Worker Pool (處理佇列)；WARMUP=true 時等暖機完成才開始認領任務
+	a.startWorkersAfterWarmup(a.Config.WorkerCount)
+
+	// 啟動 WebSocket 廣播監聽器
+	go a.Hub.run()
+}
+
+// MCP stdio 模式：不開 Web Server，只執行背景 worker 與 MCP 協定
+func (a *App) RunMCPStdio() {
+	// stdio 模式可能與 Web Server 共用資料庫，不處理 Processing 的任務，也不做清理
+	a.startBackground()
+	a.startMCPStdio()
+	a.shutdownWorkers(a.Config.ShutdownTimeout)
+	a.stopGenerator()
+}
+
+// 停止常駐的生成後端並關閉佇列連線 (worker 都已結束)
+func (a *App) stopGenerator() {
+	if g, ok := a.Generator.(lifecycleGenerator); ok {
+		g.Close()
+	}
+	if a.Queue != nil {
+		a.Queue.Close()
+	}
+}
+
+// 啟動 Web Server，收到 SIGINT / SIGTERM 時優雅關機，SIGHUP 時重新載入設定
+func (a *App) Run() error {
+	if a.Config.WorkerCount > 0 {
+		// 上次中斷時卡在 Processing 的任務放回佇列
+		a.recoverOrphanedTasks()
+	} else {
+		// 任務由其他 process 的 worker 處理，Processing 的任務可能正在執行，不能放回佇列
+		go a.watchRemoteUpdates(a.workerCtx)
+	}
+	a.startBackground()
+	// 定期清理過期的任務與圖片，以及垃圾桶中超過保留天數的項目
+	a.startJanitor(a.workerCtx)
+	a.startWatchdog()
+	a.startTrashPurge(a.workerCtx)
+	// TELEGRAM_BOT_TOKEN 設定時接收 /imagine 指令
+	a.startTelegram(a.workerCtx)
+
+	server, err := SherryServer.NewServer(":"+a.Config.Port, a.Config.DocumentRoot, a.Config.TemplateRoot)
+	if err != nil {
+		return err
+	}
+	router := a.NewRouter(server, a.Config.DocumentRoot)
+	if router == nil {
+		return fmt.Errorf("router return nil")
+	}
+	a.Config.CORS.logMode()
+	server.Server.Handler = withBasePath(a.Config.BasePath,
+		withRequestID(withLocale(a.Config.DefaultLocale, withClientIP(a.Config.TrustProxy, withExternalURL(a.Config, withCORS(a.Config.CORS, router))))))
+	// SSE 連線是一般的 HTTP 請求，Shutdown 會等它結束，所以一開始關機就要中斷
+	server.Server.RegisterOnShutdown(a.Hub.closeAll)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// SIGHUP 時重新載入可以即時套用的設定 (見 reload.go)
+	a.watchReloadSignal(ctx)
+	stopRedirect := a.listen(server.Server, server.Start)
+	// gRPC 使用與 HTTPS 相同的憑證 (由 listen 設定)
+	stopGRPC := a.startGRPC(server.Server.TLSConfig)
+	<-ctx.Done()
+	slog.Info("Shutting down...")
+	stopRedirect()
+
+	// 1. 停止接受新的 HTTP 請求
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Server.Shutdown(shutdownCtx); err != nil && err != http.ErrServerClosed {
+		slog.Error("HTTP server shutdown error", "error", err)
+	}
+	// 2. WebSocket 連線已被 hijack，不受 Shutdown 影響，需自行關閉
+	a.Hub.closeAll()
+	stopGRPC()
+	// 3. 等待執行中的生成完成
+	a.shutdownWorkers(a.Config.ShutdownTimeout)
+	a.stopGenerator()
+	return nil
+}
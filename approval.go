@@ -0,0 +1,168 @@
+// approval.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// --- 任務審核 (APPROVAL_MODE) ---
+// 公開展示用的部署可以要求管理員先看過 prompt 才花 GPU 時間：
+//   off (預設)：建立後直接排隊
+//   all：所有新任務先進入 AwaitingApproval
+//   flagged：只有符合禁用詞 (PROMPT_DENYLIST_MODE=flag 標記的 prompt_flags) 的任務需要審核
+// worker 只認領 Pending 任務，AwaitingApproval 不會被執行；核准後改為 Pending，依建立時間排入佇列，
+// 拒絕則標記為 Failed (fail_reason 為 rejected)。審核前使用者仍可取消，排隊數量也計入 MAX_QUEUED_PER_USER。
+// 快取命中的任務 (沿用已完成的圖片) 不需要審核
+
+func (c *Config) approvalProblems() []string {
+	switch c.ApprovalMode {
+	case "off":
+		return nil
+	case "all":
+	case "flagged":
+		if c.PromptDenylist == "" || c.PromptDenylistMode != "flag" {
+			return []string{"APPROVAL_MODE=flagged requires PROMPT_DENYLIST with PROMPT_DENYLIST_MODE=flag"}
+		}
+	default:
+		return []string{fmt.Sprintf("APPROVAL_MODE=%q: must be off, all or flagged", c.ApprovalMode)}
+	}
+	if c.AdminToken == "" {
+		return []string{"APPROVAL_MODE requires ADMIN_TOKEN to approve tasks"}
+	}
+	return nil
+}
+
+// 建立任務時呼叫 (已完成 prompt 審查)
+func (a *App) needsApproval(task *Task) bool {
+	switch a.Config.ApprovalMode {
+	case "all":
+		return true
+	case "flagged":
+		return task.PromptFlags != ""
+	}
+	return false
+}
+
+var errNotAwaitingApproval = &codedError{Code: "conflict", Message: "task is not awaiting approval"}
+
+// 核准：改為 Pending 並通知 worker；排程時間未到的任務照原排程執行
+func (a *App) approveTask(ctx context.Context, id uint) (*Task, error) {
+	var task Task
+	if err := a.DB.First(&task, id).Error; err != nil {
+		return nil, err
+	}
+	if task.Status != StatusAwaitingApproval {
+		return nil, errNotAwaitingApproval
+	}
+	now := time.Now()
+	queuedAt := now
+	if task.ScheduledAt != nil && task.ScheduledAt.After(now) {
+		queuedAt = *task.ScheduledAt
+	}
+	ok, err := a.transitionTask(ctx, task.ID, StatusAwaitingApproval, StatusPending, "approved", map[string]interface{}{"queued_at": queuedAt})
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// 同時被取消或由其他管理員處理
+		return nil, errNotAwaitingApproval
+	}
+	task.Status, task.QueuedAt, task.UpdatedAt = StatusPending, &queuedAt, now
+	a.fillQueueInfo(&task)
+	a.publishTask(ctx, EventTaskUpdated, task)
+	if task.ScheduledAt == nil || !task.ScheduledAt.After(now) {
+		a.signalDispatch(task.ID)
+		a.notifyQueuePositions()
+	}
+	return &task, nil
+}
+
+// 拒絕：標記為 Failed，與其他失敗一樣送出 webhook
+func (a *App) rejectTask(ctx context.Context, id uint, reason string) (*Task, error) {
+	var task Task
+	if err := a.DB.First(&task, id).Error; err != nil {
+		return nil, err
+	}
+	if task.Status != StatusAwaitingApproval {
+		return nil, errNotAwaitingApproval
+	}
+	message := "rejected by moderator"
+	if reason != "" {
+		message = truncateHead(message+": "+reason, maxErrorMessageLen)
+	}
+	ok, err := a.transitionTask(ctx, task.ID, StatusAwaitingApproval, StatusFailed, "rejected", map[string]interface{}{"fail_reason": "rejected", "error_message": message})
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errNotAwaitingApproval
+	}
+	now := time.Now()
+	task.Status, task.FailReason, task.ErrorMessage, task.FinishedAt, task.UpdatedAt = StatusFailed, "rejected", message, &now, now
+	a.publishTask(ctx, EventTaskFinished, task)
+	return &task, nil
+}
+
+// --- 管理 API ---
+
+// GET /api/admin/approvals?limit=50
+// 等待審核的任務，最舊的在前
+func (a *App) apiAdminListApprovals(w http.ResponseWriter, r *http.Request) {
+	limit, ok := intParam(r, "limit", 50)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid limit")
+		return
+	}
+	tasks := []Task{}
+	if err := a.DB.Where("status = ?", StatusAwaitingApproval).Order("created_at asc, id asc").Limit(min(limit, 200)).Find(&tasks).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+// POST /api/admin/tasks/{id}/approve
+func (a *App) apiAdminApproveTask(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid task id")
+		return
+	}
+	task, err := a.approveTask(r.Context(), uint(id))
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	loggerFrom(r.Context()).Info("Task approved", "task_id", task.ID, "user", task.CreatedBy)
+	a.auditTask(r.Context(), "task.approved", task, "")
+	writeJSON(w, http.StatusOK, task)
+}
+
+// POST /api/admin/tasks/{id}/reject，body 可帶 {"reason": "..."} (會顯示給使用者)
+func (a *App) apiAdminRejectTask(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid task id")
+		return
+	}
+	var req reasonRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+	task, err := a.rejectTask(r.Context(), uint(id), req.Reason)
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	loggerFrom(r.Context()).Info("Task rejected", "task_id", task.ID, "user", task.CreatedBy, "reason", req.Reason)
+	a.auditTask(r.Context(), "task.rejected", task, req.Reason)
+	writeJSON(w, http.StatusOK, task)
+}
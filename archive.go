@@ -0,0 +1,191 @@
+// archive.go
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// --- 打包下載選取的圖片 ---
+// POST /api/images/archive 帶任務 ID 列表，回傳 zip：每張圖片一個檔案加上同名的中繼資料 JSON，
+// 檔名為 <任務 ID>_<prompt 開頭>.png (多張輸出的任務為 <任務 ID>_<prompt 開頭>_<index>.png，副檔名依任務的 format)。
+// 與 /api/export 不同，這是給人看的，不能再匯入
+
+// 一次最多打包的任務數
+const maxArchiveTasks = 500
+
+type archiveRequest struct {
+	TaskIDs  []uint `json:"task_ids"`
+	Upscaled bool   `json:"upscaled,omitempty"` // 有放大版時用放大版
+}
+
+// POST /api/images/archive
+func (a *App) apiImageArchive(w http.ResponseWriter, r *http.Request) {
+	var req archiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if len(req.TaskIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "task_ids is required")
+		return
+	}
+	if len(req.TaskIDs) > maxArchiveTasks {
+		writeErrorf(w, http.StatusBadRequest, "at most %d tasks per archive", maxArchiveTasks)
+		return
+	}
+	identity := identityFromRequest(r)
+	var tasks []Task
+	if err := identity.taskScope(a.DB).Where("id IN ?", req.TaskIDs).Find(&tasks).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	byID := make(map[uint]*Task, len(tasks))
+	for i := range tasks {
+		byID[tasks[i].ID] = &tasks[i]
+	}
+	// 依要求的順序，重複的 ID 只放一次
+	ordered := make([]*Task, 0, len(tasks))
+	for _, id := range req.TaskIDs {
+		task, ok := byID[id]
+		if !ok {
+			writeErrorf(w, http.StatusNotFound, "task %d not found", id)
+			return
+		}
+		if task != nil {
+			ordered = append(ordered, task)
+			byID[id] = nil
+		}
+	}
+	if err := a.loadTaskImages(ordered...); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// 還沒有圖片 (未完成) 或被隔離的任務略過，以 header 告知
+	var skipped []string
+	included := ordered[:0]
+	for _, task := range ordered {
+		if task.ImagePath == "" || task.Quarantined {
+			skipped = append(skipped, strconv.FormatUint(uint64(task.ID), 10))
+			continue
+		}
+		included = append(included, task)
+	}
+	if len(included) == 0 {
+		writeError(w, http.StatusNotFound, "no images available for the selected tasks")
+		return
+	}
+
+	name := fmt.Sprintf("mcpzimage-images-%s-%s.zip", identity.keyName(), time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	if len(skipped) > 0 {
+		w.Header().Set("X-Archive-Skipped", strings.Join(skipped, ","))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	// 已經開始傳送，之後的錯誤只能記 log
+	logger := loggerFrom(r.Context())
+	zw := zip.NewWriter(w)
+	files := 0
+	for _, task := range included {
+		n, err := a.archiveTask(r, zw, task, req.Upscaled)
+		if err != nil {
+			logger.Error("Archive error", "task_id", task.ID, "error", err)
+			return
+		}
+		files += n
+	}
+	if err := zw.Close(); err != nil {
+		logger.Error("Archive error", "error", err)
+		return
+	}
+	logger.Info("Archive finished", "user", identity.keyName(), "tasks", len(included), "images", files, "skipped", len(skipped))
+}
+
+// 寫入任務的每張圖片與中繼資料，回傳寫入的圖片數；儲存後端找不到的檔案略過
+func (a *App) archiveTask(r *http.Request, zw *zip.Writer, task *Task, upscaled bool) (int, error) {
+	base := archiveBaseName(task)
+	count := max(len(task.Images), 1)
+	written := 0
+	for index := 0; index < count; index++ {
+		img, ok := task.image(index)
+		if !ok {
+			continue
+		}
+		key := img.ImagePath
+		if upscaled && img.UpscaledPath != "" {
+			key = img.UpscaledPath
+		}
+		name := base
+		if count > 1 {
+			name = fmt.Sprintf("%s_%d", base, index)
+		}
+		src, modTime, err := a.storageFor(task).Open(r.Context(), key)
+		if err != nil {
+			continue
+		}
+		// PNG / JPEG 已經壓縮過，不再壓縮
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name + filepath.Ext(key), Method: zip.Store, Modified: modTime})
+		if err == nil {
+			_, err = io.Copy(f, src)
+		}
+		src.Close()
+		if err != nil {
+			return written, err
+		}
+		written++
+
+		// 與生成時寫在圖片旁的 JSON 相同 (多張輸出的任務記錄該張的 seed)
+		meta := metadataFromTask(task, task.FinishedAt)
+		meta.Image = img.ImagePath
+		if count > 1 {
+			meta.Seed, meta.NumImages = img.Seed, 0
+		}
+		data, err := json.MarshalIndent(meta, "", "  ")
+		if err != nil {
+			return written, err
+		}
+		f, err = zw.CreateHeader(&zip.FileHeader{Name: name + ".json", Method: zip.Deflate, Modified: modTime})
+		if err != nil {
+			return written, err
+		}
+		if _, err := f.Write(data); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// 檔名 (不含副檔名)：任務 ID 加上 prompt 開頭的文字，只保留字母與數字，其餘以 - 連接
+func archiveBaseName(task *Task) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range task.Prompt {
+		if b.Len() >= 40 {
+			break
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return fmt.Sprintf("%d", task.ID)
+	}
+	return fmt.Sprintf("%d_%s", task.ID, b.String())
+}
@@ -0,0 +1,144 @@
+// audit.go
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- 稽核紀錄 ---
+// 重要操作 (建立、取消、刪除任務，登入與驗證失敗，管理操作，自動清理) 各記錄一筆 AuditEvent，
+// 包含執行者、來源 IP 與時間，管理員以 GET /api/admin/audit 查詢。
+// 執行者：API key 名稱、admin (ADMIN_TOKEN)、anonymous (未啟用驗證) 或 system (背景工作與 CLI)
+
+type AuditEvent struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Action     string    `gorm:"index;size:64" json:"action"` // 例如 task.created、queue.paused
+	Actor      string    `gorm:"index" json:"actor"`
+	UserID     uint      `gorm:"index" json:"user_id,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	TargetType string    `json:"target_type,omitempty"` // task、queue、api_key …
+	TargetID   string    `gorm:"index" json:"target_id,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
+
+type clientIPKey struct{}
+type adminKey struct{}
+
+// 把來源 IP 放進 request context；TRUST_PROXY=true 時採用 X-Forwarded-For 的第一個位址 (前面有反向代理時)
+func withClientIP(trustProxy bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if trustProxy {
+			if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+				first, _, _ := strings.Cut(forwarded, ",")
+				ip = strings.TrimSpace(first)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	})
+}
+
+// 從 context 判斷執行者
+func auditActor(ctx context.Context) (actor string, userID uint, ip string) {
+	ip, _ = ctx.Value(clientIPKey{}).(string)
+	if identity, ok := ctx.Value(identityKey{}).(*Identity); ok && identity != nil {
+		return identity.KeyName, identity.UserID, ip
+	}
+	if admin, _ := ctx.Value(adminKey{}).(bool); admin {
+		return "admin", 0, ip
+	}
+	if ip != "" {
+		return "anonymous", 0, ip
+	}
+	return "system", 0, ip
+}
+
+// 記錄一筆稽核紀錄；寫入失敗只記 log，不影響原本的操作
+func (a *App) audit(ctx context.Context, action, targetType, targetID, detail string) {
+	actor, userID, ip := auditActor(ctx)
+	event := AuditEvent{Action: action, Actor: actor, UserID: userID, IP: ip, TargetType: targetType, TargetID: targetID, Detail: truncateHead(detail, 500)}
+	if err := a.DB.Create(&event).Error; err != nil {
+		loggerFrom(ctx).Warn("Record audit event error", "action", action, "error", err)
+	}
+}
+
+// 以任務為對象的稽核紀錄
+func (a *App) auditTask(ctx context.Context, action string, task *Task, detail string) {
+	a.audit(ctx, action, "task", strconv.FormatUint(uint64(task.ID), 10), detail)
+}
+
+// GET /api/admin/audit?action=task.created&actor=alice&user_id=1&target_type=task&target_id=12&from=2025-01-01&to=2025-02-01&page=1&page_size=100
+// action 結尾為 . 時以前綴比對 (例如 task.)
+func (a *App) apiAdminAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page, ok := intParam(r, "page", 1)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid page")
+		return
+	}
+	pageSize, ok := intParam(r, "page_size", 100)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid page_size")
+		return
+	}
+	if pageSize > 1000 {
+		pageSize = 1000
+	}
+
+	q := a.DB.Model(&AuditEvent{})
+	if action := query.Get("action"); strings.HasSuffix(action, ".") {
+		q = q.Where("action LIKE ?", action+"%")
+	} else if action != "" {
+		q = q.Where("action = ?", action)
+	}
+	for _, field := range []string{"actor", "ip", "target_type", "target_id"} {
+		if v := query.Get(field); v != "" {
+			q = q.Where(field+" = ?", v)
+		}
+	}
+	if s := query.Get("user_id"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid user_id")
+			return
+		}
+		q = q.Where("user_id = ?", id)
+	}
+	if s := query.Get("from"); s != "" {
+		from, err := parseDateParam(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid from date")
+			return
+		}
+		q = q.Where("created_at >= ?", from)
+	}
+	if s := query.Get("to"); s != "" {
+		to, err := parseDateParam(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid to date")
+			return
+		}
+		q = q.Where("created_at < ?", to)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var events []AuditEvent
+	if err := q.Order("id desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&events).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, pageResponse{Items: events, Page: page, PageSize: pageSize, Total: total})
+}
@@ -0,0 +1,213 @@
+// auth.go
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// --- API Key 驗證 ---
+
+// 資料庫中的 API key，只存 SHA-256 雜湊
+type APIKey struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"uniqueIndex" json:"name"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	KeyHash   string    `gorm:"uniqueIndex" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 通過驗證的呼叫者
+type Identity struct {
+	KeyName       string
+	UserID        uint
+	WorkspaceID   uint   // 以 X-Workspace 選擇的工作區，0 代表個人 (見 workspace.go)
+	WorkspaceRole string // 在工作區中的角色
+	Role          string // 使用者的角色 (見 rbac.go)，每個請求從資料庫讀取
+	Locale        string // 使用者設定的語系 (見 i18n.go)，空字串代表依 Accept-Language
+}
+
+type identityKey struct{}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// 從環境變數 API_KEYS 載入，格式：name1:key1;name2:key2
+// 每個 key 名稱對應一個同名使用者，不存在時自動建立
+func (a *App) loadAPIKeys() error {
+	keys := make(map[string]*Identity)
+	for _, entry := range strings.Split(os.Getenv("API_KEYS"), ";") {
+		name, key, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || name == "" || key == "" {
+			continue
+		}
+		user, err := a.findOrCreateUser(name)
+		if err != nil {
+			return err
+		}
+		keys[hashAPIKey(key)] = &Identity{KeyName: name, UserID: user.ID}
+	}
+	a.authMutex.Lock()
+	a.envAPIKeys = keys
+	a.authMutex.Unlock()
+	a.refreshDBAPIKeys()
+	return nil
+}
+
+// 還沒有 API key 時重新查詢資料庫的間隔，讓另一個 process 以 -new-key 建立的 key 生效
+const apiKeyRecheckInterval = 10 * time.Second
+
+// 資料庫中是否有 API key：結果記在 dbAPIKeys，建立 key 時更新，不必每個請求都查詢資料庫；
+// 還沒有 key 時最多每 apiKeyRecheckInterval 查詢一次
+func (a *App) hasDBAPIKeys() bool {
+	if a.dbAPIKeys.Load() {
+		return true
+	}
+	now := time.Now().UnixNano()
+	last := a.dbAPIKeysAt.Load()
+	if now-last < int64(apiKeyRecheckInterval) || !a.dbAPIKeysAt.CompareAndSwap(last, now) {
+		return false
+	}
+	a.refreshDBAPIKeys()
+	return a.dbAPIKeys.Load()
+}
+
+// 重新查詢資料庫中的 API key 數量，查詢失敗時保留原本的結果
+func (a *App) refreshDBAPIKeys() {
+	a.dbAPIKeysAt.Store(time.Now().UnixNano())
+	var count int64
+	if err := a.DB.Model(&APIKey{}).Count(&count).Error; err != nil {
+		slog.Warn("Count API keys error", "error", err)
+		return
+	}
+	a.dbAPIKeys.Store(count > 0)
+}
+
+// 沒有設定任何 key 也沒有設定登入服務時不啟用驗證 (開發模式)
+func (a *App) authEnabled() bool {
+	if a.Config.Login.enabled() {
+		return true
+	}
+	a.authMutex.RLock()
+	n := len(a.envAPIKeys)
+	a.authMutex.RUnlock()
+	if n > 0 {
+		return true
+	}
+	return a.hasDBAPIKeys()
+}
+
+// 建立新的 API key 並綁定同名使用者，回傳明碼 (只會顯示這一次)
+func (a *App) createAPIKey(name string) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	user, err := a.findOrCreateUser(name)
+	if err != nil {
+		return "", err
+	}
+	key := "zk_" + hex.EncodeToString(b)
+	if err := a.DB.Create(&APIKey{Name: name, UserID: user.ID, KeyHash: hashAPIKey(key)}).Error; err != nil {
+		return "", err
+	}
+	a.dbAPIKeys.Store(true)
+	return key, nil
+}
+
+// 依序從 Authorization: Bearer、X-API-Key、?token= 取得 key
+// (瀏覽器的 WebSocket 無法自訂 header，只能用 query string)；
+// Authorization: Basic 時以密碼為 key，使用者名稱不檢查 (A1111 的 --api-auth client，見 a1111.go)
+func tokenFromRequest(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("token")
+}
+
+func (a *App) lookupAPIKey(key string) (*Identity, bool) {
+	if key == "" {
+		return nil, false
+	}
+	hash := hashAPIKey(key)
+	a.authMutex.RLock()
+	identity, ok := a.envAPIKeys[hash]
+	a.authMutex.RUnlock()
+	if ok {
+		return identity, true
+	}
+	var apiKey APIKey
+	if err := a.DB.Where("key_hash = ?", hash).First(&apiKey).Error; err != nil {
+		return nil, false
+	}
+	return &Identity{KeyName: apiKey.Name, UserID: apiKey.UserID}, true
+}
+
+// 驗證 middleware，通過後把 Identity 放進 request context
+func (a *App) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.authEnabled() {
+			if workspaceFromRequest(r) != "" {
+				writeTaskError(w, invalidField("workspace", "workspaces require authentication"))
+				return
+			}
+			next(w, r)
+			return
+		}
+		identity, ok := a.resolveIdentity(r)
+		if !ok {
+			// 沒帶 key 的請求 (例如尚未設定 key 的網頁) 不記錄
+			if tokenFromRequest(r) != "" {
+				a.audit(r.Context(), "auth.failed", "", "", r.Method+" "+r.URL.Path)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mcpzimage"`)
+			writeError(w, http.StatusUnauthorized, "invalid or missing API key")
+			return
+		}
+		identity, err := a.withUserRole(identity)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if identity.Locale != "" {
+			r = withRequestLocale(w, r, identity.Locale)
+		}
+		identity, err = a.selectWorkspace(identity, workspaceFromRequest(r))
+		if err != nil {
+			writeTaskError(w, err)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)))
+	}
+}
+
+// 取得呼叫者，未啟用驗證時為 nil
+func identityFromRequest(r *http.Request) *Identity {
+	return identityFromContext(r.Context())
+}
+
+func identityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+func (a *App) logAuthMode() {
+	if !a.authEnabled() {
+		slog.Warn("no API keys configured, authentication is disabled")
+	}
+}
@@ -0,0 +1,179 @@
+// batch.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- 批次任務 ---
+
+// 一次送出多筆任務時建立的批次，成員以 Task.BatchID 連結
+type Batch struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	CreatedBy string    `json:"created_by"`
+	Size      int       `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 單一批次最多任務數
+const maxBatchSize = 100
+
+// 批次建立請求：prompts 陣列，或單一 prompt 加上 count 產生多個變化
+// (有指定 seed 時依序遞增，否則每張隨機)
+type batchRequest struct {
+	Prompts     []string   `json:"prompts"`
+	Prompt      string     `json:"prompt"`
+	Count       int        `json:"count"`
+	ScheduledAt *time.Time `json:"scheduled_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	CallbackURL string     `json:"callback_url"` // 每個任務結束時各通知一次
+	Tags        []string   `json:"tags"`         // 每個任務都加上的標籤
+	Force       bool       `json:"force"`        // 略過生成結果快取
+	Enhance     bool       `json:"enhance"`      // 先以 LLM 擴寫 prompt (相同的 prompt 只擴寫一次)
+	GenerationParams
+}
+
+// 建立批次的回應
+type batchCreated struct {
+	Batch *Batch `json:"batch"`
+	Tasks []Task `json:"tasks"`
+}
+
+// 批次狀態摘要
+type batchStatus struct {
+	Batch     Batch          `json:"batch"`
+	Total     int            `json:"total"`
+	Counts    map[string]int `json:"counts"`   // 各狀態的任務數
+	Finished  int            `json:"finished"` // 已結束 (Completed/Failed/Cancelled)
+	Percent   int            `json:"percent"`
+	Completed bool           `json:"completed"` // 所有任務都已結束
+	Tasks     []Task         `json:"tasks"`
+}
+
+// 把批次請求展開成多筆任務
+func (req batchRequest) expand(identity *Identity) ([]Task, error) {
+	var prompts []string
+	var seeds []*int64
+	if len(req.Prompts) > 0 {
+		for _, p := range req.Prompts {
+			prompts = append(prompts, strings.TrimSpace(p))
+			seeds = append(seeds, req.Seed)
+		}
+	} else {
+		if req.Count <= 0 {
+			return nil, invalidf("prompts or count is required")
+		}
+		for i := 0; i < req.Count; i++ {
+			prompts = append(prompts, strings.TrimSpace(req.Prompt))
+			if req.Seed != nil {
+				seed := *req.Seed + int64(i)
+				seeds = append(seeds, &seed)
+			} else {
+				seeds = append(seeds, nil)
+			}
+		}
+	}
+	if len(prompts) > maxBatchSize {
+		return nil, invalidf("batch size must not exceed %d", maxBatchSize)
+	}
+
+	tasks := make([]Task, len(prompts))
+	for i, prompt := range prompts {
+		params := req.GenerationParams
+		params.Seed = seeds[i]
+		tasks[i] = Task{
+			Prompt:           prompt,
+			GenerationParams: params,
+			ScheduledAt:      req.ScheduledAt,
+			ExpiresAt:        req.ExpiresAt,
+			CallbackURL:      strings.TrimSpace(req.CallbackURL),
+			CreatedBy:        identity.keyName(),
+			UserID:           identity.userID(),
+			Force:            req.Force,
+			Enhance:          req.Enhance,
+			Tags:             req.Tags,
+		}
+	}
+	return tasks, nil
+}
+
+// 建立批次與所有成員任務
+func (a *App) createBatch(ctx context.Context, req batchRequest, identity *Identity) (*Batch, []Task, error) {
+	tasks, err := req.expand(identity)
+	if err != nil {
+		return nil, nil, err
+	}
+	batch := &Batch{UserID: identity.userID(), CreatedBy: identity.keyName(), Size: len(tasks)}
+	tasks, err = a.createTasks(ctx, tasks, batch)
+	if err != nil {
+		return nil, nil, err
+	}
+	return batch, tasks, nil
+}
+
+func (a *App) loadBatchStatus(id uint, identity *Identity) (*batchStatus, error) {
+	var batch Batch
+	if err := identity.scope(a.DB).First(&batch, id).Error; err != nil {
+		return nil, err
+	}
+	status := &batchStatus{Batch: batch, Counts: make(map[string]int)}
+	if err := a.DB.Where("batch_id = ?", batch.ID).Order("id asc").Find(&status.Tasks).Error; err != nil {
+		return nil, err
+	}
+	status.Total = len(status.Tasks)
+	for _, task := range status.Tasks {
+		status.Counts[string(task.Status)]++
+		if task.Status.finished() {
+			status.Finished++
+		}
+	}
+	if status.Total > 0 {
+		status.Percent = status.Finished * 100 / status.Total
+	}
+	status.Completed = status.Finished == status.Total
+	return status, nil
+}
+
+// POST /api/tasks/batch
+func (a *App) apiCreateBatch(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	req.Force = req.Force || r.URL.Query().Get("force") == "true"
+	batch, tasks, err := a.createBatch(r.Context(), req, identityFromRequest(r))
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, batchCreated{Batch: batch, Tasks: tasks})
+}
+
+// GET /api/batches/{id}
+func (a *App) apiGetBatch(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid batch id")
+		return
+	}
+	status, err := a.loadBatchStatus(uint(id), identityFromRequest(r))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, "batch not found")
+		} else {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,133 @@
+// cache.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// --- 生成結果快取 ---
+// 相同的 (prompt, 參數, 模型, seed) 會產生相同的圖片，已經有完成的任務時直接沿用它的圖片，
+// 新任務建立後立刻是 Completed，不佔用 GPU。請求帶 force=true 時一律重新生成。
+// 沒有指定 seed (隨機) 與 img2img 的任務不會命中快取
+
+// RESULT_CACHE：off (預設)、user (只沿用自己的任務)、global (所有使用者共用)；回傳空字串代表關閉
+func resultCacheMode() string {
+	switch strings.ToLower(os.Getenv("RESULT_CACHE")) {
+	case "user", "true":
+		return "user"
+	case "global":
+		return "global"
+	}
+	return ""
+}
+
+// 快取鍵：prompt 與所有生成參數的 SHA-256；無法重現的任務回傳空字串
+func resultCacheKey(task *Task) string {
+	if task.Seed == nil || task.InitImagePath != "" || task.MaskPath != "" || task.ControlImagePath != "" {
+		return ""
+	}
+	data, _ := json.Marshal(struct {
+		Prompt string           `json:"prompt"`
+		Params GenerationParams `json:"params"`
+	}{task.Prompt, task.GenerationParams})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// 建立任務前呼叫：一律記錄快取鍵 (之後開啟快取也能命中)，命中時改為沿用既有圖片的 Completed 任務
+func (a *App) applyResultCache(tasks []Task) error {
+	for i := range tasks {
+		task := &tasks[i]
+		task.CacheKey = resultCacheKey(task)
+		if task.CacheKey == "" || a.Config.ResultCache == "" || task.Force {
+			continue
+		}
+		q := a.DB.Where("cache_key = ? AND status = ? AND image_path <> ''", task.CacheKey, StatusCompleted)
+		if a.Config.ResultCache == "user" {
+			q = q.Where("user_id = ?", task.UserID)
+		}
+		// 圖片放在各自工作區的儲存前綴下，只能沿用同一個工作區 (或同為個人) 的任務
+		if task.WorkspaceID != nil {
+			q = q.Where("workspace_id = ?", *task.WorkspaceID)
+		} else {
+			q = q.Where("workspace_id IS NULL")
+		}
+		var cached Task
+		if err := q.Order("id desc").First(&cached).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return err
+		}
+		task.Status = StatusCompleted
+		task.ImagePath = cached.ImagePath
+		task.ThumbnailPath = cached.ThumbnailPath
+		task.UpscaledPath = cached.UpscaledPath
+		task.StorageKey = cached.StorageKey
+		task.ImageURL = cached.ImageURL
+		task.ContentType = cached.ContentType
+		task.SafetyVerdict, task.SafetyScore, task.SafetyLabels = cached.SafetyVerdict, cached.SafetyScore, cached.SafetyLabels
+		if err := a.loadTaskImages(&cached); err != nil {
+			return err
+		}
+		task.Images = cached.Images
+		task.CachedFrom = &cached.ID
+		if cached.CachedFrom != nil {
+			// 指向實際生成圖片的任務
+			task.CachedFrom = cached.CachedFrom
+		}
+	}
+	return nil
+}
+
+// 快取命中的任務與來源共用圖片檔，刪除任務時只刪除沒有其他任務引用的檔案。
+// 一併讀取 task 的 Images，之後的 task.imageKeys() 才會包含所有圖片
+func (a *App) imageKeysInUse(task *Task) map[string]bool {
+	inUse := make(map[string]bool)
+	if err := a.loadTaskImages(task); err != nil {
+		slog.Warn("Load task images error", "task_id", task.ID, "error", err)
+	}
+	keys := task.imageKeys()
+	if len(keys) == 0 {
+		return inUse
+	}
+	var others []Task
+	// 垃圾桶中的任務可能被還原，也算在使用中
+	err := a.DB.Unscoped().Select("image_path", "thumbnail_path", "upscaled_path").
+		Where("id <> ? AND (image_path IN ? OR thumbnail_path IN ? OR upscaled_path IN ?)", task.ID, keys, keys, keys).
+		Find(&others).Error
+	if err != nil {
+		// 查詢失敗時保守處理，不刪除任何檔案
+		for _, key := range keys {
+			inUse[key] = true
+		}
+		return inUse
+	}
+	var images []TaskImage
+	err = a.DB.Select("image_path", "thumbnail_path", "upscaled_path").
+		Where("task_id <> ? AND (image_path IN ? OR thumbnail_path IN ? OR upscaled_path IN ?)", task.ID, keys, keys, keys).
+		Find(&images).Error
+	if err != nil {
+		for _, key := range keys {
+			inUse[key] = true
+		}
+		return inUse
+	}
+	for _, img := range images {
+		others = append(others, Task{ImagePath: img.ImagePath, ThumbnailPath: img.ThumbnailPath, UpscaledPath: img.UpscaledPath})
+	}
+	for _, other := range others {
+		for _, key := range other.imageKeys() {
+			inUse[key] = true
+		}
+	}
+	return inUse
+}
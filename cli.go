@@ -0,0 +1,211 @@
+// cli.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// --- 子命令 ---
+//   serve (預設)：HTTP / WebSocket 服務，-workers 0 時只提供 API，由其他主機的 worker 處理任務
+//   worker：只執行 worker，不開 Web Server (例如放在另一台 GPU 主機，共用同一個資料庫)
+//   enqueue "prompt"：直接寫入資料庫建立任務
+//   status：印出佇列統計
+
+// 只執行 worker 時預設較短的掃描間隔，新任務不會有跨 process 的即時通知
+const remoteWorkerPollInterval = 2 * time.Second
+
+func runCommand(app *App, args []string) error {
+	cmd, rest := "serve", args
+	if len(args) > 0 {
+		cmd, rest = args[0], args[1:]
+	}
+	switch cmd {
+	case "serve":
+		fs := flag.NewFlagSet("serve", flag.ExitOnError)
+		workers := fs.Int("workers", app.Config.WorkerCount, "worker 數量，0 代表只提供 API")
+		fs.Parse(rest)
+		app.Config.WorkerCount = max(*workers, 0)
+		app.Config.fixFlags(fs, map[string]string{"workers": "WORKER_COUNT"})
+		if err := app.Config.checkRuntime(true); err != nil {
+			return err
+		}
+		return app.Run()
+	case "worker":
+		fs := flag.NewFlagSet("worker", flag.ExitOnError)
+		workers := fs.Int("workers", app.Config.WorkerCount, "worker 數量")
+		poll := fs.Duration("poll", remoteWorkerPollInterval, "閒置時掃描資料庫的間隔")
+		fs.Parse(rest)
+		app.Config.WorkerCount = max(*workers, 1)
+		app.Config.PollInterval = *poll
+		app.Config.fixFlags(fs, map[string]string{"workers": "WORKER_COUNT"})
+		// -poll 沒有指定時也不使用 QUEUE_POLL_INTERVAL
+		app.Config.fixSetting("QUEUE_POLL_INTERVAL")
+		if err := app.Config.checkRuntime(false); err != nil {
+			return err
+		}
+		app.RunWorker()
+		return nil
+	case "enqueue":
+		return app.cliEnqueue(rest)
+	case "status":
+		return app.cliStatus(rest)
+	}
+	return fmt.Errorf("unknown command: %s (serve, worker, enqueue, status)", cmd)
+}
+
+// 只執行 worker，收到 SIGINT / SIGTERM 時等執行中的任務完成再結束，SIGHUP 時重新載入設定
+func (a *App) RunWorker() {
+	a.startBackground()
+	a.watchReloadSignal(a.workerCtx)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	slog.Info("Shutting down worker...")
+	a.shutdownWorkers(a.Config.ShutdownTimeout)
+	a.stopGenerator()
+}
+
+// mcpzimage enqueue [-seed 1 -steps 30 ...] [-wait] "prompt"
+// 印出建立的任務 (JSON)；-wait 時等到任務結束再印出，失敗或取消時以非 0 結束
+func (a *App) cliEnqueue(args []string) error {
+	fs := flag.NewFlagSet("enqueue", flag.ExitOnError)
+	var params GenerationParams
+	fs.StringVar(&params.NegativePrompt, "negative", "", "negative prompt")
+	fs.IntVar(&params.Width, "width", 0, "寬度 (8 的倍數)")
+	fs.IntVar(&params.Height, "height", 0, "高度 (8 的倍數)")
+	fs.IntVar(&params.Steps, "steps", 0, "inference steps")
+	fs.Float64Var(&params.GuidanceScale, "guidance", 0, "guidance scale")
+	fs.IntVar(&params.Upscale, "upscale", 0, "放大倍數 (2 或 4)")
+	fs.StringVar(&params.Model, "model", "", "模型名稱")
+	fs.StringVar(&params.Format, "format", "", "輸出格式 (png、jpeg、webp)")
+	fs.IntVar(&params.Quality, "quality", 0, "jpeg 品質 (1-100)")
+	watermark := fs.String("watermark", "", "加上浮水印 (true / false)，空白代表伺服器預設")
+	strip := fs.String("strip-metadata", "", "移除圖片中的中繼資料 (true / false)，空白代表伺服器預設")
+	seed := fs.Int64("seed", -1, "seed，-1 代表隨機")
+	user := fs.String("user", "", "任務擁有者 (使用者名稱)，空白代表不屬於任何使用者")
+	force := fs.Bool("force", false, "略過生成結果快取")
+	enhance := fs.Bool("enhance", false, "先以 LLM 擴寫 prompt (需要 PROMPT_ENHANCE_URL)")
+	wait := fs.Bool("wait", false, "等待任務結束")
+	fs.Parse(args)
+
+	prompt := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if prompt == "" {
+		return errors.New("usage: mcpzimage enqueue [flags] \"prompt\"")
+	}
+	if *seed >= 0 {
+		params.Seed = seed
+	}
+	for _, f := range []struct {
+		name  string
+		value string
+		dst   **bool
+	}{{"watermark", *watermark, &params.Watermark}, {"strip-metadata", *strip, &params.StripMetadata}} {
+		if f.value != "" {
+			b, err := strconv.ParseBool(f.value)
+			if err != nil {
+				return fmt.Errorf("invalid -%s %q", f.name, f.value)
+			}
+			*f.dst = &b
+		}
+	}
+	task := Task{Prompt: prompt, GenerationParams: params, CreatedBy: "cli", Force: *force, Enhance: *enhance}
+	if *user != "" {
+		u, err := a.findOrCreateUser(*user)
+		if err != nil {
+			return err
+		}
+		task.CreatedBy, task.UserID = u.Name, u.ID
+	}
+
+	// createTask 會推播給 Hub，沒有連線時直接丟棄
+	go a.Hub.run()
+	created, err := a.createTask(context.Background(), task)
+	if err != nil {
+		return err
+	}
+	if *wait {
+		for !created.Status.finished() {
+			time.Sleep(time.Second)
+			if err := a.DB.First(created, created.ID).Error; err != nil {
+				return err
+			}
+		}
+	}
+	out, _ := json.MarshalIndent(created, "", "  ")
+	fmt.Println(string(out))
+	if *wait && created.Status != StatusCompleted {
+		return fmt.Errorf("task %d %s: %s", created.ID, created.Status, created.ErrorMessage)
+	}
+	return nil
+}
+
+// mcpzimage status [-json]
+func (a *App) cliStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "以 JSON 輸出")
+	fs.Parse(args)
+
+	stats, err := a.queueCounts(time.Now())
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		out, _ := json.MarshalIndent(stats, "", "  ")
+		fmt.Println(string(out))
+		return nil
+	}
+	statuses := make([]string, 0, len(stats.Counts))
+	for status := range stats.Counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		fmt.Printf("%-12s %d\n", status, stats.Counts[status])
+	}
+	fmt.Printf("%-12s %d\n", "ready", stats.Ready)
+	fmt.Printf("%-12s %d\n", "scheduled", stats.Scheduled)
+	if stats.OldestPendingAt != nil {
+		fmt.Printf("oldest pending: %s (%s ago)\n", stats.OldestPendingAt.Format(time.RFC3339), time.Since(*stats.OldestPendingAt).Round(time.Second))
+	}
+	return nil
+}
+
+// --- 分開部署時的狀態同步 ---
+// 沒有本機 worker 時，任務由其他 process 更新，Hub 收不到通知；定期從資料庫讀取有變動的任務推播給前端
+// (生成進度只存在 worker 的記憶體中，不會同步)
+
+const remoteUpdatePollInterval = time.Second
+
+func (a *App) watchRemoteUpdates(ctx context.Context) {
+	since := time.Now()
+	ticker := time.NewTicker(remoteUpdatePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var tasks []Task
+		if err := a.DB.Where("updated_at > ?", since).Order("updated_at asc").Limit(500).Find(&tasks).Error; err != nil {
+			slog.Warn("Poll task updates error", "error", err)
+			continue
+		}
+		for _, task := range tasks {
+			a.Hub.notifyUpdate(task)
+			since = task.UpdatedAt
+		}
+	}
+}
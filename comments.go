@@ -0,0 +1,252 @@
+// comments.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// --- 任務留言 ---
+// 看得到任務的人 (任務擁有者、工作區任務的所有成員、未啟用驗證時所有人) 都能留言；留言可以指向多張輸出中的某一張，
+// 並以 region_x / region_y / region_width / region_height (相對於圖片寬高的 0~1 比例) 框出圖片上的區域。
+// 只有留言者可以修改，留言者與任務擁有者可以刪除。新增、修改與刪除都會推播 comment 訊息給訂閱該任務的連線
+
+const maxCommentLength = 4000
+
+type Comment struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	TaskID       uint      `gorm:"index" json:"task_id"`
+	UserID       uint      `gorm:"index" json:"user_id"` // 留言者
+	Author       string    `json:"author"`               // 留言者的 API key 名稱
+	Text         string    `json:"text"`
+	ImageIndex   int       `json:"image_index"` // 多張輸出時指向第幾張 (images 的 index)，單張為 0
+	RegionX      *float64  `json:"region_x,omitempty"`
+	RegionY      *float64  `json:"region_y,omitempty"`
+	RegionWidth  *float64  `json:"region_width,omitempty"`
+	RegionHeight *float64  `json:"region_height,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// 新增與修改留言的請求；修改時 region 沒有出現代表移除
+type commentRequest struct {
+	Text         string   `json:"text"`
+	ImageIndex   int      `json:"image_index"`
+	RegionX      *float64 `json:"region_x,omitempty"`
+	RegionY      *float64 `json:"region_y,omitempty"`
+	RegionWidth  *float64 `json:"region_width,omitempty"`
+	RegionHeight *float64 `json:"region_height,omitempty"`
+}
+
+func (req *commentRequest) validate(task *Task) error {
+	req.Text = strings.TrimSpace(req.Text)
+	if req.Text == "" {
+		return invalidField("text", "text is required")
+	}
+	if utf8.RuneCountInString(req.Text) > maxCommentLength {
+		return invalidField("text", "text must not exceed %d characters", maxCommentLength)
+	}
+	if req.ImageIndex < 0 || req.ImageIndex >= task.imageCount() {
+		return invalidField("image_index", "image_index must be between 0 and %d", task.imageCount()-1)
+	}
+	region := []*float64{req.RegionX, req.RegionY, req.RegionWidth, req.RegionHeight}
+	set := 0
+	for _, v := range region {
+		if v != nil {
+			set++
+		}
+	}
+	if set == 0 {
+		return nil
+	}
+	if set != len(region) {
+		return invalidField("region", "region_x, region_y, region_width and region_height must be set together")
+	}
+	x, y, w, h := *req.RegionX, *req.RegionY, *req.RegionWidth, *req.RegionHeight
+	if x < 0 || y < 0 || w <= 0 || h <= 0 || x+w > 1 || y+h > 1 {
+		return invalidField("region", "region must lie within the image (fractions between 0 and 1)")
+	}
+	return nil
+}
+
+func (req commentRequest) apply(c *Comment) {
+	c.Text, c.ImageIndex = req.Text, req.ImageIndex
+	c.RegionX, c.RegionY, c.RegionWidth, c.RegionHeight = req.RegionX, req.RegionY, req.RegionWidth, req.RegionHeight
+}
+
+// 推播的留言訊息：{"type":"comment","action":"created","task_id":..,"data":{...}}
+type WSComment struct {
+	Type   string  `json:"type"`
+	Seq    int64   `json:"seq"`
+	Action string  `json:"action"` // created、updated、deleted
+	TaskID uint    `json:"task_id"`
+	Data   Comment `json:"data"`
+}
+
+func (h *Hub) notifyComment(task *Task, action string, comment Comment) {
+	jsonResp, _ := json.Marshal(WSComment{Type: "comment", Seq: h.nextSeq(), Action: action, TaskID: task.ID, Data: comment})
+	h.publish(wsEvent{TaskID: task.ID, UserID: task.UserID, WorkspaceID: task.WorkspaceID, Payload: jsonResp})
+}
+
+// 從路徑 {id} 取出要留言的任務。除了 taskScope 看得到的任務，工作區的任務對該工作區所有成員開放，
+// 不需要先以 X-Workspace 選擇工作區；看不到的任務一律視為不存在
+func (a *App) loadCommentTask(w http.ResponseWriter, r *http.Request) (*Task, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid task id")
+		return nil, false
+	}
+	var task Task
+	if err := a.DB.First(&task, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = errTaskNotFound
+		}
+		writeTaskError(w, err)
+		return nil, false
+	}
+	visible, err := a.canComment(identityFromRequest(r), &task)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	if !visible {
+		writeTaskError(w, errTaskNotFound)
+		return nil, false
+	}
+	return &task, true
+}
+
+// 呼叫者是否看得到任務並可以留言
+func (a *App) canComment(identity *Identity, task *Task) (bool, error) {
+	if identity.sees(task.UserID, task.WorkspaceID) {
+		return true, nil
+	}
+	if task.WorkspaceID == nil {
+		return false, nil
+	}
+	var count int64
+	err := a.DB.Model(&WorkspaceMember{}).Where("workspace_id = ? AND user_id = ?", *task.WorkspaceID, identity.userID()).Count(&count).Error
+	return count > 0, err
+}
+
+// 從路徑 {id} 與 {comment} 取出任務與留言，找不到時直接寫出錯誤
+func (a *App) loadCommentFromPath(w http.ResponseWriter, r *http.Request) (*Task, *Comment, bool) {
+	task, ok := a.loadCommentTask(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	id, err := strconv.ParseUint(r.PathValue("comment"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid comment id")
+		return nil, nil, false
+	}
+	var comment Comment
+	if err := a.DB.Where("task_id = ?", task.ID).First(&comment, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, "comment not found")
+		} else {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil, nil, false
+	}
+	return task, &comment, true
+}
+
+// 是否為留言者 (未啟用驗證時不限制)
+func (id *Identity) wrote(comment *Comment) bool {
+	return id == nil || id.UserID == 0 || comment.UserID == id.UserID
+}
+
+// GET /api/tasks/{id}/comments
+func (a *App) apiListComments(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadCommentTask(w, r)
+	if !ok {
+		return
+	}
+	comments := []Comment{}
+	if err := a.DB.Where("task_id = ?", task.ID).Order("id asc").Find(&comments).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, comments)
+}
+
+// POST /api/tasks/{id}/comments
+func (a *App) apiCreateComment(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadCommentTask(w, r)
+	if !ok {
+		return
+	}
+	var req commentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if err := req.validate(task); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	identity := identityFromRequest(r)
+	comment := Comment{TaskID: task.ID, UserID: identity.userID(), Author: identity.keyName()}
+	req.apply(&comment)
+	if err := a.DB.Create(&comment).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.Hub.notifyComment(task, "created", comment)
+	writeJSON(w, http.StatusCreated, comment)
+}
+
+// PUT /api/tasks/{id}/comments/{comment}，只有留言者可以修改
+func (a *App) apiUpdateComment(w http.ResponseWriter, r *http.Request) {
+	task, comment, ok := a.loadCommentFromPath(w, r)
+	if !ok {
+		return
+	}
+	if !identityFromRequest(r).wrote(comment) {
+		writeError(w, http.StatusForbidden, "only the author can edit a comment")
+		return
+	}
+	var req commentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if err := req.validate(task); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	req.apply(comment)
+	if err := a.DB.Model(comment).Select("text", "image_index", "region_x", "region_y", "region_width", "region_height").Updates(comment).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.Hub.notifyComment(task, "updated", *comment)
+	writeJSON(w, http.StatusOK, comment)
+}
+
+// DELETE /api/tasks/{id}/comments/{comment}，留言者與任務擁有者可以刪除
+func (a *App) apiDeleteComment(w http.ResponseWriter, r *http.Request) {
+	task, comment, ok := a.loadCommentFromPath(w, r)
+	if !ok {
+		return
+	}
+	identity := identityFromRequest(r)
+	if !identity.wrote(comment) && !identity.owns(task) {
+		writeError(w, http.StatusForbidden, "only the author or the task owner can delete a comment")
+		return
+	}
+	if err := a.DB.Delete(comment).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.Hub.notifyComment(task, "deleted", *comment)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,319 @@
+// compare.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- A/B 比較 ---
+// 以相同的 seed 與參數生成兩張圖片，只改變 prompt (prompts 兩個) 或模型 (prompt 加上 models 兩個)，
+// 兩個任務以 Task.ComparisonID 連結。GET /api/compare/{id} 回傳兩邊的任務、不同的欄位與以詞為單位的 prompt 差異，
+// 前端可以直接並排顯示。沒有指定 seed 時建立比較時隨機選一個，兩邊共用
+
+type Comparison struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      uint      `gorm:"index" json:"user_id"`
+	CreatedBy   string    `json:"created_by"`
+	Mode        string    `json:"mode"` // prompt 或 model
+	Seed        int64     `json:"seed"` // 兩邊共用的 seed
+	LeftTaskID  uint      `json:"left_task_id"`
+	RightTaskID uint      `json:"right_task_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// 建立比較的請求：prompts 兩個不同的 prompt，或 prompt 加上 models 兩個不同的模型；其他生成參數兩邊相同
+type compareRequest struct {
+	Prompts     []string   `json:"prompts"`
+	Prompt      string     `json:"prompt"`
+	Models      []string   `json:"models"`
+	ScheduledAt *time.Time `json:"scheduled_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	CallbackURL string     `json:"callback_url"` // 每個任務結束時各通知一次
+	Tags        []string   `json:"tags"`         // 兩個任務都加上的標籤
+	Force       bool       `json:"force"`        // 略過生成結果快取
+	GenerationParams
+}
+
+// 比較的內容：left 為 prompts[0] / models[0]，right 為另一個；任務被永久刪除時為 null
+type comparisonView struct {
+	Comparison  Comparison    `json:"comparison"`
+	Left        *Task         `json:"left"`
+	Right       *Task         `json:"right"`
+	Differences []fieldDiff   `json:"differences"`           // 兩邊不同的 prompt 與生成參數
+	PromptDiff  []diffSegment `json:"prompt_diff,omitempty"` // prompt 不同時以詞為單位的差異
+	Completed   bool          `json:"completed"`             // 兩邊都已結束
+}
+
+type fieldDiff struct {
+	Field string      `json:"field"`
+	Left  interface{} `json:"left"`
+	Right interface{} `json:"right"`
+}
+
+type diffSegment struct {
+	Op   string `json:"op"` // equal、removed (只在 left)、added (只在 right)
+	Text string `json:"text"`
+}
+
+// 把比較請求展開成兩個任務 (尚未寫入資料庫)，回傳比較的模式
+func (req compareRequest) expand(identity *Identity) ([]Task, string, error) {
+	var prompts, models []string
+	var mode string
+	switch {
+	case len(req.Prompts) > 0:
+		if len(req.Models) > 0 {
+			return nil, "", invalidField("models", "compare either two prompts or two models, not both")
+		}
+		if len(req.Prompts) != 2 {
+			return nil, "", invalidField("prompts", "prompts must contain exactly 2 prompts")
+		}
+		prompts = []string{strings.TrimSpace(req.Prompts[0]), strings.TrimSpace(req.Prompts[1])}
+		if prompts[0] == prompts[1] {
+			return nil, "", invalidField("prompts", "prompts must be different")
+		}
+		models = []string{req.Model, req.Model}
+		mode = "prompt"
+	case len(req.Models) > 0:
+		if len(req.Models) != 2 {
+			return nil, "", invalidField("models", "models must contain exactly 2 models")
+		}
+		if req.Model != "" {
+			return nil, "", invalidField("model", "model cannot be combined with models")
+		}
+		if req.Models[0] == req.Models[1] {
+			return nil, "", invalidField("models", "models must be different")
+		}
+		prompt := strings.TrimSpace(req.Prompt)
+		prompts, models = []string{prompt, prompt}, req.Models
+		mode = "model"
+	default:
+		return nil, "", invalidf("prompts or models is required")
+	}
+	if req.NumImages > 1 {
+		return nil, "", invalidField("num_images", "num_images is not supported for comparisons")
+	}
+
+	seed := req.Seed
+	if seed == nil {
+		n := rand.Int64N(1 << 32)
+		seed = &n
+	}
+	tasks := make([]Task, 2)
+	for i := range tasks {
+		params := req.GenerationParams
+		params.Seed, params.Model = seed, models[i]
+		tasks[i] = Task{
+			Prompt:           prompts[i],
+			GenerationParams: params,
+			ScheduledAt:      req.ScheduledAt,
+			ExpiresAt:        req.ExpiresAt,
+			CallbackURL:      strings.TrimSpace(req.CallbackURL),
+			CreatedBy:        identity.keyName(),
+			UserID:           identity.userID(),
+			Force:            req.Force,
+			Tags:             req.Tags,
+		}
+	}
+	return tasks, mode, nil
+}
+
+// 建立比較與兩個任務；任務建立失敗時一併刪除比較
+func (a *App) createComparison(ctx context.Context, req compareRequest, identity *Identity) (*comparisonView, error) {
+	tasks, mode, err := req.expand(identity)
+	if err != nil {
+		return nil, err
+	}
+	cmp := &Comparison{UserID: identity.userID(), CreatedBy: identity.keyName(), Mode: mode, Seed: *tasks[0].Seed}
+	if err := a.DB.Create(cmp).Error; err != nil {
+		return nil, err
+	}
+	for i := range tasks {
+		tasks[i].ComparisonID = &cmp.ID
+	}
+	created, err := a.createTasks(ctx, tasks, nil)
+	if err != nil {
+		a.DB.Delete(cmp)
+		return nil, err
+	}
+	cmp.LeftTaskID, cmp.RightTaskID = created[0].ID, created[1].ID
+	if err := a.DB.Model(cmp).Updates(map[string]interface{}{"left_task_id": cmp.LeftTaskID, "right_task_id": cmp.RightTaskID}).Error; err != nil {
+		return nil, err
+	}
+	return newComparisonView(*cmp, created), nil
+}
+
+func (a *App) loadComparison(id uint, identity *Identity) (*comparisonView, error) {
+	var cmp Comparison
+	if err := identity.scope(a.DB).First(&cmp, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &codedError{Code: "not_found", Message: "comparison not found"}
+		}
+		return nil, err
+	}
+	// 移到垃圾桶的任務仍然顯示，永久刪除後才消失
+	var tasks []Task
+	if err := a.DB.Unscoped().Where("comparison_id = ?", cmp.ID).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	for i := range tasks {
+		if err := a.loadTaskDetail(&tasks[i]); err != nil {
+			return nil, err
+		}
+	}
+	return newComparisonView(cmp, tasks), nil
+}
+
+func newComparisonView(cmp Comparison, tasks []Task) *comparisonView {
+	view := &comparisonView{Comparison: cmp, Differences: []fieldDiff{}}
+	for i := range tasks {
+		switch tasks[i].ID {
+		case cmp.LeftTaskID:
+			view.Left = &tasks[i]
+		case cmp.RightTaskID:
+			view.Right = &tasks[i]
+		}
+	}
+	if view.Left == nil || view.Right == nil {
+		return view
+	}
+	view.Completed = view.Left.Status.finished() && view.Right.Status.finished()
+	view.Differences = paramDifferences(view.Left, view.Right)
+	if view.Left.Prompt != view.Right.Prompt {
+		view.PromptDiff = diffWords(view.Left.Prompt, view.Right.Prompt)
+	}
+	return view
+}
+
+// 以 JSON 欄位比較兩個任務的 prompt 與生成參數，依欄位名稱排序
+func paramDifferences(left, right *Task) []fieldDiff {
+	fields := func(t *Task) map[string]interface{} {
+		data, _ := json.Marshal(struct {
+			Prompt string `json:"prompt"`
+			GenerationParams
+		}{t.Prompt, t.GenerationParams})
+		m := make(map[string]interface{})
+		json.Unmarshal(data, &m)
+		return m
+	}
+	l, r := fields(left), fields(right)
+	var names []string
+	for name := range l {
+		names = append(names, name)
+	}
+	for name := range r {
+		if _, ok := l[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	diffs := []fieldDiff{}
+	for _, name := range names {
+		if !reflect.DeepEqual(l[name], r[name]) {
+			diffs = append(diffs, fieldDiff{Field: name, Left: l[name], Right: r[name]})
+		}
+	}
+	return diffs
+}
+
+// 兩邊合計超過此詞數時不計算 LCS，整段視為刪除再新增
+const maxDiffWords = 2000
+
+// 以空白分詞的 LCS 差異，相鄰同類的詞合併成一段
+func diffWords(left, right string) []diffSegment {
+	a, b := strings.Fields(left), strings.Fields(right)
+	var segments []diffSegment
+	add := func(op, word string) {
+		if n := len(segments); n > 0 && segments[n-1].Op == op {
+			segments[n-1].Text += " " + word
+			return
+		}
+		segments = append(segments, diffSegment{Op: op, Text: word})
+	}
+	if len(a)+len(b) > maxDiffWords {
+		for _, w := range a {
+			add("removed", w)
+		}
+		for _, w := range b {
+			add("added", w)
+		}
+		return segments
+	}
+
+	// lcs[i][j]：a[i:] 與 b[j:] 的最長共同子序列長度
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			add("equal", a[i])
+			i, j = i+1, j+1
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			add("removed", a[i])
+			i++
+		default:
+			add("added", b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		add("removed", a[i])
+	}
+	for ; j < len(b); j++ {
+		add("added", b[j])
+	}
+	return segments
+}
+
+// POST /api/compare
+func (a *App) apiCreateComparison(w http.ResponseWriter, r *http.Request) {
+	var req compareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	req.Force = req.Force || r.URL.Query().Get("force") == "true"
+	view, err := a.createComparison(r.Context(), req, identityFromRequest(r))
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, view)
+}
+
+// GET /api/compare/{id}
+func (a *App) apiGetComparison(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid comparison id")
+		return
+	}
+	view, err := a.loadComparison(uint(id), identityFromRequest(r))
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
@@ -0,0 +1,582 @@
+// config.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// --- 設定 ---
+
+// 啟動時從環境變數 (envfile 與選用的 YAML 設定檔) 讀取一次並檢查，之後只透過 App.Config 存取
+type Config struct {
+	Port         string
+	TLS          tlsSettings // HTTPS，見 tls.go
+	BasePath     string      // 在反向代理的子路徑下執行 (BASE_PATH，例如 /zimage)，見 proxy.go
+	GRPCPort     string      // gRPC API 的 port (GRPC_PORT)，空字串代表不提供，見 grpc.go
+	DocumentRoot string
+	TemplateRoot string
+
+	DBDriver string // sqlite、postgres、mysql
+	DBPath   string // sqlite 資料庫 (queue.db) 所在目錄，設定 DB_DSN 時不使用
+
+	Generator string // python、sidecar、http、mock
+	Upscaler  string // 空字串代表不提供放大
+	// python 腳本的執行環境 (直譯器、虛擬環境、工作目錄、環境變數)，見 python.go
+	Python        pythonRuntime // 生成後端 (GENERATOR_ 前綴)
+	UpscalePython pythonRuntime // UPSCALER=python (UPSCALE_ 前綴)
+	SafetyPython  pythonRuntime // SAFETY_CHECK=script (SAFETY_ 前綴)
+
+	GPUDevices    string // GPU_DEVICES：空字串 (有 nvidia-smi 時偵測)、auto、none 或 index:vram_mb 清單
+	GPUTaskVRAMMB int    // 每個任務需要的剩餘 VRAM (GPU_TASK_VRAM_MB)
+
+	ImageDir          string
+	UploadDir         string
+	MaxUploadMB       int
+	MaxImportMB       int // 匯入檔大小上限 (MAX_IMPORT_MB)，見 export.go
+	WorkerCount       int
+	PollInterval      time.Duration // 沒有收到新任務通知時掃描資料庫的間隔 (QUEUE_POLL_INTERVAL)
+	QueueBackend      string        // 通知 worker 的佇列：db (預設，只靠資料庫) 或 redis，見 taskqueue.go
+	RedisURL          string        // QUEUE_BACKEND=redis 時的連線網址 (REDIS_URL)
+	RedisQueueKey     string        // Redis list 的 key (REDIS_QUEUE_KEY)
+	WorkerID          string        // 記錄在任務 claimed_by 的識別 (WORKER_ID，預設主機名稱)
+	WorkerLease       time.Duration // 認領任務的租約長度 (WORKER_LEASE)
+	GenerationTimeout time.Duration
+	MaxTaskAttempts   int           // 任務最多被認領的次數，用完後 watchdog 不再放回佇列 (MAX_TASK_ATTEMPTS)
+	TaskExpiry        time.Duration // 沒有指定 expires_at 的任務多久還沒開始執行就放棄 (TASK_EXPIRY)，0 代表不限制
+	ShutdownTimeout   time.Duration
+	ThumbnailSize     int
+	MinFreeDiskMB     uint64
+	DiskGuard         bool            // 可用空間低於 MIN_FREE_DISK_MB 時自動暫停佇列 (DISK_GUARD，預設開啟)
+	Warmup            warmupConfig    // 啟動時先生成一張小圖載入模型 (WARMUP)，見 warmup.go
+	Watermark         watermarkConfig // 圖片的浮水印 (WATERMARK_*)，見 watermark.go
+	StripMetadata     bool            // 預設移除圖片中的中繼資料 (STRIP_METADATA)
+
+	MaxQueuedPerUser int // 0 代表不限制
+	MaxTasksPerHour  int // 0 代表不限制
+
+	Retention retentionPolicy
+	TrashDays int // 垃圾桶保留天數 (TRASH_DAYS)，0 代表不自動清除
+	Models    modelRegistry
+	// 每個模型在同一個 process 內同時執行的任務上限 (MODEL_CONCURRENCY)，見 modellanes.go
+	ModelConcurrency map[string]int
+	LoRAs            loraRegistry // LORA_DIR 與 EMBEDDING_DIR，見 lora.go
+	ControlNetTypes  []string     // 生成後端支援的 ControlNet 類型 (CONTROLNET_TYPES)，見 controlnet.go
+	CORS             corsPolicy   // ALLOWED_ORIGINS
+
+	PublicURL     string         // 對外網址 (例如 https://zimage.example.com，含 BASE_PATH)，用於 webhook 的圖片連結
+	WebhookSecret string         // webhook 的 HMAC 簽章金鑰，空字串代表不簽署
+	Notify        notifyConfig   // Discord / Slack 通知 (DISCORD_WEBHOOK_URL、SLACK_WEBHOOK_URL)，見 notify.go
+	Telegram      telegramConfig // Telegram bot (TELEGRAM_BOT_TOKEN)，見 telegram.go
+
+	ResultCache string // 生成結果快取的範圍：""、"user"、"global"
+
+	PromptSyntaxCheck  bool          // 建立任務前檢查 prompt 的權重語法 (PROMPT_SYNTAX_CHECK，預設開啟)，見 promptsyntax.go
+	Enhance            enhanceConfig // 以 LLM 擴寫 prompt (PROMPT_ENHANCE_URL)，見 enhance.go
+	PromptDenylist     string        // 禁用詞檔案 (PROMPT_DENYLIST)，空字串代表不檢查
+	PromptDenylistMode string        // reject 拒絕建立任務，flag 只標記 (PROMPT_DENYLIST_MODE)
+	ApprovalMode       string        // 需要管理員核准才執行的任務：off、all、flagged (APPROVAL_MODE)，見 approval.go
+
+	SafetyCheck     string  // 內容安全檢查：""、script、http (SAFETY_CHECK)
+	SafetyMode      string  // warn 只記錄，block 隔離不安全的圖片 (SAFETY_MODE)
+	SafetyThreshold float64 // 分數達到此值視為不安全 (SAFETY_THRESHOLD)
+
+	AdminToken        string      // 未設定時 /api/admin/* 只接受 admin 角色的使用者
+	DefaultUserRole   string      // 自動建立的使用者的角色 (DEFAULT_USER_ROLE)，見 rbac.go
+	DefaultLocale     string      // 沒有 Accept-Language 與使用者設定時的語系 (DEFAULT_LOCALE)，見 i18n.go
+	Login             loginConfig // 瀏覽器以 OIDC / GitHub 登入 (OIDC_*、GITHUB_*)，見 oauth.go
+	TrustProxy        bool        // 稽核紀錄的來源 IP 採用 X-Forwarded-For (TRUST_PROXY)
+	StartPaused       bool        // QUEUE_PAUSED=true 時以暫停狀態啟動，維護期間重新啟動也不會開始生成
+	PresenceBroadcast bool        // 連線加入或離開時廣播 presence 訊息 (PRESENCE_BROADCAST)
+
+	ConfigFile string          // -config 或 CONFIG_FILE，重新載入設定時再讀一次 (見 reload.go)
+	fixedEnv   map[string]bool // 啟動前就存在的環境變數與命令列參數，重新載入時不變
+}
+
+// 讀取並檢查設定，有錯誤時一次列出所有問題
+func loadConfig() (*Config, error) {
+	cfg := &Config{
+		Port:               envString("PORT", "80"),
+		TLS:                tlsFromEnv(),
+		BasePath:           normalizeBasePath(os.Getenv("BASE_PATH")),
+		GRPCPort:           os.Getenv("GRPC_PORT"),
+		DocumentRoot:       os.Getenv("DocumentRoot"),
+		TemplateRoot:       envString("TemplateRoot", "www/template"),
+		DBDriver:           strings.ToLower(envString("DB_DRIVER", "sqlite")),
+		DBPath:             os.Getenv("DBPath"),
+		Generator:          envString("GENERATOR", "python"),
+		Upscaler:           os.Getenv("UPSCALER"),
+		Python:             pythonFromEnv("GENERATOR"),
+		UpscalePython:      pythonFromEnv("UPSCALE"),
+		SafetyPython:       pythonFromEnv("SAFETY"),
+		GPUDevices:         os.Getenv("GPU_DEVICES"),
+		GPUTaskVRAMMB:      gpuTaskVRAM(),
+		ImageDir:           imageDir(),
+		UploadDir:          uploadDir(),
+		MaxUploadMB:        maxUploadMB(),
+		MaxImportMB:        maxImportMB(),
+		WorkerCount:        workerCount(),
+		PollInterval:       pollInterval(),
+		QueueBackend:       strings.ToLower(envString("QUEUE_BACKEND", "db")),
+		RedisURL:           envString("REDIS_URL", "redis://localhost:6379/0"),
+		RedisQueueKey:      envString("REDIS_QUEUE_KEY", "zimage:queue"),
+		WorkerID:           workerID(),
+		WorkerLease:        workerLease(),
+		GenerationTimeout:  generationTimeout(),
+		MaxTaskAttempts:    maxTaskAttempts(),
+		TaskExpiry:         taskExpiry(),
+		ShutdownTimeout:    shutdownTimeout(),
+		ThumbnailSize:      thumbnailSize(),
+		MinFreeDiskMB:      minFreeDiskMB(),
+		DiskGuard:          os.Getenv("DISK_GUARD") != "false",
+		Warmup:             warmupFromEnv(),
+		Watermark:          watermarkFromEnv(),
+		StripMetadata:      os.Getenv("STRIP_METADATA") == "true",
+		MaxQueuedPerUser:   envInt("MAX_QUEUED_PER_USER"),
+		MaxTasksPerHour:    envInt("MAX_TASKS_PER_HOUR"),
+		Retention:          retentionFromEnv(),
+		TrashDays:          trashDays(),
+		Models:             modelRegistry{Dir: os.Getenv("MODEL_DIR"), Manifest: os.Getenv("MODEL_MANIFEST")},
+		ModelConcurrency:   modelConcurrency(),
+		LoRAs:              loraRegistry{Dir: os.Getenv("LORA_DIR"), EmbeddingDir: os.Getenv("EMBEDDING_DIR")},
+		ControlNetTypes:    controlNetTypesFromEnv(),
+		CORS:               corsFromEnv(),
+		PublicURL:          strings.TrimRight(os.Getenv("PUBLIC_URL"), "/"),
+		WebhookSecret:      os.Getenv("WEBHOOK_SECRET"),
+		Notify:             notifyFromEnv(),
+		Telegram:           telegramFromEnv(),
+		ResultCache:        resultCacheMode(),
+		PromptSyntaxCheck:  promptSyntaxCheck(),
+		Enhance:            enhanceFromEnv(),
+		PromptDenylist:     os.Getenv("PROMPT_DENYLIST"),
+		PromptDenylistMode: strings.ToLower(envString("PROMPT_DENYLIST_MODE", "reject")),
+		ApprovalMode:       strings.ToLower(envString("APPROVAL_MODE", "off")),
+		SafetyCheck:        strings.ToLower(os.Getenv("SAFETY_CHECK")),
+		SafetyMode:         strings.ToLower(envString("SAFETY_MODE", "warn")),
+		SafetyThreshold:    safetyThreshold(),
+		AdminToken:         os.Getenv("ADMIN_TOKEN"),
+		DefaultUserRole:    strings.ToLower(envString("DEFAULT_USER_ROLE", roleCreator)),
+		DefaultLocale:      defaultLocale(),
+		Login:              loginFromEnv(),
+		TrustProxy:         os.Getenv("TRUST_PROXY") == "true",
+		StartPaused:        os.Getenv("QUEUE_PAUSED") == "true",
+		PresenceBroadcast:  os.Getenv("PRESENCE_BROADCAST") == "true",
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func pollInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("QUEUE_POLL_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return dispatchScanInterval
+}
+
+func envString(name, def string) string {
+	if s := os.Getenv(name); s != "" {
+		return s
+	}
+	return def
+}
+
+// --- 設定檔 ---
+// -config config.yaml (或環境變數 CONFIG_FILE) 指定的 YAML，key 與環境變數名稱相同 (大小寫不拘)：
+//   PORT: 8080
+//   worker_count: 2
+//   allowed_origins: [https://a.example.com, https://b.example.com]
+// 已經存在的環境變數 (含 envfile) 優先，設定檔只補上沒有設定的值
+
+// 名稱含小寫字母的設定，其他 key 一律轉成大寫
+var configFileAliases = map[string]string{
+	"documentroot": "DocumentRoot",
+	"templateroot": "TemplateRoot",
+	"dbpath":       "DBPath",
+}
+
+func loadConfigFile(path string) error {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	for name, value := range values {
+		if _, exists := os.LookupEnv(name); !exists {
+			os.Setenv(name, value)
+		}
+	}
+	return nil
+}
+
+// 讀取設定檔，回傳環境變數名稱與值
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	env := make(map[string]string, len(values))
+	for key, value := range values {
+		name, ok := configFileAliases[strings.ToLower(key)]
+		if !ok {
+			name = strings.ToUpper(key)
+		}
+		var s string
+		switch v := value.(type) {
+		case nil:
+		case []interface{}:
+			// 清單以逗號連接，例如 ALLOWED_ORIGINS
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			s = strings.Join(items, ",")
+		case map[string]interface{}:
+			return nil, fmt.Errorf("%s: %s: nested values are not supported", path, key)
+		default:
+			s = fmt.Sprint(v)
+		}
+		env[name] = s
+	}
+	return env, nil
+}
+
+// --- 設定檢查 ---
+
+// 設定錯誤，列出所有問題後拒絕啟動
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// 有設定但格式錯誤的值不再默默改用預設值
+var (
+	intSettings = []string{
+		"WORKER_COUNT", "SHUTDOWN_TIMEOUT", "THUMBNAIL_SIZE", "MAX_UPLOAD_MB", "MAX_IMPORT_MB", "MIN_FREE_DISK_MB",
+		"MAX_QUEUED_PER_USER", "MAX_TASKS_PER_HOUR", "RETENTION_DAYS", "RETENTION_MAX_MB", "TRASH_DAYS",
+		"DB_MAX_OPEN_CONNS", "DB_MAX_IDLE_CONNS", "GPU_TASK_VRAM_MB", "MAX_TASK_ATTEMPTS",
+		"WARMUP_SIZE", "WARMUP_STEPS",
+	}
+	durationSettings = []string{
+		"QUEUE_POLL_INTERVAL", "WORKER_LEASE", "GENERATION_TIMEOUT", "RETENTION_INTERVAL",
+		"DB_CONN_MAX_LIFETIME", "GENERATOR_MOCK_DELAY", "WARMUP_TIMEOUT", "SESSION_TTL", "TASK_EXPIRY",
+		"SQLITE_BUSY_TIMEOUT", "PROMPT_ENHANCE_TIMEOUT",
+	}
+	boolSettings = []string{"QUEUE_PAUSED", "RETENTION_DRY_RUN", "AUTO_MIGRATE", "S3_USE_SSL", "S3_KEEP_LOCAL", "TRUST_PROXY", "DISK_GUARD", "PRESENCE_BROADCAST", "WARMUP", "WATERMARK_ENFORCE", "STRIP_METADATA", "PROMPT_SYNTAX_CHECK"}
+)
+
+// 啟動前即可檢查的項目：數值格式、資料庫與後端名稱
+func (c *Config) validate() error {
+	var problems []string
+	for _, name := range intSettings {
+		if s := os.Getenv(name); s != "" {
+			if n, err := strconv.Atoi(s); err != nil || n < 0 {
+				problems = append(problems, fmt.Sprintf("%s=%q: must be a non-negative integer", name, s))
+			}
+		}
+	}
+	for _, name := range durationSettings {
+		if s := os.Getenv(name); s != "" {
+			if d, err := time.ParseDuration(s); err != nil || d < 0 {
+				problems = append(problems, fmt.Sprintf("%s=%q: must be a duration such as 30s or 10m", name, s))
+			}
+		}
+	}
+	for _, name := range boolSettings {
+		if s := os.Getenv(name); s != "" {
+			if _, err := strconv.ParseBool(s); err != nil {
+				problems = append(problems, fmt.Sprintf("%s=%q: must be true or false", name, s))
+			}
+		}
+	}
+	if d, err := time.ParseDuration(os.Getenv("WORKER_LEASE")); err == nil && d < 5*time.Second {
+		problems = append(problems, fmt.Sprintf("WORKER_LEASE=%q: must be at least 5s", os.Getenv("WORKER_LEASE")))
+	}
+
+	switch strings.ToLower(c.GPUDevices) {
+	case "", "auto", "none":
+	default:
+		if _, err := parseGPUDevices(c.GPUDevices); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if _, err := parseModelConcurrency(os.Getenv("MODEL_CONCURRENCY")); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if n, err := strconv.Atoi(c.Port); err != nil || n < 1 || n > 65535 {
+		problems = append(problems, fmt.Sprintf("PORT=%q: must be a port number", c.Port))
+	}
+	if c.GRPCPort != "" {
+		if n, err := strconv.Atoi(c.GRPCPort); err != nil || n < 1 || n > 65535 || c.GRPCPort == c.Port {
+			problems = append(problems, fmt.Sprintf("GRPC_PORT=%q: must be a port number different from PORT", c.GRPCPort))
+		}
+	}
+	problems = append(problems, c.TLS.problems()...)
+	problems = append(problems, basePathProblems(c.BasePath)...)
+	switch c.DBDriver {
+	case "sqlite":
+		if os.Getenv("DB_DSN") == "" && !isDir(filepath.Dir(c.sqlitePath())) {
+			problems = append(problems, fmt.Sprintf("DBPath=%q: directory does not exist", c.DBPath))
+		}
+	case "postgres", "mysql":
+	default:
+		problems = append(problems, fmt.Sprintf("DB_DRIVER=%q: must be sqlite, postgres or mysql", c.DBDriver))
+	}
+	switch c.Generator {
+	case "python", "sidecar", "mock":
+	case "http":
+		if os.Getenv("GENERATOR_URL") == "" {
+			problems = append(problems, "GENERATOR_URL is required for GENERATOR=http")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("GENERATOR=%q: must be python, sidecar, http or mock", c.Generator))
+	}
+	problems = append(problems, c.queueProblems()...)
+	problems = append(problems, c.controlNetProblems()...)
+	problems = append(problems, c.Enhance.problems()...)
+	switch c.Upscaler {
+	case "", "python", "resize":
+	default:
+		problems = append(problems, fmt.Sprintf("UPSCALER=%q: must be python or resize", c.Upscaler))
+	}
+	switch storage := strings.ToLower(os.Getenv("STORAGE")); storage {
+	case "", "local":
+	case "s3":
+		if os.Getenv("S3_ENDPOINT") == "" || os.Getenv("S3_BUCKET") == "" {
+			problems = append(problems, "S3_ENDPOINT and S3_BUCKET are required for STORAGE=s3")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("STORAGE=%q: must be local or s3", storage))
+	}
+	if c.PromptDenylistMode != "reject" && c.PromptDenylistMode != "flag" {
+		problems = append(problems, fmt.Sprintf("PROMPT_DENYLIST_MODE=%q: must be reject or flag", c.PromptDenylistMode))
+	}
+	if c.PromptDenylist != "" {
+		if _, err := parseDenylist(c.PromptDenylist); err != nil {
+			problems = append(problems, fmt.Sprintf("PROMPT_DENYLIST=%q: %v", c.PromptDenylist, err))
+		}
+	}
+	problems = append(problems, c.approvalProblems()...)
+	if !validRole(c.DefaultUserRole) {
+		problems = append(problems, fmt.Sprintf("DEFAULT_USER_ROLE=%q: must be viewer, creator or admin", c.DefaultUserRole))
+	}
+	problems = append(problems, localeProblems()...)
+	problems = append(problems, c.Warmup.problems()...)
+	problems = append(problems, c.Watermark.problems()...)
+	problems = append(problems, c.Notify.problems()...)
+	problems = append(problems, c.Telegram.problems()...)
+	problems = append(problems, c.Login.problems()...)
+	switch c.SafetyCheck {
+	case "", "script":
+	case "http":
+		if os.Getenv("SAFETY_URL") == "" {
+			problems = append(problems, "SAFETY_URL is required for SAFETY_CHECK=http")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("SAFETY_CHECK=%q: must be script or http", c.SafetyCheck))
+	}
+	if c.SafetyMode != "warn" && c.SafetyMode != "block" {
+		problems = append(problems, fmt.Sprintf("SAFETY_MODE=%q: must be warn or block", c.SafetyMode))
+	}
+	if s := os.Getenv("SAFETY_THRESHOLD"); s != "" {
+		if f, err := strconv.ParseFloat(s, 64); err != nil || f <= 0 || f > 1 {
+			problems = append(problems, fmt.Sprintf("SAFETY_THRESHOLD=%q: must be a number between 0 and 1", s))
+		}
+	}
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// 依執行模式檢查需要的檔案與程式 (子命令決定 worker 數量之後才呼叫)：
+// 提供網頁且設定 DocumentRoot 時需要該目錄，有 worker 並使用 python 後端時需要 python 與 Z-Image 專案目錄。
+// 通過後記錄實際使用的設定
+func (c *Config) checkRuntime(web bool) error {
+	var problems []string
+	if web && c.DocumentRoot != "" && !isDir(c.DocumentRoot) {
+		problems = append(problems, fmt.Sprintf("DocumentRoot=%q: directory does not exist", c.DocumentRoot))
+	}
+	if c.WorkerCount > 0 {
+		for _, p := range c.pythonRuntimes() {
+			problems = append(problems, p.problems()...)
+		}
+	}
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	args := []interface{}{}
+	for _, e := range c.entries() {
+		args = append(args, e.Name, e.Value)
+	}
+	slog.Info("Effective config", args...)
+	return nil
+}
+
+// sqlite 資料庫檔案
+func (c *Config) sqlitePath() string {
+	return filepath.Join(c.DBPath, "queue.db")
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// --- 實際使用的設定 ---
+
+type configEntry struct {
+	Name  string
+	Value string
+}
+
+// 以環境變數名稱列出設定值，密鑰只顯示是否設定
+func (c *Config) entries() []configEntry {
+	secret := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return "********"
+	}
+	origins := strings.Join(c.CORS.Origins, ",")
+	if c.CORS.AllowAll {
+		origins = "*"
+	}
+	var chats []string
+	for _, id := range c.Telegram.AllowedChats {
+		chats = append(chats, strconv.FormatInt(id, 10))
+	}
+	return []configEntry{
+		{"PORT", c.Port},
+		{"TLS_CERT", c.TLS.CertFile},
+		{"TLS_KEY", c.TLS.KeyFile},
+		{"CERT_DOMAINS", strings.Join(c.TLS.Domains, ",")},
+		{"CERT_CACHE_DIR", c.TLS.CacheDir},
+		{"HTTP_REDIRECT_PORT", c.TLS.RedirectPort},
+		{"BASE_PATH", c.BasePath},
+		{"GRPC_PORT", c.GRPCPort},
+		{"DocumentRoot", c.DocumentRoot},
+		{"TemplateRoot", c.TemplateRoot},
+		{"DB_DRIVER", c.DBDriver},
+		{"DBPath", c.DBPath},
+		{"GENERATOR", c.Generator},
+		{"UPSCALER", c.Upscaler},
+		{"GENERATOR_PYTHON", c.Python.describe()},
+		{"UPSCALE_PYTHON", c.UpscalePython.describe()},
+		{"SAFETY_PYTHON", c.SafetyPython.describe()},
+		{"GPU_DEVICES", c.GPUDevices},
+		{"GPU_TASK_VRAM_MB", strconv.Itoa(c.GPUTaskVRAMMB)},
+		{"IMAGE_DIR", c.ImageDir},
+		{"UPLOAD_DIR", c.UploadDir},
+		{"MAX_UPLOAD_MB", strconv.Itoa(c.MaxUploadMB)},
+		{"MAX_IMPORT_MB", strconv.Itoa(c.MaxImportMB)},
+		{"WORKER_COUNT", strconv.Itoa(c.WorkerCount)},
+		{"QUEUE_POLL_INTERVAL", c.PollInterval.String()},
+		{"QUEUE_BACKEND", c.QueueBackend},
+		{"REDIS_URL", redactedURL(c.RedisURL)},
+		{"REDIS_QUEUE_KEY", c.RedisQueueKey},
+		{"WORKER_ID", c.WorkerID},
+		{"WORKER_LEASE", c.WorkerLease.String()},
+		{"GENERATION_TIMEOUT", c.GenerationTimeout.String()},
+		{"MAX_TASK_ATTEMPTS", strconv.Itoa(c.MaxTaskAttempts)},
+		{"TASK_EXPIRY", c.TaskExpiry.String()},
+		{"SHUTDOWN_TIMEOUT", strconv.Itoa(int(c.ShutdownTimeout / time.Second))},
+		{"THUMBNAIL_SIZE", strconv.Itoa(c.ThumbnailSize)},
+		{"MIN_FREE_DISK_MB", strconv.FormatUint(c.MinFreeDiskMB, 10)},
+		{"DISK_GUARD", strconv.FormatBool(c.DiskGuard)},
+		{"MAX_QUEUED_PER_USER", strconv.Itoa(c.MaxQueuedPerUser)},
+		{"MAX_TASKS_PER_HOUR", strconv.Itoa(c.MaxTasksPerHour)},
+		{"RETENTION_DAYS", strconv.Itoa(int(c.Retention.MaxAge / (24 * time.Hour)))},
+		{"RETENTION_MAX_MB", strconv.FormatInt(c.Retention.MaxBytes/(1024*1024), 10)},
+		{"RETENTION_INTERVAL", c.Retention.Interval.String()},
+		{"RETENTION_DRY_RUN", strconv.FormatBool(c.Retention.DryRun)},
+		{"TRASH_DAYS", strconv.Itoa(c.TrashDays)},
+		{"MODEL_DIR", c.Models.Dir},
+		{"MODEL_MANIFEST", c.Models.Manifest},
+		{"MODEL_CONCURRENCY", formatModelConcurrency(c.ModelConcurrency)},
+		{"LORA_DIR", c.LoRAs.Dir},
+		{"EMBEDDING_DIR", c.LoRAs.EmbeddingDir},
+		{"CONTROLNET_TYPES", strings.Join(c.ControlNetTypes, ",")},
+		{"ALLOWED_ORIGINS", origins},
+		{"PUBLIC_URL", c.PublicURL},
+		{"WEBHOOK_SECRET", secret(c.WebhookSecret)},
+		{"DISCORD_WEBHOOK_URL", secret(c.Notify.DiscordURL)},
+		{"SLACK_WEBHOOK_URL", secret(c.Notify.SlackURL)},
+		{"NOTIFY_TEMPLATE_COMPLETED", c.Notify.CompletedTemplate},
+		{"NOTIFY_TEMPLATE_FAILED", c.Notify.FailedTemplate},
+		{"TELEGRAM_BOT_TOKEN", secret(c.Telegram.Token)},
+		{"TELEGRAM_API_URL", c.Telegram.APIURL},
+		{"TELEGRAM_USER", c.Telegram.User},
+		{"TELEGRAM_ALLOWED_CHATS", strings.Join(chats, ",")},
+		{"RESULT_CACHE", c.ResultCache},
+		{"PROMPT_SYNTAX_CHECK", strconv.FormatBool(c.PromptSyntaxCheck)},
+		{"PROMPT_ENHANCE_URL", c.Enhance.URL},
+		{"PROMPT_ENHANCE_API_KEY", secret(c.Enhance.APIKey)},
+		{"PROMPT_ENHANCE_MODEL", c.Enhance.Model},
+		{"PROMPT_ENHANCE_TIMEOUT", c.Enhance.Timeout.String()},
+		{"PROMPT_DENYLIST", c.PromptDenylist},
+		{"PROMPT_DENYLIST_MODE", c.PromptDenylistMode},
+		{"APPROVAL_MODE", c.ApprovalMode},
+		{"SAFETY_CHECK", c.SafetyCheck},
+		{"SAFETY_MODE", c.SafetyMode},
+		{"SAFETY_THRESHOLD", strconv.FormatFloat(c.SafetyThreshold, 'g', -1, 64)},
+		{"ADMIN_TOKEN", secret(c.AdminToken)},
+		{"DEFAULT_USER_ROLE", c.DefaultUserRole},
+		{"DEFAULT_LOCALE", c.DefaultLocale},
+		{"OIDC_ISSUER", c.Login.OIDCIssuer},
+		{"OIDC_CLIENT_ID", c.Login.OIDCClientID},
+		{"OIDC_CLIENT_SECRET", secret(c.Login.OIDCClientSecret)},
+		{"OIDC_NAME", c.Login.OIDCName},
+		{"OIDC_SCOPES", strings.Join(c.Login.OIDCScopes, " ")},
+		{"GITHUB_CLIENT_ID", c.Login.GitHubClientID},
+		{"GITHUB_CLIENT_SECRET", secret(c.Login.GitHubSecret)},
+		{"LOGIN_ALLOWED_DOMAINS", strings.Join(c.Login.AllowedDomains, ",")},
+		{"SESSION_TTL", c.Login.SessionTTL.String()},
+		{"TRUST_PROXY", strconv.FormatBool(c.TrustProxy)},
+		{"QUEUE_PAUSED", strconv.FormatBool(c.StartPaused)},
+		{"PRESENCE_BROADCAST", strconv.FormatBool(c.PresenceBroadcast)},
+		{"WARMUP", strconv.FormatBool(c.Warmup.Enabled)},
+		{"WARMUP_SIZE", strconv.Itoa(c.Warmup.Size)},
+		{"WARMUP_STEPS", strconv.Itoa(c.Warmup.Steps)},
+		{"WARMUP_TIMEOUT", c.Warmup.Timeout.String()},
+		{"WATERMARK_TEXT", c.Watermark.Text},
+		{"WATERMARK_IMAGE", c.Watermark.Image},
+		{"WATERMARK_POSITION", c.Watermark.Position},
+		{"WATERMARK_OPACITY", strconv.FormatFloat(c.Watermark.Opacity, 'g', -1, 64)},
+		{"WATERMARK_SCALE", strconv.FormatFloat(c.Watermark.Scale, 'g', -1, 64)},
+		{"WATERMARK_ENFORCE", strconv.FormatBool(c.Watermark.Enforce)},
+		{"STRIP_METADATA", strconv.FormatBool(c.StripMetadata)},
+	}
+}
+
+// -print-config：以設定檔格式輸出，可直接存成 config.yaml 使用 (密鑰已遮蔽，需自行填回)
+func (c *Config) printYAML(w io.Writer) error {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, e := range c.entries() {
+		doc.Content = append(doc.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: e.Name},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: e.Value})
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
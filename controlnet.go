@@ -0,0 +1,127 @@
+// controlnet.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// --- ControlNet ---
+// 任務帶一張條件圖 (control_image) 與條件類型 (control_type) 引導構圖：
+//
+//	canny 邊緣線稿、depth 深度圖、pose 人物骨架 (openpose)
+//
+// 條件圖以 POST /api/tasks/controlnet 上傳 (可同時帶 img2img 的 image / mask)，存在 UPLOAD_DIR。
+// 生成後端是否支援由 CONTROLNET_TYPES 設定 (python / sidecar 腳本載入了哪些 ControlNet 模型)，
+// 未列出的類型建立任務時回 400；http 後端 (Automatic1111) 不支援。
+// python 後端收到 --control-image <路徑> --control-type <類型> 與選填的 --control-scale
+
+var controlNetTypes = []string{"canny", "depth", "pose"}
+
+// ControlNet 的強度上限 (diffusers 的 controlnet_conditioning_scale)
+const maxControlScale = 2.0
+
+// 生成後端支援的類型，從環境變數 CONTROLNET_TYPES (以逗號分隔) 取得，未設定代表不支援 ControlNet
+func controlNetTypesFromEnv() []string {
+	var types []string
+	for _, s := range strings.Split(os.Getenv("CONTROLNET_TYPES"), ",") {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" && !slices.Contains(types, s) {
+			types = append(types, s)
+		}
+	}
+	return types
+}
+
+func (c *Config) controlNetProblems() []string {
+	var problems []string
+	for _, t := range c.ControlNetTypes {
+		if !slices.Contains(controlNetTypes, t) {
+			problems = append(problems, fmt.Sprintf("CONTROLNET_TYPES=%q: unknown type %s (must be %s)",
+				os.Getenv("CONTROLNET_TYPES"), t, strings.Join(controlNetTypes, ", ")))
+		}
+	}
+	if len(c.ControlNetTypes) > 0 && c.Generator == "http" {
+		problems = append(problems, "CONTROLNET_TYPES: ControlNet is not supported by the http generator")
+	}
+	return problems
+}
+
+// 檢查 control_type 與 control_scale 的格式，是否支援在建立任務時由 checkControlNet 檢查
+func (p GenerationParams) validateControlNet() error {
+	if p.ControlType != "" && !slices.Contains(controlNetTypes, p.ControlType) {
+		return invalidField("control_type", "control_type must be %s", strings.Join(controlNetTypes, ", "))
+	}
+	if p.ControlScale < 0 || p.ControlScale > maxControlScale {
+		return invalidField("control_scale", "control_scale must be between 0 and %g", maxControlScale)
+	}
+	if p.ControlScale > 0 && p.ControlType == "" {
+		return invalidField("control_scale", "control_scale requires control_type")
+	}
+	return nil
+}
+
+// 建立任務時檢查條件圖與類型是否成對，以及生成後端是否支援這個類型
+func (a *App) checkControlNet(task *Task) error {
+	if task.ControlType == "" && task.ControlImagePath == "" {
+		return nil
+	}
+	if task.ControlImagePath == "" {
+		return invalidField("control_image", "control_type requires a control_image (POST /api/tasks/controlnet)")
+	}
+	if task.ControlType == "" {
+		return invalidField("control_type", "control_type is required with a control_image")
+	}
+	if !slices.Contains(a.Config.ControlNetTypes, task.ControlType) {
+		return invalidField("control_type", "controlnet %s is not supported by the %s generator", task.ControlType, a.Config.Generator)
+	}
+	return nil
+}
+
+// run_z_image.py 的 ControlNet 參數 (條件圖由 pythonArgs 加上)
+func (p GenerationParams) controlNetArgs() []string {
+	if p.ControlType == "" {
+		return nil
+	}
+	args := []string{"--control-type", p.ControlType}
+	if p.ControlScale > 0 {
+		args = append(args, "--control-scale", strconv.FormatFloat(p.ControlScale, 'f', -1, 64))
+	}
+	return args
+}
+
+// 條件圖：上傳的 control_image 檔案，或以 control_source_task_id 指定自己已完成任務的結果 (例如以生成的骨架圖作為 pose)
+func (a *App) controlImageFromRequest(r *http.Request) (string, error) {
+	if s := r.FormValue("control_source_task_id"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return "", invalidField("control_source_task_id", "invalid control_source_task_id")
+		}
+		var source Task
+		if err := identityFromRequest(r).taskScope(a.DB).First(&source, id).Error; err != nil || source.ImagePath == "" {
+			return "", invalidField("control_source_task_id", "source task %d has no image", id)
+		}
+		f, _, err := a.storageFor(&source).Open(r.Context(), source.ImagePath)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		return a.saveUpload(f, "control source image", "control")
+	}
+	file, _, err := r.FormFile("control_image")
+	if err != nil {
+		return "", invalidField("control_image", "control_image file or control_source_task_id is required")
+	}
+	defer file.Close()
+	return a.saveUpload(file, "control_image", "control")
+}
+
+// POST /api/tasks/controlnet (multipart/form-data)
+// control_image (或 control_source_task_id): 條件圖，control_type: canny、depth 或 pose，
+// image / source_task_id / mask 為選填 (同時做 img2img)，其他欄位與 POST /api/tasks/img2img 相同
+func (a *App) apiCreateControlNetTask(w http.ResponseWriter, r *http.Request) {
+	a.createUploadTask(w, r, true)
+}
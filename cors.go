@@ -0,0 +1,117 @@
+// cors.go
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// --- CORS 與來源檢查 ---
+// ALLOWED_ORIGINS 同時決定 WebSocket 接受哪些 Origin，以及 REST / MCP 回應哪些 CORS header：
+//   未設定：只接受同源 (前端由本服務提供時不需要設定)
+//   https://app.example.com,https://*.example.com：另外允許列出的來源 (* 只能用在子網域)
+//   *：開發模式，接受任何來源
+// 沒有 Origin header 的請求 (curl、MCP client) 不受影響，仍需通過 API key 驗證
+
+type corsPolicy struct {
+	AllowAll bool
+	Origins  []string // 正規化後的 scheme://host[:port]
+}
+
+func corsFromEnv() corsPolicy {
+	var p corsPolicy
+	for _, origin := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		origin = strings.ToLower(strings.TrimRight(strings.TrimSpace(origin), "/"))
+		switch origin {
+		case "":
+		case "*":
+			p.AllowAll = true
+		default:
+			p.Origins = append(p.Origins, origin)
+		}
+	}
+	return p
+}
+
+func (p corsPolicy) logMode() {
+	if p.AllowAll {
+		slog.Warn("ALLOWED_ORIGINS=*, accepting requests from any origin (development mode)")
+	}
+}
+
+// 是否允許跨來源的 origin
+func (p corsPolicy) allowed(origin string) bool {
+	if p.AllowAll {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.Origins {
+		if allowed == origin {
+			return true
+		}
+		// https://*.example.com 符合 https://a.example.com，但不符合 https://example.com
+		if scheme, host, ok := strings.Cut(allowed, "://*."); ok &&
+			strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+host) {
+			return true
+		}
+	}
+	return false
+}
+
+// 同源：Origin 的 host 與請求的 Host 相同
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// WebSocket 的 CheckOrigin
+func (p corsPolicy) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || sameOrigin(r, origin) || p.allowed(origin) {
+		return true
+	}
+	loggerFrom(r.Context()).Warn("WebSocket origin rejected", "origin", origin)
+	return false
+}
+
+// 前端跨來源呼叫 API 時需要帶的 header
+const (
+	corsAllowMethods  = "GET, POST, PUT, DELETE, OPTIONS"
+	corsAllowHeaders  = "Authorization, Content-Type, X-API-Key, X-Request-ID"
+	corsExposeHeaders = "X-Request-ID, Content-Disposition"
+)
+
+// 允許的來源加上 CORS header，並直接回應 preflight (OPTIONS) 請求
+func withCORS(p corsPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || sameOrigin(r, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !p.allowed(origin) {
+			if preflight {
+				writeError(w, http.StatusForbidden, "origin not allowed")
+				return
+			}
+			// 不加 CORS header，瀏覽器會擋下回應
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,89 @@
+// database.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// --- 資料庫連線 ---
+
+// 依 DB_DRIVER 選擇資料庫：sqlite (預設)、postgres、mysql
+// DSN 可直接以 DB_DSN 指定，否則 sqlite 使用 DBPath 下的 queue.db，其他由 DBSERVER / DBNAME / DBLOGIN / DBPASSWORD 組成
+func openDatabase(cfg *Config) (*gorm.DB, error) {
+	driver := cfg.DBDriver
+	dsn := os.Getenv("DB_DSN")
+
+	var dialector gorm.Dialector
+	switch driver {
+	case "", "sqlite":
+		if dsn == "" {
+			dsn = cfg.sqlitePath()
+		}
+		// WAL、busy timeout 等並行設定，見 sqlite.go
+		dialector = sqlite.Open(sqliteDSN(dsn))
+	case "postgres":
+		if dsn == "" {
+			dsn = fmt.Sprintf("host=%s user=%s password=%s dbname=%s sslmode=disable",
+				dbHost("5432"), os.Getenv("DBLOGIN"), os.Getenv("DBPASSWORD"), os.Getenv("DBNAME"))
+		}
+		dialector = postgres.Open(dsn)
+	case "mysql":
+		if dsn == "" {
+			dsn = fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+				os.Getenv("DBLOGIN"), os.Getenv("DBPASSWORD"), dbHost("3306"), os.Getenv("DBNAME"))
+		}
+		dialector = mysql.Open(dsn)
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER: %s", driver)
+	}
+
+	conn, err := gorm.Open(dialector, &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent), // 設定為靜音模式
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := configurePool(conn); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// postgres 用 host=...，mysql 用 host:port；DBSERVER 沒帶 port 時補上預設值
+func dbHost(defaultPort string) string {
+	host := os.Getenv("DBSERVER")
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	if defaultPort == "3306" && !strings.Contains(host, ":") {
+		return host + ":" + defaultPort
+	}
+	return host
+}
+
+// 連線池設定：DB_MAX_OPEN_CONNS、DB_MAX_IDLE_CONNS、DB_CONN_MAX_LIFETIME (例如 30m)
+func configurePool(conn *gorm.DB) error {
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return err
+	}
+	if n := envInt("DB_MAX_OPEN_CONNS"); n > 0 {
+		sqlDB.SetMaxOpenConns(n)
+	}
+	if n := envInt("DB_MAX_IDLE_CONNS"); n > 0 {
+		sqlDB.SetMaxIdleConns(n)
+	}
+	if d, err := time.ParseDuration(os.Getenv("DB_CONN_MAX_LIFETIME")); err == nil && d > 0 {
+		sqlDB.SetConnMaxLifetime(d)
+	}
+	return nil
+}
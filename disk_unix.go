@@ -0,0 +1,15 @@
+//go:build !windows
+
+// disk_unix.go
+package main
+
+import "syscall"
+
+// 目錄所在磁碟的可用空間 (bytes)
+func diskFreeBytes(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+// disk_windows.go
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// 目錄所在磁碟的可用空間 (bytes)
+func diskFreeBytes(dir string) (uint64, error) {
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var freeBytes uint64
+	r, _, err := procGetDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&freeBytes)), 0, 0)
+	if r == 0 {
+		return 0, err
+	}
+	return freeBytes, nil
+}
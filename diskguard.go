@@ -0,0 +1,44 @@
+// diskguard.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// --- 磁碟空間保護 ---
+// worker 認領任務前檢查圖片目錄的可用空間，低於 MIN_FREE_DISK_MB 時自動暫停佇列 (cause=disk)、
+// 推播 queue_state 並記錄稽核事件，避免 python 寫檔失敗時只留下難以理解的錯誤。
+// 空間恢復後自動恢復佇列；管理員手動暫停的佇列不會被自動恢復。DISK_GUARD=false 關閉
+
+// 檢查可用空間並切換暫停狀態，空間足夠 (或無法取得) 時回傳 true
+func (a *App) checkDiskGuard() bool {
+	if !a.Config.DiskGuard {
+		return true
+	}
+	a.diskGuardMutex.Lock()
+	defer a.diskGuardMutex.Unlock()
+	free, err := diskFreeBytes(a.Config.ImageDir)
+	if err != nil {
+		// 目錄還不存在等情況交給 /readyz 回報，不阻擋生成
+		return true
+	}
+	freeMB := free / 1024 / 1024
+	low := freeMB < a.Config.MinFreeDiskMB
+	a.diskLow.Store(low)
+
+	state := a.queueStatus()
+	switch {
+	case low && !state.Paused:
+		reason := fmt.Sprintf("low disk space: %d MB free, %d MB required", freeMB, a.Config.MinFreeDiskMB)
+		slog.Error("Disk space low, queue paused", "dir", a.Config.ImageDir, "free_mb", freeMB, "min_free_mb", a.Config.MinFreeDiskMB)
+		a.audit(context.Background(), "queue.paused", "queue", "", reason)
+		a.setQueuePausedBy(true, reason, "disk")
+	case !low && state.Paused && state.Cause == "disk":
+		slog.Info("Disk space recovered, queue resumed", "free_mb", freeMB)
+		a.audit(context.Background(), "queue.resumed", "queue", "", fmt.Sprintf("disk space recovered: %d MB free", freeMB))
+		a.setQueuePaused(false, "")
+	}
+	return !low
+}
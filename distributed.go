@@ -0,0 +1,176 @@
+// distributed.go
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// --- 分散式 worker ---
+// Web Server 與多個 worker process 可以在不同主機上共用同一個資料庫 (建議 Postgres)。
+// worker 認領任務時記錄 claimed_by 與 lease_expires_at，執行期間定期延長租約；
+// process 當掉時租約不再延長，過期後任何一個 process 都會把任務放回佇列。
+// 每個 process 也在 worker_nodes 表留下心跳，供管理 API 查看。
+
+// 執行中的任務被其他 process 改掉 (取消、強制失敗或租約過期被回收) 時使用的 cause，worker 不寫回結果
+var errLeaseLost = errors.New("task lease lost")
+
+// 各 process 的心跳紀錄
+type WorkerNode struct {
+	ID         string    `gorm:"primaryKey;size:128" json:"id"`
+	Hostname   string    `json:"hostname"`
+	PID        int       `gorm:"column:pid" json:"pid"`
+	Workers    int       `json:"workers"`
+	Running    int       `json:"running"` // 執行中的任務數
+	StartedAt  time.Time `json:"started_at"`
+	LastSeenAt time.Time `gorm:"index" json:"last_seen_at"`
+	Alive      bool      `gorm:"-" json:"alive"` // 回傳時才計算：最近一個租約期間內有心跳
+}
+
+// 租約長度，從環境變數 WORKER_LEASE 取得 (例如 90s)，預設 60 秒；心跳間隔為租約的 1/3
+func workerLease() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("WORKER_LEASE")); err == nil && d >= 5*time.Second {
+		return d
+	}
+	return 60 * time.Second
+}
+
+// 寫在 claimed_by 的識別：WORKER_ID 或主機名稱。
+// 重新啟動時會把自己名下的 Processing 任務放回佇列，同一台主機上執行多個 process 時必須各自設定 WORKER_ID
+func workerID() string {
+	if id := os.Getenv("WORKER_ID"); id != "" {
+		return id
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "localhost"
+	}
+	return host
+}
+
+// 心跳、延長租約與回收過期任務；stopHeartbeat 之後刪除自己的心跳紀錄
+func (a *App) startHeartbeat() {
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	a.stopHeartbeat = func() {
+		stop()
+		<-done
+	}
+
+	hostname, _ := os.Hostname()
+	node := WorkerNode{ID: a.Config.WorkerID, Hostname: hostname, PID: os.Getpid(), Workers: a.Config.WorkerCount, StartedAt: time.Now()}
+	var existing WorkerNode
+	if a.DB.First(&existing, "id = ?", node.ID).Error == nil && existing.PID != node.PID && time.Since(existing.LastSeenAt) < a.Config.WorkerLease {
+		slog.Warn("Another process with the same WORKER_ID is alive", "worker_id", node.ID, "pid", existing.PID, "hostname", existing.Hostname)
+	}
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(a.Config.WorkerLease / 3)
+		defer ticker.Stop()
+		for {
+			a.heartbeat(&node)
+			select {
+			case <-ctx.Done():
+				a.DB.Delete(&WorkerNode{}, "id = ? AND pid = ?", node.ID, node.PID)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (a *App) heartbeat(node *WorkerNode) {
+	now := time.Now()
+	a.runningMutex.Lock()
+	running := make(map[uint]context.CancelCauseFunc, len(a.runningTasks))
+	for id, cancel := range a.runningTasks {
+		running[id] = cancel
+	}
+	a.runningMutex.Unlock()
+
+	// 只有提供 worker 的 process 需要心跳與延長租約
+	if a.Config.WorkerCount > 0 {
+		node.LastSeenAt, node.Running = now, len(running)
+		if err := a.DB.Save(node).Error; err != nil {
+			slog.Warn("Worker heartbeat error", "error", err)
+		}
+		expires := now.Add(a.Config.WorkerLease)
+		for id, cancel := range running {
+			result := a.DB.Model(&Task{}).
+				Where("id = ? AND claimed_by = ? AND status = ?", id, a.Config.WorkerID, StatusProcessing).
+				UpdateColumn("lease_expires_at", expires)
+			if result.Error != nil {
+				slog.Warn("Renew lease error", "task_id", id, "error", result.Error)
+				continue
+			}
+			if result.RowsAffected == 0 {
+				// 任務在其他 process 被取消、強制失敗或回收，停止生成
+				slog.Warn("Task lease lost, stopping generation", "task_id", id)
+				cancel(errLeaseLost)
+			}
+		}
+	}
+	a.reapExpiredLeases(now)
+	a.expireTasks(now)
+	// 很久沒有心跳的紀錄 (沒有正常結束的 process) 一天後清除
+	a.DB.Where("last_seen_at < ?", now.Add(-24*time.Hour)).Delete(&WorkerNode{})
+}
+
+// 把租約過期的 Processing 任務放回佇列
+func (a *App) reapExpiredLeases(now time.Time) {
+	var expired []Task
+	if err := a.DB.Where("status = ? AND lease_expires_at < ?", StatusProcessing, now).Find(&expired).Error; err != nil {
+		slog.Warn("Query expired leases error", "error", err)
+		return
+	}
+	var requeued []uint
+	for _, task := range expired {
+		ok, err := a.transitionTask(context.Background(), task.ID, StatusProcessing, StatusRetrying, "lease_expired", nil, transitionGuard{LeaseExpiredBefore: &now})
+		if err != nil || !ok {
+			continue
+		}
+		requeued = append(requeued, task.ID)
+		slog.Warn("Requeued task from dead worker", "task_id", task.ID, "claimed_by", task.ClaimedBy)
+		task.Status, task.ClaimedBy, task.LeaseExpiresAt = StatusRetrying, "", nil
+		task.QueuedAt, task.StartedAt = &now, nil
+		a.publishTask(context.Background(), EventTaskUpdated, task)
+	}
+	if len(requeued) > 0 {
+		a.signalDispatch(requeued...)
+		a.notifyQueuePositions()
+	}
+}
+
+// 標記不在本 process 執行的 Processing 任務 (取消或強制失敗)；
+// 若還有 worker 在跑，它下次延長租約時會發現並停止生成
+func (a *App) markRemoteTask(task *Task, to TaskStatus, reason string, fields map[string]interface{}) error {
+	ok, err := a.transitionTask(context.Background(), task.ID, StatusProcessing, to, reason, fields)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errTaskNotCancellable
+	}
+	var updated Task
+	if err := a.DB.First(&updated, task.ID).Error; err != nil {
+		return err
+	}
+	*task = updated
+	return nil
+}
+
+// 管理 API 用：所有 process 的心跳紀錄
+func (a *App) workerNodes() ([]WorkerNode, error) {
+	var nodes []WorkerNode
+	if err := a.DB.Order("id asc").Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+	for i := range nodes {
+		nodes[i].Alive = time.Since(nodes[i].LastSeenAt) < a.Config.WorkerLease
+	}
+	return nodes, nil
+}
@@ -0,0 +1,183 @@
+// enhance.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// --- Prompt 擴寫 (LLM) ---
+// 建立任務時帶 "enhance": true，先把 prompt 交給 OpenAI 相容的 chat completions API (PROMPT_ENHANCE_URL，
+// 例如 https://api.openai.com/v1 或 Ollama 的 http://localhost:11434/v1) 擴寫成詳細的描述再排入佇列。
+// 任務的 prompt 為擴寫後的內容 (生成、快取、重新執行都使用它)，使用者原本輸入的記錄在 original_prompt。
+// 擴寫後的 prompt 一樣經過語法檢查與禁用詞檢查；LLM 無法使用時回 503，不建立任務
+
+const (
+	defaultEnhanceTimeout = 30 * time.Second
+	maxEnhancedPromptLen  = 2000
+	defaultEnhanceSystem  = "You expand short image generation prompts into a single detailed prompt for a text-to-image model. " +
+		"Describe the subject, setting, composition, lighting, style and mood in one paragraph of comma-separated phrases. " +
+		"Keep the user's intent and any explicit details. Reply with the prompt only, without quotes or explanations."
+)
+
+type enhanceConfig struct {
+	URL     string // chat completions 的 base URL (PROMPT_ENHANCE_URL)，空字串代表不提供
+	APIKey  string // PROMPT_ENHANCE_API_KEY，以 Bearer 送出
+	Model   string // PROMPT_ENHANCE_MODEL
+	System  string // system prompt (PROMPT_ENHANCE_SYSTEM)
+	Timeout time.Duration
+}
+
+func enhanceFromEnv() enhanceConfig {
+	e := enhanceConfig{
+		URL:     strings.TrimRight(os.Getenv("PROMPT_ENHANCE_URL"), "/"),
+		APIKey:  os.Getenv("PROMPT_ENHANCE_API_KEY"),
+		Model:   envString("PROMPT_ENHANCE_MODEL", "gpt-4o-mini"),
+		System:  envString("PROMPT_ENHANCE_SYSTEM", defaultEnhanceSystem),
+		Timeout: defaultEnhanceTimeout,
+	}
+	if d, err := time.ParseDuration(os.Getenv("PROMPT_ENHANCE_TIMEOUT")); err == nil && d > 0 {
+		e.Timeout = d
+	}
+	return e
+}
+
+func (e enhanceConfig) configured() bool {
+	return e.URL != ""
+}
+
+func (e enhanceConfig) problems() []string {
+	if e.URL == "" {
+		return nil
+	}
+	if u, err := url.Parse(e.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return []string{fmt.Sprintf("PROMPT_ENHANCE_URL=%q: must be an http or https URL", redactedURL(e.URL))}
+	}
+	return nil
+}
+
+// base URL 加上 /chat/completions (已經是完整路徑時不重複)
+func (e enhanceConfig) endpoint() string {
+	if strings.HasSuffix(e.URL, "/chat/completions") {
+		return e.URL
+	}
+	return e.URL + "/chat/completions"
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatCompletionRequest struct {
+	Model       string        `json:"model"`
+	Messages    []chatMessage `json:"messages"`
+	Temperature float64       `json:"temperature"`
+}
+
+type chatCompletionResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// LLM 無法使用，REST 回 503；detail 為錯誤或可翻譯的訊息
+func enhanceError(detail interface{}) error {
+	return newCodedError("unavailable", "prompt enhancement failed: %v", detail)
+}
+
+// 以 LLM 擴寫 prompt
+func (a *App) enhancePrompt(ctx context.Context, prompt string) (string, error) {
+	cfg := a.Config.Enhance
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+	body, _ := json.Marshal(chatCompletionRequest{
+		Model:       cfg.Model,
+		Messages:    []chatMessage{{Role: "system", Content: cfg.System}, {Role: "user", Content: prompt}},
+		Temperature: 0.7,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.endpoint(), bytes.NewReader(body))
+	if err != nil {
+		return "", enhanceError(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", enhanceError(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", enhanceError(err)
+	}
+	var result chatCompletionResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", enhanceError(fmt.Errorf("%s: invalid response", resp.Status))
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := resp.Status
+		if result.Error != nil && result.Error.Message != "" {
+			msg += ": " + result.Error.Message
+		}
+		return "", enhanceError(fmt.Errorf("%s", msg))
+	}
+	if len(result.Choices) == 0 {
+		return "", enhanceError(fmt.Errorf("empty response"))
+	}
+	// 模型有時仍會加上引號
+	enhanced := strings.Trim(strings.TrimSpace(result.Choices[0].Message.Content), "\"'")
+	enhanced = strings.TrimSpace(enhanced)
+	if enhanced == "" {
+		return "", enhanceError(fmt.Errorf("empty response"))
+	}
+	if r := []rune(enhanced); len(r) > maxEnhancedPromptLen {
+		enhanced = string(r[:maxEnhancedPromptLen])
+	}
+	return enhanced, nil
+}
+
+// 建立任務前擴寫有 Enhance 的任務；同一次建立中相同的 prompt (例如批次) 只呼叫一次
+func (a *App) enhancePrompts(ctx context.Context, tasks []Task) error {
+	enhanced := make(map[string]string)
+	for i := range tasks {
+		task := &tasks[i]
+		if !task.Enhance {
+			continue
+		}
+		if !a.Config.Enhance.configured() {
+			return invalidField("enhance", "prompt enhancement is not configured on this server")
+		}
+		prompt, ok := enhanced[task.Prompt]
+		if !ok {
+			started := time.Now()
+			var err error
+			if prompt, err = a.enhancePrompt(ctx, task.Prompt); err != nil {
+				return err
+			}
+			if a.Config.PromptSyntaxCheck {
+				// 模型產生的括號不成對時不排入佇列，由使用者重試
+				if err := checkPromptSyntax("prompt", prompt); err != nil {
+					return enhanceError(newMessage("enhanced prompt is invalid: %v", err))
+				}
+			}
+			loggerFrom(ctx).Info("Prompt enhanced", "original", task.Prompt, "enhanced", prompt, "elapsed", time.Since(started))
+			enhanced[task.Prompt] = prompt
+		}
+		task.OriginalPrompt, task.Prompt = task.Prompt, prompt
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// eventbus.go
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// --- 內部事件匯流排 ---
+// 任務生命週期的事件由建立任務、worker 與管理操作發布，WebSocket / SSE 推播、webhook 與聊天通知、
+// ETA 統計與稽核紀錄各自訂閱，發布端不需要知道有哪些功能在聽 (見 subscribeTaskEvents)。
+// 訂閱者在發布端的 goroutine 內依訂閱順序同步執行，同一個任務的事件順序不變；
+// 不能阻塞 (推播只是放進 Hub 的 channel，webhook 另外開 goroutine 送出)
+
+type TaskEventType string
+
+const (
+	EventTaskCreated  TaskEventType = "task.created"  // 建立任務 (包含快取命中直接完成的任務)
+	EventTaskStarted  TaskEventType = "task.started"  // worker 開始生成
+	EventTaskProgress TaskEventType = "task.progress" // 生成進度與多張輸出的單張完成
+	EventTaskUpdated  TaskEventType = "task.updated"  // 其他還沒結束的狀態變更：核准、放回佇列 (Retrying)
+	EventTaskFinished TaskEventType = "task.finished" // 進入結束狀態 (Completed、Failed、Cancelled)
+)
+
+type TaskEvent struct {
+	Type TaskEventType
+	Ctx  context.Context // 發布端的 context，稽核紀錄以此判斷執行者
+	Task Task
+
+	// task.progress：Percent 為進度；ImageIndex >= 0 時為第幾張圖片完成 (ImageCount 張中)
+	Percent    int
+	ImageIndex int
+	ImageCount int
+	ImageSeed  *int64
+}
+
+type EventBus struct {
+	mutex       sync.RWMutex
+	subscribers map[TaskEventType][]func(TaskEvent)
+}
+
+func newEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[TaskEventType][]func(TaskEvent))}
+}
+
+// 訂閱一或多種事件
+func (b *EventBus) Subscribe(fn func(TaskEvent), types ...TaskEventType) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, t := range types {
+		b.subscribers[t] = append(b.subscribers[t], fn)
+	}
+}
+
+// 發布事件；單一訂閱者 panic 只記 log，不影響發布端與其他訂閱者
+func (b *EventBus) Publish(event TaskEvent) {
+	if event.Ctx == nil {
+		event.Ctx = context.Background()
+	}
+	b.mutex.RLock()
+	subscribers := b.subscribers[event.Type]
+	b.mutex.RUnlock()
+	for _, fn := range subscribers {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					slog.Error("Event subscriber panic", "event", string(event.Type), "task_id", event.Task.ID, "panic", r)
+				}
+			}()
+			fn(event)
+		}()
+	}
+}
+
+// 發布任務事件的捷徑
+func (a *App) publishTask(ctx context.Context, eventType TaskEventType, task Task) {
+	a.Events.Publish(TaskEvent{Type: eventType, Ctx: ctx, Task: task, ImageIndex: -1})
+}
+
+// 任務變更後發布：結束狀態為 task.finished，其他為 task.updated
+func (a *App) publishTaskChange(ctx context.Context, task Task) {
+	if task.Status.finished() {
+		a.publishTask(ctx, EventTaskFinished, task)
+	} else {
+		a.publishTask(ctx, EventTaskUpdated, task)
+	}
+}
+
+// 內建的訂閱者
+func (a *App) subscribeTaskEvents() {
+	// WebSocket / SSE 推播
+	a.Events.Subscribe(func(e TaskEvent) {
+		switch {
+		case e.Type == EventTaskCreated:
+			a.Hub.notify("new_task", e.Task)
+		case e.Type == EventTaskProgress && e.ImageIndex >= 0:
+			a.Hub.notifyImage(&e.Task, e.ImageIndex, e.ImageCount, e.ImageSeed)
+		case e.Type == EventTaskProgress:
+			a.Hub.notifyProgress(&e.Task, e.Percent)
+		default:
+			a.Hub.notifyUpdate(e.Task)
+		}
+	}, EventTaskCreated, EventTaskStarted, EventTaskProgress, EventTaskUpdated, EventTaskFinished)
+
+	// webhook、Discord / Slack 與 Telegram 通知
+	a.Events.Subscribe(func(e TaskEvent) {
+		a.notifyWebhook(e.Task)
+	}, EventTaskFinished)
+
+	// 生成時間統計 (ETA 估算)，快取命中的任務沒有實際生成
+	a.Events.Subscribe(func(e TaskEvent) {
+		t := e.Task
+		if t.Status == StatusCompleted && t.CachedFrom == nil && t.StartedAt != nil && t.FinishedAt != nil {
+			a.genDurations.add(t.FinishedAt.Sub(*t.StartedAt))
+		}
+	}, EventTaskFinished)
+
+	// 稽核紀錄
+	a.Events.Subscribe(func(e TaskEvent) {
+		a.auditTask(e.Ctx, "task.created", &e.Task, e.Task.Prompt)
+	}, EventTaskCreated)
+}
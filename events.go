@@ -0,0 +1,73 @@
+// events.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Server-Sent Events (GET /api/events) ---
+// 給無法使用 WebSocket 的 client (curl、在代理伺服器後面的簡單儀表板)，
+// 與 /ws 共用 Hub，推播相同的 new_task / update / progress / position / queue_state 訊息 (data 為相同的 JSON)
+
+// 沒有訊息時定期送出註解行，避免代理伺服器把閒置連線切斷
+const sseKeepAlive = 30 * time.Second
+
+// GET /api/events?task_ids=1,2 或 ?all=true；都沒有指定時訂閱自己的所有任務
+func (a *App) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	var ids []uint
+	if s := r.URL.Query().Get("task_ids"); s != "" {
+		for _, part := range strings.Split(s, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid task_ids")
+				return
+			}
+			ids = append(ids, uint(id))
+		}
+	}
+	all := len(ids) == 0 || r.URL.Query().Get("all") == "true"
+
+	identity := identityFromRequest(r)
+	client := newWSClient(a.Hub, nil, identity)
+	client.describe(r, "sse")
+	defer client.close()
+	client.subscribe(a.DB, ids, all)
+	logger := loggerFrom(r.Context()).With("client_id", client.id)
+	logger.Info("SSE connected", "user", identity.keyName())
+	defer logger.Info("SSE disconnected")
+	a.Hub.register(client)
+	defer a.Hub.unregister(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // 關閉 nginx 的緩衝
+	w.WriteHeader(http.StatusOK)
+	client.writeJSON(WSQueueState{Type: "queue_state", QueueState: a.queueStatus()})
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.done:
+			return
+		case msg := <-client.send:
+			fmt.Fprintf(w, "data: %s\n\n", msg)
+			flusher.Flush()
+		case <-ticker.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// expiry.go
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// --- 任務過期 ---
+// 佇列塞住或暫停很久時，排隊中的任務可能在好幾天後才生成，這時結果通常已經沒有用。
+// 建立任務時可以指定 expires_at，沒有指定時為進入佇列的時間 (排程任務為排程時間) 加上 TASK_EXPIRY；
+// 到時間還沒被 worker 認領的任務 (AwaitingApproval、Pending、Retrying) 不再認領，
+// 由每個 process 的心跳 (見 distributed.go) 標記為 Failed (fail_reason=expired)。已經在執行的任務不受影響
+
+// 沒有指定 expires_at 的任務的有效期間，從環境變數 TASK_EXPIRY 取得，0 代表不過期
+func taskExpiry() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("TASK_EXPIRY"))
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// 過期時間必須晚於現在與排程時間
+func validateExpiry(task *Task, now time.Time) error {
+	if task.ExpiresAt == nil {
+		return nil
+	}
+	if !task.ExpiresAt.After(now) {
+		return invalidField("expires_at", "expires_at must be in the future")
+	}
+	if task.ScheduledAt != nil && !task.ExpiresAt.After(*task.ScheduledAt) {
+		return invalidField("expires_at", "expires_at must be after scheduled_at")
+	}
+	return nil
+}
+
+// 沒有指定時套用 TASK_EXPIRY，queuedAt 為進入佇列的時間
+func (a *App) applyDefaultExpiry(task *Task, queuedAt time.Time) {
+	if task.ExpiresAt == nil && a.Config.TaskExpiry > 0 {
+		expiresAt := queuedAt.Add(a.Config.TaskExpiry)
+		task.ExpiresAt = &expiresAt
+	}
+}
+
+// 還沒開始執行的任務是否已經過期
+func (t *Task) expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !t.ExpiresAt.After(now)
+}
+
+// 把過期的任務標記為 Failed；多個 process 同時執行時以帶條件的 UPDATE 確保只處理一次
+func (a *App) expireTasks(now time.Time) {
+	var expired []Task
+	statuses := append([]TaskStatus{StatusAwaitingApproval}, queuedStatuses...)
+	if err := a.DB.Where("status IN ? AND expires_at <= ?", statuses, now).Find(&expired).Error; err != nil {
+		slog.Warn("Query expired tasks error", "error", err)
+		return
+	}
+	for _, task := range expired {
+		fields := map[string]interface{}{"fail_reason": "expired", "error_message": "task expired before it started"}
+		ok, err := a.transitionTask(context.Background(), task.ID, task.Status, StatusFailed, "expired", fields)
+		if err != nil || !ok {
+			continue
+		}
+		slog.Info("Task expired", "task_id", task.ID, "status", task.Status, "expires_at", task.ExpiresAt)
+		if err := a.DB.First(&task, task.ID).Error; err != nil {
+			continue
+		}
+		a.publishTask(context.Background(), EventTaskFinished, task)
+	}
+	if len(expired) > 0 {
+		a.notifyQueuePositions()
+	}
+}
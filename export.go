@@ -0,0 +1,484 @@
+// export.go
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- 匯出 / 匯入任務紀錄 ---
+// 匯出檔為 zip：manifest.json、tasks.jsonl (每行一個任務) 與 images/ 下的圖片檔，
+// 用於搬到另一台伺服器，或在清除 SQLite 檔案之前備份
+
+const exportVersion = 1
+
+type exportManifest struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	User       string    `json:"user"`
+	Tasks      int       `json:"tasks"`
+	Images     bool      `json:"images"` // false 代表只有任務紀錄 (?images=false)
+}
+
+// 匯入結果
+type importResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"` // 只匯入已完成的任務，其他狀態略過
+	Images   int `json:"images"`
+}
+
+// 匯入檔大小上限 (MB)，從環境變數 MAX_IMPORT_MB 取得，預設 2048
+func maxImportMB() int {
+	if n := envInt("MAX_IMPORT_MB"); n > 0 {
+		return n
+	}
+	return 2048
+}
+
+// 解壓後的大小限制，避免 zip bomb 佔滿磁碟：每個檔案不超過 MAX_IMPORT_MB，
+// 所有檔案合計不超過 maxImportExpansion 倍 (壓縮檔本身已受 MAX_IMPORT_MB 限制)
+const maxImportExpansion = 2
+
+type importBudget struct {
+	perFile   int64
+	remaining int64
+}
+
+func (a *App) newImportBudget() *importBudget {
+	limit := int64(a.Config.MaxImportMB) << 20
+	return &importBudget{perFile: limit, remaining: limit * maxImportExpansion}
+}
+
+// 開啟壓縮檔內的檔案，解壓超過限制時讀取會回傳 payload_too_large 錯誤
+func (b *importBudget) open(f *zip.File) (io.ReadCloser, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &importReader{ReadCloser: rc, r: io.LimitReader(rc, b.perFile+1), name: f.Name, budget: b}, nil
+}
+
+type importReader struct {
+	io.ReadCloser
+	r      io.Reader
+	name   string
+	n      int64
+	budget *importBudget
+}
+
+func (r *importReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	r.budget.remaining -= int64(n)
+	switch {
+	case r.n > r.budget.perFile:
+		return n, newCodedError("payload_too_large", "%s must not exceed %d MB when decompressed", r.name, r.budget.perFile>>20)
+	case r.budget.remaining < 0:
+		return n, newCodedError("payload_too_large", "import must not exceed %d MB when decompressed", r.budget.perFile*maxImportExpansion>>20)
+	}
+	return n, err
+}
+
+// GET /api/export?images=false
+func (a *App) apiExport(w http.ResponseWriter, r *http.Request) {
+	identity := identityFromRequest(r)
+	var tasks []Task
+	if err := identity.taskScope(a.DB).Order("id asc").Find(&tasks).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := a.loadTaskListImages(tasks); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := a.loadTaskListTags(tasks); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	withImages := r.URL.Query().Get("images") != "false"
+
+	name := fmt.Sprintf("mcpzimage-export-%s-%s.zip", identity.keyName(), time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	w.WriteHeader(http.StatusOK)
+
+	// 已經開始傳送，之後的錯誤只能記 log
+	logger := loggerFrom(r.Context())
+	if err := a.writeExport(r, zip.NewWriter(w), identity, tasks, withImages); err != nil {
+		logger.Error("Export error", "error", err)
+		return
+	}
+	logger.Info("Export finished", "user", identity.keyName(), "tasks", len(tasks), "images", withImages)
+}
+
+func (a *App) writeExport(r *http.Request, zw *zip.Writer, identity *Identity, tasks []Task, withImages bool) error {
+	manifest := exportManifest{
+		Version:    exportVersion,
+		ExportedAt: time.Now().UTC(),
+		User:       identity.keyName(),
+		Tasks:      len(tasks),
+		Images:     withImages,
+	}
+	f, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(manifest); err != nil {
+		return err
+	}
+
+	f, err = zw.Create("tasks.jsonl")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for i := range tasks {
+		if err := enc.Encode(tasks[i]); err != nil {
+			return err
+		}
+	}
+
+	if withImages {
+		// 快取命中的任務共用圖片，同一個檔案只放一次
+		written := make(map[string]bool)
+		for i := range tasks {
+			for _, key := range tasks[i].imageKeys() {
+				if written[key] {
+					continue
+				}
+				written[key] = true
+				if err := a.exportImage(r, zw, &tasks[i], key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return zw.Close()
+}
+
+// 找不到的檔案 (例如舊任務沒有中繼資料 JSON) 直接略過
+func (a *App) exportImage(r *http.Request, zw *zip.Writer, task *Task, key string) error {
+	src, modTime, err := a.storageFor(task).Open(r.Context(), key)
+	if err != nil {
+		return nil
+	}
+	defer src.Close()
+	// PNG / JPEG 已經壓縮過，不再壓縮
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: "images/" + filepath.Base(key), Method: zip.Store, Modified: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, src)
+	return err
+}
+
+// POST /api/import (body 為 zip，或 multipart/form-data 的 file 欄位)
+// 匯入的任務屬於呼叫者，取得新的 ID；圖片以新的檔名存進儲存後端，不會覆蓋既有檔案
+func (a *App) apiImport(w http.ResponseWriter, r *http.Request) {
+	limit := int64(a.Config.MaxImportMB) << 20
+	r.Body = http.MaxBytesReader(w, r.Body, limit+1<<20)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			a.writeImportError(w, err)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	// zip 需要隨機存取，先存成暫存檔
+	tmp, err := os.CreateTemp("", "mcpzimage-import-*.zip")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, body)
+	if err != nil {
+		a.writeImportError(w, err)
+		return
+	}
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid zip file")
+		return
+	}
+
+	result, err := a.importArchive(r, zr, identityFromRequest(r))
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	loggerFrom(r.Context()).Info("Import finished", "user", identityFromRequest(r).keyName(),
+		"imported", result.Imported, "skipped", result.Skipped, "images", result.Images)
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (a *App) writeImportError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeErrorf(w, http.StatusRequestEntityTooLarge, "import must not exceed %d MB", a.Config.MaxImportMB)
+		return
+	}
+	writeError(w, http.StatusBadRequest, "invalid import body")
+}
+
+func (a *App) importArchive(r *http.Request, zr *zip.Reader, identity *Identity) (*importResult, error) {
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	budget := a.newImportBudget()
+	var manifest exportManifest
+	if err := readZipJSON(budget, files["manifest.json"], &manifest); err != nil {
+		if errorCode(err) == "payload_too_large" {
+			return nil, err
+		}
+		return nil, invalidf("manifest.json is missing or invalid")
+	}
+	if manifest.Version != exportVersion {
+		return nil, invalidf("unsupported export version %d", manifest.Version)
+	}
+	tasks, err := readZipTasks(budget, files["tasks.jsonl"])
+	if err != nil {
+		return nil, err
+	}
+
+	result := &importResult{}
+	// 匯入的任務屬於目前選擇的工作區 (沒有選擇時為個人任務)，不採用匯入檔中的 workspace_id；
+	// 圖片存在該工作區的前綴下，與 exportImage 讀取的位置相同
+	var workspaceID *uint
+	if id := identity.workspaceID(); id != 0 {
+		workspaceID = &id
+	}
+	storage := a.storageFor(&Task{WorkspaceID: workspaceID})
+	// 新檔名加上這次匯入的前綴，同一個檔案被多個任務引用時只存一次
+	b := make([]byte, 4)
+	rand.Read(b)
+	prefix := "import_" + hex.EncodeToString(b) + "_"
+	renamed := make(map[string]string)
+	urls := make(map[string]string)
+	committed := false
+	defer func() {
+		// 失敗時移除已經存進去的圖片
+		if committed {
+			return
+		}
+		for _, key := range renamed {
+			if key != "" {
+				storage.Delete(r.Context(), key)
+			}
+		}
+	}()
+	storeImage := func(key string) (string, string, error) {
+		if key == "" {
+			return "", "", nil
+		}
+		if newKey, ok := renamed[key]; ok {
+			return newKey, urls[newKey], nil
+		}
+		f := files["images/"+filepath.Base(key)]
+		if f == nil {
+			renamed[key] = ""
+			return "", "", nil
+		}
+		newKey := prefix + filepath.Base(key)
+		url, err := a.importImage(r, storage, budget, f, newKey)
+		if err != nil {
+			return "", "", err
+		}
+		renamed[key], urls[newKey] = newKey, url
+		result.Images++
+		return newKey, url, nil
+	}
+
+	var imported []Task
+	oldIDs := make(map[uint]int) // 舊 ID -> imported 的索引，用來改寫 cached_from
+	for _, task := range tasks {
+		// 建立任務只能是 Completed (見 taskstate.go)，失敗、取消與還沒結束的任務不匯入
+		if task.Status != StatusCompleted {
+			result.Skipped++
+			continue
+		}
+		// 中繼資料 JSON 跟著原圖改名 (imageKeys 由原圖檔名推算)
+		if task.ImagePath != "" {
+			if _, _, err := storeImage(metadataName(task.ImagePath)); err != nil {
+				return nil, err
+			}
+		}
+		image, url, err := storeImage(task.ImagePath)
+		if err != nil {
+			return nil, err
+		}
+		thumb, _, err := storeImage(task.ThumbnailPath)
+		if err != nil {
+			return nil, err
+		}
+		upscaled, _, err := storeImage(task.UpscaledPath)
+		if err != nil {
+			return nil, err
+		}
+		// 多張輸出的其他圖片同樣改名
+		for i := range task.Images {
+			img := &task.Images[i]
+			if img.ImagePath != "" {
+				if _, _, err := storeImage(metadataName(img.ImagePath)); err != nil {
+					return nil, err
+				}
+			}
+			if img.ImagePath, img.ImageURL, err = storeImage(img.ImagePath); err != nil {
+				return nil, err
+			}
+			if img.ThumbnailPath, _, err = storeImage(img.ThumbnailPath); err != nil {
+				return nil, err
+			}
+			if img.UpscaledPath, _, err = storeImage(img.UpscaledPath); err != nil {
+				return nil, err
+			}
+			img.StorageKey = img.ImagePath
+		}
+		oldIDs[task.ID] = len(imported)
+		task.ID = 0
+		task.UserID = identity.userID()
+		task.CreatedBy = identity.keyName()
+		task.WorkspaceID = workspaceID
+		// 隔離、內容檢查結果、垃圾桶與 worker 的租約不採用匯入檔的內容
+		task.Quarantined, task.SafetyVerdict, task.SafetyScore, task.SafetyLabels = false, "", nil, ""
+		task.DeletedAt = gorm.DeletedAt{}
+		task.ClaimedBy, task.LeaseExpiresAt, task.Attempts = "", nil, 0
+		task.ImagePath, task.ThumbnailPath, task.UpscaledPath = image, thumb, upscaled
+		task.StorageKey, task.ImageURL = image, url
+		// 快取鍵要在清掉 img2img 的原始圖片之前計算
+		task.CacheKey = resultCacheKey(&task)
+		// 排程、批次、比較、webhook、Telegram 聊天室與上傳的原始圖片不跟著搬移
+		task.ScheduledAt, task.ExpiresAt, task.BatchID, task.ComparisonID, task.CallbackURL = nil, nil, nil, nil, ""
+		task.TelegramChatID, task.TelegramMessageID = nil, 0
+		task.InitImagePath, task.MaskPath, task.ControlImagePath = "", "", ""
+		imported = append(imported, task)
+	}
+	if len(imported) == 0 {
+		return result, nil
+	}
+
+	// cached_from 與 parent_task_id 先清掉，建立後再換成新的 ID (來源不在匯入檔內時保持空白)
+	cachedFrom := make([]*uint, len(imported))
+	parents := make([]*uint, len(imported))
+	for i := range imported {
+		cachedFrom[i], imported[i].CachedFrom = imported[i].CachedFrom, nil
+		parents[i], imported[i].ParentTaskID = imported[i].ParentTaskID, nil
+	}
+	// 任務、圖片與標籤在同一個交易中寫入，並記錄建立的轉換
+	if err := a.Tasks.Import(r.Context(), imported); err != nil {
+		return nil, err
+	}
+	committed = true
+	// 任務已經寫入，關聯改寫失敗時只記 log，關聯保持空白
+	for _, ref := range []struct {
+		column string
+		old    []*uint
+	}{{"cached_from", cachedFrom}, {"parent_task_id", parents}} {
+		for i, old := range ref.old {
+			if old == nil {
+				continue
+			}
+			if j, ok := oldIDs[*old]; ok {
+				if err := a.DB.Model(&Task{}).Where("id = ?", imported[i].ID).Update(ref.column, imported[j].ID).Error; err != nil {
+					loggerFrom(r.Context()).Warn("Import task reference error", "task_id", imported[i].ID, "column", ref.column, "error", err)
+				}
+			}
+		}
+	}
+	result.Imported = len(imported)
+	return result, nil
+}
+
+// 解壓到圖片目錄再交給儲存後端，與生成完成時的流程相同
+func (a *App) importImage(r *http.Request, storage Storage, budget *importBudget, f *zip.File, key string) (string, error) {
+	src, err := budget.open(f)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	if err := os.MkdirAll(a.Config.ImageDir, os.ModePerm); err != nil {
+		return "", err
+	}
+	localPath, _ := filepath.Abs(filepath.Join(a.Config.ImageDir, key))
+	dst, err := os.Create(localPath)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(localPath)
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		return "", err
+	}
+	url, err := storage.Put(r.Context(), key, localPath)
+	if err != nil {
+		loggerFrom(r.Context()).Warn("Import image upload failed", "key", key, "error", err)
+		os.Remove(localPath)
+		return "", err
+	}
+	return url, nil
+}
+
+func readZipJSON(budget *importBudget, f *zip.File, v interface{}) error {
+	if f == nil {
+		return errors.New("file not found")
+	}
+	rc, err := budget.open(f)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return json.NewDecoder(rc).Decode(v)
+}
+
+func readZipTasks(budget *importBudget, f *zip.File) ([]Task, error) {
+	if f == nil {
+		return nil, invalidf("tasks.jsonl is missing")
+	}
+	rc, err := budget.open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var tasks []Task
+	scanner := bufio.NewScanner(rc)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var task Task
+		if err := json.Unmarshal(scanner.Bytes(), &task); err != nil {
+			return nil, invalidf("tasks.jsonl line %d: %v", line, err)
+		}
+		tasks = append(tasks, task)
+	}
+	if err := scanner.Err(); err != nil {
+		if errorCode(err) == "payload_too_large" {
+			return nil, err
+		}
+		return nil, invalidf("tasks.jsonl: %v", err)
+	}
+	return tasks, nil
+}
@@ -0,0 +1,189 @@
+// gallery.go
+package main
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- 圖庫查詢 ---
+
+// 分頁結果
+type pageResponse struct {
+	Items    interface{} `json:"items"`
+	Page     int         `json:"page"`
+	PageSize int         `json:"page_size"`
+	Total    int64       `json:"total"`
+}
+
+// 日期可用 RFC3339 或 2006-01-02
+func parseDateParam(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// 讀取正整數 query 參數，未提供時回傳預設值
+func intParam(r *http.Request, name string, def int) (int, bool) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// GET /api/images/{id}/file?size=thumb|full|upscaled&index=1
+// index 為多張輸出的第幾張 (從 0 起算，預設 0)
+// 只有任務擁有者可以讀取；檔名包含時間戳記不會被覆寫，可以長時間快取
+func (a *App) apiImageFile(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadTaskFromPath(w, r)
+	if !ok {
+		return
+	}
+	if task.Quarantined {
+		writeError(w, http.StatusForbidden, "image quarantined by content filter")
+		return
+	}
+	a.serveTaskImage(w, r, task, "")
+}
+
+// 依 size 與 index 參數回傳任務的圖片檔，prefix 為儲存 key 的前綴 (隔離區為 quarantine/)
+func (a *App) serveTaskImage(w http.ResponseWriter, r *http.Request, task *Task, prefix string) {
+	index := 0
+	if s := r.URL.Query().Get("index"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid index")
+			return
+		}
+		index = n
+	}
+	if index > 0 {
+		if err := a.loadTaskImages(task); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	img, ok := task.image(index)
+	if !ok {
+		writeError(w, http.StatusNotFound, "image not available")
+		return
+	}
+	name := img.ImagePath
+	switch r.URL.Query().Get("size") {
+	case "", "full":
+	case "thumb":
+		// 沒有縮圖時退回原圖
+		if img.ThumbnailPath != "" {
+			name = img.ThumbnailPath
+		}
+	case "upscaled":
+		name = img.UpscaledPath
+	default:
+		writeError(w, http.StatusBadRequest, "size must be thumb, full or upscaled")
+		return
+	}
+	if name == "" {
+		writeError(w, http.StatusNotFound, "image not available")
+		return
+	}
+
+	f, modTime, err := a.storageFor(task).Open(r.Context(), prefix+name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "image file not found")
+		return
+	}
+	defer f.Close()
+
+	// 原圖與放大的圖片以任務記錄的格式為準 (見 imageformat.go)，縮圖與舊任務依副檔名
+	if ct := mime.TypeByExtension(filepath.Ext(name)); task.ContentType != "" && (name == img.ImagePath || name == img.UpscaledPath) {
+		w.Header().Set("Content-Type", task.ContentType)
+	} else if ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, name, modTime, f)
+}
+
+// GET /api/images?status=Completed&page=1&page_size=50&q=sunset&from=2025-01-01&to=2025-02-01&tag=a,b&collection=1
+func (a *App) apiListImages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page, ok := intParam(r, "page", 1)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid page")
+		return
+	}
+	pageSize, ok := intParam(r, "page_size", 50)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid page_size")
+		return
+	}
+	if pageSize > 200 {
+		pageSize = 200
+	}
+
+	q := identityFromRequest(r).taskScope(a.DB.Model(&Task{}))
+
+	// 預設只列出已完成 (有圖片) 的任務，status=all 代表不篩選
+	status := query.Get("status")
+	if status == "" {
+		status = string(StatusCompleted)
+	}
+	if status != "all" {
+		q = q.Where("status = ?", status)
+	}
+	if text := strings.TrimSpace(query.Get("q")); text != "" {
+		q = q.Where("prompt LIKE ?", "%"+text+"%")
+	}
+	if s := query.Get("from"); s != "" {
+		from, err := parseDateParam(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid from date")
+			return
+		}
+		q = q.Where("created_at >= ?", from)
+	}
+	if s := query.Get("to"); s != "" {
+		to, err := parseDateParam(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid to date")
+			return
+		}
+		q = q.Where("created_at < ?", to)
+	}
+	q, err := filterTasks(q, r)
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var tasks []Task
+	if err := q.Order("created_at desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&tasks).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := a.loadTaskListImages(tasks); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := a.loadTaskListTags(tasks); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, pageResponse{Items: tasks, Page: page, PageSize: pageSize, Total: total})
+}
@@ -0,0 +1,267 @@
+// generator.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// --- 生成後端 ---
+
+// 一次生成請求，後端需把圖片寫到 OutputPath；Params.NumImages > 1 時第 i 張寫到 imageOutputPath(OutputPath, i)
+type GenerateRequest struct {
+	TaskID     uint
+	Prompt     string
+	Params     GenerationParams
+	OutputPath string // 絕對路徑
+	// img2img 的原始圖片絕對路徑，空字串代表 text-to-image
+	InitImagePath string
+	// inpainting 遮罩絕對路徑，需搭配 InitImagePath
+	MaskPath string
+	// ControlNet 條件圖絕對路徑，類型與強度在 Params (見 controlnet.go)
+	ControlImagePath string
+	// 模型路徑 (MODEL_DIR 下的檔案或 manifest 的 path)，空字串代表腳本預設模型
+	ModelPath string
+	// LORA_DIR / EMBEDDING_DIR 下的檔案與權重 (見 lora.go)
+	LoRAs []loraPath
+	// 回報進度 (0-100)，可為 nil
+	Progress func(percent int)
+	// 每寫完一張圖片時回報 (index 從 0 起算，seed 為這張實際使用的值，可為 nil)，可為 nil
+	ImageReady func(index int, seed *int64)
+	// 回報生成後端的一行輸出 (不含進度等 JSON 協定行)，可為 nil
+	Log func(line string)
+}
+
+// 回報進度，忽略未設定 callback 的情況
+func (r GenerateRequest) reportProgress(percent int) {
+	if r.Progress != nil {
+		r.Progress(percent)
+	}
+}
+
+// 回報完成一張圖片，忽略未設定 callback 的情況
+func (r GenerateRequest) reportImage(index int, seed *int64) {
+	if r.ImageReady != nil {
+		r.ImageReady(index, seed)
+	}
+}
+
+// 回報一行輸出，忽略空白行、JSON 協定行與未設定 callback 的情況
+func (r GenerateRequest) reportLog(line []byte) {
+	line = bytes.TrimSpace(line)
+	if r.Log != nil && len(line) > 0 && line[0] != '{' {
+		r.Log(string(line))
+	}
+}
+
+type GenerateResult struct {
+	OutputPath string
+	Seed       *int64 // 後端回報實際使用的 seed (第 0 張)，沒有回報時為 nil
+}
+
+// 生成失敗，Log 保留後端輸出 (python 的 stdout/stderr) 供使用者查看
+type GenerationError struct {
+	Message string
+	Log     string
+}
+
+func (e *GenerationError) Error() string {
+	if e.Log == "" {
+		return e.Message
+	}
+	return e.Message + ", log: " + e.Log
+}
+
+// 生成後端介面，ctx 取消時必須中止生成
+type Generator interface {
+	Generate(ctx context.Context, req GenerateRequest) (*GenerateResult, error)
+}
+
+// 需要常駐 process 的後端 (sidecar)：worker 啟動前預先載入模型，關機時停止
+type lifecycleGenerator interface {
+	Start() error
+	Close() error
+}
+
+// 依設定 GENERATOR 選擇後端：python (預設)、sidecar (常駐 python)、http、mock
+// python 的執行環境見 python.go (PYTHON_PATH、PYTHON_VENV、ZIMAGE_DIR 等)；
+// devices 為 GPU 排程使用的 GPU，sidecar 每張 GPU 各啟動一個
+func newGenerator(cfg *Config, devices []string) (Generator, error) {
+	switch cfg.Generator {
+	case "", "python":
+		return &pythonGenerator{
+			Runtime: cfg.Python,
+			Script:  "run_z_image.py",
+		}, nil
+	case "sidecar":
+		script := os.Getenv("SIDECAR_SCRIPT")
+		if script == "" {
+			script = "run_z_image.py"
+		}
+		if len(devices) == 0 {
+			return &sidecarGenerator{Runtime: cfg.Python, Script: script}, nil
+		}
+		gens := &deviceGenerators{byDevice: make(map[string]*sidecarGenerator), order: devices}
+		for _, device := range devices {
+			gens.byDevice[device] = &sidecarGenerator{Runtime: cfg.Python, Script: script, Device: device}
+		}
+		return gens, nil
+	case "http":
+		url := os.Getenv("GENERATOR_URL")
+		if url == "" {
+			return nil, fmt.Errorf("GENERATOR_URL is required for http generator")
+		}
+		return newHTTPGenerator(url), nil
+	case "mock":
+		delay, _ := time.ParseDuration(os.Getenv("GENERATOR_MOCK_DELAY"))
+		return &mockGenerator{Delay: delay}, nil
+	}
+	return nil, fmt.Errorf("unknown generator: %s", cfg.Generator)
+}
+
+// 圖片存放目錄，從環境變數 IMAGE_DIR 取得；不放在 DocumentRoot 下，
+// 避免被靜態檔案伺服器直接公開，一律透過 /api/images/{id}/file 存取
+func imageDir() string {
+	if dir := os.Getenv("IMAGE_DIR"); dir != "" {
+		return dir
+	}
+	return "www/data/images"
+}
+
+// 在 outputDir 產生輸出檔名與絕對路徑
+func newOutputPath(outputDir string, taskID uint) (string, string) {
+	fileName := fmt.Sprintf("task_%d_%d.png", taskID, time.Now().Unix())
+	os.MkdirAll(outputDir, os.ModePerm)
+
+	// 使用絕對路徑
+	absOutputDir, _ := filepath.Abs(outputDir)
+	return fileName, filepath.Join(absOutputDir, fileName)
+}
+
+// run_z_image.py 的 CLI 參數 (不含腳本名稱)，sidecar 也以相同格式傳送
+func pythonArgs(req GenerateRequest) []string {
+	args := []string{"--prompt", req.Prompt, "--output", req.OutputPath}
+	args = append(args, req.Params.Args()...)
+	if req.InitImagePath != "" {
+		args = append(args, "--init-image", req.InitImagePath)
+	}
+	if req.MaskPath != "" {
+		args = append(args, "--mask", req.MaskPath)
+	}
+	if req.ControlImagePath != "" {
+		args = append(args, "--control-image", req.ControlImagePath)
+	}
+	if req.ModelPath != "" {
+		args = append(args, "--model", req.ModelPath)
+	}
+	args = append(args, loraArgs(req.LoRAs)...)
+	return args
+}
+
+// --- 本機 Z-Image python 腳本 ---
+type pythonGenerator struct {
+	Runtime pythonRuntime
+	Script  string
+}
+
+func (g *pythonGenerator) Generate(ctx context.Context, req GenerateRequest) (*GenerateResult, error) {
+	// 工作目錄已設為 Runtime.Dir，腳本路徑不可再加上目錄
+	// 使用 CommandContext，任務被取消時會直接 kill python process
+	args := append([]string{g.Script}, pythonArgs(req)...)
+	cmd := g.Runtime.command(ctx, gpuFrom(ctx), args...)
+
+	// stdout 與 stderr 合併，邊讀邊解析進度
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("python error: %v", err)
+	}
+
+	var output bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanProgress(pr, &output, req, loggerFrom(ctx))
+	}()
+
+	err := cmd.Wait()
+	pw.Close()
+	<-done
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &GenerationError{Message: fmt.Sprintf("python error: %v", err), Log: output.String()}
+	}
+	return &GenerateResult{OutputPath: req.OutputPath, Seed: parseSeed(output.Bytes())}, nil
+}
+
+// --- 測試用後端：不需要 GPU，產生單色圖片 ---
+type mockGenerator struct {
+	Delay time.Duration
+}
+
+func (g *mockGenerator) Generate(ctx context.Context, req GenerateRequest) (*GenerateResult, error) {
+	// 分 10 段等待，模擬逐步回報進度
+	for i := 1; i <= 10; i++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(g.Delay / 10):
+		}
+		req.reportLog([]byte(fmt.Sprintf("mock step %d/10", i)))
+		req.reportProgress(i * 10)
+	}
+
+	width, height := req.Params.Width, req.Params.Height
+	if width == 0 {
+		width = 512
+	}
+	if height == 0 {
+		height = 512
+	}
+	seed := req.Params.Seed
+	if seed == nil {
+		n := rand.Int64N(1 << 32)
+		seed = &n
+	}
+	// 用 task id 決定顏色，方便在前端分辨；多張時每張顏色不同，seed 依序加 1
+	for i := 0; i < req.Params.imageCount(); i++ {
+		n := uint8(req.TaskID) + uint8(i)*29
+		c := color.RGBA{n * 47, n * 91, n * 13, 255}
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				img.Set(x, y, c)
+			}
+		}
+		if err := writePNG(imageOutputPath(req.OutputPath, i), img); err != nil {
+			return nil, err
+		}
+		imageSeed := *seed + int64(i)
+		req.reportImage(i, &imageSeed)
+	}
+	return &GenerateResult{OutputPath: req.OutputPath, Seed: seed}, nil
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
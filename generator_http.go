@@ -0,0 +1,182 @@
+// generator_http.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// --- 遠端 HTTP 生成後端 ---
+// 使用 Automatic1111 相容的 /sdapi/v1/txt2img 與 img2img API (A1111、Forge、SD.Next 等)
+type httpGenerator struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func newHTTPGenerator(baseURL string) *httpGenerator {
+	return &httpGenerator{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Client:  &http.Client{},
+	}
+}
+
+type txt2imgRequest struct {
+	Prompt         string  `json:"prompt"`
+	NegativePrompt string  `json:"negative_prompt,omitempty"`
+	Width          int     `json:"width,omitempty"`
+	Height         int     `json:"height,omitempty"`
+	Steps          int     `json:"steps,omitempty"`
+	CfgScale       float64 `json:"cfg_scale,omitempty"`
+	Seed           int64   `json:"seed"`
+	BatchSize      int     `json:"batch_size,omitempty"` // 多張輸出
+	// 以下只用於 img2img
+	InitImages        []string `json:"init_images,omitempty"` // base64
+	DenoisingStrength float64  `json:"denoising_strength,omitempty"`
+	Mask              string   `json:"mask,omitempty"` // base64，inpainting 用
+	// 指定 checkpoint 時以 sd_model_checkpoint 切換模型
+	OverrideSettings map[string]interface{} `json:"override_settings,omitempty"`
+}
+
+type txt2imgResponse struct {
+	Images []string `json:"images"` // base64 PNG
+	Info   string   `json:"info"`   // JSON 字串，包含實際使用的 seed
+}
+
+func (g *httpGenerator) Generate(ctx context.Context, req GenerateRequest) (*GenerateResult, error) {
+	// 建立任務時已經檢查 (見 controlnet.go)，這裡擋下切換生成後端前排入的任務
+	if req.ControlImagePath != "" {
+		return nil, &GenerationError{Message: "controlnet is not supported by the http generator"}
+	}
+	body := txt2imgRequest{
+		Prompt:         loraPrompt(req.Prompt, req.LoRAs),
+		NegativePrompt: req.Params.NegativePrompt,
+		Width:          req.Params.Width,
+		Height:         req.Params.Height,
+		Steps:          req.Params.Steps,
+		CfgScale:       req.Params.GuidanceScale,
+		Seed:           -1, // A1111 以 -1 代表隨機
+	}
+	if count := req.Params.imageCount(); count > 1 {
+		body.BatchSize = count
+	}
+	if req.Params.Seed != nil {
+		body.Seed = *req.Params.Seed
+	}
+	if req.Params.Model != "" {
+		body.OverrideSettings = map[string]interface{}{"sd_model_checkpoint": req.Params.Model}
+	}
+	endpoint := "/sdapi/v1/txt2img"
+	if req.InitImagePath != "" {
+		data, err := os.ReadFile(req.InitImagePath)
+		if err != nil {
+			return nil, err
+		}
+		endpoint = "/sdapi/v1/img2img"
+		body.InitImages = []string{base64.StdEncoding.EncodeToString(data)}
+		body.DenoisingStrength = req.Params.Strength
+		if req.MaskPath != "" {
+			mask, err := os.ReadFile(req.MaskPath)
+			if err != nil {
+				return nil, err
+			}
+			body.Mask = base64.StdEncoding.EncodeToString(mask)
+		}
+	}
+	payload, _ := json.Marshal(body)
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, g.BaseURL+endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	// 生成期間輪詢 /sdapi/v1/progress 回報進度
+	if req.Progress != nil {
+		pollCtx, stopPoll := context.WithCancel(ctx)
+		defer stopPoll()
+		go g.pollProgress(pollCtx, req.reportProgress)
+	}
+
+	resp, err := g.Client.Do(httpReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("http generator error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("http generator status %d: %s", resp.StatusCode, string(msg))
+	}
+
+	var result txt2imgResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("http generator invalid response: %v", err)
+	}
+	count := req.Params.imageCount()
+	if len(result.Images) < count {
+		return nil, fmt.Errorf("http generator returned %d of %d images", len(result.Images), count)
+	}
+	var info struct {
+		Seed     *int64  `json:"seed"`
+		AllSeeds []int64 `json:"all_seeds"`
+	}
+	json.Unmarshal([]byte(result.Info), &info)
+	// 多張時 A1111 可能在最前面多回傳一張拼接的 grid，取最後 count 張
+	images := result.Images[len(result.Images)-count:]
+	for i, encoded := range images {
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("http generator invalid image: %v", err)
+		}
+		if err := os.WriteFile(imageOutputPath(req.OutputPath, i), data, 0644); err != nil {
+			return nil, err
+		}
+		seed := info.Seed
+		if i < len(info.AllSeeds) {
+			seed = &info.AllSeeds[i]
+		}
+		req.reportImage(i, seed)
+	}
+	return &GenerateResult{OutputPath: req.OutputPath, Seed: info.Seed}, nil
+}
+
+func (g *httpGenerator) pollProgress(ctx context.Context, report func(int)) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last := -1
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, g.BaseURL+"/sdapi/v1/progress?skip_current_image=true", nil)
+		if err != nil {
+			return
+		}
+		resp, err := g.Client.Do(httpReq)
+		if err != nil {
+			continue
+		}
+		var p struct {
+			Progress float64 `json:"progress"` // 0 ~ 1
+		}
+		json.NewDecoder(resp.Body).Decode(&p)
+		resp.Body.Close()
+		if percent := clampPercent(int(p.Progress * 100)); percent != last && percent > 0 {
+			last = percent
+			report(percent)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+// generator_sidecar.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// --- 常駐 python sidecar ---
+// 每個任務都重新啟動 python 需要重新載入模型 (30~60 秒)，sidecar 只在啟動時載入一次。
+// 以 `python <Script> --serve` 啟動，透過 stdin / stdout 逐行交換 JSON：
+//
+//	Go -> python: {"id":1,"type":"generate","args":["--prompt","...","--output","..."]}
+//	              {"id":2,"type":"ping"}
+//	python -> Go: {"event":"ready"}                    模型載入完成
+//	              {"id":1,"progress":42}               進度 (也接受 step/total 與 tqdm 輸出)
+//	              {"id":1,"image":1,"seed":12346}      多張輸出時每寫完一張
+//	              {"id":1,"done":true} 或 {"id":1,"error":"..."}
+//	              {"id":2,"pong":true,"model_loaded":true}
+//
+// 其他輸出 (含 stderr) 視為 log。process 結束後會自動重啟。
+type sidecarGenerator struct {
+	Runtime pythonRuntime
+	Script  string
+	Device  string // GPU 排程指定的 GPU (CUDA_VISIBLE_DEVICES)，空字串代表不指定
+
+	genMutex sync.Mutex // 一張 GPU 一次只送一個請求
+	mutex    sync.Mutex // 保護 proc、closed、restarts
+	proc     *sidecarProcess
+	closed   bool
+	restarts int
+	nextID   atomic.Uint64
+}
+
+type sidecarProcess struct {
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+	stdin  io.WriteCloser
+	msgs   chan sidecarMessage // stdout 結束時關閉
+	ready  chan struct{}       // 收到 {"event":"ready"} 時關閉
+	exited chan struct{}       // process 結束後關閉，之後可讀取 err
+	err    error
+	killed atomic.Bool // 因取消任務而被結束，不算當機
+}
+
+type sidecarRequest struct {
+	ID   uint64   `json:"id"`
+	Type string   `json:"type"`
+	Args []string `json:"args,omitempty"`
+}
+
+type sidecarMessage struct {
+	ID          uint64 `json:"id"`
+	Event       string `json:"event"`
+	Done        bool   `json:"done"`
+	Error       string `json:"error"`
+	Pong        bool   `json:"pong"`
+	ModelLoaded bool   `json:"model_loaded"`
+	raw         []byte
+}
+
+// 預先啟動 process 載入模型，不等待載入完成
+func (g *sidecarGenerator) Start() error {
+	_, err := g.process()
+	return err
+}
+
+// 關閉 stdin 讓 python 自行結束，5 秒內沒結束就強制 kill
+func (g *sidecarGenerator) Close() error {
+	g.mutex.Lock()
+	g.closed = true
+	p := g.proc
+	g.proc = nil
+	g.mutex.Unlock()
+	if p == nil {
+		return nil
+	}
+	p.stdin.Close()
+	select {
+	case <-p.exited:
+	case <-time.After(5 * time.Second):
+		p.cancel()
+		<-p.exited
+	}
+	return nil
+}
+
+// 目前的 process，尚未啟動或已結束時重新啟動
+func (g *sidecarGenerator) process() (*sidecarProcess, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.closed {
+		return nil, fmt.Errorf("python sidecar is closed")
+	}
+	if g.proc != nil {
+		return g.proc, nil
+	}
+	p, err := g.startProcess()
+	if err != nil {
+		return nil, err
+	}
+	g.proc = p
+	return p, nil
+}
+
+func (g *sidecarGenerator) startProcess() (*sidecarProcess, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := g.Runtime.command(ctx, g.Device, g.Script, "--serve")
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("python sidecar error: %v", err)
+	}
+	slog.Info("Python sidecar started", "pid", cmd.Process.Pid)
+
+	p := &sidecarProcess{
+		cmd:    cmd,
+		cancel: cancel,
+		stdin:  stdin,
+		msgs:   make(chan sidecarMessage, 256),
+		ready:  make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	go g.readOutput(p, pr)
+	go func() {
+		p.err = cmd.Wait()
+		cancel()
+		close(p.exited)
+		pw.Close()
+		g.processExited(p)
+	}()
+	return p, nil
+}
+
+// 讀取 stdout，轉成訊息交給目前的請求；沒有人在等的訊息直接丟棄
+func (g *sidecarGenerator) readOutput(p *sidecarProcess, r io.Reader) {
+	defer close(p.msgs)
+	readyOnce := sync.Once{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	scanner.Split(scanLinesOrCR)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var m sidecarMessage
+		if line[0] == '{' {
+			json.Unmarshal(line, &m)
+		}
+		if m.ID == 0 && m.Event == "" {
+			slog.Debug("python output", "line", string(line))
+		}
+		if m.Event == "ready" {
+			readyOnce.Do(func() {
+				close(p.ready)
+				g.mutex.Lock()
+				g.restarts = 0
+				g.mutex.Unlock()
+				slog.Info("Python sidecar ready", "pid", p.cmd.Process.Pid)
+			})
+			continue
+		}
+		m.raw = append([]byte(nil), line...)
+		select {
+		case p.msgs <- m:
+		default:
+		}
+	}
+	io.Copy(io.Discard, r)
+}
+
+// process 結束後自動重啟；連續當機時逐步拉長間隔 (最多 1 分鐘)
+func (g *sidecarGenerator) processExited(p *sidecarProcess) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.proc != p || g.closed {
+		return
+	}
+	g.proc = nil
+	delay := time.Duration(0)
+	if !p.killed.Load() {
+		g.restarts++
+		delay = min(time.Second<<min(g.restarts, 6), time.Minute)
+		slog.Warn("Python sidecar exited, restarting", "error", p.err, "retry_in", delay.String())
+	}
+	time.AfterFunc(delay, func() {
+		if _, err := g.process(); err != nil {
+			slog.Error("Python sidecar restart failed", "error", err)
+		}
+	})
+}
+
+// 送出請求，逐一回傳同一 id 的訊息 (以及沒有 id 的 log 行) 給 handle，handle 回傳 true 代表結束
+func (g *sidecarGenerator) call(ctx context.Context, p *sidecarProcess, req sidecarRequest, handle func(sidecarMessage) bool) error {
+	// 丟棄上一個請求留下的訊息
+	for drained := false; !drained; {
+		select {
+		case <-p.msgs:
+		default:
+			drained = true
+		}
+	}
+	line, _ := json.Marshal(req)
+	if _, err := p.stdin.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("python sidecar write error: %v", err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m, ok := <-p.msgs:
+			if !ok {
+				<-p.exited
+				return fmt.Errorf("python sidecar exited: %v", p.err)
+			}
+			if (m.ID == req.ID || m.ID == 0) && handle(m) {
+				return nil
+			}
+		}
+	}
+}
+
+// 等待模型載入完成；ctx 結束時不中止載入，留給下一個任務使用
+func (g *sidecarGenerator) waitReady(ctx context.Context, p *sidecarProcess) error {
+	select {
+	case <-p.ready:
+		return nil
+	case <-p.exited:
+		return fmt.Errorf("python sidecar exited while loading model: %v", p.err)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (g *sidecarGenerator) Generate(ctx context.Context, req GenerateRequest) (*GenerateResult, error) {
+	g.genMutex.Lock()
+	defer g.genMutex.Unlock()
+
+	p, err := g.process()
+	if err != nil {
+		return nil, err
+	}
+	if err := g.waitReady(ctx, p); err != nil {
+		return nil, err
+	}
+
+	var output bytes.Buffer
+	var genErr error
+	last := -1
+	err = g.call(ctx, p, sidecarRequest{ID: g.nextID.Add(1), Type: "generate", Args: pythonArgs(req)}, func(m sidecarMessage) bool {
+		output.Write(m.raw)
+		output.WriteByte('\n')
+		if m.Error != "" {
+			genErr = &GenerationError{Message: "python error: " + m.Error, Log: output.String()}
+			return true
+		}
+		if m.Done {
+			return true
+		}
+		req.reportLog(m.raw)
+		if percent := parseProgress(m.raw); percent >= 0 && percent != last {
+			last = percent
+			req.reportProgress(percent)
+		}
+		if index, seed, ok := parseImageReady(m.raw); ok {
+			req.reportImage(index, seed)
+		}
+		return false
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			// 無法只中止單一請求，結束整個 process (之後自動重啟)
+			p.killed.Store(true)
+			p.cancel()
+			<-p.exited
+			return nil, ctx.Err()
+		}
+		return nil, &GenerationError{Message: err.Error(), Log: output.String()}
+	}
+	if genErr != nil {
+		return nil, genErr
+	}
+	return &GenerateResult{OutputPath: req.OutputPath, Seed: parseSeed(output.Bytes())}, nil
+}
+
+// readyz：確認 sidecar 已載入模型並能回應 ping；生成中代表 process 正常，不另外檢查
+func (g *sidecarGenerator) Check(ctx context.Context) error {
+	if !g.genMutex.TryLock() {
+		return nil
+	}
+	defer g.genMutex.Unlock()
+
+	g.mutex.Lock()
+	p := g.proc
+	g.mutex.Unlock()
+	if p == nil {
+		return fmt.Errorf("python sidecar is not running")
+	}
+	select {
+	case <-p.ready:
+	default:
+		return fmt.Errorf("python sidecar is loading model")
+	}
+
+	var loaded bool
+	err := g.call(ctx, p, sidecarRequest{ID: g.nextID.Add(1), Type: "ping"}, func(m sidecarMessage) bool {
+		loaded = m.ModelLoaded
+		return m.Pong
+	})
+	if err != nil {
+		return fmt.Errorf("python sidecar ping failed: %v", err)
+	}
+	if !loaded {
+		return fmt.Errorf("python sidecar model not loaded")
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+// gpu.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- GPU 排程 ---
+// GPU_DEVICES 設定可用的 GPU：
+//   未設定：有 nvidia-smi 時自動偵測，沒有時不做 GPU 排程 (mock、http 後端或只用 CPU)
+//   auto：一定要偵測到 nvidia-smi，否則拒絕啟動
+//   0:24576,1:12288：靜態設定 (index:VRAM MB)，不執行 nvidia-smi，剩餘 VRAM 以本 process 執行中的任務估算
+//   none：停用
+// 每個 worker 固定使用一張 GPU (worker i 使用第 (i-1) % n 張)，python 以 CUDA_VISIBLE_DEVICES 執行。
+// 該 GPU 的剩餘 VRAM 少於 GPU_TASK_VRAM_MB (預設 8192) 時不認領任務，等其他程式釋放 VRAM
+
+// nvidia-smi 的結果快取時間，避免每個 worker 每次認領都執行一次
+const gpuProbeInterval = 2 * time.Second
+
+// VRAM 不足時重新檢查的間隔
+const gpuRetryInterval = 5 * time.Second
+
+type gpuDevice struct {
+	Index       int    `json:"index"`
+	Name        string `json:"name,omitempty"`
+	TotalMB     int    `json:"total_mb"`
+	FreeMB      int    `json:"free_mb"`               // nvidia-smi 回報值；靜態設定時為 total - reserved
+	Utilization *int   `json:"utilization,omitempty"` // GPU 使用率 (%)，只有 nvidia-smi 提供
+	ReservedMB  int    `json:"reserved_mb"`           // 本 process 執行中任務預留的 VRAM
+	Running     int    `json:"running"`
+}
+
+type gpuScheduler struct {
+	Probe    bool // 以 nvidia-smi 取得剩餘 VRAM 與使用率
+	TaskVRAM int  // 每個任務需要的 VRAM (MB)
+
+	mutex    sync.Mutex
+	devices  []*gpuDevice
+	probedAt time.Time
+	probeErr error
+	waiting  map[int]bool // 等待 VRAM 中的 worker，只在狀態改變時記錄 log
+}
+
+func gpuTaskVRAM() int {
+	if n := envInt("GPU_TASK_VRAM_MB"); n > 0 {
+		return n
+	}
+	return 8192
+}
+
+// 依 GPU_DEVICES 建立排程器；沒有可用的 GPU 時所有方法都直接放行
+func newGPUScheduler(cfg *Config) (*gpuScheduler, error) {
+	s := &gpuScheduler{TaskVRAM: cfg.GPUTaskVRAMMB, waiting: make(map[int]bool)}
+	switch strings.ToLower(cfg.GPUDevices) {
+	case "none":
+		return s, nil
+	case "", "auto":
+		if _, err := exec.LookPath("nvidia-smi"); err != nil {
+			if cfg.GPUDevices != "" {
+				return nil, fmt.Errorf("GPU_DEVICES=auto: nvidia-smi not found")
+			}
+			slog.Info("nvidia-smi not found, GPU scheduling disabled")
+			return s, nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		devices, err := queryNvidiaSMI(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("query GPUs: %w", err)
+		}
+		s.Probe, s.devices, s.probedAt = true, devices, time.Now()
+	default:
+		devices, err := parseGPUDevices(cfg.GPUDevices)
+		if err != nil {
+			return nil, err
+		}
+		s.devices = devices
+	}
+	for _, d := range s.devices {
+		slog.Info("GPU detected", "index", d.Index, "name", d.Name, "total_mb", d.TotalMB, "free_mb", d.FreeMB)
+		if d.TotalMB < s.TaskVRAM {
+			slog.Warn("GPU has less VRAM than GPU_TASK_VRAM_MB, workers on it will never start a task", "index", d.Index, "total_mb", d.TotalMB, "task_vram_mb", s.TaskVRAM)
+		}
+	}
+	return s, nil
+}
+
+// 靜態設定：0:24576,1:12288
+func parseGPUDevices(s string) ([]*gpuDevice, error) {
+	var devices []*gpuDevice
+	for _, entry := range strings.Split(s, ",") {
+		index, vram, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		i, err1 := strconv.Atoi(index)
+		mb, err2 := strconv.Atoi(vram)
+		if !ok || err1 != nil || err2 != nil || i < 0 || mb <= 0 {
+			return nil, fmt.Errorf("GPU_DEVICES=%q: expected index:vram_mb[,index:vram_mb...]", s)
+		}
+		devices = append(devices, &gpuDevice{Index: i, TotalMB: mb, FreeMB: mb})
+	}
+	return devices, nil
+}
+
+// nvidia-smi --query-gpu，每張 GPU 一行：index, name, memory.total, memory.free, utilization.gpu
+func queryNvidiaSMI(ctx context.Context) ([]*gpuDevice, error) {
+	out, err := exec.CommandContext(ctx, "nvidia-smi",
+		"--query-gpu=index,name,memory.total,memory.free,utilization.gpu",
+		"--format=csv,noheader,nounits").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("nvidia-smi: %s", bytes.TrimSpace(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("nvidia-smi: %w", err)
+	}
+	var devices []*gpuDevice
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) != 5 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		index, err1 := strconv.Atoi(fields[0])
+		total, err2 := strconv.Atoi(fields[2])
+		free, err3 := strconv.Atoi(fields[3])
+		if err1 != nil || err2 != nil || err3 != nil {
+			return nil, fmt.Errorf("nvidia-smi: unexpected output %q", line)
+		}
+		d := &gpuDevice{Index: index, Name: fields[1], TotalMB: total, FreeMB: free}
+		// 部分 GPU 不支援時回報 [N/A]
+		if util, err := strconv.Atoi(fields[4]); err == nil {
+			d.Utilization = &util
+		}
+		devices = append(devices, d)
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("nvidia-smi: no GPU found")
+	}
+	return devices, nil
+}
+
+func (s *gpuScheduler) enabled() bool {
+	return len(s.devices) > 0
+}
+
+// worker 使用的 GPU，沒有 GPU 排程時為 nil
+func (s *gpuScheduler) deviceFor(workerID int) *gpuDevice {
+	if !s.enabled() {
+		return nil
+	}
+	return s.devices[(workerID-1)%len(s.devices)]
+}
+
+// CUDA_VISIBLE_DEVICES 的值，沒有 GPU 排程時為空字串
+func (s *gpuScheduler) deviceID(workerID int) string {
+	if d := s.deviceFor(workerID); d != nil {
+		return strconv.Itoa(d.Index)
+	}
+	return ""
+}
+
+// 所有 GPU 的 CUDA_VISIBLE_DEVICES 值 (每張 GPU 一個 sidecar)
+func (s *gpuScheduler) deviceIDs() []string {
+	ids := make([]string, len(s.devices))
+	for i, d := range s.devices {
+		ids[i] = strconv.Itoa(d.Index)
+	}
+	return ids
+}
+
+// 更新 nvidia-smi 的剩餘 VRAM 與使用率，需持有 mutex
+func (s *gpuScheduler) refreshLocked() {
+	if !s.Probe || time.Since(s.probedAt) < gpuProbeInterval {
+		return
+	}
+	s.probedAt = time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	devices, err := queryNvidiaSMI(ctx)
+	s.probeErr = err
+	if err != nil {
+		slog.Warn("Probe GPUs error", "error", err)
+		return
+	}
+	byIndex := make(map[int]*gpuDevice, len(devices))
+	for _, d := range devices {
+		byIndex[d.Index] = d
+	}
+	for _, d := range s.devices {
+		if probed, ok := byIndex[d.Index]; ok {
+			d.TotalMB, d.FreeMB, d.Utilization = probed.TotalMB, probed.FreeMB, probed.Utilization
+		}
+	}
+}
+
+// 認領任務前呼叫：worker 的 GPU 有足夠 VRAM 時預留並回傳 release，不足時回傳 false
+func (s *gpuScheduler) reserve(workerID int) (release func(), ok bool) {
+	d := s.deviceFor(workerID)
+	if d == nil {
+		return func() {}, true
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.refreshLocked()
+
+	// 執行中的任務可能還沒配置 VRAM，剩餘量取 nvidia-smi 回報值與預留後剩餘的較小者
+	available := d.TotalMB - d.ReservedMB
+	if s.Probe {
+		available = min(available, d.FreeMB)
+	}
+	if available < s.TaskVRAM {
+		if !s.waiting[workerID] {
+			s.waiting[workerID] = true
+			slog.Warn("Not enough free VRAM, waiting", "worker_id", workerID, "gpu", d.Index, "available_mb", available, "task_vram_mb", s.TaskVRAM)
+		}
+		return nil, false
+	}
+	if s.waiting[workerID] {
+		delete(s.waiting, workerID)
+		slog.Info("GPU has enough free VRAM again", "worker_id", workerID, "gpu", d.Index)
+	}
+	d.ReservedMB += s.TaskVRAM
+	d.Running++
+	if !s.Probe {
+		d.FreeMB = d.TotalMB - d.ReservedMB
+	}
+	return func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		d.ReservedMB -= s.TaskVRAM
+		d.Running--
+		if !s.Probe {
+			d.FreeMB = d.TotalMB - d.ReservedMB
+		}
+	}, true
+}
+
+// worker 是否正在等待 VRAM
+func (s *gpuScheduler) isWaiting(workerID int) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.waiting[workerID]
+}
+
+// 管理 API 與 readyz 用的目前狀態
+func (s *gpuScheduler) snapshot() ([]gpuDevice, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.refreshLocked()
+	devices := make([]gpuDevice, len(s.devices))
+	for i, d := range s.devices {
+		devices[i] = *d
+	}
+	return devices, s.probeErr
+}
+
+// --- 指定 GPU 執行 ---
+
+type gpuKey struct{}
+
+// 生成與放大在 worker 的 GPU 上執行 (python 以 CUDA_VISIBLE_DEVICES 啟動)
+func withGPU(ctx context.Context, device string) context.Context {
+	if device == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, gpuKey{}, device)
+}
+
+func gpuFrom(ctx context.Context) string {
+	device, _ := ctx.Value(gpuKey{}).(string)
+	return device
+}
+
+// 子程序要加上的環境變數 (見 pythonRuntime.environ)；nvidia-smi 的 index 依 PCI bus 排列，CUDA 需使用相同順序
+func gpuEnv(device string) []string {
+	if device == "" {
+		return nil
+	}
+	return []string{"CUDA_DEVICE_ORDER=PCI_BUS_ID", "CUDA_VISIBLE_DEVICES=" + device}
+}
+
+// 多張 GPU 時每張各啟動一個 sidecar，依任務的 GPU 分派
+type deviceGenerators struct {
+	byDevice map[string]*sidecarGenerator
+	order    []string
+}
+
+func (g *deviceGenerators) Generate(ctx context.Context, req GenerateRequest) (*GenerateResult, error) {
+	gen, ok := g.byDevice[gpuFrom(ctx)]
+	if !ok {
+		gen = g.byDevice[g.order[0]]
+	}
+	return gen.Generate(ctx, req)
+}
+
+func (g *deviceGenerators) Start() error {
+	for _, device := range g.order {
+		if err := g.byDevice[device].Start(); err != nil {
+			return fmt.Errorf("gpu %s: %w", device, err)
+		}
+	}
+	return nil
+}
+
+func (g *deviceGenerators) Close() error {
+	for _, device := range g.order {
+		g.byDevice[device].Close()
+	}
+	return nil
+}
+
+func (g *deviceGenerators) Check(ctx context.Context) error {
+	for _, device := range g.order {
+		if err := g.byDevice[device].Check(ctx); err != nil {
+			return fmt.Errorf("gpu %s: %w", device, err)
+		}
+	}
+	return nil
+}
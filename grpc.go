@@ -0,0 +1,399 @@
+// grpc.go
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/asccclass/mcpzimage/zimagepb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative zimagepb/zimage.proto
+
+// --- gRPC API ---
+// GRPC_PORT 有設定時另外提供 zimage.v1.ZImage (定義在 zimagepb/zimage.proto)：
+// CreateTask / GetTask / ListTasks 與 REST 共用 createTask、findTask 等；StreamUpdates 與 WebSocket / SSE 共用 Hub，
+// 推播的事件相同 (new_task、update 另外轉成 Task message)。
+// 驗證與 REST 相同，API key 放在 metadata (authorization: Bearer ... 或 x-api-key)；啟用 HTTPS 時使用相同的憑證
+
+type grpcServer struct {
+	zimagepb.UnimplementedZImageServer
+	app *App
+}
+
+// 在 GRPC_PORT 開始接受連線 (不會阻塞)，回傳關閉函式；tlsConfig 為 nil 時不加密
+func (a *App) startGRPC(tlsConfig *tls.Config) func() {
+	if a.Config.GRPCPort == "" {
+		return func() {}
+	}
+	listener, err := net.Listen("tcp", ":"+a.Config.GRPCPort)
+	if err != nil {
+		slog.Error("gRPC listen error", "port", a.Config.GRPCPort, "error", err)
+		return func() {}
+	}
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(a.grpcUnaryInterceptor),
+		grpc.StreamInterceptor(a.grpcStreamInterceptor),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig.Clone())))
+	}
+	server := grpc.NewServer(opts...)
+	zimagepb.RegisterZImageServer(server, &grpcServer{app: a})
+	// 讓 grpcurl 等工具不需要 .proto 也能呼叫 (同樣需要 API key)
+	reflection.Register(server)
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			slog.Error("gRPC server error", "error", err)
+		}
+	}()
+	slog.Info("gRPC server started", "port", a.Config.GRPCPort, "tls", tlsConfig != nil)
+	return func() {
+		// StreamUpdates 在 Hub.closeAll 時結束；還沒結束的請求最多再等 10 秒
+		timer := time.AfterFunc(10*time.Second, server.Stop)
+		defer timer.Stop()
+		server.GracefulStop()
+	}
+}
+
+// 與 HTTP 的 withRequestID / withClientIP / requireAuth 相同：request_id、來源 IP 與 Identity 放進 context
+func (a *App) grpcContext(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	requestID := firstMetadata(md, "x-request-id")
+	if requestID == "" {
+		requestID = newShortID()
+	}
+	ctx = withLogger(ctx, slog.Default().With("request_id", requestID, "grpc_method", method))
+	if p, ok := peer.FromContext(ctx); ok {
+		ip, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			ip = p.Addr.String()
+		}
+		ctx = context.WithValue(ctx, clientIPKey{}, ip)
+	}
+	if !a.authEnabled() {
+		return ctx, nil
+	}
+	token := firstMetadata(md, "x-api-key")
+	if auth := firstMetadata(md, "authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	identity, ok := a.lookupAPIKey(token)
+	if !ok {
+		if token != "" {
+			a.audit(ctx, "auth.failed", "", "", "grpc "+method)
+		}
+		return nil, status.Error(codes.Unauthenticated, "invalid or missing API key")
+	}
+	identity, err := a.withUserRole(identity)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	identity, err = a.selectWorkspace(identity, firstMetadata(md, "x-workspace"))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return context.WithValue(ctx, identityKey{}, identity), nil
+}
+
+func firstMetadata(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (a *App) grpcUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.grpcContext(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *App) grpcStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.grpcContext(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &grpcContextStream{ServerStream: stream, ctx: ctx})
+}
+
+// 換掉 Context 的 ServerStream
+type grpcContextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcContextStream) Context() context.Context {
+	return s.ctx
+}
+
+// 依 errorCode 對應 gRPC 狀態碼 (與 writeTaskError 的 HTTP 狀態碼一致)，
+// 錯誤代碼放在 ErrorInfo 的 reason，參數錯誤的欄位放在 metadata 的 field
+func grpcError(err error) error {
+	code := codes.Internal
+	switch errorCode(err) {
+	case "invalid_request", "prompt_rejected":
+		code = codes.InvalidArgument
+	case "not_found":
+		code = codes.NotFound
+	case "conflict":
+		code = codes.FailedPrecondition
+	case "forbidden":
+		code = codes.PermissionDenied
+	case "quota_exceeded":
+		code = codes.ResourceExhausted
+	case "unavailable":
+		code = codes.Unavailable
+	}
+	info := &errdetails.ErrorInfo{Reason: errorCode(err), Domain: "mcpzimage"}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) && validationErr.Field != "" {
+		info.Metadata = map[string]string{"field": validationErr.Field}
+	}
+	st, detailErr := status.New(code, err.Error()).WithDetails(info)
+	if detailErr != nil {
+		return status.Error(code, err.Error())
+	}
+	return st.Err()
+}
+
+// --- RPC ---
+
+func (s *grpcServer) CreateTask(ctx context.Context, req *zimagepb.CreateTaskRequest) (*zimagepb.Task, error) {
+	identity := identityFromContext(ctx)
+	if err := identity.checkRole(roleCreator); err != nil {
+		return nil, grpcError(err)
+	}
+	task := Task{
+		Prompt:           strings.TrimSpace(req.GetPrompt()),
+		GenerationParams: paramsFromProto(req.GetParams()),
+		CallbackURL:      strings.TrimSpace(req.GetCallbackUrl()),
+		CreatedBy:        identity.keyName(),
+		UserID:           identity.userID(),
+		Force:            req.GetForce(),
+		Tags:             req.GetTags(),
+	}
+	if req.ScheduledAt != nil {
+		scheduledAt := req.ScheduledAt.AsTime()
+		task.ScheduledAt = &scheduledAt
+	}
+	created, err := s.app.createTask(ctx, task)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return taskToProto(created), nil
+}
+
+func (s *grpcServer) GetTask(ctx context.Context, req *zimagepb.GetTaskRequest) (*zimagepb.Task, error) {
+	task, err := s.app.findTask(identityFromContext(ctx), uint(req.GetId()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := s.app.loadTaskDetail(task); err != nil {
+		return nil, grpcError(err)
+	}
+	return taskToProto(task), nil
+}
+
+func (s *grpcServer) ListTasks(ctx context.Context, req *zimagepb.ListTasksRequest) (*zimagepb.ListTasksResponse, error) {
+	limit := int(req.GetLimit())
+	switch {
+	case limit < 0:
+		return nil, grpcError(invalidField("limit", "invalid limit"))
+	case limit == 0:
+		limit = 20
+	case limit > 100:
+		limit = 100
+	}
+	tasks, err := s.app.Tasks.ListHistory(ctx, TaskHistoryFilter{
+		UserID:       identityFromContext(ctx).userID(),
+		WorkspaceID:  identityFromContext(ctx).workspaceID(),
+		Tags:         req.GetTags(),
+		CollectionID: uint(req.GetCollectionId()),
+		Limit:        limit,
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &zimagepb.ListTasksResponse{Tasks: make([]*zimagepb.Task, len(tasks))}
+	for i := range tasks {
+		resp.Tasks[i] = taskToProto(&tasks[i])
+	}
+	return resp, nil
+}
+
+// 與 SSE 相同以沒有 conn 的 wsClient 接收 Hub 的推播；一開始訂閱自己的所有任務，client 可以隨時送出 StreamRequest 調整
+func (s *grpcServer) StreamUpdates(stream zimagepb.ZImage_StreamUpdatesServer) error {
+	a := s.app
+	ctx := stream.Context()
+	identity := identityFromContext(ctx)
+	client := newWSClient(a.Hub, nil, identity)
+	client.kind = "grpc"
+	client.remoteAddr, _ = ctx.Value(clientIPKey{}).(string)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		client.userAgent = truncateHead(firstMetadata(md, "user-agent"), 200)
+	}
+	client.connectedAt = time.Now()
+	client.touch()
+	defer client.close()
+	client.subscribe(a.DB, nil, true)
+	logger := loggerFrom(ctx).With("client_id", client.id)
+	logger.Info("gRPC stream connected", "user", identity.keyName())
+	defer logger.Info("gRPC stream disconnected")
+	a.Hub.register(client)
+	defer a.Hub.unregister(client)
+
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err == io.EOF {
+				// client 不再送出訂閱變更，仍繼續推播
+				return
+			}
+			if err != nil {
+				client.close()
+				return
+			}
+			client.touch()
+			client.subscribe(a.DB, protoIDs(req.GetSubscribe()), req.All != nil && req.GetAll())
+			client.unsubscribe(protoIDs(req.GetUnsubscribe()), req.All != nil && !req.GetAll())
+		}
+	}()
+
+	client.writeJSON(WSQueueState{Type: "queue_state", QueueState: a.queueStatus()})
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-client.done:
+			// 伺服器關機或被管理員中斷
+			return status.Error(codes.Unavailable, "stream closed by server")
+		case msg := <-client.send:
+			if err := stream.Send(taskEventFromJSON(msg)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// --- 轉換 ---
+
+// Hub 推播的 JSON 訊息轉成 TaskEvent
+func taskEventFromJSON(msg []byte) *zimagepb.TaskEvent {
+	var envelope struct {
+		Type   string          `json:"type"`
+		Seq    int64           `json:"seq"`
+		TaskID uint            `json:"task_id"`
+		Data   json.RawMessage `json:"data"`
+	}
+	json.Unmarshal(msg, &envelope)
+	event := &zimagepb.TaskEvent{Type: envelope.Type, Seq: envelope.Seq, TaskId: uint64(envelope.TaskID), Json: string(msg)}
+	if envelope.Type == "new_task" || envelope.Type == "update" {
+		var task Task
+		if err := json.Unmarshal(envelope.Data, &task); err == nil {
+			event.Task = taskToProto(&task)
+			event.TaskId = uint64(task.ID)
+		}
+	}
+	return event
+}
+
+func protoIDs(ids []uint64) []uint {
+	out := make([]uint, len(ids))
+	for i, id := range ids {
+		out[i] = uint(id)
+	}
+	return out
+}
+
+func paramsFromProto(p *zimagepb.GenerationParams) GenerationParams {
+	if p == nil {
+		return GenerationParams{}
+	}
+	return GenerationParams{
+		NegativePrompt: p.GetNegativePrompt(),
+		Width:          int(p.GetWidth()),
+		Height:         int(p.GetHeight()),
+		Steps:          int(p.GetSteps()),
+		GuidanceScale:  p.GetGuidanceScale(),
+		Seed:           p.Seed,
+		Strength:       p.GetStrength(),
+		Upscale:        int(p.GetUpscale()),
+		Model:          p.GetModel(),
+		NumImages:      int(p.GetNumImages()),
+		Format:         p.GetFormat(),
+		Quality:        int(p.GetQuality()),
+		Watermark:      p.Watermark,
+		StripMetadata:  p.StripMetadata,
+	}
+}
+
+func paramsToProto(p GenerationParams) *zimagepb.GenerationParams {
+	return &zimagepb.GenerationParams{
+		NegativePrompt: p.NegativePrompt,
+		Width:          int32(p.Width),
+		Height:         int32(p.Height),
+		Steps:          int32(p.Steps),
+		GuidanceScale:  p.GuidanceScale,
+		Seed:           p.Seed,
+		Strength:       p.Strength,
+		Upscale:        int32(p.Upscale),
+		Model:          p.Model,
+		NumImages:      int32(p.NumImages),
+		Format:         p.Format,
+		Quality:        int32(p.Quality),
+		Watermark:      p.Watermark,
+		StripMetadata:  p.StripMetadata,
+	}
+}
+
+func taskToProto(t *Task) *zimagepb.Task {
+	pb := &zimagepb.Task{
+		Id:            uint64(t.ID),
+		Prompt:        t.Prompt,
+		Params:        paramsToProto(t.GenerationParams),
+		Status:        string(t.Status),
+		FailReason:    t.FailReason,
+		ErrorMessage:  t.ErrorMessage,
+		ImagePath:     t.ImagePath,
+		ImageUrl:      t.ImageURL,
+		UsedSeed:      t.UsedSeed,
+		CreatedBy:     t.CreatedBy,
+		Tags:          t.Tags,
+		QueuePosition: int32(t.QueuePosition),
+		EtaSeconds:    int32(t.ETASeconds),
+		CreatedAt:     timestamppb.New(t.CreatedAt),
+		StartedAt:     protoTime(t.StartedAt),
+		FinishedAt:    protoTime(t.FinishedAt),
+		ContentType:   t.ContentType,
+	}
+	for _, image := range t.Images {
+		pb.Images = append(pb.Images, &zimagepb.TaskImage{Index: int32(image.Position), ImagePath: image.ImagePath, ImageUrl: image.ImageURL, Seed: image.Seed})
+	}
+	return pb
+}
+
+func protoTime(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
@@ -0,0 +1,204 @@
+// health.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// --- Health / Readiness (Kubernetes probes) ---
+
+// 生成後端可選擇實作，用於 readiness 檢查
+type healthChecker interface {
+	Check(ctx context.Context) error
+}
+
+// 每個 worker 最後一次活動時間與目前處理中的任務
+type workerState struct {
+	LastBeat   time.Time `json:"last_beat"`
+	TaskID     uint      `json:"task_id,omitempty"`
+	GPU        string    `json:"gpu,omitempty"`         // 使用的 GPU (CUDA_VISIBLE_DEVICES)
+	WaitingGPU bool      `json:"waiting_gpu,omitempty"` // GPU 剩餘 VRAM 不足，暫停認領
+}
+
+// worker 每次迴圈與開始/結束任務時呼叫
+func (a *App) workerBeat(workerID int, taskID uint) {
+	state := &workerState{LastBeat: time.Now(), TaskID: taskID, GPU: a.GPUs.deviceID(workerID), WaitingGPU: a.GPUs.isWaiting(workerID)}
+	a.workerStateMutex.Lock()
+	a.workerStates[workerID] = state
+	a.workerStateMutex.Unlock()
+}
+
+func (a *App) workerGone(workerID int) {
+	a.workerStateMutex.Lock()
+	delete(a.workerStates, workerID)
+	a.workerStateMutex.Unlock()
+}
+
+// 可用磁碟空間下限 (MB)，從環境變數 MIN_FREE_DISK_MB 取得，預設 500
+func minFreeDiskMB() uint64 {
+	n := envInt("MIN_FREE_DISK_MB")
+	if n <= 0 {
+		return 500
+	}
+	return uint64(n)
+}
+
+type checkResult struct {
+	Status string      `json:"status"` // ok / fail
+	Error  string      `json:"error,omitempty"`
+	Detail interface{} `json:"detail,omitempty"`
+}
+
+func checkOK(detail interface{}) checkResult { return checkResult{Status: "ok", Detail: detail} }
+func checkFail(err error) checkResult        { return checkResult{Status: "fail", Error: err.Error()} }
+
+func (a *App) checkDatabase(ctx context.Context) checkResult {
+	sqlDB, err := a.DB.DB()
+	if err != nil {
+		return checkFail(err)
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return checkFail(err)
+	}
+	return checkOK(nil)
+}
+
+func (a *App) checkGenerator(ctx context.Context) checkResult {
+	if a.Generator == nil {
+		return checkFail(fmt.Errorf("generator not initialized"))
+	}
+	if checker, ok := a.Generator.(healthChecker); ok {
+		if err := checker.Check(ctx); err != nil {
+			return checkFail(err)
+		}
+	}
+	return checkOK(nil)
+}
+
+// 閒置中的 worker 至少每個掃描間隔會醒來一次；處理中的 worker 視為存活
+func (a *App) checkWorkers() checkResult {
+	if a.Config.WorkerCount == 0 {
+		// serve -workers 0：任務由其他主機的 worker 處理
+		var alive int64
+		a.DB.Model(&WorkerNode{}).Where("last_seen_at > ?", time.Now().Add(-a.Config.WorkerLease)).Count(&alive)
+		return checkOK(map[string]interface{}{"mode": "remote", "nodes": alive})
+	}
+	a.workerStateMutex.Lock()
+	defer a.workerStateMutex.Unlock()
+	stale := 2*a.Config.PollInterval + 5*time.Second
+	alive := 0
+	for _, state := range a.workerStates {
+		if state.TaskID != 0 || time.Since(state.LastBeat) < stale {
+			alive++
+		}
+	}
+	detail := map[string]int{"alive": alive, "configured": a.Config.WorkerCount}
+	if alive == 0 {
+		return checkResult{Status: "fail", Error: "no live workers", Detail: detail}
+	}
+	return checkOK(detail)
+}
+
+// GPU 排程：回報各 GPU 的 VRAM 與使用率；nvidia-smi 執行失敗 (例如驅動異常) 時不接受流量
+func (a *App) checkGPU() checkResult {
+	if !a.GPUs.enabled() {
+		return checkOK(map[string]string{"mode": "none"})
+	}
+	devices, err := a.GPUs.snapshot()
+	if err != nil {
+		return checkResult{Status: "fail", Error: err.Error(), Detail: devices}
+	}
+	return checkOK(devices)
+}
+
+func (a *App) checkDisk() checkResult {
+	dir := a.Config.ImageDir
+	os.MkdirAll(dir, os.ModePerm)
+	free, err := diskFreeBytes(dir)
+	if err != nil {
+		return checkFail(err)
+	}
+	freeMB := free / 1024 / 1024
+	detail := map[string]uint64{"free_mb": freeMB, "min_free_mb": a.Config.MinFreeDiskMB}
+	if freeMB < a.Config.MinFreeDiskMB {
+		return checkResult{Status: "fail", Error: "low disk space", Detail: detail}
+	}
+	return checkOK(detail)
+}
+
+func (a *App) checkStorage(ctx context.Context) checkResult {
+	if checker, ok := a.Storage.(healthChecker); ok {
+		if err := checker.Check(ctx); err != nil {
+			return checkFail(err)
+		}
+	}
+	return checkOK(nil)
+}
+
+// GET /healthz：程序存活即可，讓 Kubernetes 不要因為 GPU 後端暫時異常就重啟；
+// 磁碟空間不足而自動暫停佇列時仍回 200，status 為 degraded 並附上磁碟檢查結果
+func (a *App) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	if a.diskLow.Load() {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "degraded", "disk": a.checkDisk()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// GET /readyz：資料庫、生成後端、worker、磁碟空間都正常且暖機完成才接受流量
+func (a *App) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	checks := map[string]checkResult{
+		"database":  a.checkDatabase(ctx),
+		"generator": a.checkGenerator(ctx),
+		"workers":   a.checkWorkers(),
+		"disk":      a.checkDisk(),
+		"storage":   a.checkStorage(ctx),
+		"gpu":       a.checkGPU(),
+		"queue":     a.checkQueue(ctx),
+		"warmup":    a.checkWarmup(),
+	}
+	status, code := "ok", http.StatusOK
+	for _, c := range checks {
+		if c.Status != "ok" {
+			status, code = "fail", http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, code, map[string]interface{}{"status": status, "checks": checks})
+}
+
+// python 後端：確認直譯器與腳本存在 (不實際載入模型)
+func (g *pythonGenerator) Check(ctx context.Context) error {
+	if _, err := exec.LookPath(g.Runtime.Python); err != nil {
+		return fmt.Errorf("python interpreter not found: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(g.Runtime.Dir, g.Script)); err != nil {
+		return fmt.Errorf("z-image script not found: %v", err)
+	}
+	return nil
+}
+
+// http 後端：確認遠端服務有回應
+func (g *httpGenerator) Check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.BaseURL+"/sdapi/v1/progress", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("generator unreachable: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("generator status %d", resp.StatusCode)
+	}
+	return nil
+}
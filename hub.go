@@ -0,0 +1,437 @@
+// hub.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+// --- WebSocket 管理 ---
+// 心跳與寫入設定：超過 wsPongWait 沒收到任何資料 (含 pong) 視為斷線
+const (
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsPingPeriod     = wsPongWait * 9 / 10
+	wsMaxMessageSize = 64 * 1024
+	wsSendBuffer     = 256 // 批次建立最多 100 筆，每筆可能同時有廣播與回應
+
+	// worker 推播時放入的緩衝；run 暫時跟不上時 worker 不會被卡住，滿了才丟棄最舊的事件
+	hubBroadcastBuffer = 1024
+)
+
+// 每個連線訂閱的任務，只推播這些任務的更新；SSE (/api/events) 的連線也使用同一個結構，conn 為 nil
+type wsClient struct {
+	id        string
+	conn      *websocket.Conn
+	hub       *Hub
+	identity  *Identity     // 未啟用驗證時為 nil
+	send      chan []byte   // 由 writePump 依序寫出，gorilla/websocket 不允許同時寫入
+	done      chan struct{} // 連線關閉後 close
+	closeOnce sync.Once
+	subs      map[uint]bool // 訂閱的任務 ID
+	all       bool          // 訂閱自己的所有任務 (未啟用驗證時為全部任務)
+	protocol  int           // 以 hello 協商的協定版本，只在讀取迴圈中使用
+	locale    string        // 錯誤訊息的語系，連線時決定 (見 i18n.go)
+
+	// 連線資訊，見 presence.go
+	kind        string
+	baseURL     string
+	remoteAddr  string
+	userAgent   string
+	connectedAt time.Time
+	lastActive  atomic.Int64 // UnixNano
+}
+
+func newWSClient(hub *Hub, conn *websocket.Conn, identity *Identity) *wsClient {
+	return &wsClient{
+		hub:      hub,
+		id:       newShortID(),
+		conn:     conn,
+		identity: identity,
+		send:     make(chan []byte, wsSendBuffer),
+		done:     make(chan struct{}),
+		subs:     make(map[uint]bool),
+		protocol: wsMinProtocol,
+	}
+}
+
+// 關閉連線；讀取端會因此收到錯誤並把 client 從 clients 移除
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	})
+}
+
+// 唯一的寫入者：送出佇列中的訊息並定期 ping
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.close()
+	}()
+	for {
+		select {
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// 放入送出佇列，不會阻塞；佇列已滿代表 client 跟不上，丟棄最舊的訊息讓出空間
+// (任務的 update 都帶完整狀態，較新的訊息會蓋過被丟棄的)。連線已關閉時回傳 false
+func (c *wsClient) enqueue(msg []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	for {
+		select {
+		case c.send <- msg:
+			return true
+		default:
+		}
+		select {
+		case <-c.send:
+			if n := c.hub.droppedMessages.Add(1); n%100 == 1 {
+				slog.Warn("WebSocket client too slow, dropping oldest messages", "client_id", c.id, "dropped_total", n)
+			}
+		default:
+		}
+	}
+}
+
+// 寫入一個 JSON 訊息
+func (c *wsClient) writeJSON(v interface{}) error {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if !c.enqueue(msg) {
+		return errClientClosed
+	}
+	return nil
+}
+
+// 錯誤訊息，內容與 REST 的錯誤回應相同：
+// 協定 2 為 {"type":"error","code":"quota_exceeded","message":"...","details":{...}}，
+// 協定 1 包在 data 裡：{"type":"error","data":{"code":...,"message":...}}
+func (c *wsClient) writeError(err error) error {
+	body := apiError{Code: errorCode(err), Message: localizeError(c.locale, err), Details: errorDetails(err)}
+	if c.protocol < 2 {
+		return c.writeJSON(WSResponse{Type: "error", Data: body})
+	}
+	return c.writeJSON(WSError{Type: "error", apiError: body})
+}
+
+type WSError struct {
+	Type string `json:"type"`
+	apiError
+}
+
+// --- 協定版本 ---
+// 連線後伺服器先送出 hello (目前的版本與支援範圍)，client 以 {"type":"hello","version":2} 選擇版本；
+// 沒有送 hello 的舊 client 維持版本 1。
+//
+//	1：最初的格式，錯誤包在 data 裡
+//	2：錯誤的 code、message、details 放在最上層
+const (
+	wsMinProtocol = 1
+	wsMaxProtocol = 2
+)
+
+type WSHello struct {
+	Type       string `json:"type"`
+	Version    int    `json:"version"` // 這個連線目前使用的版本
+	MinVersion int    `json:"min_version"`
+	MaxVersion int    `json:"max_version"`
+	ClientID   string `json:"client_id"`
+	Seq        int64  `json:"seq"`                // 目前的序號，之後斷線重連時以 resume 的 since_seq 帶回
+	BaseURL    string `json:"base_url,omitempty"` // 服務的對外網址 (含 BASE_PATH)，見 proxy.go
+	Locale     string `json:"locale,omitempty"`   // 錯誤訊息使用的語系，見 i18n.go
+}
+
+func (c *wsClient) writeHello() error {
+	return c.writeJSON(WSHello{Type: "hello", Version: c.protocol, MinVersion: wsMinProtocol, MaxVersion: wsMaxProtocol, ClientID: c.id, Seq: c.hub.currentSeq(), BaseURL: c.baseURL, Locale: c.locale})
+}
+
+// 處理 client 的 hello，不支援的版本回 unsupported_version 並維持原本的版本
+func (c *wsClient) negotiate(version int) error {
+	if version < wsMinProtocol || version > wsMaxProtocol {
+		return c.writeError(newCodedError("unsupported_version", "protocol version %d is not supported (%d-%d)", version, wsMinProtocol, wsMaxProtocol))
+	}
+	c.protocol = version
+	return c.writeHello()
+}
+
+// 讀取一則 client 訊息；格式錯誤時回傳 *codedError (invalid_message)，連線可以繼續使用，
+// 其他錯誤代表連線已中斷
+func (c *wsClient) readMessage() (WSMessage, error) {
+	var msg WSMessage
+	kind, data, err := c.conn.ReadMessage()
+	if err != nil {
+		return msg, err
+	}
+	c.touch()
+	if kind != websocket.TextMessage {
+		return msg, &codedError{Code: "invalid_message", Message: "messages must be JSON text frames"}
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return msg, newCodedError("invalid_message", "invalid JSON message: %v", err)
+	}
+	if msg.Type == "" {
+		return msg, &codedError{Code: "invalid_message", Message: "message type is required"}
+	}
+	return msg, nil
+}
+
+var errClientClosed = errors.New("websocket client closed")
+
+// 要推播的訊息，只送給訂閱該任務的連線
+type wsEvent struct {
+	TaskID      uint
+	UserID      uint
+	WorkspaceID *uint
+	Global      bool // 送給所有連線，不看訂閱 (例如佇列狀態)
+	Payload     []byte
+}
+
+// 用來管理所有連線的 Clients，以便廣播訊息。
+// 連線的加入、離開與推播都經由 channel 交給 run 依序處理；
+// 推播端 (worker) 只把事件放進有緩衝的 broadcast，不會因為 run 或某個連線卡住而阻塞
+type Hub struct {
+	clients   map[*wsClient]bool
+	broadcast chan wsEvent
+	joins     chan *wsClient // register
+	leaves    chan *wsClient // unregister
+	mutex     sync.Mutex     // 保護 clients 與各連線的訂閱清單
+
+	presence        bool         // 連線加入或離開時廣播 presence (PRESENCE_BROADCAST)
+	seq             atomic.Int64 // 最後一個推播的序號，見 resume.go
+	droppedEvents   atomic.Int64 // broadcast 已滿被丟棄的事件
+	droppedMessages atomic.Int64 // 連線的送出佇列已滿被丟棄的訊息
+}
+
+func newHub(presence bool) *Hub {
+	return &Hub{
+		presence:  presence,
+		clients:   make(map[*wsClient]bool),
+		broadcast: make(chan wsEvent, hubBroadcastBuffer),
+		joins:     make(chan *wsClient),
+		leaves:    make(chan *wsClient),
+	}
+}
+
+func (h *Hub) register(client *wsClient) {
+	h.joins <- client
+}
+
+func (h *Hub) unregister(client *wsClient) {
+	h.leaves <- client
+}
+
+// 放進 broadcast，不會阻塞；已滿時丟棄最舊的事件
+func (h *Hub) publish(event wsEvent) {
+	for {
+		select {
+		case h.broadcast <- event:
+			return
+		default:
+		}
+		select {
+		case <-h.broadcast:
+			if n := h.droppedEvents.Add(1); n%100 == 1 {
+				slog.Warn("Hub broadcast queue full, dropping oldest events", "dropped_total", n)
+			}
+		default:
+		}
+	}
+}
+
+// 連線數與丟棄的訊息數，列在 /api/admin/stats
+type hubStats struct {
+	Clients         int   `json:"clients"`
+	DroppedEvents   int64 `json:"dropped_events"`
+	DroppedMessages int64 `json:"dropped_messages"`
+}
+
+func (h *Hub) stats() hubStats {
+	h.mutex.Lock()
+	clients := len(h.clients)
+	h.mutex.Unlock()
+	return hubStats{Clients: clients, DroppedEvents: h.droppedEvents.Load(), DroppedMessages: h.droppedMessages.Load()}
+}
+
+// 回傳給前端的訊息格式
+type WSResponse struct {
+	Type string      `json:"type"`          // "history", "update", "new_task", "error"
+	Seq  int64       `json:"seq,omitempty"` // 推播的訊息才有，見 resume.go
+	Data interface{} `json:"data"`
+}
+
+func (h *Hub) run() {
+	for {
+		select {
+		case client := <-h.joins:
+			h.mutex.Lock()
+			h.clients[client] = true
+			h.mutex.Unlock()
+			h.notifyPresence("join", client)
+		case client := <-h.leaves:
+			h.mutex.Lock()
+			delete(h.clients, client)
+			h.mutex.Unlock()
+			h.notifyPresence("leave", client)
+		case event := <-h.broadcast:
+			// 推播給訂閱該任務的連線；只放進各連線的送出佇列，不會因為某個連線卡住而拖慢其他人
+			h.mutex.Lock()
+			for client := range h.clients {
+				if !client.wants(event) {
+					continue
+				}
+				if !client.enqueue(event.Payload) {
+					delete(h.clients, client)
+				}
+			}
+			h.mutex.Unlock()
+		}
+	}
+}
+
+// 通知所有 WebSocket client 伺服器即將關閉並中斷連線 (SSE 連線直接結束)
+func (h *Hub) closeAll() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for client := range h.clients {
+		// WriteControl 可以和 writePump 同時呼叫
+		if client.conn != nil {
+			client.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		}
+		client.close()
+		delete(h.clients, client)
+	}
+}
+
+// 進度訊息直接放在最上層：{"type":"progress","task_id":..,"percent":..}
+type WSProgress struct {
+	Type    string `json:"type"`
+	Seq     int64  `json:"seq"`
+	TaskID  uint   `json:"task_id"`
+	Percent int    `json:"percent"`
+}
+
+func (h *Hub) notifyProgress(task *Task, percent int) {
+	jsonResp, _ := json.Marshal(WSProgress{Type: "progress", Seq: h.nextSeq(), TaskID: task.ID, Percent: percent})
+	h.publish(wsEvent{TaskID: task.ID, UserID: task.UserID, WorkspaceID: task.WorkspaceID, Payload: jsonResp})
+}
+
+// 多張輸出時每寫完一張推播：{"type":"image","task_id":..,"index":1,"count":4,"seed":..}；
+// 圖片在任務完成 (update) 之後才能讀取
+type WSImage struct {
+	Type   string `json:"type"`
+	Seq    int64  `json:"seq"`
+	TaskID uint   `json:"task_id"`
+	Index  int    `json:"index"`
+	Count  int    `json:"count"`
+	Seed   *int64 `json:"seed,omitempty"`
+}
+
+func (h *Hub) notifyImage(task *Task, index, count int, seed *int64) {
+	jsonResp, _ := json.Marshal(WSImage{Type: "image", Seq: h.nextSeq(), TaskID: task.ID, Index: index, Count: count, Seed: seed})
+	h.publish(wsEvent{TaskID: task.ID, UserID: task.UserID, WorkspaceID: task.WorkspaceID, Payload: jsonResp})
+}
+
+type WSPosition struct {
+	Type       string `json:"type"`
+	Seq        int64  `json:"seq"`
+	TaskID     uint   `json:"task_id"`
+	Position   int    `json:"position"`
+	ETASeconds int    `json:"eta_seconds,omitempty"`
+}
+
+func (h *Hub) notifyPosition(task Task, position, etaSeconds int) {
+	jsonResp, _ := json.Marshal(WSPosition{Type: "position", Seq: h.nextSeq(), TaskID: task.ID, Position: position, ETASeconds: etaSeconds})
+	h.publish(wsEvent{TaskID: task.ID, UserID: task.UserID, WorkspaceID: task.WorkspaceID, Payload: jsonResp})
+}
+
+type WSQueueState struct {
+	Type string `json:"type"`
+	Seq  int64  `json:"seq,omitempty"`
+	QueueState
+}
+
+func (h *Hub) notifyQueueState(state QueueState) {
+	jsonResp, _ := json.Marshal(WSQueueState{Type: "queue_state", Seq: h.nextSeq(), QueueState: state})
+	h.publish(wsEvent{Global: true, Payload: jsonResp})
+}
+
+func (h *Hub) notifyUpdate(task Task) {
+	h.notify("update", task)
+}
+
+// 將任務包裝成 WSResponse 後推播給訂閱該任務的前端
+func (h *Hub) notify(msgType string, task Task) {
+	resp := WSResponse{Type: msgType, Seq: h.nextSeq(), Data: task}
+	jsonResp, _ := json.Marshal(resp)
+	h.publish(wsEvent{TaskID: task.ID, UserID: task.UserID, WorkspaceID: task.WorkspaceID, Payload: jsonResp})
+}
+
+// 是否要把這個事件送給此連線 (呼叫端需持有 hub.mutex)
+func (c *wsClient) wants(event wsEvent) bool {
+	if event.Global {
+		return true
+	}
+	if c.all && c.identity.sees(event.UserID, event.WorkspaceID) {
+		return true
+	}
+	return c.subs[event.TaskID]
+}
+
+// 調整連線的訂閱清單，只能訂閱自己 (或所選工作區) 的任務
+func (c *wsClient) subscribe(db *gorm.DB, ids []uint, all bool) {
+	if len(ids) > 0 && c.identity.userID() != 0 {
+		var owned []uint
+		c.identity.taskScope(db.Model(&Task{})).Where("id IN ?", ids).Pluck("id", &owned)
+		ids = owned
+	}
+	c.hub.mutex.Lock()
+	defer c.hub.mutex.Unlock()
+	for _, id := range ids {
+		c.subs[id] = true
+	}
+	if all {
+		c.all = true
+	}
+}
+
+func (c *wsClient) unsubscribe(ids []uint, all bool) {
+	c.hub.mutex.Lock()
+	defer c.hub.mutex.Unlock()
+	for _, id := range ids {
+		delete(c.subs, id)
+	}
+	if all {
+		c.all = false
+	}
+}
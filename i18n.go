@@ -0,0 +1,471 @@
+// i18n.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- 多語系 ---
+// REST 與 WebSocket 回給前端的錯誤訊息 (message) 依語系翻譯，code 與 details 不變，程式仍以 code 判斷。
+// 語系依序取自使用者設定 (PUT /api/me/locale)、Accept-Language、DEFAULT_LOCALE，
+// 決定後放在回應的 Content-Language 與 request context。
+// 錯誤建立時保留英文格式 (message ID) 與參數 (見 newCodedError、invalidf)，寫出時才以格式查表翻譯，
+// 參數本身是訊息或錯誤時也一併翻譯；沒有譯文的訊息維持英文。任務狀態等前端自行顯示的文字由 GET /api/i18n 提供
+
+const (
+	localeEN   = "en"
+	localeZhTW = "zh-TW"
+)
+
+var supportedLocales = []string{localeEN, localeZhTW}
+
+type localeKey struct{}
+
+// 從環境變數 DEFAULT_LOCALE 取得，不支援的語系由 Config.Problems 回報
+func defaultLocale() string {
+	if locale := normalizeLocale(os.Getenv("DEFAULT_LOCALE")); locale != "" {
+		return locale
+	}
+	return localeEN
+}
+
+func localeProblems() []string {
+	if s := os.Getenv("DEFAULT_LOCALE"); s != "" && normalizeLocale(s) == "" {
+		return []string{fmt.Sprintf("DEFAULT_LOCALE=%q: must be %s", s, strings.Join(supportedLocales, " or "))}
+	}
+	return nil
+}
+
+// 語言標籤對應到支援的語系，不支援時回傳空字串；中文一律使用 zh-TW
+func normalizeLocale(tag string) string {
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	switch {
+	case tag == "en" || strings.HasPrefix(tag, "en-"):
+		return localeEN
+	case tag == "zh" || strings.HasPrefix(tag, "zh-"):
+		return localeZhTW
+	}
+	return ""
+}
+
+// 依 Accept-Language 的 q 值選擇支援的語系，都不支援時使用 def
+func negotiateLocale(acceptLanguage, def string) string {
+	type candidate struct {
+		locale string
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if s, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(s, 64); err == nil {
+				q = v
+			}
+		}
+		if locale := normalizeLocale(tag); locale != "" && q > 0 {
+			candidates = append(candidates, candidate{locale, q})
+		}
+	}
+	if len(candidates) == 0 {
+		return def
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].locale
+}
+
+// 依 Accept-Language 決定語系，已登入的使用者有設定時由 requireAuth 覆寫
+func withLocale(def string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, withRequestLocale(w, r, negotiateLocale(r.Header.Get("Accept-Language"), def)))
+	})
+}
+
+// 設定回應的語系：Content-Language 給 writeError 使用，context 給 WebSocket / SSE 連線使用
+func withRequestLocale(w http.ResponseWriter, r *http.Request, locale string) *http.Request {
+	w.Header().Set("Content-Language", locale)
+	return r.WithContext(context.WithValue(r.Context(), localeKey{}, locale))
+}
+
+func localeFrom(ctx context.Context) string {
+	if locale, ok := ctx.Value(localeKey{}).(string); ok {
+		return locale
+	}
+	return localeEN
+}
+
+// 回應已決定的語系
+func responseLocale(w http.ResponseWriter) string {
+	return w.Header().Get("Content-Language")
+}
+
+// 英文訊息格式 (message ID) -> 譯文，譯文使用與原文相同的動詞，順序不同時以 %[n] 指定參數
+var messageCatalog = map[string]map[string]string{
+	localeZhTW: {
+		// 驗證與權限
+		"invalid or missing API key":              "API key 無效或未提供",
+		"invalid or missing admin token":          "管理員 token 無效或未提供",
+		"admin API is disabled":                   "管理 API 已停用",
+		"%s role required":                        "需要 %s 角色",
+		"workspaces require authentication":       "使用工作區需要驗證",
+		"only workspace owners can do this":       "只有工作區擁有者可以執行此操作",
+		"origin not allowed":                      "不允許的來源網域",
+		"this account is not allowed to sign in":  "這個帳號不允許登入",
+		"login failed":                            "登入失敗",
+		"login failed: %s":                        "登入失敗：%s",
+		"login session expired, please try again": "登入階段已過期，請重新登入",
+		"login provider is unavailable":           "登入服務目前無法使用",
+		"unknown login provider":                  "未知的登入服務",
+		"invalid login state":                     "登入狀態無效",
+
+		// 一般請求格式
+		"invalid body":                         "請求內容格式錯誤",
+		"invalid JSON body":                    "JSON 格式錯誤",
+		"invalid multipart form":               "multipart 表單格式錯誤",
+		"invalid %s":                           "%s 格式錯誤",
+		"invalid limit":                        "limit 格式錯誤",
+		"invalid task id":                      "任務 ID 格式錯誤",
+		"invalid task_ids":                     "task_ids 格式錯誤",
+		"task_ids is required":                 "需要 task_ids",
+		"invalid overrides: %v":                "overrides 格式錯誤：%v",
+		"invalid locale":                       "不支援的語系",
+		"locale requires a user account":       "設定語系需要使用者帳號",
+		"invalid batch id":                     "批次 ID 格式錯誤",
+		"invalid collection id":                "收藏集 ID 格式錯誤",
+		"invalid comment id":                   "留言 ID 格式錯誤",
+		"invalid comparison id":                "比較 ID 格式錯誤",
+		"invalid prompt id":                    "prompt ID 格式錯誤",
+		"invalid share id":                     "分享連結 ID 格式錯誤",
+		"invalid tag id":                       "標籤 ID 格式錯誤",
+		"invalid template id":                  "範本 ID 格式錯誤",
+		"invalid user id":                      "使用者 ID 格式錯誤",
+		"invalid workspace id":                 "工作區 ID 格式錯誤",
+		"invalid user_id":                      "user_id 格式錯誤",
+		"invalid source_task_id":               "source_task_id 格式錯誤",
+		"invalid control_source_task_id":       "control_source_task_id 格式錯誤",
+		"invalid enhance":                      "enhance 格式錯誤",
+		"invalid seed":                         "seed 格式錯誤",
+		"invalid expires_at":                   "expires_at 格式錯誤",
+		"invalid scheduled_at":                 "scheduled_at 格式錯誤",
+		"invalid from":                         "from 格式錯誤",
+		"invalid to":                           "to 格式錯誤",
+		"invalid from date":                    "起始日期格式錯誤",
+		"invalid to date":                      "結束日期格式錯誤",
+		"invalid since":                        "since 格式錯誤",
+		"invalid older_than":                   "older_than 格式錯誤",
+		"invalid page":                         "page 格式錯誤",
+		"invalid page_size":                    "page_size 格式錯誤",
+		"invalid index":                        "index 格式錯誤",
+		"invalid timeout":                      "timeout 格式錯誤",
+		"from must not be after to":            "from 不能晚於 to",
+		"size must be thumb, full or upscaled": "size 必須是 thumb、full 或 upscaled",
+
+		// 找不到
+		"task not found":                             "找不到任務",
+		"task %d not found":                          "找不到任務 %d",
+		"task not found in trash":                    "垃圾桶中找不到任務",
+		"task not found in quarantine":               "隔離區中找不到任務",
+		"batch not found":                            "找不到批次",
+		"comparison not found":                       "找不到比較",
+		"comment not found":                          "找不到留言",
+		"collection not found":                       "找不到收藏集",
+		"tag not found":                              "找不到標籤",
+		"template not found":                         "找不到範本",
+		"template not found: %s":                     "找不到範本：%s",
+		"prompt not found":                           "找不到 prompt",
+		"preview not found":                          "找不到預覽圖",
+		"share link not found":                       "找不到分享連結",
+		"share link has expired":                     "分享連結已過期",
+		"workspace not found":                        "找不到工作區",
+		"member not found":                           "找不到成員",
+		"user not found":                             "找不到使用者",
+		"client not found":                           "找不到連線",
+		"image not available":                        "圖片無法使用",
+		"image file not found":                       "找不到圖檔",
+		"image quarantined by content filter":        "圖片已被內容檢查隔離",
+		"task not in collection":                     "任務不在收藏集中",
+		"no images available for the selected tasks": "選擇的任務沒有可用的圖片",
+		"prompt denylist is not configured":          "沒有設定 prompt 禁用詞清單",
+		"unknown user: %s":                           "未知的使用者：%s",
+
+		// 任務狀態
+		"task cannot be created as %s":        "無法以 %s 狀態建立任務",
+		"task cannot move from %s to %s":      "任務無法從 %s 變更為 %s",
+		"task cannot be imported as %s":       "無法匯入 %s 狀態的任務",
+		"task was changed by another request": "任務已被其他請求修改",
+		"task is not awaiting approval":       "任務不在等待審核狀態",
+		"only completed tasks can be shared":  "只有已完成的任務可以分享",
+		"task is processing":                  "任務正在執行中",
+		"task is not pending or processing":   "任務不在排隊或執行中",
+
+		// 生成參數
+		"prompt is required":                                                 "需要 prompt",
+		"prompt and template cannot be used together":                        "prompt 與 template 不能同時使用",
+		"missing template variables: %s":                                     "缺少範本變數：%s",
+		"%s must be a multiple of 8 between 64 and 2048":                     "%s 必須是 8 的倍數，介於 64 到 2048",
+		"steps must be between 1 and 150":                                    "steps 必須介於 1 到 150",
+		"guidance_scale must be between 0 and 30":                            "guidance_scale 必須介於 0 到 30",
+		"seed must not be negative":                                          "seed 不能是負數",
+		"strength must be between 0 and 1":                                   "strength 必須介於 0 到 1",
+		"num_images must be between 1 and %d":                                "num_images 必須介於 1 到 %d",
+		"upscale must be 2 or 4":                                             "upscale 必須是 2 或 4",
+		"upscale is not available on this server":                            "這台伺服器不提供放大",
+		"format must be png, jpeg or webp":                                   "format 必須是 png、jpeg 或 webp",
+		"quality must be between 1 and 100":                                  "quality 必須介於 1 到 100",
+		"quality only applies to format jpeg":                                "quality 只適用於 jpeg 格式",
+		"watermark is not configured on this server":                         "這台伺服器沒有設定浮水印",
+		"watermark cannot be disabled on this server":                        "這台伺服器不允許關閉浮水印",
+		"model selection is not available on this server":                    "這台伺服器不提供模型選擇",
+		"unknown model: %s":                                                  "未知的模型：%s",
+		"at most %d loras":                                                   "最多 %d 個 LoRA",
+		"lora name is required":                                              "需要 LoRA 名稱",
+		"duplicate lora: %s":                                                 "重複的 LoRA：%s",
+		"unknown lora: %s":                                                   "未知的 LoRA：%s",
+		"lora weight must be between %g and %g":                              "LoRA 權重必須介於 %g 到 %g",
+		"loras are not available on this server":                             "這台伺服器不提供 LoRA",
+		"invalid loras":                                                      "loras 格式錯誤",
+		"control_type must be %s":                                            "control_type 必須是 %s",
+		"control_scale must be between 0 and %g":                             "control_scale 必須介於 0 到 %g",
+		"control_scale requires control_type":                                "control_scale 需要搭配 control_type",
+		"control_type is required with a control_image":                      "有 control_image 時需要 control_type",
+		"control_type requires a control_image (POST /api/tasks/controlnet)": "control_type 需要搭配 control_image (POST /api/tasks/controlnet)",
+		"controlnet %s is not supported by the %s generator":                 "%[2]s 生成後端不支援 controlnet %[1]s",
+		"control_image file or control_source_task_id is required":           "需要 control_image 檔案或 control_source_task_id",
+		"callback_url must be an http or https URL":                          "callback_url 必須是 http 或 https 網址",
+		"expires_at must be in the future":                                   "expires_at 必須是未來的時間",
+		"expires_at must be after scheduled_at":                              "expires_at 必須晚於 scheduled_at",
+		"at most %d tags per task":                                           "每個任務最多 %d 個標籤",
+		"tag %q must be 1-%d characters without commas":                      "標籤 %q 必須是 1 到 %d 個字元且不含逗號",
+		"count must be between 1 and %d":                                     "count 必須介於 1 到 %d",
+		"prompts or count is required":                                       "需要 prompts 或 count",
+		"batch size must not exceed %d":                                      "批次數量不能超過 %d",
+		"no tasks to create":                                                 "沒有要建立的任務",
+		"%s must not be negative":                                            "%s 不能是負數",
+		"n must be between 1 and %d":                                         "n 必須介於 1 到 %d",
+		"batch_size × n_iter must not exceed %d":                             "batch_size × n_iter 不能超過 %d",
+		"size must be WIDTHxHEIGHT, for example 1024x1024":                   "size 必須是 寬x高，例如 1024x1024",
+		"response_format must be url or b64_json":                            "response_format 必須是 url 或 b64_json",
+		"sd_model_checkpoint must be a string":                               "sd_model_checkpoint 必須是字串",
+		"unknown sd_model_checkpoint %q":                                     "未知的 sd_model_checkpoint %q",
+		"expires_in must be a positive duration such as 72h":                 "expires_in 必須是正的時間長度，例如 72h",
+		"expires_in and expires_at cannot be combined":                       "expires_in 與 expires_at 不能同時使用",
+
+		// 比較
+		"prompts or models is required":                      "需要 prompts 或 models",
+		"compare either two prompts or two models, not both": "只能比較兩個 prompt 或兩個模型，不能同時使用",
+		"prompts must contain exactly 2 prompts":             "prompts 必須剛好包含 2 個 prompt",
+		"prompts must be different":                          "prompts 必須不同",
+		"models must contain exactly 2 models":               "models 必須剛好包含 2 個模型",
+		"models must be different":                           "models 必須不同",
+		"model cannot be combined with models":               "model 不能與 models 同時使用",
+		"num_images is not supported for comparisons":        "比較不支援 num_images",
+
+		// prompt 檢查與擴寫
+		"%s: %s at position %d": "%[1]s 第 %[3]d 個字元：%[2]s",
+		"unexpected %q":         "多餘的 %q",
+		"unclosed %q":           "%q 沒有關閉",
+		"%q does not match %q (opened at position %d)":        "%[1]q 與第 %[3]d 個字元的 %[2]q 不成對",
+		"invalid weight %q":                                   "權重 %q 格式錯誤",
+		"weight %s must be between 0 and %g":                  "權重 %s 必須介於 0 到 %g",
+		"prompt rejected by content policy":                   "prompt 違反內容政策",
+		"prompt enhancement is not configured on this server": "這台伺服器沒有設定 prompt 擴寫",
+		"prompt enhancement failed: %v":                       "prompt 擴寫失敗：%v",
+		"enhanced prompt is invalid: %v":                      "擴寫後的 prompt 格式錯誤：%v",
+
+		// 配額
+		"quota exceeded: at most %d queued tasks per user":            "超過配額：每位使用者最多 %d 個排隊中的任務",
+		"quota exceeded: at most %d tasks per hour":                   "超過配額：每小時最多 %d 個任務",
+		"quota exceeded: at most %d queued tasks per workspace":       "超過配額：每個工作區最多 %d 個排隊中的任務",
+		"quota exceeded: at most %d tasks per hour in this workspace": "超過配額：這個工作區每小時最多 %d 個任務",
+
+		// 上傳與匯入
+		"%s must be a PNG, JPEG or WebP image":           "%s 必須是 PNG、JPEG 或 WebP 圖片",
+		"%s must not exceed 4096x4096":                   "%s 不能超過 4096x4096",
+		"image file or source_task_id is required":       "需要圖片檔案或 source_task_id",
+		"source task %d has no image":                    "來源任務 %d 沒有圖片",
+		"mask requires an image":                         "mask 需要搭配圖片",
+		"mask must have the same size as the image":      "mask 必須與圖片大小相同",
+		"upload must not exceed %d MB":                   "上傳檔案不能超過 %d MB",
+		"import must not exceed %d MB":                   "匯入檔案不能超過 %d MB",
+		"import must not exceed %d MB when decompressed": "匯入檔案解壓後不能超過 %d MB",
+		"%s must not exceed %d MB when decompressed":     "%s 解壓後不能超過 %d MB",
+		"invalid zip file":                               "zip 檔案格式錯誤",
+		"at most %d tasks per archive":                   "每個封存檔最多 %d 個任務",
+		"invalid import body":                            "匯入內容格式錯誤",
+		"manifest.json is missing or invalid":            "manifest.json 不存在或格式錯誤",
+		"unsupported export version %d":                  "不支援的匯出檔版本 %d",
+		"tasks.jsonl is missing":                         "缺少 tasks.jsonl",
+		"tasks.jsonl line %d: %v":                        "tasks.jsonl 第 %d 行：%v",
+		"tasks.jsonl: %v":                                "tasks.jsonl：%v",
+
+		// 留言、範本、工作區
+		"text is required":                     "需要內容",
+		"text must not exceed %d characters":   "內容不能超過 %d 個字元",
+		"image_index must be between 0 and %d": "image_index 必須介於 0 到 %d",
+		"region_x, region_y, region_width and region_height must be set together":                "region_x、region_y、region_width 與 region_height 必須同時設定",
+		"region must lie within the image (fractions between 0 and 1)":                           "region 必須在圖片範圍內 (介於 0 到 1 的比例)",
+		"name is required and must not exceed %d characters":                                     "需要名稱，且不能超過 %d 個字元",
+		"name is required, must not exceed %d characters and may only contain a-z, 0-9, - and _": "需要名稱，不能超過 %d 個字元，且只能包含 a-z、0-9、- 與 _",
+		"template is required and must not exceed %d characters":                                 "需要範本內容，且不能超過 %d 個字元",
+		"only the author can edit a comment":                                                     "只有留言者可以修改留言",
+		"only the author or the task owner can delete a comment":                                 "只有留言者或任務擁有者可以刪除留言",
+		"template name already exists":                                                           "範本名稱已存在",
+		"workspace must keep at least one owner":                                                 "工作區至少需要一位擁有者",
+		"role must be owner or member":                                                           "role 必須是 owner 或 member",
+		"role must be viewer, creator or admin":                                                  "role 必須是 viewer、creator 或 admin",
+		"cannot remove your own admin role":                                                      "不能移除自己的 admin 角色",
+
+		// 通知
+		"discord notifications are not configured on this server": "這台伺服器沒有設定 Discord 通知",
+		"slack notifications are not configured on this server":   "這台伺服器沒有設定 Slack 通知",
+
+		// WebSocket
+		"messages must be JSON text frames":            "訊息必須是 JSON 文字訊框",
+		"invalid JSON message: %v":                     "JSON 訊息格式錯誤：%v",
+		"message type is required":                     "需要訊息類型 (type)",
+		"unknown message type %q":                      "未知的訊息類型 %q",
+		"protocol version %d is not supported (%d-%d)": "不支援協定版本 %d (%d-%d)",
+	},
+}
+
+// 任務狀態的顯示文字，由 GET /api/i18n 提供給前端
+var statusLabels = map[string]map[TaskStatus]string{
+	localeEN: {
+		StatusAwaitingApproval: "Awaiting approval",
+		StatusPending:          "Pending",
+		StatusRetrying:         "Retrying",
+		StatusProcessing:       "Processing",
+		StatusCompleted:        "Completed",
+		StatusFailed:           "Failed",
+		StatusCancelled:        "Cancelled",
+	},
+	localeZhTW: {
+		StatusAwaitingApproval: "等待審核",
+		StatusPending:          "排隊中",
+		StatusRetrying:         "等待重試",
+		StatusProcessing:       "繪製中",
+		StatusCompleted:        "已完成",
+		StatusFailed:           "失敗",
+		StatusCancelled:        "已取消",
+	},
+}
+
+// 可翻譯的訊息：Format 是英文的 fmt 格式，同時作為查表的 message ID
+type message struct {
+	Format string
+	Args   []interface{}
+}
+
+func newMessage(format string, args ...interface{}) message {
+	return message{Format: format, Args: args}
+}
+
+// 英文訊息
+func (m message) String() string {
+	if len(m.Args) == 0 {
+		return m.Format
+	}
+	return fmt.Sprintf(m.Format, m.Args...)
+}
+
+// 依語系翻譯，沒有譯文時回傳英文；參數是訊息或錯誤時也翻譯
+func (m message) localize(locale string) string {
+	translation, ok := messageCatalog[locale][m.Format]
+	if !ok {
+		return m.String()
+	}
+	if len(m.Args) == 0 {
+		return translation
+	}
+	args := make([]interface{}, len(m.Args))
+	for i, arg := range m.Args {
+		args[i] = localizeArg(locale, arg)
+	}
+	return fmt.Sprintf(translation, args...)
+}
+
+// 保留 message ID 與參數的錯誤 (codedError、ValidationError 等)
+type localizedError interface {
+	error
+	localMessage() message
+}
+
+func localizeArg(locale string, arg interface{}) interface{} {
+	switch v := arg.(type) {
+	case message:
+		return v.localize(locale)
+	case localizedError:
+		return v.localMessage().localize(locale)
+	}
+	return arg
+}
+
+// 錯誤訊息依語系翻譯；以 fmt.Errorf 包裝過的錯誤沒有 message ID，維持英文
+func localizeError(locale string, err error) string {
+	if e, ok := err.(localizedError); ok {
+		return e.localMessage().localize(locale)
+	}
+	return err.Error()
+}
+
+// GET /api/i18n 的回應
+type i18nResponse struct {
+	Locale   string                `json:"locale"`
+	Locales  []string              `json:"locales"`
+	Statuses map[TaskStatus]string `json:"statuses"`
+}
+
+// GET /api/i18n
+func (a *App) apiI18n(w http.ResponseWriter, r *http.Request) {
+	locale := localeFrom(r.Context())
+	writeJSON(w, http.StatusOK, i18nResponse{Locale: locale, Locales: supportedLocales, Statuses: statusLabels[locale]})
+}
+
+// PUT /api/me/locale 的請求與回應 (回應為之後使用的語系)
+type localeSetting struct {
+	Locale string `json:"locale"`
+}
+
+// PUT /api/me/locale {"locale": "zh-TW"}，空字串代表改回依 Accept-Language 決定
+func (a *App) apiUpdateLocale(w http.ResponseWriter, r *http.Request) {
+	var req localeSetting
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	locale := normalizeLocale(req.Locale)
+	if req.Locale != "" && locale == "" {
+		writeTaskError(w, invalidField("locale", "invalid locale"))
+		return
+	}
+	userID := identityFromRequest(r).userID()
+	if userID == 0 {
+		writeTaskError(w, invalidField("locale", "locale requires a user account"))
+		return
+	}
+	if err := a.DB.Model(&User{}).Where("id = ?", userID).Update("locale", locale).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if locale == "" {
+		locale = negotiateLocale(r.Header.Get("Accept-Language"), a.Config.DefaultLocale)
+	}
+	w.Header().Set("Content-Language", locale)
+	writeJSON(w, http.StatusOK, localeSetting{Locale: locale})
+}
+
+// 使用者設定的語系是否有效 (資料庫中的值可能來自舊版本)
+func userLocale(locale string) string {
+	if slices.Contains(supportedLocales, locale) {
+		return locale
+	}
+	return ""
+}
@@ -0,0 +1,224 @@
+// i18n_test.go
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 建立可翻譯訊息的函式與其格式 (message ID) 參數的位置
+var messageFormatArgs = map[string]int{
+	"newMessage":    0,
+	"newCodedError": 1,
+	"invalidf":      0,
+	"invalidField":  1,
+	"writeError":    2,
+	"writeErrorf":   2,
+	"quotaExceeded": 1,
+	"fail":          2, // checkPromptSyntax
+}
+
+// 從原始碼收集程式實際產生的訊息格式與第一次出現的位置
+func sourceMessageFormats(t *testing.T) map[string]token.Position {
+	t.Helper()
+	files, err := os.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	formats := make(map[string]token.Position)
+	fset := token.NewFileSet()
+	add := func(expr ast.Expr) {
+		if lit, ok := expr.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			if s, err := strconv.Unquote(lit.Value); err == nil {
+				if _, ok := formats[s]; !ok {
+					formats[s] = fset.Position(lit.Pos())
+				}
+			}
+		}
+	}
+	for _, entry := range files {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				if fn, ok := n.Fun.(*ast.Ident); ok {
+					if i, ok := messageFormatArgs[fn.Name]; ok && i < len(n.Args) {
+						add(n.Args[i])
+					}
+				}
+			case *ast.CompositeLit:
+				if typ, ok := n.Type.(*ast.Ident); ok && (typ.Name == "codedError" || typ.Name == "apiError") {
+					for _, elt := range n.Elts {
+						if kv, ok := elt.(*ast.KeyValueExpr); ok {
+							if key, ok := kv.Key.(*ast.Ident); ok && key.Name == "Message" {
+								add(kv.Value)
+							}
+						}
+					}
+				}
+			}
+			return true
+		})
+	}
+	return formats
+}
+
+func TestMessageCatalogMatchesSource(t *testing.T) {
+	formats := sourceMessageFormats(t)
+	for locale, catalog := range messageCatalog {
+		for format := range catalog {
+			if _, ok := formats[format]; !ok {
+				t.Errorf("%s: catalog entry %q is not produced by any error", locale, format)
+			}
+		}
+		// 反方向：程式回給使用者的訊息都要有譯文
+		for format, pos := range formats {
+			if _, ok := catalog[format]; !ok {
+				t.Errorf("%s: %s: message %q is missing from the catalog", locale, pos, format)
+			}
+		}
+	}
+}
+
+var formatVerb = regexp.MustCompile(`%(\[(\d+)\])?[-+# 0-9.]*([a-zA-Z%])`)
+
+// 格式中各參數 (從 1 起算) 使用的動詞
+func formatVerbs(format string) map[int]string {
+	verbs := make(map[int]string)
+	arg := 0
+	for _, m := range formatVerb.FindAllStringSubmatch(format, -1) {
+		if m[3] == "%" {
+			continue
+		}
+		if m[2] != "" {
+			arg, _ = strconv.Atoi(m[2])
+		} else {
+			arg++
+		}
+		verbs[arg] = m[3]
+	}
+	return verbs
+}
+
+func TestMessageCatalogVerbs(t *testing.T) {
+	for locale, catalog := range messageCatalog {
+		for format, translation := range catalog {
+			if want, got := formatVerbs(format), formatVerbs(translation); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %q uses verbs %v, want %v as in %q", locale, translation, got, want, format)
+			}
+		}
+	}
+}
+
+func TestLocalizeError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		en   string
+		zhTW string
+	}{
+		{
+			name: "validation",
+			err:  invalidField("width", "%s must be a multiple of 8 between 64 and 2048", "width"),
+			en:   "width must be a multiple of 8 between 64 and 2048",
+			zhTW: "width 必須是 8 的倍數，介於 64 到 2048",
+		},
+		{
+			name: "quoted argument",
+			err:  invalidField("tags", "tag %q must be 1-%d characters without commas", "a,b", 32),
+			en:   `tag "a,b" must be 1-32 characters without commas`,
+			zhTW: `標籤 "a,b" 必須是 1 到 32 個字元且不含逗號`,
+		},
+		{
+			name: "prompt syntax",
+			err:  checkPromptSyntax("prompt", "a cat (b"),
+			en:   `prompt: unclosed '(' at position 6`,
+			zhTW: `prompt 第 6 個字元：'(' 沒有關閉`,
+		},
+		{
+			name: "nested message",
+			err:  enhanceError(newMessage("enhanced prompt is invalid: %v", checkPromptSyntax("prompt", "x)"))),
+			en:   `prompt enhancement failed: enhanced prompt is invalid: prompt: unexpected ')' at position 1`,
+			zhTW: `prompt 擴寫失敗：擴寫後的 prompt 格式錯誤：prompt 第 1 個字元：多餘的 ')'`,
+		},
+		{
+			name: "moderation",
+			err:  &ModerationError{Matched: []string{"x"}},
+			en:   "prompt rejected by content policy",
+			zhTW: "prompt 違反內容政策",
+		},
+		{
+			name: "quota",
+			err:  quotaExceeded(5, "quota exceeded: at most %d tasks per hour"),
+			en:   "quota exceeded: at most 5 tasks per hour",
+			zhTW: "超過配額：每小時最多 5 個任務",
+		},
+		{
+			name: "static coded error",
+			err:  errTaskNotFound,
+			en:   "task not found",
+			zhTW: "找不到任務",
+		},
+		{
+			name: "untranslated",
+			err:  invalidf("not in the catalog"),
+			en:   "not in the catalog",
+			zhTW: "not in the catalog",
+		},
+		{
+			name: "wrapped",
+			err:  fmt.Errorf("import: %w", errTaskNotFound),
+			en:   "import: task not found",
+			zhTW: "import: task not found",
+		},
+	}
+	for _, tt := range tests {
+		if tt.err == nil {
+			t.Errorf("%s: no error", tt.name)
+			continue
+		}
+		if got := tt.err.Error(); got != tt.en {
+			t.Errorf("%s: Error() = %q, want %q", tt.name, got, tt.en)
+		}
+		if got := localizeError(localeEN, tt.err); got != tt.en {
+			t.Errorf("%s: en = %q, want %q", tt.name, got, tt.en)
+		}
+		if got := localizeError(localeZhTW, tt.err); got != tt.zhTW {
+			t.Errorf("%s: zh-TW = %q, want %q", tt.name, got, tt.zhTW)
+		}
+	}
+}
+
+func TestNegotiateLocale(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", localeEN},
+		{"zh-TW,zh;q=0.9,en;q=0.8", localeZhTW},
+		{"en-US,zh;q=0.5", localeEN},
+		{"fr,zh-CN;q=0.7", localeZhTW},
+		{"fr,de", localeEN},
+		{"zh;q=0,en;q=0.1", localeEN},
+	}
+	for _, tt := range tests {
+		if got := negotiateLocale(tt.header, localeEN); got != tt.want {
+			t.Errorf("negotiateLocale(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
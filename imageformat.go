@@ -0,0 +1,100 @@
+// imageformat.go
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- 輸出格式 ---
+// 任務可以指定 format：png (預設)、jpeg (quality 1-100，預設 90) 或 webp (無失真，見 webp.go)。
+// 生成後端一律輸出 PNG，worker 在內容安全檢查之後、產生縮圖與中繼資料之前轉換；放大後的圖片使用相同格式。
+// 實際格式記錄在任務的 content_type，下載圖片時以此設定 Content-Type
+
+const defaultJPEGQuality = 90
+
+type outputFormat struct {
+	Ext         string
+	ContentType string
+}
+
+var outputFormats = map[string]outputFormat{
+	"png":  {".png", "image/png"},
+	"jpeg": {".jpg", "image/jpeg"},
+	"webp": {".webp", "image/webp"},
+}
+
+func (p GenerationParams) validateFormat() error {
+	if p.Format != "" {
+		if _, ok := outputFormats[p.Format]; !ok {
+			return invalidField("format", "format must be png, jpeg or webp")
+		}
+	}
+	if p.Quality != 0 {
+		if p.Format != "jpeg" {
+			return invalidField("quality", "quality only applies to format jpeg")
+		}
+		if p.Quality < 1 || p.Quality > 100 {
+			return invalidField("quality", "quality must be between 1 and 100")
+		}
+	}
+	return nil
+}
+
+// 任務的輸出格式，沒有指定時為 png
+func (p GenerationParams) outputFormat() outputFormat {
+	if f, ok := outputFormats[p.Format]; ok {
+		return f
+	}
+	return outputFormats["png"]
+}
+
+// 換成輸出格式的副檔名：task_1_123.png -> task_1_123.jpg
+func (p GenerationParams) outputName(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + p.outputFormat().Ext
+}
+
+// 把生成後端 (或放大) 寫出的 PNG 轉成任務要求的格式，回傳新的路徑並刪除原檔；PNG 不需要轉換
+func (p GenerationParams) convertImage(path string) (string, error) {
+	dst := p.outputName(path)
+	if dst == path {
+		return path, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return "", fmt.Errorf("decode %s: %w", filepath.Base(path), err)
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	switch p.Format {
+	case "jpeg":
+		quality := p.Quality
+		if quality == 0 {
+			quality = defaultJPEGQuality
+		}
+		err = jpeg.Encode(out, img, &jpeg.Options{Quality: quality})
+	case "webp":
+		err = encodeWebP(out, img)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return "", fmt.Errorf("encode %s: %w", p.Format, err)
+	}
+	os.Remove(path)
+	return dst, nil
+}
@@ -0,0 +1,211 @@
+// janitor.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- 自動清理 (Retention) ---
+
+// 只清理已結束的任務，Pending / Processing 不動
+// 清理設定：
+//
+//	RETENTION_DAYS      任務保留天數，0 代表不依時間清理
+//	RETENTION_MAX_MB    圖片目錄大小上限 (MB)，超過時從最舊的任務開始刪除，0 代表不限制
+//	RETENTION_INTERVAL  檢查間隔，預設 1h
+//	RETENTION_DRY_RUN   true 時只記錄會刪除的內容
+type retentionPolicy struct {
+	MaxAge   time.Duration
+	MaxBytes int64
+	Interval time.Duration
+	DryRun   bool
+}
+
+func retentionFromEnv() retentionPolicy {
+	p := retentionPolicy{
+		MaxAge:   time.Duration(envInt("RETENTION_DAYS")) * 24 * time.Hour,
+		MaxBytes: int64(envInt("RETENTION_MAX_MB")) * 1024 * 1024,
+		Interval: time.Hour,
+		DryRun:   os.Getenv("RETENTION_DRY_RUN") == "true",
+	}
+	if d, err := time.ParseDuration(os.Getenv("RETENTION_INTERVAL")); err == nil && d > 0 {
+		p.Interval = d
+	}
+	return p
+}
+
+func (p retentionPolicy) enabled() bool {
+	return p.MaxAge > 0 || p.MaxBytes > 0
+}
+
+// 單次清理的統計
+type cleanupStats struct {
+	Tasks int
+	Files int
+	Bytes int64
+}
+
+// 背景定期清理，直到 ctx 結束；沒有全域設定時仍然執行，工作區可以各自設定保留天數 (見 workspace.go)。
+// 每次清理時重新讀取設定，重新載入 RETENTION_* 後下一次檢查就生效 (見 reload.go)
+func (a *App) startJanitor(ctx context.Context) {
+	policy := a.Config.Retention
+	if policy.enabled() {
+		slog.Info("Retention janitor started", "max_age", policy.MaxAge, "max_mb", policy.MaxBytes/1024/1024,
+			"interval", policy.Interval, "dry_run", policy.DryRun)
+	}
+	go func() {
+		ticker := time.NewTicker(policy.Interval)
+		defer ticker.Stop()
+		interval := policy.Interval
+		for {
+			policy := a.Config.Retention
+			if policy.Interval != interval {
+				interval = policy.Interval
+				ticker.Reset(interval)
+			}
+			a.runCleanup(ctx, policy)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (a *App) runCleanup(ctx context.Context, policy retentionPolicy) {
+	start := time.Now()
+	var stats cleanupStats
+
+	// 1. 超過保留天數的任務 (含垃圾桶中的任務)；有自己保留天數的工作區不套用全域設定
+	if policy.MaxAge > 0 {
+		q := a.DB.Unscoped().Where("(workspace_id IS NULL OR workspace_id NOT IN (SELECT id FROM workspaces WHERE retention_days > 0))")
+		if !a.removeOlderThan(ctx, q, policy.MaxAge, policy.DryRun, &stats) {
+			return
+		}
+	}
+	var workspaces []Workspace
+	if err := a.DB.Where("retention_days > 0").Find(&workspaces).Error; err != nil {
+		slog.Error("Retention query error", "error", err)
+		return
+	}
+	for _, ws := range workspaces {
+		q := a.DB.Unscoped().Where("workspace_id = ?", ws.ID)
+		if !a.removeOlderThan(ctx, q, time.Duration(ws.RetentionDays)*24*time.Hour, policy.DryRun, &stats) {
+			return
+		}
+	}
+
+	// 2. 圖片目錄 (含垃圾桶) 超過大小上限，先刪垃圾桶中的任務，再從最舊的任務開始刪除
+	if policy.MaxBytes > 0 {
+		used := dirSize(a.Config.ImageDir) - stats.Bytes
+		if used > policy.MaxBytes {
+			var tasks []Task
+			err := a.DB.Unscoped().Where("status IN ? AND image_path <> ''", finishedStatuses).
+				Order("deleted_at IS NULL, created_at asc").Find(&tasks).Error
+			if err != nil {
+				slog.Error("Retention query error", "error", err)
+				return
+			}
+			for i := range tasks {
+				if used <= policy.MaxBytes || ctx.Err() != nil {
+					break
+				}
+				before := stats.Bytes
+				a.removeTask(ctx, &tasks[i], policy.DryRun, &stats)
+				used -= stats.Bytes - before
+			}
+		}
+	}
+
+	if stats.Tasks > 0 {
+		slog.Info("Retention cleanup finished", "tasks", stats.Tasks, "files", stats.Files,
+			"freed_bytes", stats.Bytes, "dry_run", policy.DryRun, "elapsed", time.Since(start))
+		if !policy.DryRun {
+			a.audit(ctx, "retention.purged", "", "", fmt.Sprintf("tasks=%d files=%d bytes=%d", stats.Tasks, stats.Files, stats.Bytes))
+		}
+	}
+}
+
+// 刪除 q 中建立超過 maxAge 的已結束任務，查詢失敗或 ctx 結束時回傳 false
+func (a *App) removeOlderThan(ctx context.Context, q *gorm.DB, maxAge time.Duration, dryRun bool, stats *cleanupStats) bool {
+	var tasks []Task
+	err := q.Where("status IN ? AND created_at < ?", finishedStatuses, time.Now().Add(-maxAge)).
+		Order("created_at asc").Find(&tasks).Error
+	if err != nil {
+		slog.Error("Retention query error", "error", err)
+		return false
+	}
+	for i := range tasks {
+		if ctx.Err() != nil {
+			return false
+		}
+		a.removeTask(ctx, &tasks[i], dryRun, stats)
+	}
+	return true
+}
+
+// 刪除任務與圖片 (儲存後端與本機副本)
+func (a *App) removeTask(ctx context.Context, task *Task, dryRun bool, stats *cleanupStats) {
+	inUse := a.imageKeysInUse(task)
+	for _, name := range task.imageKeys() {
+		if inUse[name] {
+			continue
+		}
+		// 被隔離的任務圖片在 quarantine/ 下 (移到垃圾桶時不搬移)，垃圾桶中的任務在 trash/ 下
+		key := name
+		if task.Quarantined {
+			key = quarantineKey(name)
+		} else if task.DeletedAt.Valid {
+			key = trashKey(name)
+		}
+		localPath := localKeyPath(a.Config.ImageDir, task.storagePrefix()+key)
+		if info, err := os.Stat(localPath); err == nil {
+			stats.Bytes += info.Size()
+		}
+		stats.Files++
+		if dryRun {
+			continue
+		}
+		if err := a.storageFor(task).Delete(ctx, key); err != nil {
+			slog.Warn("Retention delete image error", "task_id", task.ID, "key", key, "error", err)
+		}
+		os.Remove(localPath)
+	}
+	stats.Tasks++
+	if dryRun {
+		slog.Info("Retention dry-run", "task_id", task.ID, "created_at", task.CreatedAt)
+		return
+	}
+	if err := a.DB.Unscoped().Delete(task).Error; err != nil {
+		slog.Error("Retention delete task error", "task_id", task.ID, "error", err)
+		return
+	}
+	a.DB.Where("task_id = ?", task.ID).Delete(&TaskImage{})
+	a.removeTaskLinks(task.ID)
+	a.removeUploads(task)
+	a.Hub.notify("deleted", *task)
+}
+
+// 目錄內所有檔案的大小總和
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
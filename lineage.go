@@ -0,0 +1,187 @@
+// lineage.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- 衍生任務 ---
+// 由既有任務建立的新任務 (variations、重跑、以 source_task_id 建立的 img2img) 以 parent_task_id 記錄來源，
+// GET /api/tasks/{id}/lineage 列出上游與直接衍生的任務，前端可以據此顯示修改的過程
+
+// variations 預設與最多的張數
+const (
+	defaultVariations = 4
+	maxVariations     = 16
+)
+
+// 以來源任務的 prompt 與參數建立新任務 (尚未寫入資料庫)；img2img 的原始圖片與遮罩另外複製一份
+func (a *App) deriveTask(src *Task, identity *Identity) (Task, error) {
+	task := Task{
+		Prompt:           src.Prompt,
+		GenerationParams: src.GenerationParams,
+		CreatedBy:        identity.keyName(),
+		UserID:           identity.userID(),
+		ParentTaskID:     &src.ID,
+	}
+	if err := a.copyUploads(src, &task); err != nil {
+		return Task{}, err
+	}
+	return task, nil
+}
+
+// 以新的隨機 seed 重新生成 count 張
+func (a *App) createVariations(ctx context.Context, src *Task, count int, identity *Identity) ([]Task, error) {
+	tasks := make([]Task, 0, count)
+	for i := 0; i < count; i++ {
+		task, err := a.deriveTask(src, identity)
+		if err != nil {
+			for j := range tasks {
+				a.removeUploads(&tasks[j])
+			}
+			return nil, err
+		}
+		task.Seed = nil
+		tasks = append(tasks, task)
+	}
+	created, err := a.createTasks(ctx, tasks, nil)
+	if err != nil {
+		for j := range tasks {
+			a.removeUploads(&tasks[j])
+		}
+		return nil, err
+	}
+	return created, nil
+}
+
+// 重跑時可覆寫的欄位；沒有出現的欄位沿用來源任務，"seed": null 代表改用隨機 seed
+type rerunRequest struct {
+	Prompt string `json:"prompt"`
+	GenerationParams
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CallbackURL string     `json:"callback_url,omitempty"`
+	Force       bool       `json:"force,omitempty"`
+}
+
+// 複製來源任務重新建立一個任務，overrides 為 rerunRequest 格式的 JSON (可為空)。
+// 預設沿用來源實際使用的 seed，只修改部分參數時可以得到相近的圖片
+// force 為 true 時 (?force=true) 一律重新生成，與 body 的 force 相同
+func (a *App) rerunTask(ctx context.Context, src *Task, overrides []byte, force bool, identity *Identity) (*Task, error) {
+	task, err := a.deriveTask(src, identity)
+	if err != nil {
+		return nil, err
+	}
+	req := rerunRequest{Prompt: src.Prompt, GenerationParams: src.GenerationParams}
+	if req.Seed == nil {
+		req.Seed = src.UsedSeed
+	}
+	if len(overrides) > 0 {
+		if err := json.Unmarshal(overrides, &req); err != nil {
+			a.removeUploads(&task)
+			return nil, invalidf("invalid overrides: %v", err)
+		}
+	}
+	task.Prompt = strings.TrimSpace(req.Prompt)
+	task.GenerationParams = req.GenerationParams
+	task.ScheduledAt = req.ScheduledAt
+	task.ExpiresAt = req.ExpiresAt
+	task.CallbackURL = strings.TrimSpace(req.CallbackURL)
+	task.Force = req.Force || force
+	created, err := a.createTask(ctx, task)
+	if err != nil {
+		a.removeUploads(&task)
+		return nil, err
+	}
+	return created, nil
+}
+
+// POST /api/tasks/{id}/rerun?force=true，body 為選填的覆寫欄位，例如 {"steps": 40, "seed": null}
+func (a *App) apiRerunTask(w http.ResponseWriter, r *http.Request) {
+	src, ok := a.loadTaskFromPath(w, r)
+	if !ok {
+		return
+	}
+	overrides, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	task, err := a.rerunTask(r.Context(), src, overrides, r.URL.Query().Get("force") == "true", identityFromRequest(r))
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, task)
+}
+
+// 任務的來源鏈與衍生任務
+type taskLineage struct {
+	Ancestors []Task `json:"ancestors"` // 從最上游到直接來源
+	Task      *Task  `json:"task"`
+	Children  []Task `json:"children"` // 直接衍生的任務
+}
+
+// 往上追溯的層數上限，避免資料錯誤時無限循環
+const maxLineageDepth = 50
+
+// GET /api/tasks/{id}/lineage
+func (a *App) apiTaskLineage(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadTaskFromPath(w, r)
+	if !ok {
+		return
+	}
+	identity := identityFromRequest(r)
+	lineage := taskLineage{Ancestors: []Task{}, Task: task, Children: []Task{}}
+	for parentID := task.ParentTaskID; parentID != nil && len(lineage.Ancestors) < maxLineageDepth; {
+		var parent Task
+		if err := identity.taskScope(a.DB).First(&parent, *parentID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// 來源已刪除，鏈到此為止
+				break
+			}
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		lineage.Ancestors = append([]Task{parent}, lineage.Ancestors...)
+		parentID = parent.ParentTaskID
+	}
+	if err := identity.taskScope(a.DB).Where("parent_task_id = ?", task.ID).Order("id asc").Find(&lineage.Children).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, lineage)
+}
+
+// POST /api/tasks/{id}/variations?count=4
+// 以相同的 prompt 與參數、新的隨機 seed 建立 count 個任務 (預設 4，最多 16)，parent_task_id 為來源任務
+func (a *App) apiCreateVariations(w http.ResponseWriter, r *http.Request) {
+	src, ok := a.loadTaskFromPath(w, r)
+	if !ok {
+		return
+	}
+	count := defaultVariations
+	if s := r.URL.Query().Get("count"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxVariations {
+			writeTaskError(w, invalidField("count", "count must be between 1 and %d", maxVariations))
+			return
+		}
+		count = n
+	}
+	tasks, err := a.createVariations(r.Context(), src, count, identityFromRequest(r))
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, tasks)
+}
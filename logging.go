@@ -0,0 +1,82 @@
+// logging.go
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// --- 結構化 Log ---
+
+type loggerKey struct{}
+
+// 依環境變數設定預設 logger：
+//
+//	LOG_LEVEL：debug / info (預設) / warn / error
+//	LOG_FORMAT：text (預設) / json
+//
+// 一律寫到 stderr，stdio MCP 模式下 stdout 保留給 JSON-RPC
+func setupLogger() {
+	var level slog.Level
+	switch strings.ToLower(os.Getenv("LOG_LEVEL")) {
+	case "debug":
+		level = slog.LevelDebug
+	case "warn":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if strings.ToLower(os.Getenv("LOG_FORMAT")) == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// 把帶有 request_id / task_id 等欄位的 logger 放進 context
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// 啟動失敗時使用，記錄錯誤後結束程式
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func newShortID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 每個 HTTP 請求帶一個 request_id (沿用上游的 X-Request-ID)，並回傳在 header 中
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = newShortID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		logger := slog.Default().With("request_id", requestID)
+		next.ServeHTTP(w, r.WithContext(withLogger(r.Context(), logger)))
+	})
+}
@@ -0,0 +1,241 @@
+// lora.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- LoRA 與 embedding ---
+// 任務的 loras 欄位指定要套用的 LoRA (或 textual inversion embedding) 與權重：
+//
+//	"loras": [{"name": "watercolor", "weight": 0.8}, {"name": "easynegative"}]
+//
+// 名稱為 LORA_DIR / EMBEDDING_DIR 下的檔名 (不含副檔名)，建立任務時檢查是否存在。
+// python 後端收到 --lora <路徑>:<權重> 與 --embedding <路徑>，http 後端在 prompt 加上 <lora:名稱:權重>。
+// 與模型清單相同，每次查詢都重新掃描目錄；同名的檔案旁邊有圖片 (名稱.preview.png、名稱.png 等) 時作為預覽圖
+
+const (
+	maxLoRAs      = 8
+	maxLoRAWeight = 4.0
+	loraTypeLoRA  = "lora"
+	loraTypeEmbed = "embedding"
+)
+
+// 任務使用的 LoRA，存在 tasks.loras (JSON)
+type LoRA struct {
+	Name   string  `json:"name"`
+	Weight float64 `json:"weight,omitempty"` // 0 視為 1；embedding 不使用
+}
+
+// GET /api/loras 的項目，Path 與預覽圖路徑不對外公開
+type LoRAInfo struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`                  // lora 或 embedding
+	HasPreview bool   `json:"has_preview,omitempty"` // 可以從 GET /api/loras/{name}/preview 取得預覽圖
+	Path       string `json:"-"`
+	preview    string
+}
+
+// LORA_DIR 與 EMBEDDING_DIR
+type loraRegistry struct {
+	Dir          string
+	EmbeddingDir string
+}
+
+var errLoRAsNotConfigured = errors.New("loras are not configured")
+
+// 預覽圖的檔名，依序尋找
+var loraPreviewSuffixes = []string{".preview.png", ".preview.jpg", ".preview.jpeg", ".preview.webp", ".png", ".jpg", ".jpeg", ".webp"}
+
+func (l *loraRegistry) configured() bool {
+	return l.Dir != "" || l.EmbeddingDir != ""
+}
+
+// 兩個目錄的 LoRA 與 embedding，依名稱排序；與 LoRA 同名的 embedding 不列出
+func (l *loraRegistry) List() ([]LoRAInfo, error) {
+	loras, err := scanLoRADir(l.Dir, loraTypeLoRA)
+	if err != nil {
+		return nil, err
+	}
+	embeddings, err := scanLoRADir(l.EmbeddingDir, loraTypeEmbed)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(loras))
+	for _, info := range loras {
+		names[info.Name] = true
+	}
+	for _, info := range embeddings {
+		if !names[info.Name] {
+			loras = append(loras, info)
+		}
+	}
+	sort.Slice(loras, func(i, j int) bool { return loras[i].Name < loras[j].Name })
+	return loras, nil
+}
+
+// 目錄下的模型檔 (副檔名與 MODEL_DIR 相同)，dir 為空字串時沒有任何項目
+func scanLoRADir(dir, kind string) ([]LoRAInfo, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		files[e.Name()] = true
+	}
+	var infos []LoRAInfo
+	for _, e := range entries {
+		name := e.Name()
+		ext := filepath.Ext(name)
+		if e.IsDir() || strings.HasPrefix(name, ".") || !modelExtensions[strings.ToLower(ext)] {
+			continue
+		}
+		base := strings.TrimSuffix(name, ext)
+		info := LoRAInfo{Name: base, Type: kind}
+		info.Path, _ = filepath.Abs(filepath.Join(dir, name))
+		for _, suffix := range loraPreviewSuffixes {
+			if files[base+suffix] {
+				info.preview = filepath.Join(dir, base+suffix)
+				info.HasPreview = true
+				break
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// 依名稱找 LoRA 或 embedding，找不到時回傳 ValidationError
+func (l *loraRegistry) Lookup(name string) (*LoRAInfo, error) {
+	if !l.configured() {
+		return nil, errLoRAsNotConfigured
+	}
+	infos, err := l.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range infos {
+		if infos[i].Name == name {
+			return &infos[i], nil
+		}
+	}
+	return nil, invalidField("loras", "unknown lora: %s", name)
+}
+
+// 檢查任務的 loras 並補上預設權重
+func (a *App) resolveLoRAs(p *GenerationParams) error {
+	if len(p.LoRAs) == 0 {
+		p.LoRAs = nil
+		return nil
+	}
+	if len(p.LoRAs) > maxLoRAs {
+		return invalidField("loras", "at most %d loras", maxLoRAs)
+	}
+	seen := make(map[string]bool, len(p.LoRAs))
+	for i := range p.LoRAs {
+		lora := &p.LoRAs[i]
+		lora.Name = strings.TrimSpace(lora.Name)
+		if lora.Name == "" {
+			return invalidField("loras", "lora name is required")
+		}
+		if seen[lora.Name] {
+			return invalidField("loras", "duplicate lora: %s", lora.Name)
+		}
+		seen[lora.Name] = true
+		if lora.Weight < -maxLoRAWeight || lora.Weight > maxLoRAWeight {
+			return invalidField("loras", "lora weight must be between %g and %g", -maxLoRAWeight, maxLoRAWeight)
+		}
+		if lora.Weight == 0 {
+			lora.Weight = 1
+		}
+		if _, err := a.Config.LoRAs.Lookup(lora.Name); err != nil {
+			if errors.Is(err, errLoRAsNotConfigured) {
+				return invalidField("loras", "loras are not available on this server")
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// 交給生成後端的 LoRA 路徑與權重
+type loraPath struct {
+	Name   string
+	Type   string
+	Path   string
+	Weight float64
+}
+
+// 任務使用的 LoRA 路徑；可能在排隊期間被移除，找不到時視為生成失敗
+func (a *App) loraPaths(loras []LoRA) ([]loraPath, error) {
+	paths := make([]loraPath, 0, len(loras))
+	for _, lora := range loras {
+		info, err := a.Config.LoRAs.Lookup(lora.Name)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, loraPath{Name: info.Name, Type: info.Type, Path: info.Path, Weight: lora.Weight})
+	}
+	return paths, nil
+}
+
+// run_z_image.py 的 --lora / --embedding 參數
+func loraArgs(loras []loraPath) []string {
+	var args []string
+	for _, lora := range loras {
+		if lora.Type == loraTypeEmbed {
+			args = append(args, "--embedding", lora.Path)
+		} else {
+			args = append(args, "--lora", lora.Path+":"+strconv.FormatFloat(lora.Weight, 'f', -1, 64))
+		}
+	}
+	return args
+}
+
+// Automatic1111 的 prompt 語法：LoRA 為 <lora:名稱:權重>，embedding 直接寫名稱
+func loraPrompt(prompt string, loras []loraPath) string {
+	for _, lora := range loras {
+		if lora.Type == loraTypeEmbed {
+			prompt += ", " + lora.Name
+		} else {
+			prompt += fmt.Sprintf(" <lora:%s:%s>", lora.Name, strconv.FormatFloat(lora.Weight, 'f', -1, 64))
+		}
+	}
+	return prompt
+}
+
+// GET /api/loras
+func (a *App) apiListLoRAs(w http.ResponseWriter, r *http.Request) {
+	loras, err := a.Config.LoRAs.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if loras == nil {
+		loras = []LoRAInfo{}
+	}
+	writeJSON(w, http.StatusOK, loras)
+}
+
+// GET /api/loras/{name}/preview
+func (a *App) apiLoRAPreview(w http.ResponseWriter, r *http.Request) {
+	info, err := a.Config.LoRAs.Lookup(r.PathValue("name"))
+	if err != nil || info.preview == "" {
+		writeError(w, http.StatusNotFound, "preview not found")
+		return
+	}
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeFile(w, r, info.preview)
+}
@@ -0,0 +1,109 @@
+// main.go
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	mcpMode := flag.String("mcp", "", "以 MCP 模式執行，目前支援 stdio")
+	newKeyName := flag.String("new-api-key", "", "建立指定名稱的 API key 並印出後結束")
+	setRole := flag.String("set-role", "", "設定使用者的角色 (name:viewer、creator 或 admin) 後結束")
+	migrateCmd := flag.String("migrate", "", "執行資料庫 migration 後結束：up、down (還原一個版本)、status")
+	configFile := flag.String("config", "", "YAML 設定檔 (也可用環境變數 CONFIG_FILE 指定)")
+	printConfig := flag.Bool("print-config", false, "印出實際使用的設定 (YAML) 後結束")
+	flag.Parse()
+
+	// 啟動前就存在的環境變數優先於 envfile 與設定檔，重新載入設定時也不變 (見 reload.go)
+	fixedEnv := environNames()
+	// 指定設定檔時 envfile 可以省略
+	if err := godotenv.Load("envfile"); err != nil && (*configFile == "" || !errors.Is(err, os.ErrNotExist)) {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if *configFile == "" {
+		*configFile = os.Getenv("CONFIG_FILE")
+	}
+	if *configFile != "" {
+		if err := loadConfigFile(*configFile); err != nil {
+			fmt.Fprintln(os.Stderr, "load config file:", err)
+			os.Exit(1)
+		}
+	}
+	setupLogger()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	cfg.ConfigFile, cfg.fixedEnv = *configFile, fixedEnv
+	if *printConfig {
+		if err := cfg.printYAML(os.Stdout); err != nil {
+			fatal("print config failed", "error", err)
+		}
+		return
+	}
+
+	if *migrateCmd != "" {
+		if err := runMigrateCommand(cfg, *migrateCmd); err != nil {
+			fatal("migrate failed", "error", err)
+		}
+		return
+	}
+
+	app, err := NewApp(cfg)
+	if err != nil {
+		fatal("failed to init app", "error", err)
+	}
+
+	// 產生新的 API key 後結束
+	if *newKeyName != "" {
+		key, err := app.createAPIKey(*newKeyName)
+		if err != nil {
+			fatal("failed to create API key", "error", err)
+		}
+		app.audit(context.Background(), "api_key.created", "api_key", *newKeyName, "")
+		fmt.Println(key)
+		return
+	}
+	if err := app.loadAPIKeys(); err != nil {
+		fatal("failed to load API keys", "error", err)
+	}
+	// 設定角色後結束 (API_KEYS 的使用者在上面建立)
+	if *setRole != "" {
+		if err := app.runSetRole(*setRole); err != nil {
+			fatal("failed to set role", "error", err)
+		}
+		return
+	}
+	app.logAuthMode()
+
+	switch *mcpMode {
+	case "":
+		// 子命令：serve (預設)、worker、enqueue、status
+		if err := runCommand(app, flag.Args()); err != nil {
+			var cfgErr *ConfigError
+			if errors.As(err, &cfgErr) {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fatal("command failed", "error", err)
+		}
+	case "stdio":
+		// MCP stdio 模式：由 MCP client (如 Claude Desktop) 啟動，不開 Web Server
+		if err := app.Config.checkRuntime(false); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		app.RunMCPStdio()
+	default:
+		fatal("unsupported mcp mode", "mode", *mcpMode)
+	}
+}
@@ -0,0 +1,401 @@
+// mcp.go
+// MCP (Model Context Protocol) 伺服器，提供 stdio 與 SSE 兩種傳輸方式，
+// 讓 Claude Desktop 等 MCP client 可以直接把 Z-Image 任務丟進佇列
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const mcpProtocolVersion = "2024-11-05"
+
+// --- JSON-RPC 2.0 訊息格式 ---
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// JSON-RPC 標準錯誤碼
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+// MCP tool 定義
+type mcpTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type mcpToolResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError,omitempty"`
+}
+
+var mcpTools = []mcpTool{
+	{
+		Name:        "generate_image",
+		Description: "Enqueue a Z-Image generation task and return the created task.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"prompt":          map[string]interface{}{"type": "string", "description": "Text prompt for the image"},
+				"negative_prompt": map[string]interface{}{"type": "string", "description": "Things to avoid in the image"},
+				"width":           map[string]interface{}{"type": "integer", "description": "Image width in pixels (multiple of 8)"},
+				"height":          map[string]interface{}{"type": "integer", "description": "Image height in pixels (multiple of 8)"},
+				"steps":           map[string]interface{}{"type": "integer", "description": "Number of inference steps"},
+				"guidance_scale":  map[string]interface{}{"type": "number", "description": "Classifier-free guidance scale"},
+				"seed":            map[string]interface{}{"type": "integer", "description": "Random seed for reproducible output"},
+				"upscale":         map[string]interface{}{"type": "integer", "description": "Upscale factor after generation (2 or 4)"},
+				"model":           map[string]interface{}{"type": "string", "description": "Model name from list_models (optional)"},
+				"loras": map[string]interface{}{"type": "array", "description": "LoRAs or embeddings from list_loras to apply (optional)",
+					"items": map[string]interface{}{"type": "object", "required": []string{"name"}, "properties": map[string]interface{}{
+						"name":   map[string]interface{}{"type": "string"},
+						"weight": map[string]interface{}{"type": "number", "description": "LoRA strength (default 1)"},
+					}}},
+				"num_images":     map[string]interface{}{"type": "integer", "description": "Number of images to generate from the prompt (1-8)"},
+				"format":         map[string]interface{}{"type": "string", "enum": []string{"png", "jpeg", "webp"}, "description": "Output image format (default png)"},
+				"quality":        map[string]interface{}{"type": "integer", "description": "JPEG quality 1-100 (default 90)"},
+				"watermark":      map[string]interface{}{"type": "boolean", "description": "Stamp the server's watermark on the image (default set by the server)"},
+				"strip_metadata": map[string]interface{}{"type": "boolean", "description": "Do not embed generation parameters or other metadata in the image"},
+				"scheduled_at":   map[string]interface{}{"type": "string", "description": "RFC3339 time; the task will not run before this"},
+				"expires_at":     map[string]interface{}{"type": "string", "description": "RFC3339 time; the task fails as expired if it has not started by then"},
+				"callback_url":   map[string]interface{}{"type": "string", "description": "URL to POST a signed JSON payload to when the task completes or fails"},
+				"force":          map[string]interface{}{"type": "boolean", "description": "Always generate a new image even if an identical result is cached"},
+				"enhance":        map[string]interface{}{"type": "boolean", "description": "Expand the prompt into a detailed description with the server's LLM before generating"},
+				"template":       map[string]interface{}{"type": "string", "description": "Name of a saved prompt template to use instead of prompt"},
+				"variables":      map[string]interface{}{"type": "object", "description": "Values for the template's {placeholders}", "additionalProperties": map[string]interface{}{"type": "string"}},
+			},
+		},
+	},
+	{
+		Name:        "get_task_status",
+		Description: "Get the status and image path of a task by id.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"task_id": map[string]interface{}{"type": "integer", "description": "Task id returned by generate_image"},
+			},
+			"required": []string{"task_id"},
+		},
+	},
+	{
+		Name:        "list_history",
+		Description: "List the most recent tasks, newest first.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"limit": map[string]interface{}{"type": "integer", "description": "Max number of tasks (default 20, max 100)"},
+			},
+		},
+	},
+	{
+		Name:        "list_models",
+		Description: "List the models that can be passed to generate_image.",
+		InputSchema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		},
+	},
+	{
+		Name:        "list_loras",
+		Description: "List the LoRAs and embeddings that can be passed to generate_image.",
+		InputSchema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		},
+	},
+}
+
+// 處理單一 JSON-RPC 請求；notification (沒有 id) 回傳 nil
+func (a *App) handleMCPRequest(ctx context.Context, req rpcRequest, caller *Identity) *rpcResponse {
+	if req.ID == nil {
+		// notifications/initialized 等通知不需要回應
+		return nil
+	}
+	resp := &rpcResponse{JSONRPC: "2.0", ID: req.ID}
+
+	switch req.Method {
+	case "initialize":
+		resp.Result = map[string]interface{}{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+			"serverInfo":      map[string]string{"name": "mcpzimage", "version": "1.0.0"},
+		}
+	case "ping":
+		resp.Result = map[string]interface{}{}
+	case "tools/list":
+		resp.Result = map[string]interface{}{"tools": mcpTools}
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			resp.Error = &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+			return resp
+		}
+		result, err := a.callMCPTool(ctx, params.Name, params.Arguments, caller)
+		if err != nil {
+			// 工具執行錯誤以 isError 回傳，讓模型可以看到原因
+			result = &mcpToolResult{Content: []mcpContent{{Type: "text", Text: err.Error()}}, IsError: true}
+		}
+		resp.Result = result
+	default:
+		resp.Error = &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + req.Method}
+	}
+	return resp
+}
+
+func (a *App) callMCPTool(ctx context.Context, name string, rawArgs json.RawMessage, caller *Identity) (*mcpToolResult, error) {
+	var args struct {
+		Prompt      string     `json:"prompt"`
+		TaskID      uint       `json:"task_id"`
+		Limit       int        `json:"limit"`
+		ScheduledAt *time.Time `json:"scheduled_at"`
+		ExpiresAt   *time.Time `json:"expires_at"`
+		CallbackURL string     `json:"callback_url"`
+		Force       bool       `json:"force"`
+		Enhance     bool       `json:"enhance"`
+		templateRef
+		GenerationParams
+	}
+	if len(rawArgs) > 0 {
+		if err := json.Unmarshal(rawArgs, &args); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %v", err)
+		}
+	}
+
+	var data interface{}
+	switch name {
+	case "generate_image":
+		if err := caller.checkRole(roleCreator); err != nil {
+			return nil, err
+		}
+		prompt := strings.TrimSpace(args.Prompt)
+		if err := a.applyTemplate(caller, args.templateRef, &prompt, &args.GenerationParams); err != nil {
+			return nil, err
+		}
+		if prompt == "" {
+			return nil, errors.New("prompt or template is required")
+		}
+		task, err := a.createTask(ctx, Task{
+			Prompt:           prompt,
+			GenerationParams: args.GenerationParams,
+			ScheduledAt:      args.ScheduledAt,
+			ExpiresAt:        args.ExpiresAt,
+			CallbackURL:      strings.TrimSpace(args.CallbackURL),
+			CreatedBy:        caller.keyName(),
+			UserID:           caller.userID(),
+			Force:            args.Force,
+			Enhance:          args.Enhance,
+		})
+		if err != nil {
+			return nil, err
+		}
+		data = task
+	case "get_task_status":
+		var task Task
+		if err := caller.taskScope(a.DB).First(&task, args.TaskID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("task %d not found", args.TaskID)
+			}
+			return nil, err
+		}
+		a.fillQueueInfo(&task)
+		if err := a.loadTaskImages(&task); err != nil {
+			return nil, err
+		}
+		data = task
+	case "list_history":
+		limit := args.Limit
+		if limit <= 0 {
+			limit = 20
+		} else if limit > 100 {
+			limit = 100
+		}
+		var tasks []Task
+		if err := caller.taskScope(a.DB).Order("created_at desc").Limit(limit).Find(&tasks).Error; err != nil {
+			return nil, err
+		}
+		if err := a.loadTaskListImages(tasks); err != nil {
+			return nil, err
+		}
+		data = tasks
+	case "list_models":
+		models, err := a.Config.Models.List()
+		if err != nil {
+			return nil, err
+		}
+		if models == nil {
+			models = []ModelInfo{}
+		}
+		data = models
+	case "list_loras":
+		loras, err := a.Config.LoRAs.List()
+		if err != nil {
+			return nil, err
+		}
+		if loras == nil {
+			loras = []LoRAInfo{}
+		}
+		data = loras
+	default:
+		return nil, fmt.Errorf("unknown tool: %s", name)
+	}
+
+	text, _ := json.MarshalIndent(data, "", "  ")
+	return &mcpToolResult{Content: []mcpContent{{Type: "text", Text: string(text)}}}, nil
+}
+
+// 解析一行 JSON-RPC 訊息並處理，查詢範圍限制在 caller 自己的任務
+func (a *App) handleMCPMessage(ctx context.Context, raw []byte, caller *Identity) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: rpcParseError, Message: err.Error()}}
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return &rpcResponse{JSONRPC: "2.0", ID: req.ID, Error: &rpcError{Code: rpcInvalidRequest, Message: "invalid request"}}
+	}
+	return a.handleMCPRequest(ctx, req, caller)
+}
+
+// --- stdio 傳輸：每行一個 JSON-RPC 訊息 ---
+func (a *App) runMCPStdio(in io.Reader, out io.Writer) error {
+	ctx := withLogger(context.Background(), slog.Default().With("client_id", "mcp-stdio"))
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 1024*1024), 10*1024*1024)
+	enc := json.NewEncoder(out)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		// stdio 由本機 MCP client 啟動，視為擁有完整權限
+		if resp := a.handleMCPMessage(ctx, line, &Identity{KeyName: "mcp-stdio"}); resp != nil {
+			if err := enc.Encode(resp); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// --- SSE 傳輸：GET /mcp/sse 建立串流，POST /mcp/message 送出請求 ---
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (a *App) serveMCPSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sessionID := newSessionID()
+	messages := make(chan []byte, 16)
+	a.mcpMutex.Lock()
+	a.mcpSessions[sessionID] = messages
+	a.mcpMutex.Unlock()
+	defer func() {
+		a.mcpMutex.Lock()
+		delete(a.mcpSessions, sessionID)
+		a.mcpMutex.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// 告訴 client 之後要把請求 POST 到哪裡
+	endpoint := "/mcp/message?sessionId=" + sessionID
+	if token := r.URL.Query().Get("token"); token != "" {
+		// 用 query string 驗證的 client 無法自訂 header，沿用同一個 token
+		endpoint += "&token=" + url.QueryEscape(token)
+	}
+	fmt.Fprintf(w, "event: endpoint\ndata: %s\n\n", endpoint)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-messages:
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", msg)
+			flusher.Flush()
+		}
+	}
+}
+
+func (a *App) serveMCPMessage(w http.ResponseWriter, r *http.Request) {
+	a.mcpMutex.Lock()
+	messages, ok := a.mcpSessions[r.URL.Query().Get("sessionId")]
+	a.mcpMutex.Unlock()
+	if !ok {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 10*1024*1024))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if resp := a.handleMCPMessage(r.Context(), body, identityFromRequest(r)); resp != nil {
+		jsonResp, _ := json.Marshal(resp)
+		select {
+		case messages <- jsonResp:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// stdio 模式下 stdout 專供 JSON-RPC 使用，log 一律寫到 stderr (見 setupLogger)
+func (a *App) startMCPStdio() {
+	if err := a.runMCPStdio(os.Stdin, os.Stdout); err != nil {
+		slog.Error("MCP stdio error", "error", err)
+	}
+}
@@ -0,0 +1,239 @@
+// memrepo.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// --- 記憶體中的 TaskRepository ---
+// 給不需要資料庫的情境使用 (例如驗證 worker 與 handler 的流程)：
+// 所有資料在 process 結束後消失，只支援 TaskService 用到的欄位更新；不支援收藏集篩選 (CollectionID 不為 0 時沒有結果)
+
+type memoryTaskRepository struct {
+	mutex       sync.Mutex
+	tasks       map[uint]*Task
+	transitions []TaskTransition
+	nextID      uint
+	nextBatchID uint
+}
+
+// 介面改變時在編譯期發現 (見 taskservice_test.go)
+var _ TaskRepository = (*memoryTaskRepository)(nil)
+
+func newMemoryTaskRepository() *memoryTaskRepository {
+	return &memoryTaskRepository{tasks: make(map[uint]*Task)}
+}
+
+// 複製任務，避免呼叫端修改到儲存的資料
+func cloneTask(t *Task) *Task {
+	c := *t
+	c.Images = append([]TaskImage(nil), t.Images...)
+	c.Tags = append([]string(nil), t.Tags...)
+	return &c
+}
+
+func (r *memoryTaskRepository) Create(ctx context.Context, tasks []Task, batch *Batch) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	if batch != nil {
+		r.nextBatchID++
+		batch.ID, batch.CreatedAt = r.nextBatchID, now
+	}
+	for i := range tasks {
+		task := &tasks[i]
+		r.nextID++
+		task.ID = r.nextID
+		if task.CreatedAt.IsZero() {
+			task.CreatedAt = now
+		}
+		task.UpdatedAt = now
+		if batch != nil {
+			task.BatchID = &batch.ID
+		}
+		for j := range task.Images {
+			task.Images[j].TaskID = task.ID
+		}
+		r.tasks[task.ID] = cloneTask(task)
+	}
+	return nil
+}
+
+func (r *memoryTaskRepository) Get(ctx context.Context, id uint) (*Task, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	task, ok := r.tasks[id]
+	if !ok {
+		return nil, errTaskNotFound
+	}
+	return cloneTask(task), nil
+}
+
+func (r *memoryTaskRepository) NextReady(ctx context.Context, now time.Time, skipModels []string) (*Task, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var next *Task
+	for _, task := range r.tasks {
+		if !task.Status.queued() || (task.ScheduledAt != nil && task.ScheduledAt.After(now)) || task.expired(now) || slices.Contains(skipModels, task.Model) {
+			continue
+		}
+		if next == nil || task.CreatedAt.Before(next.CreatedAt) || (task.CreatedAt.Equal(next.CreatedAt) && task.ID < next.ID) {
+			next = task
+		}
+	}
+	if next == nil {
+		return nil, nil
+	}
+	return cloneTask(next), nil
+}
+
+func (r *memoryTaskRepository) Transition(ctx context.Context, id uint, from TaskStatus, fields map[string]interface{}, guard transitionGuard) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	task, ok := r.tasks[id]
+	if !ok || task.Status != from {
+		return false, nil
+	}
+	if guard.LeaseExpiredBefore != nil && (task.LeaseExpiresAt == nil || !task.LeaseExpiresAt.Before(*guard.LeaseExpiredBefore)) {
+		return false, nil
+	}
+	if guard.StartedAt != nil && (task.StartedAt == nil || !task.StartedAt.Equal(*guard.StartedAt)) {
+		return false, nil
+	}
+	if guard.Quarantined && !task.Quarantined {
+		return false, nil
+	}
+	updated := cloneTask(task)
+	if err := applyTaskFields(updated, fields); err != nil {
+		return false, err
+	}
+	r.tasks[id] = updated
+	return true, nil
+}
+
+func (r *memoryTaskRepository) SaveResult(ctx context.Context, task *Task, workerID string) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	stored, ok := r.tasks[task.ID]
+	if !ok || stored.Status != StatusProcessing || stored.ClaimedBy != workerID {
+		return false, nil
+	}
+	task.UpdatedAt = time.Now()
+	for i := range task.Images {
+		task.Images[i].TaskID = task.ID
+	}
+	r.tasks[task.ID] = cloneTask(task)
+	return true, nil
+}
+
+func (r *memoryTaskRepository) AddTransition(ctx context.Context, entry *TaskTransition) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	entry.ID = uint(len(r.transitions) + 1)
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	r.transitions = append(r.transitions, *entry)
+	return nil
+}
+
+func (r *memoryTaskRepository) History(ctx context.Context, filter TaskHistoryFilter) ([]Task, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var tasks []Task
+	if filter.CollectionID != 0 {
+		return tasks, nil
+	}
+	for _, task := range r.tasks {
+		if !(&Identity{UserID: filter.UserID, WorkspaceID: filter.WorkspaceID}).sees(task.UserID, task.WorkspaceID) {
+			continue
+		}
+		if !hasAllTags(task.Tags, filter.Tags) {
+			continue
+		}
+		tasks = append(tasks, *cloneTask(task))
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].CreatedAt.Equal(tasks[j].CreatedAt) {
+			return tasks[i].CreatedAt.After(tasks[j].CreatedAt)
+		}
+		return tasks[i].ID > tasks[j].ID
+	})
+	if filter.Limit > 0 && len(tasks) > filter.Limit {
+		tasks = tasks[:filter.Limit]
+	}
+	return tasks, nil
+}
+
+func hasAllTags(tags, wanted []string) bool {
+	for _, w := range wanted {
+		found := false
+		for _, t := range tags {
+			if t == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// 把以資料庫欄位名稱表示的更新套用到任務上 (TaskService 會用到的欄位)
+func applyTaskFields(task *Task, fields map[string]interface{}) error {
+	for column, value := range fields {
+		var ok bool
+		switch column {
+		case "status":
+			task.Status, ok = value.(TaskStatus)
+		case "updated_at":
+			task.UpdatedAt, ok = value.(time.Time)
+		case "queued_at":
+			task.QueuedAt, ok = timeValue(value)
+		case "started_at":
+			task.StartedAt, ok = timeValue(value)
+		case "finished_at":
+			task.FinishedAt, ok = timeValue(value)
+		case "lease_expires_at":
+			task.LeaseExpiresAt, ok = timeValue(value)
+		case "claimed_by":
+			task.ClaimedBy, ok = value.(string)
+		case "attempts":
+			task.Attempts, ok = value.(int)
+		case "fail_reason":
+			task.FailReason, ok = value.(string)
+		case "error_message":
+			task.ErrorMessage, ok = value.(string)
+		case "storage_key":
+			task.StorageKey, ok = value.(string)
+		case "quarantined":
+			task.Quarantined, ok = value.(bool)
+		default:
+			return fmt.Errorf("memory repository: unsupported column %q", column)
+		}
+		if !ok {
+			return fmt.Errorf("memory repository: invalid value %v for column %q", value, column)
+		}
+	}
+	return nil
+}
+
+// time.Time、*time.Time 或 nil
+func timeValue(value interface{}) (*time.Time, bool) {
+	switch v := value.(type) {
+	case nil:
+		return nil, true
+	case time.Time:
+		return &v, true
+	case *time.Time:
+		return v, true
+	}
+	return nil, false
+}
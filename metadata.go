@@ -0,0 +1,189 @@
+// metadata.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- 圖片中繼資料 ---
+// 生成完成後把參數寫進 PNG 的 tEXt chunk (輸出 JPEG / WebP 時略過)，並在圖片旁邊存一份 task_<id>_<ts>.json，
+// 資料庫遺失時仍能從圖片本身重現結果
+
+// 重現一張圖片所需的資訊
+type imageMetadata struct {
+	TaskID uint   `json:"task_id"`
+	Prompt string `json:"prompt"`
+	GenerationParams
+	Image       string     `json:"image,omitempty"` // 原圖檔名
+	CreatedBy   string     `json:"created_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Software    string     `json:"software"`
+}
+
+func metadataFromTask(task *Task, completedAt *time.Time) imageMetadata {
+	meta := imageMetadata{
+		TaskID:           task.ID,
+		Prompt:           task.Prompt,
+		GenerationParams: task.GenerationParams,
+		Image:            task.ImagePath,
+		CreatedBy:        task.CreatedBy,
+		CreatedAt:        task.CreatedAt,
+		CompletedAt:      completedAt,
+		Software:         "mcpzimage",
+	}
+	if meta.Seed == nil {
+		// 隨機 seed 的任務記錄實際使用的值，才能重現
+		meta.Seed = task.UsedSeed
+	}
+	return meta
+}
+
+// 中繼資料檔名：task_1_123.png -> task_1_123.json
+func metadataName(imageName string) string {
+	return strings.TrimSuffix(imageName, filepath.Ext(imageName)) + ".json"
+}
+
+// 寫入圖片的 tEXt chunk 與旁邊的 JSON 檔 (在交給儲存後端之前呼叫)；失敗不影響任務結果。
+// 多張輸出的任務每張各自記錄，seed 為該張實際使用的值、num_images 為 1，以便單獨重現。
+// 任務要求移除中繼資料 (strip_metadata) 時兩者都不寫入
+func (a *App) writeImageMetadata(task *Task, img TaskImage, outputPath, upscalePath string) error {
+	if task.stripsMetadata() {
+		return nil
+	}
+	now := time.Now()
+	meta := metadataFromTask(task, &now)
+	meta.Image = img.ImagePath
+	if task.imageCount() > 1 {
+		meta.Seed, meta.NumImages = img.Seed, 0
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(filepath.Dir(outputPath), metadataName(img.ImagePath)), data, 0o644); err != nil {
+		return err
+	}
+	chunks := meta.textChunks(data)
+	for _, path := range []string{outputPath, upscalePath} {
+		// JPEG / WebP 只保留旁邊的 JSON 檔
+		if path == "" || filepath.Ext(path) != ".png" {
+			continue
+		}
+		if err := writePNGText(path, chunks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 個別欄位方便一般圖片檢視器閱讀，完整內容放在 zimage:metadata
+func (m imageMetadata) textChunks(full []byte) [][2]string {
+	chunks := [][2]string{
+		{"Software", m.Software},
+		{"Creation Time", m.CreatedAt.UTC().Format(time.RFC1123)},
+		{"prompt", m.Prompt},
+	}
+	if m.NegativePrompt != "" {
+		chunks = append(chunks, [2]string{"negative_prompt", m.NegativePrompt})
+	}
+	if m.Seed != nil {
+		chunks = append(chunks, [2]string{"seed", strconv.FormatInt(*m.Seed, 10)})
+	}
+	if m.Steps > 0 {
+		chunks = append(chunks, [2]string{"steps", strconv.Itoa(m.Steps)})
+	}
+	if m.Model != "" {
+		chunks = append(chunks, [2]string{"model", m.Model})
+	}
+	return append(chunks, [2]string{"zimage:metadata", string(full)})
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// 在 IEND 之前插入文字 chunk；tEXt 只能放 Latin-1，含非 ASCII 字元 (例如中文 prompt) 時改用 UTF-8 的 iTXt
+func writePNGText(path string, chunks [][2]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, pngSignature) {
+		return errors.New("not a PNG file")
+	}
+	// 找到 IEND chunk 的位置
+	end := -1
+	for pos := len(pngSignature); pos+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		if string(data[pos+4:pos+8]) == "IEND" {
+			end = pos
+			break
+		}
+		pos += 12 + length
+	}
+	if end < 0 {
+		return errors.New("PNG has no IEND chunk")
+	}
+
+	var buf bytes.Buffer
+	buf.Write(data[:end])
+	for _, c := range chunks {
+		if isASCII(c[1]) {
+			writePNGChunk(&buf, "tEXt", []byte(c[0]+"\x00"+c[1]))
+		} else {
+			// keyword, 壓縮旗標 0, 壓縮方式 0, 語言標籤 "", 翻譯的 keyword ""
+			writePNGChunk(&buf, "iTXt", []byte(c[0]+"\x00\x00\x00\x00\x00"+c[1]))
+		}
+	}
+	buf.Write(data[end:])
+	return replaceFile(path, buf.Bytes())
+}
+
+// 先寫到暫存檔再改名，避免中途失敗留下損壞的圖片
+func replaceFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func writePNGChunk(buf *bytes.Buffer, typ string, payload []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(payload)))
+	start := buf.Len()
+	buf.WriteString(typ)
+	buf.Write(payload)
+	binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()[start:]))
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// GET /api/tasks/{id}/metadata
+// 以資料庫的紀錄組成，格式與圖片旁的 JSON 檔相同
+func (a *App) apiGetTaskMetadata(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadTaskFromPath(w, r)
+	if !ok {
+		return
+	}
+	var completedAt *time.Time
+	if task.Status == StatusCompleted {
+		completedAt = &task.UpdatedAt
+	}
+	writeJSON(w, http.StatusOK, metadataFromTask(task, completedAt))
+}
@@ -0,0 +1,604 @@
+// migrations.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- 資料庫版本遷移 ---
+// 取代啟動時直接 AutoMigrate：每個 migration 有固定的版本號與 up / down，
+// 已套用的版本記在 schema_version 表；新增欄位、改名或回填資料時在 migrations 最後加一筆，不要修改已發佈的 migration。
+// 每個 migration 在一個交易中執行 (MySQL 的 DDL 會自動 commit，失敗時可能需要手動處理)
+
+type migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// 已套用的 migration
+type SchemaVersion struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false" json:"version"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+func (SchemaVersion) TableName() string { return "schema_version" }
+
+// 依版本號排列
+var migrations = []migration{
+	{
+		// 導入版本管理之前的資料庫已經由 AutoMigrate 建好，這一步只會補上缺少的表與欄位
+		Version: 1,
+		Name:    "initial schema",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Task{}, &User{}, &APIKey{}, &Batch{}, &PromptTemplate{}, &PromptHistory{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&PromptHistory{}, &PromptTemplate{}, &Batch{}, &APIKey{}, &User{}, &Task{})
+		},
+	},
+	{
+		// 生成結果快取之前建立的任務沒有 cache_key，補上後也能被快取命中
+		Version: 2,
+		Name:    "backfill task cache keys",
+		Up: func(tx *gorm.DB) error {
+			var tasks []Task
+			return tx.Where("cache_key = '' OR cache_key IS NULL").FindInBatches(&tasks, 500, func(batch *gorm.DB, _ int) error {
+				for i := range tasks {
+					if key := resultCacheKey(&tasks[i]); key != "" {
+						if err := batch.Model(&tasks[i]).UpdateColumn("cache_key", key).Error; err != nil {
+							return err
+						}
+					}
+				}
+				return nil
+			}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Model(&Task{}).Where("cache_key <> ''").UpdateColumn("cache_key", "").Error
+		},
+	},
+	{
+		// 分散式 worker：任務的租約欄位與心跳表
+		Version: 3,
+		Name:    "worker leases and heartbeats",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Task{}, &WorkerNode{})
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"claimed_by", "lease_expires_at"} {
+				if tx.Migrator().HasColumn(&Task{}, column) {
+					if err := tx.Migrator().DropColumn(&Task{}, column); err != nil {
+						return err
+					}
+				}
+			}
+			return tx.Migrator().DropTable(&WorkerNode{})
+		},
+	},
+	{
+		// 垃圾桶：任務改為 soft delete
+		Version: 4,
+		Name:    "task soft delete",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Task{})
+		},
+		Down: func(tx *gorm.DB) error {
+			// 沒有 deleted_at 之後垃圾桶中的任務會重新出現，先永久刪除 (trash/ 下的圖片需手動清理)
+			if !tx.Migrator().HasColumn(&Task{}, "deleted_at") {
+				return nil
+			}
+			if err := tx.Unscoped().Where("deleted_at IS NOT NULL").Delete(&Task{}).Error; err != nil {
+				return err
+			}
+			if tx.Migrator().HasIndex(&Task{}, "idx_tasks_deleted_at") {
+				if err := tx.Migrator().DropIndex(&Task{}, "idx_tasks_deleted_at"); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropColumn(&Task{}, "deleted_at")
+		},
+	},
+	{
+		// 內容安全檢查的結果與隔離標記
+		Version: 5,
+		Name:    "task safety verdict",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Task{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex(&Task{}, "idx_tasks_quarantined") {
+				if err := tx.Migrator().DropIndex(&Task{}, "idx_tasks_quarantined"); err != nil {
+					return err
+				}
+			}
+			for _, column := range []string{"safety_verdict", "safety_score", "safety_labels", "quarantined"} {
+				if tx.Migrator().HasColumn(&Task{}, column) {
+					if err := tx.Migrator().DropColumn(&Task{}, column); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// prompt 禁用詞：任務上的標記與審查紀錄
+		Version: 6,
+		Name:    "prompt moderation",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Task{}, &ModerationLog{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&Task{}, "prompt_flags") {
+				if err := tx.Migrator().DropColumn(&Task{}, "prompt_flags"); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropTable(&ModerationLog{})
+		},
+	},
+	{
+		// 稽核紀錄
+		Version: 7,
+		Name:    "audit events",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&AuditEvent{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&AuditEvent{})
+		},
+	},
+	{
+		// 任務生命週期的時間欄位與狀態轉換紀錄
+		Version: 8,
+		Name:    "task lifecycle timeline",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Task{}, &TaskTransition{})
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"queued_at", "started_at", "finished_at"} {
+				if tx.Migrator().HasColumn(&Task{}, column) {
+					if err := tx.Migrator().DropColumn(&Task{}, column); err != nil {
+						return err
+					}
+				}
+			}
+			return tx.Migrator().DropTable(&TaskTransition{})
+		},
+	},
+	{
+		// 實際使用的 seed 與衍生任務的來源
+		Version: 9,
+		Name:    "task seed and lineage",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Task{})
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"used_seed", "parent_task_id"} {
+				if tx.Migrator().HasColumn(&Task{}, column) {
+					if err := tx.Migrator().DropColumn(&Task{}, column); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// 一個任務多張圖片：每張圖片一筆 task_images，既有任務的圖片補上第 0 張
+		Version: 10,
+		Name:    "task images",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&Task{}, &TaskImage{}); err != nil {
+				return err
+			}
+			return tx.Exec(`INSERT INTO task_images (task_id, position, image_path, thumbnail_path, upscaled_path, storage_key, image_url, seed, created_at)
+				SELECT id, 0, image_path, thumbnail_path, upscaled_path, storage_key, image_url, used_seed, updated_at FROM tasks WHERE image_path <> ''`).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&Task{}, "num_images") {
+				if err := tx.Migrator().DropColumn(&Task{}, "num_images"); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropTable(&TaskImage{})
+		},
+	},
+	{
+		Version: 11,
+		Name:    "task attempts",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Task{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&Task{}, "attempts") {
+				return tx.Migrator().DropColumn(&Task{}, "attempts")
+			}
+			return nil
+		},
+	},
+	{
+		Version: 12,
+		Name:    "tags and collections",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Tag{}, &TaskTag{}, &Collection{}, &CollectionTask{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&CollectionTask{}, &Collection{}, &TaskTag{}, &Tag{})
+		},
+	},
+	{
+		Version: 13,
+		Name:    "task origin url",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Task{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&Task{}, "origin_url") {
+				return tx.Migrator().DropColumn(&Task{}, "origin_url")
+			}
+			return nil
+		},
+	},
+	{
+		Version: 14,
+		Name:    "usage accounting",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Task{}, &UsageDaily{})
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"gpu_seconds", "pixel_steps", "wall_seconds"} {
+				if tx.Migrator().HasColumn(&Task{}, column) {
+					if err := tx.Migrator().DropColumn(&Task{}, column); err != nil {
+						return err
+					}
+				}
+			}
+			return tx.Migrator().DropTable(&UsageDaily{})
+		},
+	},
+	{
+		Version: 15,
+		Name:    "task output format",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Task{})
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"format", "quality", "content_type"} {
+				if tx.Migrator().HasColumn(&Task{}, column) {
+					if err := tx.Migrator().DropColumn(&Task{}, column); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 16,
+		Name:    "task watermark and metadata stripping",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Task{})
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"watermark", "strip_metadata"} {
+				if tx.Migrator().HasColumn(&Task{}, column) {
+					if err := tx.Migrator().DropColumn(&Task{}, column); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 17,
+		Name:    "comparisons",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Comparison{}, &Task{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&Task{}, "comparison_id") {
+				if err := tx.Migrator().DropColumn(&Task{}, "comparison_id"); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropTable(&Comparison{})
+		},
+	},
+	{
+		Version: 18,
+		Name:    "task comments",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Comment{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&Comment{})
+		},
+	},
+	{
+		Version: 19,
+		Name:    "share links",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&ShareLink{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&ShareLink{})
+		},
+	},
+	{
+		Version: 20,
+		Name:    "notification settings",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&NotifySetting{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&NotifySetting{})
+		},
+	},
+	{
+		Version: 21,
+		Name:    "telegram tasks",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Task{})
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"telegram_chat_id", "telegram_message_id"} {
+				if tx.Migrator().HasColumn(&Task{}, column) {
+					if err := tx.Migrator().DropColumn(&Task{}, column); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 22,
+		Name:    "workspaces",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Workspace{}, &WorkspaceMember{}, &Task{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&Task{}, "workspace_id") {
+				if err := tx.Migrator().DropColumn(&Task{}, "workspace_id"); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropTable(&WorkspaceMember{}, &Workspace{})
+		},
+	},
+	{
+		Version: 23,
+		Name:    "user roles",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&User{}); err != nil {
+				return err
+			}
+			// 既有的使用者維持原本可以建立任務的權限
+			return tx.Model(&User{}).Where("role IS NULL OR role = ?", "").Update("role", roleCreator).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&User{}, "role") {
+				return tx.Migrator().DropColumn(&User{}, "role")
+			}
+			return nil
+		},
+	},
+	{
+		Version: 24,
+		Name:    "oauth login",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&OAuthAccount{}, &LoginSession{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&LoginSession{}, &OAuthAccount{})
+		},
+	},
+	{
+		Version: 25,
+		Name:    "task expiration",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Task{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&Task{}, "expires_at") {
+				return tx.Migrator().DropColumn(&Task{}, "expires_at")
+			}
+			return nil
+		},
+	},
+	{
+		Version: 26,
+		Name:    "task loras",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Task{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&Task{}, "loras") {
+				return tx.Migrator().DropColumn(&Task{}, "loras")
+			}
+			return nil
+		},
+	},
+	{
+		Version: 27,
+		Name:    "task controlnet",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Task{})
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"control_type", "control_scale", "control_image_path"} {
+				if tx.Migrator().HasColumn(&Task{}, column) {
+					if err := tx.Migrator().DropColumn(&Task{}, column); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 28,
+		Name:    "task original prompt",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Task{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&Task{}, "original_prompt") {
+				return tx.Migrator().DropColumn(&Task{}, "original_prompt")
+			}
+			return nil
+		},
+	},
+	{
+		Version: 29,
+		Name:    "user locale",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&User{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&User{}, "locale") {
+				return tx.Migrator().DropColumn(&User{}, "locale")
+			}
+			return nil
+		},
+	},
+}
+
+// 已套用的最新版本，還沒有任何紀錄時為 0
+func schemaVersion(db *gorm.DB) (int, error) {
+	if err := db.AutoMigrate(&SchemaVersion{}); err != nil {
+		return 0, err
+	}
+	var latest SchemaVersion
+	err := db.Order("version desc").First(&latest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return latest.Version, err
+}
+
+// 尚未套用的 migration
+func pendingMigrations(db *gorm.DB) ([]migration, error) {
+	current, err := schemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	var pending []migration
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// 依序套用所有尚未套用的 migration
+func migrateUp(db *gorm.DB) error {
+	pending, err := pendingMigrations(db)
+	if err != nil {
+		return err
+	}
+	for _, m := range pending {
+		start := time.Now()
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaVersion{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		slog.Info("Migration applied", "version", m.Version, "name", m.Name, "elapsed", time.Since(start))
+	}
+	return nil
+}
+
+// 還原最新的一個 migration
+func migrateDown(db *gorm.DB) error {
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		return errors.New("no migration to roll back")
+	}
+	for _, m := range migrations {
+		if m.Version != current {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaVersion{Version: m.Version}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("rollback %d (%s): %w", m.Version, m.Name, err)
+		}
+		slog.Info("Migration rolled back", "version", m.Version, "name", m.Name)
+		return nil
+	}
+	return fmt.Errorf("unknown schema version %d", current)
+}
+
+// 啟動時呼叫：預設自動套用；AUTO_MIGRATE=false 時只檢查，有未套用的 migration 就拒絕啟動
+func migrateOnStartup(db *gorm.DB) error {
+	if os.Getenv("AUTO_MIGRATE") != "false" {
+		return migrateUp(db)
+	}
+	pending, err := pendingMigrations(db)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d pending migrations, run with -migrate up first", len(pending))
+	}
+	return nil
+}
+
+// -migrate up | down | status：只處理資料庫，不啟動服務
+func runMigrateCommand(cfg *Config, cmd string) error {
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return fmt.Errorf("connect database: %w", err)
+	}
+	switch cmd {
+	case "up":
+		return migrateUp(db)
+	case "down":
+		return migrateDown(db)
+	case "status":
+		var applied []SchemaVersion
+		if _, err := schemaVersion(db); err != nil {
+			return err
+		}
+		if err := db.Order("version asc").Find(&applied).Error; err != nil {
+			return err
+		}
+		done := make(map[int]SchemaVersion)
+		for _, v := range applied {
+			done[v.Version] = v
+		}
+		for _, m := range migrations {
+			if v, ok := done[m.Version]; ok {
+				fmt.Printf("%4d  applied  %s  %s\n", m.Version, v.AppliedAt.Format(time.RFC3339), m.Name)
+			} else {
+				fmt.Printf("%4d  pending  %s\n", m.Version, m.Name)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command: %s (up, down, status)", cmd)
+}
@@ -0,0 +1,156 @@
+// modellanes.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// --- 每個模型的並行上限 ---
+// 不同模型需要的 VRAM 不同，MODEL_CONCURRENCY=sdxl=1,flux=2 限制同一個 process 內同時執行的任務數
+// (例如兩個 SDXL 等級的任務不同時載入)。每個模型各自排隊：模型已經到達上限時，worker 略過這個模型的任務
+// 改認領其他模型的任務，執行中的任務結束後再通知 worker。
+// 上限以 process 為單位 (VRAM 在同一台主機上)，沒有列出的模型與沒有指定 model 的任務不限制 (仍受 WORKER_COUNT 限制)
+
+type modelLanes struct {
+	mutex   sync.Mutex
+	running map[string]int    // 模型 -> 本 process 執行中的任務數
+	waiting map[string][]uint // 模型 -> Redis 佇列取出時模型已滿的任務，空出位置時重新推入
+}
+
+// 模型狀態，列在 GET /api/admin/stats 的 model_lanes
+type modelLane struct {
+	Running int `json:"running"`
+	Limit   int `json:"limit"`
+	Waiting int `json:"waiting,omitempty"`
+}
+
+// 從環境變數 MODEL_CONCURRENCY 取得，格式錯誤的項目略過 (由 Config.Problems 回報)
+func modelConcurrency() map[string]int {
+	limits, _ := parseModelConcurrency(os.Getenv("MODEL_CONCURRENCY"))
+	return limits
+}
+
+// name=n[,name=n...]，n 至少為 1
+func parseModelConcurrency(s string) (map[string]int, error) {
+	limits := make(map[string]int)
+	if strings.TrimSpace(s) == "" {
+		return limits, nil
+	}
+	var err error
+	for _, entry := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		name = strings.TrimSpace(name)
+		n, convErr := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || name == "" || convErr != nil || n < 1 {
+			err = fmt.Errorf("MODEL_CONCURRENCY=%q: expected model=max_tasks[,model=max_tasks...]", s)
+			continue
+		}
+		limits[name] = n
+	}
+	return limits, err
+}
+
+// 依模型名稱排序，用於設定列表
+func formatModelConcurrency(limits map[string]int) string {
+	names := make([]string, 0, len(limits))
+	for name := range limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make([]string, len(names))
+	for i, name := range names {
+		entries[i] = name + "=" + strconv.Itoa(limits[name])
+	}
+	return strings.Join(entries, ",")
+}
+
+// 模型的並行上限，0 代表不限制
+func (a *App) modelLimit(model string) int {
+	if model == "" {
+		return 0
+	}
+	return a.Config.ModelConcurrency[model]
+}
+
+// 已經到達上限的模型；需持有 lanes.mutex
+func (a *App) fullModelsLocked() []string {
+	var full []string
+	for model, n := range a.lanes.running {
+		if limit := a.modelLimit(model); limit > 0 && n >= limit {
+			full = append(full, model)
+		}
+	}
+	return full
+}
+
+// 認領最舊的可執行任務，略過已經到達上限的模型；
+// 檢查與認領在同一個鎖內完成，同一個 process 的 worker 不會同時搶到同一個模型的最後一個位置
+func (a *App) claimNextInLanes(ctx context.Context) (*Task, error) {
+	a.lanes.mutex.Lock()
+	defer a.lanes.mutex.Unlock()
+	task, err := a.Tasks.ClaimNext(ctx, a.fullModelsLocked()...)
+	if task != nil {
+		a.lanes.running[task.Model]++
+	}
+	return task, err
+}
+
+// 認領從 Redis 佇列取出的任務；模型已滿時記下任務 ID，等位置空出時重新推入
+func (a *App) claimInLane(ctx context.Context, candidate Task) (*Task, error) {
+	a.lanes.mutex.Lock()
+	defer a.lanes.mutex.Unlock()
+	if limit := a.modelLimit(candidate.Model); limit > 0 && a.lanes.running[candidate.Model] >= limit {
+		a.lanes.waiting[candidate.Model] = append(a.lanes.waiting[candidate.Model], candidate.ID)
+		return nil, nil
+	}
+	task, err := a.Tasks.Claim(ctx, candidate)
+	if task != nil {
+		a.lanes.running[task.Model]++
+	}
+	return task, err
+}
+
+// 任務結束，空出模型的位置並通知 worker (Redis 佇列時重新推入等待中的任務)
+func (a *App) releaseLane(model string) {
+	a.lanes.mutex.Lock()
+	a.lanes.running[model]--
+	if a.lanes.running[model] <= 0 {
+		delete(a.lanes.running, model)
+	}
+	waiting := a.lanes.waiting[model]
+	delete(a.lanes.waiting, model)
+	a.lanes.mutex.Unlock()
+	if a.modelLimit(model) > 0 || len(waiting) > 0 {
+		a.signalDispatch(waiting...)
+	}
+}
+
+// 有設定上限或正在執行的模型
+func (a *App) laneSnapshot() map[string]modelLane {
+	a.lanes.mutex.Lock()
+	defer a.lanes.mutex.Unlock()
+	lanes := make(map[string]modelLane)
+	for model, limit := range a.Config.ModelConcurrency {
+		lanes[model] = modelLane{Limit: limit}
+	}
+	for model, n := range a.lanes.running {
+		if model == "" {
+			continue
+		}
+		lane := lanes[model]
+		lane.Running = n
+		lanes[model] = lane
+	}
+	for model, ids := range a.lanes.waiting {
+		lane := lanes[model]
+		lane.Waiting = len(ids)
+		lanes[model] = lane
+	}
+	return lanes
+}
@@ -0,0 +1,149 @@
+// models.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// --- 模型選擇 ---
+
+// 可用的模型 (checkpoint / LoRA 等)，Path 為交給 python 的 --model 參數，不對外公開
+type ModelInfo struct {
+	Name        string `json:"name"`
+	Path        string `json:"-"`
+	Description string `json:"description,omitempty"`
+	Default     bool   `json:"default,omitempty"`
+}
+
+// 模型清單來源：MODEL_MANIFEST (JSON 陣列) 優先，否則掃描 MODEL_DIR；
+// 每次查詢都重新讀取，新增 checkpoint 後不需要重新啟動
+type modelRegistry struct {
+	Dir      string
+	Manifest string
+}
+
+// 視為模型檔的副檔名；diffusers 格式的資料夾也算一個模型
+var modelExtensions = map[string]bool{".safetensors": true, ".ckpt": true, ".pt": true, ".pth": true, ".bin": true}
+
+var errModelsNotConfigured = errors.New("model selection is not configured")
+
+func (m *modelRegistry) configured() bool {
+	return m.Manifest != "" || m.Dir != ""
+}
+
+func (m *modelRegistry) List() ([]ModelInfo, error) {
+	if m.Manifest != "" {
+		data, err := os.ReadFile(m.Manifest)
+		if err != nil {
+			return nil, err
+		}
+		var models []ModelInfo
+		// manifest 的 path 需要讀進來，不能沿用 json:"-"
+		var raw []struct {
+			ModelInfo
+			Path string `json:"path"`
+		}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+		for _, r := range raw {
+			info := r.ModelInfo
+			info.Path = r.Path
+			if info.Path != "" && !filepath.IsAbs(info.Path) {
+				// 相對路徑以 manifest 所在目錄為準
+				info.Path = filepath.Join(filepath.Dir(m.Manifest), info.Path)
+			}
+			models = append(models, info)
+		}
+		return models, nil
+	}
+	if m.Dir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(m.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var models []ModelInfo
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if !e.IsDir() {
+			ext := filepath.Ext(name)
+			if !modelExtensions[strings.ToLower(ext)] {
+				continue
+			}
+			name = strings.TrimSuffix(name, ext)
+		}
+		path, _ := filepath.Abs(filepath.Join(m.Dir, e.Name()))
+		models = append(models, ModelInfo{Name: name, Path: path})
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].Name < models[j].Name })
+	return models, nil
+}
+
+// 依名稱找模型，找不到時回傳 ValidationError
+func (m *modelRegistry) Lookup(name string) (*ModelInfo, error) {
+	if !m.configured() {
+		return nil, errModelsNotConfigured
+	}
+	models, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range models {
+		if models[i].Name == name {
+			return &models[i], nil
+		}
+	}
+	return nil, invalidf("unknown model: %s", name)
+}
+
+// manifest 標記為 default 的模型名稱，沒有時回傳空字串
+func (m *modelRegistry) defaultModel() string {
+	models, _ := m.List()
+	for _, info := range models {
+		if info.Default {
+			return info.Name
+		}
+	}
+	return ""
+}
+
+// 任務使用的模型路徑，name 為空字串時回傳空字串 (腳本預設模型)
+func (a *App) modelPath(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	info, err := a.Config.Models.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	if info.Path == "" {
+		// manifest 沒寫 path 時直接把名稱交給後端
+		return info.Name, nil
+	}
+	return info.Path, nil
+}
+
+// GET /api/models
+func (a *App) apiListModels(w http.ResponseWriter, r *http.Request) {
+	models, err := a.Config.Models.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if models == nil {
+		models = []ModelInfo{}
+	}
+	writeJSON(w, http.StatusOK, models)
+}
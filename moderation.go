@@ -0,0 +1,268 @@
+// moderation.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Prompt 審查 ---
+// 建立任務時以 PROMPT_DENYLIST 指定的檔案檢查 prompt，在花費 GPU 時間之前擋下：
+//   一行一個詞 (不分大小寫，包含即符合)；re: 開頭為正規表示式；# 開頭為註解
+// PROMPT_DENYLIST_MODE=reject (預設) 拒絕建立任務，flag 照常建立但記錄在任務的 prompt_flags。
+// 檔案修改後自動重新載入 (最多每 5 秒檢查一次)，也可以用 POST /api/admin/moderation/reload 立即載入。
+// 被拒絕或標記的 prompt 都記錄在 moderation_logs 表
+
+// 重新檢查檔案修改時間的間隔
+const denylistCheckInterval = 5 * time.Second
+
+// prompt 符合禁用詞且 mode 為 reject 時回傳，REST 回 422
+type ModerationError struct {
+	Matched []string
+}
+
+func (e *ModerationError) Error() string {
+	return e.localMessage().String()
+}
+
+func (e *ModerationError) localMessage() message {
+	return newMessage("prompt rejected by content policy")
+}
+
+// 審查紀錄
+type ModerationLog struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	CreatedBy string    `json:"created_by"`
+	Prompt    string    `json:"prompt"`
+	Matched   string    `json:"matched"` // 符合的規則，以逗號分隔
+	Action    string    `json:"action"`  // rejected 或 flagged
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+type denyRule struct {
+	Source string // 檔案中的原始寫法，記錄與回報用
+	term   string // 小寫的詞，正規表示式時為空字串
+	re     *regexp.Regexp
+}
+
+func (r denyRule) match(lower string) bool {
+	if r.re != nil {
+		return r.re.MatchString(lower)
+	}
+	return strings.Contains(lower, r.term)
+}
+
+type promptFilter struct {
+	Path string
+	Mode string // reject 或 flag
+
+	mutex     sync.Mutex
+	rules     []denyRule
+	modTime   time.Time
+	checkedAt time.Time
+	loadedAt  time.Time
+}
+
+// 未設定 PROMPT_DENYLIST 時回傳 nil (不檢查)；啟動時檔案必須存在且格式正確
+func newPromptFilter(cfg *Config) (*promptFilter, error) {
+	if cfg.PromptDenylist == "" {
+		return nil, nil
+	}
+	f := &promptFilter{Path: cfg.PromptDenylist, Mode: cfg.PromptDenylistMode}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parseDenylist(path string) ([]denyRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var rules []denyRule
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		if expr, ok := strings.CutPrefix(s, "re:"); ok {
+			re, err := regexp.Compile("(?i)" + strings.TrimSpace(expr))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			rules = append(rules, denyRule{Source: s, re: re})
+			continue
+		}
+		rules = append(rules, denyRule{Source: s, term: strings.ToLower(s)})
+	}
+	return rules, scanner.Err()
+}
+
+// 重新讀取檔案；格式錯誤時保留原本的規則
+func (f *promptFilter) reload() error {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return err
+	}
+	rules, err := parseDenylist(f.Path)
+	if err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.rules, f.modTime, f.loadedAt = rules, info.ModTime(), time.Now()
+	f.checkedAt = f.loadedAt
+	return nil
+}
+
+// 檔案有修改時重新載入
+func (f *promptFilter) refresh() {
+	f.mutex.Lock()
+	if time.Since(f.checkedAt) < denylistCheckInterval {
+		f.mutex.Unlock()
+		return
+	}
+	f.checkedAt = time.Now()
+	modTime := f.modTime
+	f.mutex.Unlock()
+
+	info, err := os.Stat(f.Path)
+	if err != nil || info.ModTime().Equal(modTime) {
+		return
+	}
+	if err := f.reload(); err != nil {
+		slog.Error("Reload prompt denylist error, keeping previous rules", "path", f.Path, "error", err)
+		return
+	}
+	slog.Info("Prompt denylist reloaded", "path", f.Path, "rules", f.ruleCount())
+}
+
+func (f *promptFilter) ruleCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.rules)
+}
+
+// 回傳 prompt 符合的規則
+func (f *promptFilter) Check(prompt string) []string {
+	f.refresh()
+	lower := strings.ToLower(prompt)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var matched []string
+	for _, rule := range f.rules {
+		if rule.match(lower) {
+			matched = append(matched, rule.Source)
+		}
+	}
+	return matched
+}
+
+// 建立任務前呼叫：reject 模式下有任何一筆符合就全部拒絕，flag 模式記錄在任務上
+func (a *App) moderatePrompts(ctx context.Context, tasks []Task) error {
+	if a.Moderation == nil {
+		return nil
+	}
+	var rejected *ModerationError
+	for i := range tasks {
+		matched := a.Moderation.Check(tasks[i].Prompt)
+		if len(matched) == 0 {
+			continue
+		}
+		action := "flagged"
+		if a.Moderation.Mode == "reject" {
+			action = "rejected"
+			if rejected == nil {
+				rejected = &ModerationError{}
+			}
+			rejected.Matched = append(rejected.Matched, matched...)
+		} else {
+			tasks[i].PromptFlags = strings.Join(matched, ",")
+		}
+		entry := ModerationLog{UserID: tasks[i].UserID, CreatedBy: tasks[i].CreatedBy, Prompt: tasks[i].Prompt, Matched: strings.Join(matched, ","), Action: action}
+		if err := a.DB.Create(&entry).Error; err != nil {
+			loggerFrom(ctx).Warn("Record moderation log error", "error", err)
+		}
+		loggerFrom(ctx).Warn("Prompt matched denylist", "action", action, "user", tasks[i].CreatedBy, "matched", entry.Matched)
+	}
+	if rejected != nil {
+		return rejected
+	}
+	return nil
+}
+
+// --- 管理 API ---
+
+// GET /api/admin/moderation/log?action=rejected&user_id=1&limit=100
+func (a *App) apiAdminModerationLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := 100
+	if n, err := strconv.Atoi(query.Get("limit")); err == nil && n > 0 {
+		limit = min(n, 1000)
+	}
+	q := a.DB.Order("id desc").Limit(limit)
+	if action := query.Get("action"); action != "" {
+		q = q.Where("action = ?", action)
+	}
+	if s := query.Get("user_id"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid user_id")
+			return
+		}
+		q = q.Where("user_id = ?", id)
+	}
+	var logs []ModerationLog
+	if err := q.Find(&logs).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, logs)
+}
+
+// 目前的禁用詞設定
+func (a *App) moderationStatus() map[string]interface{} {
+	f := a.Moderation
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return map[string]interface{}{"path": f.Path, "mode": f.Mode, "rules": len(f.rules), "loaded_at": f.loadedAt}
+}
+
+// GET /api/admin/moderation
+func (a *App) apiAdminModeration(w http.ResponseWriter, r *http.Request) {
+	if a.Moderation == nil {
+		writeError(w, http.StatusNotFound, "prompt denylist is not configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, a.moderationStatus())
+}
+
+// POST /api/admin/moderation/reload：立即重新讀取禁用詞檔案
+func (a *App) apiAdminReloadModeration(w http.ResponseWriter, r *http.Request) {
+	if a.Moderation == nil {
+		writeError(w, http.StatusNotFound, "prompt denylist is not configured")
+		return
+	}
+	if err := a.Moderation.reload(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	loggerFrom(r.Context()).Info("Prompt denylist reloaded", "path", a.Moderation.Path, "rules", a.Moderation.ruleCount())
+	a.audit(r.Context(), "moderation.reloaded", "", "", a.Moderation.Path)
+	writeJSON(w, http.StatusOK, a.moderationStatus())
+}
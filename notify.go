@@ -0,0 +1,369 @@
+// notify.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- Discord / Slack 通知 ---
+// 伺服器設定 DISCORD_WEBHOOK_URL / SLACK_WEBHOOK_URL (頻道的 incoming webhook) 後，
+// 使用者以 PUT /api/notifications 選擇要通知的頻道與事件 (預設都不通知)，任務完成或失敗時送出訊息。
+// 訊息內容以 text/template 產生 (NOTIFY_TEMPLATE_COMPLETED / NOTIFY_TEMPLATE_FAILED)。
+// Discord 直接附上圖片檔；Slack 的 incoming webhook 不能上傳檔案，改為建立一個 7 天後到期的分享連結 (見 share.go) 顯示圖片，
+// 沒有 PUBLIC_URL 也不知道建立任務時的對外網址時只送文字。被內容安全檢查隔離的圖片不會送出
+
+const (
+	defaultNotifyCompleted = "✅ #{{.TaskID}} {{.User}}: {{.Prompt}}"
+	defaultNotifyFailed    = "❌ #{{.TaskID}} {{.User}} failed: {{.Error}}\n{{.Prompt}}"
+
+	notifyShareTTL      = 7 * 24 * time.Hour
+	discordMaxContent   = 2000            // Discord 訊息的字數上限
+	discordMaxImageSize = 8 * 1024 * 1024 // 超過時改附縮圖
+)
+
+type notifyConfig struct {
+	DiscordURL        string // DISCORD_WEBHOOK_URL
+	SlackURL          string // SLACK_WEBHOOK_URL
+	CompletedTemplate string // NOTIFY_TEMPLATE_COMPLETED
+	FailedTemplate    string // NOTIFY_TEMPLATE_FAILED
+}
+
+func notifyFromEnv() notifyConfig {
+	return notifyConfig{
+		DiscordURL:        os.Getenv("DISCORD_WEBHOOK_URL"),
+		SlackURL:          os.Getenv("SLACK_WEBHOOK_URL"),
+		CompletedTemplate: envString("NOTIFY_TEMPLATE_COMPLETED", defaultNotifyCompleted),
+		FailedTemplate:    envString("NOTIFY_TEMPLATE_FAILED", defaultNotifyFailed),
+	}
+}
+
+func (n notifyConfig) configured() bool {
+	return n.DiscordURL != "" || n.SlackURL != ""
+}
+
+func (n notifyConfig) problems() []string {
+	var problems []string
+	for _, u := range []struct{ name, value string }{{"DISCORD_WEBHOOK_URL", n.DiscordURL}, {"SLACK_WEBHOOK_URL", n.SlackURL}} {
+		if u.value != "" && validateCallbackURL(u.value) != nil {
+			problems = append(problems, fmt.Sprintf("%s=%q: must be an http or https URL", u.name, u.value))
+		}
+	}
+	for _, t := range []struct{ name, value string }{{"NOTIFY_TEMPLATE_COMPLETED", n.CompletedTemplate}, {"NOTIFY_TEMPLATE_FAILED", n.FailedTemplate}} {
+		tmpl, err := template.New(t.name).Parse(t.value)
+		if err == nil {
+			// 以範例資料執行一次，欄位名稱打錯時啟動前就會發現
+			err = tmpl.Execute(io.Discard, notifyMessage{})
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", t.name, err))
+		}
+	}
+	return problems
+}
+
+// 使用者的通知設定，沒有紀錄代表都不通知
+type NotifySetting struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	UserID    uint      `gorm:"uniqueIndex" json:"user_id"`
+	Discord   bool      `json:"discord"`   // 送到 Discord 頻道
+	Slack     bool      `json:"slack"`     // 送到 Slack 頻道
+	Completed bool      `json:"completed"` // 任務完成時通知
+	Failed    bool      `json:"failed"`    // 任務失敗時通知
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GET /api/notifications 的回應：自己的設定與伺服器提供的頻道
+type notifySettingsView struct {
+	NotifySetting
+	Channels []string `json:"channels"` // 伺服器已設定的頻道 (discord、slack)
+}
+
+// 範本可以使用的欄位
+type notifyMessage struct {
+	Event    string // completed、failed
+	TaskID   uint
+	User     string // 建立任務的 API key 名稱
+	Prompt   string
+	Model    string
+	Seed     string
+	Width    int
+	Height   int
+	Duration string // 從開始生成到結束的時間
+	Error    string // 失敗原因 (error_message，沒有時為 fail_reason)
+	URL      string // 圖片的分享連結 (只有 Slack 會建立)
+}
+
+func (n notifyConfig) channels() []string {
+	channels := []string{}
+	if n.DiscordURL != "" {
+		channels = append(channels, "discord")
+	}
+	if n.SlackURL != "" {
+		channels = append(channels, "slack")
+	}
+	return channels
+}
+
+func (a *App) notifySettingFor(userID uint) (NotifySetting, error) {
+	setting := NotifySetting{UserID: userID}
+	err := a.DB.Where("user_id = ?", userID).First(&setting).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	return setting, err
+}
+
+// GET /api/notifications
+func (a *App) apiGetNotifySettings(w http.ResponseWriter, r *http.Request) {
+	setting, err := a.notifySettingFor(identityFromRequest(r).userID())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, notifySettingsView{NotifySetting: setting, Channels: a.Config.Notify.channels()})
+}
+
+// PUT /api/notifications
+func (a *App) apiUpdateNotifySettings(w http.ResponseWriter, r *http.Request) {
+	var req NotifySetting
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Discord && a.Config.Notify.DiscordURL == "" {
+		writeTaskError(w, invalidField("discord", "discord notifications are not configured on this server"))
+		return
+	}
+	if req.Slack && a.Config.Notify.SlackURL == "" {
+		writeTaskError(w, invalidField("slack", "slack notifications are not configured on this server"))
+		return
+	}
+	setting, err := a.notifySettingFor(identityFromRequest(r).userID())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	setting.Discord, setting.Slack, setting.Completed, setting.Failed = req.Discord, req.Slack, req.Completed, req.Failed
+	if err := a.DB.Save(&setting).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, notifySettingsView{NotifySetting: setting, Channels: a.Config.Notify.channels()})
+}
+
+// 任務結束時呼叫，依任務擁有者的設定送出 Discord / Slack 訊息
+func (a *App) notifyChat(task Task) {
+	if !a.Config.Notify.configured() || (task.Status != StatusCompleted && task.Status != StatusFailed) {
+		return
+	}
+	setting, err := a.notifySettingFor(task.UserID)
+	if err != nil {
+		slog.Warn("Failed to load notification settings", "task_id", task.ID, "error", err)
+		return
+	}
+	if (task.Status == StatusCompleted && !setting.Completed) || (task.Status == StatusFailed && !setting.Failed) {
+		return
+	}
+	logger := slog.With("task_id", task.ID)
+	if setting.Discord && a.Config.Notify.DiscordURL != "" {
+		go a.notifyDiscord(task, logger.With("channel", "discord"))
+	}
+	if setting.Slack && a.Config.Notify.SlackURL != "" {
+		go a.notifySlack(task, logger.With("channel", "slack"))
+	}
+}
+
+func newNotifyMessage(task Task) notifyMessage {
+	msg := notifyMessage{
+		Event:  "completed",
+		TaskID: task.ID,
+		User:   task.CreatedBy,
+		Prompt: task.Prompt,
+		Model:  task.Model,
+		Width:  task.Width,
+		Height: task.Height,
+	}
+	if task.Seed != nil {
+		msg.Seed = fmt.Sprint(*task.Seed)
+	}
+	if task.StartedAt != nil && task.FinishedAt != nil {
+		msg.Duration = task.FinishedAt.Sub(*task.StartedAt).Round(time.Second).String()
+	}
+	if task.Status == StatusFailed {
+		msg.Event = "failed"
+		msg.Error = task.ErrorMessage
+		if msg.Error == "" {
+			msg.Error = task.FailReason
+		}
+	}
+	return msg
+}
+
+func (n notifyConfig) render(msg notifyMessage) (string, error) {
+	text := n.CompletedTemplate
+	if msg.Event == "failed" {
+		text = n.FailedTemplate
+	}
+	tmpl, err := template.New("notify").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, msg); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// 完成且沒有被隔離的任務才附上圖片
+func hasPublishableImage(task Task) bool {
+	return task.Status == StatusCompleted && !task.Quarantined && task.ImagePath != ""
+}
+
+// Discord：multipart 的 payload_json 加上圖片檔
+func (a *App) notifyDiscord(task Task, logger *slog.Logger) {
+	content, err := a.Config.Notify.render(newNotifyMessage(task))
+	if err != nil {
+		logger.Warn("Failed to render notification", "error", err)
+		return
+	}
+	content = truncateHead(content, discordMaxContent-1)
+	var image []byte
+	var name string
+	if hasPublishableImage(task) {
+		if image, name, err = a.notifyImage(task); err != nil {
+			logger.Warn("Failed to read image for notification", "error", err)
+		}
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	payload, _ := json.Marshal(map[string]interface{}{
+		"content":          content,
+		"allowed_mentions": map[string]interface{}{"parse": []string{}}, // prompt 中的 @everyone 不會通知所有人
+	})
+	mw.WriteField("payload_json", string(payload))
+	if image != nil {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="files[0]"; filename=%q`, name))
+		h.Set("Content-Type", http.DetectContentType(image))
+		part, _ := mw.CreatePart(h)
+		part.Write(image)
+	}
+	mw.Close()
+
+	client := &http.Client{Timeout: webhookTimeout}
+	a.retryDelivery(logger, func() (int, error) {
+		return postChat(client, a.Config.Notify.DiscordURL, mw.FormDataContentType(), body.Bytes())
+	})
+}
+
+// 送給 Discord 與 Telegram 的圖片，超過大小上限時改用縮圖
+func (a *App) notifyImage(task Task) ([]byte, string, error) {
+	name := task.ImagePath
+	for {
+		f, _, err := a.storageFor(&task).Open(context.Background(), name)
+		if err != nil {
+			return nil, "", err
+		}
+		data, err := io.ReadAll(io.LimitReader(f, discordMaxImageSize+1))
+		f.Close()
+		if err != nil {
+			return nil, "", err
+		}
+		if len(data) <= discordMaxImageSize {
+			return data, path.Base(name), nil
+		}
+		if name == task.ThumbnailPath || task.ThumbnailPath == "" {
+			return nil, "", errors.New("image is too large")
+		}
+		name = task.ThumbnailPath
+	}
+}
+
+// Slack：mrkdwn 文字，有對外網址時以分享連結加上 image block
+func (a *App) notifySlack(task Task, logger *slog.Logger) {
+	msg := newNotifyMessage(task)
+	var imageURL string
+	if hasPublishableImage(task) {
+		shareURL, err := a.notifyShareURL(task)
+		if err != nil {
+			logger.Warn("Failed to create share link for notification", "error", err)
+		}
+		if shareURL != "" {
+			msg.URL, imageURL = shareURL, shareURL+"/image"
+		}
+	}
+	text, err := a.Config.Notify.render(msg)
+	if err != nil {
+		logger.Warn("Failed to render notification", "error", err)
+		return
+	}
+	text = slackEscape(text)
+	payload := map[string]interface{}{"text": text}
+	if imageURL != "" {
+		payload["blocks"] = []map[string]interface{}{
+			{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": text}},
+			{"type": "image", "image_url": imageURL, "alt_text": truncateHead(task.Prompt, 1999)},
+		}
+	}
+	body, _ := json.Marshal(payload)
+	client := &http.Client{Timeout: webhookTimeout}
+	a.retryDelivery(logger, func() (int, error) {
+		return postChat(client, a.Config.Notify.SlackURL, "application/json", body)
+	})
+}
+
+// 建立到期的分享連結，沒有對外網址時回傳空字串
+func (a *App) notifyShareURL(task Task) (string, error) {
+	base := a.Config.PublicURL
+	if base == "" {
+		base = task.OriginURL
+	}
+	if base == "" {
+		return "", nil
+	}
+	expiresAt := time.Now().Add(notifyShareTTL)
+	token, err := a.createShareLink(&ShareLink{TaskID: task.ID, UserID: task.UserID, CreatedBy: "notify", ExpiresAt: &expiresAt})
+	if err != nil {
+		return "", err
+	}
+	return base + "/share/" + token, nil
+}
+
+// Slack 的 mrkdwn 需要跳脫 &、<、>
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func postChat(client *http.Client, target, contentType string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "mcpzimage-notify")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
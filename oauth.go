@@ -0,0 +1,600 @@
+// oauth.go
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- 瀏覽器登入 (OAuth2 / OIDC) ---
+// 網頁使用者以 Google 等 OIDC 服務 (OIDC_ISSUER) 或 GitHub 登入，不需要自己管理 API key：
+//
+//	GET  /auth/{provider}/login    產生 state 與 PKCE verifier (放在短效 cookie)，導向登入頁
+//	GET  /auth/{provider}/callback 檢查 state，以 code 換 access token，從 userinfo (GitHub 為 /user) 取得帳號
+//	POST /auth/logout              刪除 session
+//
+// 第一次登入時自動建立使用者 (角色為 DEFAULT_USER_ROLE)，之後以 (provider, subject) 找回同一個使用者；
+// 登入後發給 session cookie (HttpOnly、SameSite=Lax，對外網址為 https 時加上 Secure)，資料庫只存雜湊。
+// session 透過 registerUserResolver 轉成 Identity，REST、WebSocket、SSE 與 MCP SSE 都適用，權限與 API key 相同。
+// 不驗證 ID token 的簽章：access token 直接從 token endpoint 取得，再以它查詢 userinfo
+
+const (
+	sessionCookieName = "zimage_session"
+	loginCookieName   = "zimage_login" // 登入流程中的 state 與 PKCE verifier
+	loginFlowTimeout  = 10 * time.Minute
+	oauthHTTPTimeout  = 15 * time.Second
+)
+
+type loginConfig struct {
+	OIDCIssuer       string   // OIDC_ISSUER，例如 https://accounts.google.com
+	OIDCClientID     string   // OIDC_CLIENT_ID
+	OIDCClientSecret string   // OIDC_CLIENT_SECRET
+	OIDCName         string   // 登入按鈕的名稱 (OIDC_NAME)
+	OIDCScopes       []string // OIDC_SCOPES
+	GitHubClientID   string   // GITHUB_CLIENT_ID
+	GitHubSecret     string   // GITHUB_CLIENT_SECRET
+	AllowedDomains   []string // 只允許這些網域的 email 登入 (LOGIN_ALLOWED_DOMAINS)，空白代表不限制
+	SessionTTL       time.Duration
+}
+
+func loginFromEnv() loginConfig {
+	c := loginConfig{
+		OIDCIssuer:       strings.TrimRight(os.Getenv("OIDC_ISSUER"), "/"),
+		OIDCClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCName:         envString("OIDC_NAME", "OIDC"),
+		OIDCScopes:       strings.Fields(envString("OIDC_SCOPES", "openid email profile")),
+		GitHubClientID:   os.Getenv("GITHUB_CLIENT_ID"),
+		GitHubSecret:     os.Getenv("GITHUB_CLIENT_SECRET"),
+		SessionTTL:       7 * 24 * time.Hour,
+	}
+	for _, s := range strings.Split(os.Getenv("LOGIN_ALLOWED_DOMAINS"), ",") {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+			c.AllowedDomains = append(c.AllowedDomains, s)
+		}
+	}
+	if d, err := time.ParseDuration(os.Getenv("SESSION_TTL")); err == nil && d > 0 {
+		c.SessionTTL = d
+	}
+	return c
+}
+
+func (c loginConfig) enabled() bool {
+	return c.OIDCIssuer != "" || c.GitHubClientID != ""
+}
+
+func (c loginConfig) problems() []string {
+	var problems []string
+	if c.OIDCIssuer != "" {
+		if validateCallbackURL(c.OIDCIssuer) != nil {
+			problems = append(problems, fmt.Sprintf("OIDC_ISSUER=%q: must be an http or https URL", c.OIDCIssuer))
+		}
+		if c.OIDCClientID == "" || c.OIDCClientSecret == "" {
+			problems = append(problems, "OIDC_CLIENT_ID and OIDC_CLIENT_SECRET are required for OIDC_ISSUER")
+		}
+	}
+	if (c.GitHubClientID == "") != (c.GitHubSecret == "") {
+		problems = append(problems, "GITHUB_CLIENT_ID and GITHUB_CLIENT_SECRET must be set together")
+	}
+	return problems
+}
+
+// email 是否屬於允許的網域 (沒有限制時都允許)
+func (c loginConfig) allowsEmail(email string) bool {
+	if len(c.AllowedDomains) == 0 {
+		return true
+	}
+	_, domain, ok := strings.Cut(strings.ToLower(email), "@")
+	if !ok {
+		return false
+	}
+	for _, d := range c.AllowedDomains {
+		if domain == d {
+			return true
+		}
+	}
+	return false
+}
+
+// --- 登入服務 ---
+
+type oauthProvider struct {
+	Name         string // 路徑中的名稱：oidc、github
+	Label        string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+}
+
+const githubAPIURL = "https://api.github.com"
+
+// 依路徑中的名稱取得登入服務，OIDC 第一次使用時讀取 discovery 文件
+func (a *App) oauthProvider(ctx context.Context, name string) (*oauthProvider, error) {
+	c := a.Config.Login
+	switch {
+	case name == "github" && c.GitHubClientID != "":
+		return &oauthProvider{
+			Name: "github", Label: "GitHub", ClientID: c.GitHubClientID, ClientSecret: c.GitHubSecret,
+			Scopes:      []string{"read:user", "user:email"},
+			AuthURL:     "https://github.com/login/oauth/authorize",
+			TokenURL:    "https://github.com/login/oauth/access_token",
+			UserInfoURL: githubAPIURL + "/user",
+		}, nil
+	case name == "oidc" && c.OIDCIssuer != "":
+		return a.discoverOIDC(ctx)
+	}
+	return nil, &codedError{Code: "not_found", Message: "unknown login provider"}
+}
+
+// 已設定的登入服務名稱 (網頁顯示登入按鈕用)
+type loginProvider struct {
+	Name     string `json:"name"`
+	Label    string `json:"label"`
+	LoginURL string `json:"login_url"` // 相對於網站根目錄 (含 BASE_PATH)
+}
+
+func (c loginConfig) providers() []loginProvider {
+	providers := []loginProvider{}
+	if c.OIDCIssuer != "" {
+		providers = append(providers, loginProvider{Name: "oidc", Label: c.OIDCName, LoginURL: "auth/oidc/login"})
+	}
+	if c.GitHubClientID != "" {
+		providers = append(providers, loginProvider{Name: "github", Label: "GitHub", LoginURL: "auth/github/login"})
+	}
+	return providers
+}
+
+// OIDC discovery 的結果，成功後保留到程式結束
+type oidcDiscovery struct {
+	mutex    sync.Mutex
+	provider *oauthProvider
+}
+
+func (a *App) discoverOIDC(ctx context.Context) (*oauthProvider, error) {
+	a.oidc.mutex.Lock()
+	defer a.oidc.mutex.Unlock()
+	if a.oidc.provider != nil {
+		return a.oidc.provider, nil
+	}
+	c := a.Config.Login
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	}
+	if err := oauthGetJSON(ctx, c.OIDCIssuer+"/.well-known/openid-configuration", "", &doc); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimRight(doc.Issuer, "/") != c.OIDCIssuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match OIDC_ISSUER", doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.UserInfoEndpoint == "" {
+		return nil, errors.New("oidc discovery: authorization, token and userinfo endpoints are required")
+	}
+	a.oidc.provider = &oauthProvider{
+		Name: "oidc", Label: c.OIDCName, ClientID: c.OIDCClientID, ClientSecret: c.OIDCClientSecret, Scopes: c.OIDCScopes,
+		AuthURL: doc.AuthorizationEndpoint, TokenURL: doc.TokenEndpoint, UserInfoURL: doc.UserInfoEndpoint,
+	}
+	return a.oidc.provider, nil
+}
+
+func oauthGetJSON(ctx context.Context, target, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return oauthDo(req, v)
+}
+
+func oauthDo(req *http.Request, v interface{}) error {
+	resp, err := (&http.Client{Timeout: oauthHTTPTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: HTTP %d: %s", req.URL.Host, resp.StatusCode, truncateHead(string(body), 200))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// 以 authorization code 換 access token
+func (p *oauthProvider) exchange(ctx context.Context, code, redirectURI, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := oauthDo(req, &token); err != nil {
+		return "", fmt.Errorf("token exchange: %w", err)
+	}
+	// GitHub 的錯誤也是 200
+	if token.Error != "" {
+		return "", fmt.Errorf("token exchange: %s %s", token.Error, token.ErrorDescription)
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("token exchange: no access_token in response")
+	}
+	return token.AccessToken, nil
+}
+
+// 登入服務回傳的帳號資料
+type loginProfile struct {
+	Subject       string // 在登入服務中不會改變的 ID
+	Name          string // 建立使用者時的名稱
+	Email         string
+	EmailVerified bool
+}
+
+func (p *oauthProvider) profile(ctx context.Context, accessToken string) (*loginProfile, error) {
+	if p.Name == "github" {
+		return githubProfile(ctx, accessToken)
+	}
+	var info struct {
+		Subject           string      `json:"sub"`
+		Email             string      `json:"email"`
+		EmailVerified     interface{} `json:"email_verified"` // 部分服務以字串回傳
+		PreferredUsername string      `json:"preferred_username"`
+		Name              string      `json:"name"`
+	}
+	if err := oauthGetJSON(ctx, p.UserInfoURL, accessToken, &info); err != nil {
+		return nil, fmt.Errorf("userinfo: %w", err)
+	}
+	if info.Subject == "" {
+		return nil, errors.New("userinfo: missing sub")
+	}
+	name := info.PreferredUsername
+	if name == "" {
+		name, _, _ = strings.Cut(info.Email, "@")
+	}
+	verified := info.EmailVerified == true || info.EmailVerified == "true"
+	return &loginProfile{Subject: info.Subject, Name: name, Email: info.Email, EmailVerified: verified}, nil
+}
+
+// GitHub 沒有 userinfo：/user 的 email 可能不公開，改從 /user/emails 取得主要且已驗證的 email
+func githubProfile(ctx context.Context, accessToken string) (*loginProfile, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+	}
+	if err := oauthGetJSON(ctx, githubAPIURL+"/user", accessToken, &user); err != nil {
+		return nil, fmt.Errorf("github user: %w", err)
+	}
+	if user.ID == 0 {
+		return nil, errors.New("github user: missing id")
+	}
+	profile := &loginProfile{Subject: strconv.FormatInt(user.ID, 10), Name: user.Login}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := oauthGetJSON(ctx, githubAPIURL+"/user/emails", accessToken, &emails); err == nil {
+		for _, e := range emails {
+			if e.Primary && e.Verified {
+				profile.Email, profile.EmailVerified = e.Email, true
+			}
+		}
+	}
+	return profile, nil
+}
+
+// --- 使用者與 session ---
+
+// 登入服務的帳號與使用者的對應
+type OAuthAccount struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Provider    string    `gorm:"uniqueIndex:idx_oauth_account" json:"provider"`
+	Subject     string    `gorm:"uniqueIndex:idx_oauth_account" json:"subject"`
+	UserID      uint      `gorm:"index" json:"user_id"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// 瀏覽器的登入狀態，只存 token 的雜湊
+type LoginSession struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	TokenHash string    `gorm:"uniqueIndex" json:"-"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	Provider  string    `json:"provider"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 找出帳號對應的使用者，第一次登入時建立 (名稱重複時加上 -2、-3…，不會接管既有的使用者)
+func (a *App) loginUser(ctx context.Context, provider string, profile *loginProfile) (*User, error) {
+	var user User
+	created := false
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		var account OAuthAccount
+		err := tx.Where("provider = ? AND subject = ?", provider, profile.Subject).First(&account).Error
+		if err == nil {
+			if err := tx.First(&user, account.UserID).Error; err != nil {
+				return err
+			}
+			return tx.Model(&account).Updates(map[string]interface{}{"email": profile.Email, "last_login_at": time.Now()}).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		name, err := uniqueUserName(tx, profile.Name)
+		if err != nil {
+			return err
+		}
+		user = User{Name: name, Email: profile.Email, Role: a.Config.DefaultUserRole}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		account = OAuthAccount{Provider: provider, Subject: profile.Subject, UserID: user.ID, Email: profile.Email, LastLoginAt: time.Now()}
+		created = true
+		return tx.Create(&account).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		ctx = context.WithValue(ctx, identityKey{}, &Identity{KeyName: user.Name, UserID: user.ID})
+		a.audit(ctx, "user.created", "user", strconv.FormatUint(uint64(user.ID), 10), provider+" "+user.Name)
+	}
+	return &user, nil
+}
+
+func uniqueUserName(tx *gorm.DB, base string) (string, error) {
+	base = strings.TrimSpace(base)
+	if base == "" {
+		base = "user"
+	}
+	for i := 1; ; i++ {
+		name := base
+		if i > 1 {
+			name = fmt.Sprintf("%s-%d", base, i)
+		}
+		var count int64
+		if err := tx.Model(&User{}).Where("name = ?", name).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return name, nil
+		}
+	}
+}
+
+func newLoginToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// 建立 session 並回傳 cookie 中的 token；順便刪除已過期的 session
+func (a *App) createLoginSession(userID uint, provider string) (string, time.Time, error) {
+	token, err := newLoginToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	expires := now.Add(a.Config.Login.SessionTTL)
+	session := LoginSession{TokenHash: hashAPIKey(token), UserID: userID, Provider: provider, ExpiresAt: expires}
+	if err := a.DB.Create(&session).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	a.DB.Where("expires_at < ?", now).Delete(&LoginSession{})
+	return token, expires, nil
+}
+
+// userResolver：以 session cookie 取得使用者。
+// cookie 會被瀏覽器自動帶上，修改資料的請求另外檢查 Origin (SameSite=Lax 之外的 CSRF 防護)
+func (a *App) sessionIdentity(r *http.Request) (*Identity, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		if origin := r.Header.Get("Origin"); origin != "" && !sameOrigin(r, origin) && !a.Config.CORS.allowed(origin) {
+			return nil, false
+		}
+	}
+	var session LoginSession
+	if err := a.DB.Where("token_hash = ? AND expires_at > ?", hashAPIKey(cookie.Value), time.Now()).First(&session).Error; err != nil {
+		return nil, false
+	}
+	var user User
+	if err := a.DB.First(&user, session.UserID).Error; err != nil {
+		return nil, false
+	}
+	return &Identity{KeyName: user.Name, UserID: user.ID}, true
+}
+
+// --- handler ---
+
+// cookie 的路徑與 Secure 依對外網址決定
+func (a *App) loginCookie(r *http.Request, name, value, path string, expires time.Time) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     a.Config.BasePath + path,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(a.Config.externalURL(r), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	} else {
+		cookie.Expires = expires
+	}
+	return cookie
+}
+
+// 只接受本站的路徑，避免被當成 open redirect
+func (a *App) loginRedirect(target string) string {
+	if target == "" || !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.ContainsAny(target, "\\\r\n") {
+		return a.Config.BasePath + "/"
+	}
+	return target
+}
+
+func (a *App) loginCallbackURL(r *http.Request, provider string) string {
+	return a.Config.externalURL(r) + "/auth/" + provider + "/callback"
+}
+
+// GET /auth/providers：已設定的登入服務
+func (a *App) serveLoginProviders(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Config.Login.providers())
+}
+
+// GET /auth/{provider}/login?redirect=/zimage/：導向登入服務
+func (a *App) serveLogin(w http.ResponseWriter, r *http.Request) {
+	provider, err := a.oauthProvider(r.Context(), r.PathValue("provider"))
+	if err != nil {
+		if errorCode(err) == "not_found" {
+			writeTaskError(w, err)
+		} else {
+			loggerFrom(r.Context()).Error("Login provider error", "error", err)
+			writeError(w, http.StatusBadGateway, "login provider is unavailable")
+		}
+		return
+	}
+	state, err := newLoginToken()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	verifier, err := newLoginToken()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	flow := url.Values{"state": {state}, "verifier": {verifier}, "redirect": {a.loginRedirect(r.URL.Query().Get("redirect"))}}
+	http.SetCookie(w, a.loginCookie(r, loginCookieName, flow.Encode(), "/auth/", time.Now().Add(loginFlowTimeout)))
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.ClientID},
+		"redirect_uri":          {a.loginCallbackURL(r, provider.Name)},
+		"scope":                 {strings.Join(provider.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	target := provider.AuthURL
+	if strings.Contains(target, "?") {
+		target += "&" + query.Encode()
+	} else {
+		target += "?" + query.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// GET /auth/{provider}/callback：完成登入，設定 session cookie 後導回網頁
+func (a *App) serveLoginCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	provider, err := a.oauthProvider(ctx, r.PathValue("provider"))
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	cookie, err := r.Cookie(loginCookieName)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "login session expired, please try again")
+		return
+	}
+	http.SetCookie(w, a.loginCookie(r, loginCookieName, "", "/auth/", time.Time{}))
+	flow, err := url.ParseQuery(cookie.Value)
+	state := r.URL.Query().Get("state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(flow.Get("state"))) != 1 {
+		writeError(w, http.StatusBadRequest, "invalid login state")
+		return
+	}
+	if e := r.URL.Query().Get("error"); e != "" {
+		writeErrorf(w, http.StatusForbidden, "login failed: %s", e)
+		return
+	}
+	accessToken, err := provider.exchange(ctx, r.URL.Query().Get("code"), a.loginCallbackURL(r, provider.Name), flow.Get("verifier"))
+	if err != nil {
+		loggerFrom(ctx).Warn("Login failed", "provider", provider.Name, "error", err)
+		writeError(w, http.StatusBadGateway, "login failed")
+		return
+	}
+	profile, err := provider.profile(ctx, accessToken)
+	if err != nil {
+		loggerFrom(ctx).Warn("Login failed", "provider", provider.Name, "error", err)
+		writeError(w, http.StatusBadGateway, "login failed")
+		return
+	}
+	if len(a.Config.Login.AllowedDomains) > 0 && (!profile.EmailVerified || !a.Config.Login.allowsEmail(profile.Email)) {
+		a.audit(ctx, "auth.failed", "", "", provider.Name+" login: "+profile.Email)
+		writeError(w, http.StatusForbidden, "this account is not allowed to sign in")
+		return
+	}
+	user, err := a.loginUser(ctx, provider.Name, profile)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	token, expires, err := a.createLoginSession(user.ID, provider.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	http.SetCookie(w, a.loginCookie(r, sessionCookieName, token, "/", expires))
+	identity := &Identity{KeyName: user.Name, UserID: user.ID}
+	a.audit(context.WithValue(ctx, identityKey{}, identity), "auth.login", "", "", provider.Name)
+	http.Redirect(w, r, flow.Get("redirect"), http.StatusFound)
+}
+
+// POST /auth/logout：刪除 session 並清除 cookie
+func (a *App) serveLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+		if identity, ok := a.sessionIdentity(r); ok {
+			a.audit(context.WithValue(r.Context(), identityKey{}, identity), "auth.logout", "", "", "")
+		}
+		a.DB.Where("token_hash = ?", hashAPIKey(cookie.Value)).Delete(&LoginSession{})
+	}
+	http.SetCookie(w, a.loginCookie(r, sessionCookieName, "", "/", time.Time{}))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,264 @@
+// openai.go
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- OpenAI Images API 相容層 ---
+// POST /v1/images/generations 接受 OpenAI 的請求格式 (prompt、n、size、response_format)，
+// 建立一般的任務後等待完成再一次回傳，現有的 OpenAI SDK 只要把 base URL 指向 <伺服器>/v1、API key 換成這裡的 key 即可使用。
+// n 對應 num_images (同一個任務)；model 只有在 GET /api/models 有這個名稱時才採用，其他名稱 (dall-e-3 等) 使用預設模型。
+// response_format=url 時回傳 1 小時後到期的分享連結 (見 share.go)，b64_json 時直接回傳圖片內容。
+// 連線在完成前中斷時取消任務；等待超過 syncTaskTimeout 時回 504，任務繼續執行，之後可以在歷史紀錄找到
+
+const (
+	syncTaskTimeout = 10 * time.Minute // 同步 API (OpenAI、A1111) 最多等待的時間
+	openAIURLTTL    = time.Hour        // 與 OpenAI 回傳的網址相同的有效時間
+)
+
+// OpenAI 的請求欄位，加上 negative_prompt、seed、steps、guidance_scale 等擴充 (SDK 的 extra_body)
+type openAIImageRequest struct {
+	Prompt         string  `json:"prompt"`
+	Model          string  `json:"model,omitempty"`
+	N              int     `json:"n,omitempty"`               // 1~8，預設 1
+	Size           string  `json:"size,omitempty"`            // 寬x高，例如 1024x1024；auto 或空白代表預設
+	ResponseFormat string  `json:"response_format,omitempty"` // url (預設) 或 b64_json
+	OutputFormat   string  `json:"output_format,omitempty"`   // png (預設)、jpeg、webp
+	Quality        string  `json:"quality,omitempty"`         // 不使用，只為了接受 OpenAI 的欄位
+	Style          string  `json:"style,omitempty"`           // 不使用
+	User           string  `json:"user,omitempty"`            // 不使用
+	NegativePrompt string  `json:"negative_prompt,omitempty"`
+	Seed           *int64  `json:"seed,omitempty"`
+	Steps          int     `json:"steps,omitempty"`
+	GuidanceScale  float64 `json:"guidance_scale,omitempty"`
+}
+
+type openAIImageResponse struct {
+	Created int64             `json:"created"`
+	Data    []openAIImageData `json:"data"`
+}
+
+type openAIImageData struct {
+	URL           string `json:"url,omitempty"`
+	B64JSON       string `json:"b64_json,omitempty"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+}
+
+// OpenAI 格式的錯誤：{"error": {"message": "...", "type": "...", "param": null, "code": null}}
+type openAIError struct {
+	Error openAIErrorBody `json:"error"`
+}
+
+type openAIErrorBody struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, errType, code, param, message string) {
+	body := openAIErrorBody{Message: message, Type: errType}
+	if code != "" {
+		body.Code = &code
+	}
+	if param != "" {
+		body.Param = &param
+	}
+	writeJSON(w, status, openAIError{Error: body})
+}
+
+// 把任務的錯誤轉成 OpenAI 的錯誤類型
+func writeOpenAITaskError(w http.ResponseWriter, err error) {
+	var param string
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		// 以 OpenAI 的欄位名稱回報
+		switch param = validationErr.Field; param {
+		case "width", "height":
+			param = "size"
+		case "num_images":
+			param = "n"
+		case "format":
+			param = "output_format"
+		}
+	}
+	switch errorCode(err) {
+	case "invalid_request", "not_found", "conflict":
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", param, err.Error())
+	case "prompt_rejected":
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "content_policy_violation", "prompt", err.Error())
+	case "quota_exceeded":
+		writeOpenAIError(w, http.StatusTooManyRequests, "rate_limit_error", "rate_limit_exceeded", "", err.Error())
+	default:
+		writeOpenAIError(w, http.StatusInternalServerError, "server_error", "", "", err.Error())
+	}
+}
+
+// 轉成任務，檢查 OpenAI 特有的欄位
+func (req openAIImageRequest) task(a *App, identity *Identity) (Task, error) {
+	params := GenerationParams{NegativePrompt: req.NegativePrompt, Seed: req.Seed, Steps: req.Steps, GuidanceScale: req.GuidanceScale}
+	if req.N < 0 || req.N > maxNumImages {
+		return Task{}, invalidField("n", "n must be between 1 and %d", maxNumImages)
+	}
+	params.NumImages = req.N
+	if req.Size != "" && req.Size != "auto" {
+		w, h, ok := strings.Cut(req.Size, "x")
+		width, errW := strconv.Atoi(w)
+		height, errH := strconv.Atoi(h)
+		if !ok || errW != nil || errH != nil {
+			return Task{}, invalidField("size", "size must be WIDTHxHEIGHT, for example 1024x1024")
+		}
+		params.Width, params.Height = width, height
+	}
+	switch req.ResponseFormat {
+	case "", "url", "b64_json":
+	default:
+		return Task{}, invalidField("response_format", "response_format must be url or b64_json")
+	}
+	params.Format = req.OutputFormat
+	if req.Model != "" {
+		if _, err := a.Config.Models.Lookup(req.Model); err == nil {
+			params.Model = req.Model
+		}
+	}
+	return Task{
+		Prompt:           strings.TrimSpace(req.Prompt),
+		GenerationParams: params,
+		CreatedBy:        identity.keyName(),
+		UserID:           identity.userID(),
+	}, nil
+}
+
+// 同步 API 等待任務結束：呼叫端在完成前離開時取消任務 (沒有人會收到結果)，
+// 超過 syncTaskTimeout 時回傳 context.DeadlineExceeded，任務繼續執行
+func (a *App) waitForCaller(r *http.Request, id uint) (*Task, error) {
+	ctx, cancel := context.WithTimeout(r.Context(), syncTaskTimeout)
+	defer cancel()
+	task, err := a.waitForTask(ctx, id)
+	if err != nil && r.Context().Err() != nil {
+		if _, cancelErr := a.cancelTask(id, identityFromRequest(r)); cancelErr != nil {
+			loggerFrom(r.Context()).Warn("Cancel abandoned task failed", "task_id", id, "error", cancelErr)
+		}
+	}
+	return task, err
+}
+
+// 讀取任務的圖片檔並以 base64 編碼
+func (a *App) imageBase64(ctx context.Context, task *Task, name string) (string, error) {
+	f, _, err := a.storageFor(task).Open(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(content), nil
+}
+
+// POST /v1/images/generations
+func (a *App) apiOpenAIImageGenerations(w http.ResponseWriter, r *http.Request) {
+	var req openAIImageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", "", "invalid JSON body")
+		return
+	}
+	identity := identityFromRequest(r)
+	task, err := req.task(a, identity)
+	if err != nil {
+		writeOpenAITaskError(w, err)
+		return
+	}
+	created, err := a.createTask(r.Context(), task)
+	if err != nil {
+		writeOpenAITaskError(w, err)
+		return
+	}
+
+	done, err := a.waitForCaller(r, created.ID)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			writeOpenAIError(w, http.StatusGatewayTimeout, "server_error", "timeout",
+				"", fmt.Sprintf("task %d did not finish within %s; it keeps running and can be fetched from /api/tasks/%d", created.ID, syncTaskTimeout, created.ID))
+			return
+		}
+		if r.Context().Err() == nil {
+			writeOpenAITaskError(w, err)
+		}
+		return
+	}
+	switch {
+	case done.Status == StatusCancelled:
+		writeOpenAIError(w, http.StatusInternalServerError, "server_error", "", "", fmt.Sprintf("task %d was cancelled", done.ID))
+		return
+	case done.Status != StatusCompleted:
+		msg := done.ErrorMessage
+		if msg == "" {
+			msg = done.FailReason
+		}
+		if done.FailReason == "rejected" {
+			writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "content_policy_violation", "prompt", msg)
+			return
+		}
+		writeOpenAIError(w, http.StatusInternalServerError, "server_error", "", "", fmt.Sprintf("task %d failed: %s", done.ID, msg))
+		return
+	case done.Quarantined:
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "content_policy_violation", "", "the generated image was blocked by the content filter")
+		return
+	}
+
+	data, err := a.openAIImageData(r.Context(), done, req.ResponseFormat == "b64_json")
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, "server_error", "", "", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, openAIImageResponse{Created: done.CreatedAt.Unix(), Data: data})
+}
+
+// 每張圖片一筆：b64 時讀取圖片內容，否則建立一個分享連結，各張以 index 參數區分
+func (a *App) openAIImageData(ctx context.Context, task *Task, b64 bool) ([]openAIImageData, error) {
+	if err := a.loadTaskImages(task); err != nil {
+		return nil, err
+	}
+	var shareURL string
+	if !b64 {
+		expiresAt := time.Now().Add(openAIURLTTL)
+		token, err := a.createShareLink(&ShareLink{TaskID: task.ID, UserID: task.UserID, CreatedBy: task.CreatedBy, HidePrompt: true, ExpiresAt: &expiresAt})
+		if err != nil {
+			return nil, err
+		}
+		shareURL = externalURLFrom(ctx) + "/share/" + token + "/image"
+	}
+	data := []openAIImageData{}
+	for index := 0; index < task.imageCount(); index++ {
+		img, ok := task.image(index)
+		if !ok || img.ImagePath == "" {
+			continue
+		}
+		if !b64 {
+			url := shareURL
+			if index > 0 {
+				url += "?index=" + strconv.Itoa(index)
+			}
+			data = append(data, openAIImageData{URL: url})
+			continue
+		}
+		content, err := a.imageBase64(ctx, task, img.ImagePath)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, openAIImageData{B64JSON: content})
+	}
+	return data, nil
+}
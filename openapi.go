@@ -0,0 +1,541 @@
+// openapi.go
+package main
+
+import (
+	"maps"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- OpenAPI 文件 ---
+// GET /api/openapi.json 提供 OpenAPI 3.0 文件，供產生 client SDK。
+// 每個 REST 路由在 apiOperations 登記一筆 (新增 API 時與 router.go 一起修改)，
+// request / response 的 schema 由 Go 型別的 json tag 以 reflection 產生，不另外手寫
+
+const openAPIVersion = "3.0.3"
+
+// 一個 REST 操作
+type apiOperation struct {
+	Method   string
+	Path     string
+	Tag      string
+	Summary  string
+	Auth     string      // key (API key，預設)、admin (ADMIN_TOKEN 或 admin 角色) 或 none
+	Query    []apiParam  // query string 參數；路徑中的 {id} 自動加入
+	Request  interface{} // JSON body 的型別 (零值)，apiForm 代表 multipart/form-data
+	Response interface{} // 成功回應的型別，nil 代表沒有 body
+	Status   int         // 成功的狀態碼，預設 200
+	Content  string      // 非 JSON 的回應 (例如 image/png、application/zip)
+	Upload   []string    // 也接受的非 JSON request body 類型 (例如 application/zip)
+}
+
+type apiParam struct {
+	Name        string
+	Type        string // string、integer、boolean
+	Description string
+}
+
+// multipart/form-data 的欄位，型別為 apiBinary 的欄位是檔案
+type apiForm struct {
+	Fields interface{}
+}
+
+// 檔案內容 (format: binary)
+type apiBinary struct{}
+
+// 分頁回應 (pageResponse)，Item 為 items 的元素型別
+type apiPage struct {
+	Item interface{}
+}
+
+func param(name, typ, description string) apiParam {
+	return apiParam{Name: name, Type: typ, Description: description}
+}
+
+// img2img 表單欄位 (生成參數與 JSON 相同)
+type img2imgForm struct {
+	Image        apiBinary `json:"image"` // 與 source_task_id 擇一
+	Mask         apiBinary `json:"mask,omitempty"`
+	SourceTaskID uint      `json:"source_task_id,omitempty"`
+	Prompt       string    `json:"prompt"`
+	CallbackURL  string    `json:"callback_url,omitempty"`
+	ScheduledAt  time.Time `json:"scheduled_at,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at,omitempty"`
+	GenerationParams
+}
+
+// ControlNet 表單欄位：條件圖必填，原始圖片與遮罩為選填 (同時做 img2img)
+type controlNetForm struct {
+	ControlImage        apiBinary `json:"control_image"` // 與 control_source_task_id 擇一
+	ControlSourceTaskID uint      `json:"control_source_task_id,omitempty"`
+	Image               apiBinary `json:"image,omitempty"`
+	Mask                apiBinary `json:"mask,omitempty"`
+	SourceTaskID        uint      `json:"source_task_id,omitempty"`
+	Prompt              string    `json:"prompt"`
+	CallbackURL         string    `json:"callback_url,omitempty"`
+	ScheduledAt         time.Time `json:"scheduled_at,omitempty"`
+	ExpiresAt           time.Time `json:"expires_at,omitempty"`
+	GenerationParams
+}
+
+type importForm struct {
+	File apiBinary `json:"file"`
+}
+
+type reasonRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+type favoriteRequest struct {
+	Favorite *bool `json:"favorite,omitempty"`
+}
+
+type recoverResult struct {
+	Recovered []uint `json:"recovered"`
+}
+
+type purgeResult struct {
+	Purged int `json:"purged"`
+}
+
+// 所有 REST 操作，依 router.go 的順序排列
+var apiOperations = []apiOperation{
+	{Method: "GET", Path: "/healthz", Tag: "health", Summary: "程序存活檢查", Auth: "none", Response: map[string]string{}},
+	{Method: "GET", Path: "/readyz", Tag: "health", Summary: "資料庫、生成後端、worker、磁碟與 GPU 都正常時回 200，否則 503", Auth: "none", Response: map[string]interface{}{}},
+	{Method: "GET", Path: "/api/openapi.json", Tag: "health", Summary: "本文件", Auth: "none", Response: map[string]interface{}{}},
+	{Method: "GET", Path: "/api/events", Tag: "tasks", Summary: "任務狀態的 Server-Sent Events", Content: "text/event-stream",
+		Query: []apiParam{param("task_ids", "string", "逗號分隔的任務 ID"), param("all", "boolean", "訂閱自己的所有任務")}},
+
+	{Method: "GET", Path: "/auth/providers", Tag: "auth", Summary: "已設定的瀏覽器登入服務", Auth: "none", Response: []loginProvider{}},
+	{Method: "GET", Path: "/auth/{provider}/login", Tag: "auth", Summary: "導向登入服務 (oidc、github)", Auth: "none", Status: http.StatusFound,
+		Query: []apiParam{param("redirect", "string", "登入後回到的本站路徑")}},
+	{Method: "GET", Path: "/auth/{provider}/callback", Tag: "auth", Summary: "登入服務導回，設定 session cookie", Auth: "none", Status: http.StatusFound},
+	{Method: "POST", Path: "/auth/logout", Tag: "auth", Summary: "登出 (刪除 session)", Auth: "none", Status: http.StatusNoContent},
+	{Method: "GET", Path: "/api/me", Tag: "auth", Summary: "目前的使用者、角色與工作區", Response: meResponse{}},
+	{Method: "PUT", Path: "/api/me/locale", Tag: "auth", Summary: "設定錯誤訊息的語系 (en、zh-TW，空字串代表依 Accept-Language)", Request: localeSetting{}, Response: localeSetting{}},
+	{Method: "GET", Path: "/api/i18n", Tag: "auth", Summary: "目前的語系與任務狀態的顯示文字", Response: i18nResponse{}},
+
+	{Method: "POST", Path: "/api/tasks", Tag: "tasks", Summary: "建立任務", Request: createTaskRequest{}, Response: Task{}, Status: http.StatusCreated,
+		Query: []apiParam{param("force", "boolean", "略過生成結果快取")}},
+	{Method: "GET", Path: "/api/tasks", Tag: "tasks", Summary: "最近的任務", Response: []Task{},
+		Query: []apiParam{
+			param("limit", "integer", "筆數 (預設 20，最多 100)"),
+			param("tag", "string", "逗號分隔的標籤，需同時具有"),
+			param("collection", "integer", "收藏集 ID"),
+		}},
+	{Method: "GET", Path: "/api/tasks/{id}", Tag: "tasks", Summary: "查詢任務", Response: Task{}},
+	{Method: "GET", Path: "/api/tasks/{id}/wait", Tag: "tasks", Summary: "等到任務結束 (200)，逾時仍未結束時回 202 與目前的紀錄", Response: Task{},
+		Query: []apiParam{param("timeout", "string", "最多等待的時間，例如 60s (預設 30s，最多 5m)")}},
+	{Method: "PUT", Path: "/api/tasks/{id}/tags", Tag: "tags", Summary: "取代任務的標籤", Request: taskTagsRequest{}, Response: Task{}},
+	{Method: "DELETE", Path: "/api/tasks/{id}", Tag: "tasks", Summary: "移到垃圾桶", Status: http.StatusNoContent,
+		Query: []apiParam{param("permanent", "boolean", "直接永久刪除")}},
+	{Method: "POST", Path: "/api/tasks/{id}/cancel", Tag: "tasks", Summary: "取消排隊中或執行中的任務", Response: Task{}, Status: http.StatusAccepted},
+	{Method: "GET", Path: "/api/tasks/{id}/metadata", Tag: "tasks", Summary: "重現圖片所需的中繼資料", Response: imageMetadata{}},
+	{Method: "POST", Path: "/api/tasks/{id}/variations", Tag: "tasks", Summary: "以新的隨機 seed 重新生成", Response: []Task{}, Status: http.StatusCreated,
+		Query: []apiParam{param("count", "integer", "張數 (預設 4，最多 16)")}},
+	{Method: "POST", Path: "/api/tasks/{id}/rerun", Tag: "tasks", Summary: "複製任務重新生成，可覆寫部分欄位", Request: rerunRequest{}, Response: Task{}, Status: http.StatusCreated,
+		Query: []apiParam{param("force", "boolean", "略過生成結果快取")}},
+	{Method: "GET", Path: "/api/tasks/{id}/lineage", Tag: "tasks", Summary: "任務的來源鏈與衍生任務", Response: taskLineage{}},
+	{Method: "GET", Path: "/api/tasks/{id}/logs", Tag: "tasks", Summary: "生成後端的即時輸出", Response: taskLogsResponse{},
+		Query: []apiParam{param("since", "integer", "只回傳行號大於等於此值的行 (上一次回應的 next)")}},
+	{Method: "POST", Path: "/api/tasks/{id}/share", Tag: "share", Summary: "建立公開分享連結 (網址只回傳這一次)", Request: shareRequest{}, Response: shareCreated{}, Status: http.StatusCreated},
+	{Method: "GET", Path: "/api/tasks/{id}/shares", Tag: "share", Summary: "任務的分享連結", Response: []ShareLink{}},
+	{Method: "DELETE", Path: "/api/tasks/{id}/shares/{share}", Tag: "share", Summary: "撤銷分享連結", Status: http.StatusNoContent},
+	{Method: "GET", Path: "/share/{token}", Tag: "share", Summary: "分享頁面 (圖片與參數)", Auth: "none", Content: "text/html"},
+	{Method: "GET", Path: "/share/{token}/image", Tag: "share", Summary: "分享的圖片", Auth: "none", Content: "image/*",
+		Query: []apiParam{param("size", "string", "thumb、full (預設) 或 upscaled"), param("index", "integer", "多張輸出的第幾張 (從 0 起算)")}},
+	{Method: "GET", Path: "/api/tasks/{id}/comments", Tag: "comments", Summary: "任務的留言", Response: []Comment{}},
+	{Method: "POST", Path: "/api/tasks/{id}/comments", Tag: "comments", Summary: "新增留言，可框出圖片上的區域", Request: commentRequest{}, Response: Comment{}, Status: http.StatusCreated},
+	{Method: "PUT", Path: "/api/tasks/{id}/comments/{comment}", Tag: "comments", Summary: "修改自己的留言", Request: commentRequest{}, Response: Comment{}},
+	{Method: "DELETE", Path: "/api/tasks/{id}/comments/{comment}", Tag: "comments", Summary: "刪除留言 (留言者或任務擁有者)", Status: http.StatusNoContent},
+	{Method: "POST", Path: "/api/tasks/batch", Tag: "tasks", Summary: "批次建立任務", Request: batchRequest{}, Response: batchCreated{}, Status: http.StatusCreated,
+		Query: []apiParam{param("force", "boolean", "略過生成結果快取")}},
+	{Method: "POST", Path: "/api/tasks/img2img", Tag: "tasks", Summary: "以上傳的圖片建立 img2img / inpainting 任務", Request: apiForm{img2imgForm{}}, Response: Task{}, Status: http.StatusCreated},
+	{Method: "POST", Path: "/api/tasks/controlnet", Tag: "tasks", Summary: "以上傳的條件圖 (canny / depth / pose) 建立 ControlNet 任務", Request: apiForm{controlNetForm{}}, Response: Task{}, Status: http.StatusCreated},
+	{Method: "GET", Path: "/api/batches/{id}", Tag: "tasks", Summary: "批次進度", Response: batchStatus{}},
+	{Method: "POST", Path: "/api/compare", Tag: "tasks", Summary: "以相同 seed 比較兩個 prompt 或兩個模型", Request: compareRequest{}, Response: comparisonView{}, Status: http.StatusCreated,
+		Query: []apiParam{param("force", "boolean", "略過生成結果快取")}},
+	{Method: "GET", Path: "/api/compare/{id}", Tag: "tasks", Summary: "比較的兩個任務與差異", Response: comparisonView{}},
+	{Method: "POST", Path: "/v1/images/generations", Tag: "openai", Summary: "OpenAI Images API 相容：建立任務並等待完成 (錯誤為 OpenAI 格式)", Request: openAIImageRequest{}, Response: openAIImageResponse{}},
+	{Method: "POST", Path: "/sdapi/v1/txt2img", Tag: "a1111", Summary: "Automatic1111 相容：建立任務並等待完成，以 base64 回傳圖片", Request: a1111Txt2ImgRequest{}, Response: a1111Txt2ImgResponse{}},
+	{Method: "GET", Path: "/sdapi/v1/sd-models", Tag: "a1111", Summary: "Automatic1111 格式的模型清單", Response: []a1111Model{}},
+
+	{Method: "GET", Path: "/api/trash", Tag: "trash", Summary: "垃圾桶中的任務", Response: []Task{},
+		Query: []apiParam{param("limit", "integer", "筆數 (預設 50，最多 200)")}},
+	{Method: "DELETE", Path: "/api/trash", Tag: "trash", Summary: "清空垃圾桶", Response: purgeResult{}},
+	{Method: "DELETE", Path: "/api/trash/{id}", Tag: "trash", Summary: "永久刪除垃圾桶中的任務", Status: http.StatusNoContent},
+	{Method: "POST", Path: "/api/tasks/{id}/restore", Tag: "trash", Summary: "從垃圾桶還原", Response: Task{}},
+
+	{Method: "GET", Path: "/api/images", Tag: "images", Summary: "圖庫 (分頁)", Response: apiPage{Task{}},
+		Query: []apiParam{
+			param("status", "string", "任務狀態，預設 Completed，all 代表不篩選"),
+			param("q", "string", "prompt 包含的文字"),
+			param("from", "string", "建立時間下限 (RFC3339 或 2006-01-02)"),
+			param("to", "string", "建立時間上限"),
+			param("page", "integer", "頁碼，從 1 開始"),
+			param("page_size", "integer", "每頁筆數 (預設 50，最多 200)"),
+			param("tag", "string", "逗號分隔的標籤，需同時具有"),
+			param("collection", "integer", "收藏集 ID"),
+		}},
+	{Method: "GET", Path: "/api/images/{id}/file", Tag: "images", Summary: "讀取圖片檔", Content: "image/*",
+		Query: []apiParam{param("size", "string", "thumb、full (預設) 或 upscaled"), param("index", "integer", "多張輸出的第幾張 (從 0 起算)")}},
+	{Method: "POST", Path: "/api/images/archive", Tag: "images", Summary: "打包下載選取任務的圖片與中繼資料 (zip)", Request: archiveRequest{}, Content: "application/zip"},
+	{Method: "GET", Path: "/api/models", Tag: "images", Summary: "可用的模型", Response: []ModelInfo{}},
+	{Method: "GET", Path: "/api/loras", Tag: "images", Summary: "可用的 LoRA 與 embedding", Response: []LoRAInfo{}},
+	{Method: "GET", Path: "/api/loras/{name}/preview", Tag: "images", Summary: "LoRA 的預覽圖", Content: "image/*"},
+	{Method: "GET", Path: "/api/queue", Tag: "tasks", Summary: "佇列是否暫停", Response: QueueState{}},
+	{Method: "GET", Path: "/api/usage", Tag: "tasks", Summary: "自己每天的用量 (GPU 秒數、pixel-steps、處理時間)", Response: usageReport{},
+		Query: []apiParam{param("from", "string", "起始日期 (預設 30 天前)"), param("to", "string", "結束日期 (含，預設今天)")}},
+	{Method: "GET", Path: "/api/notifications", Tag: "tasks", Summary: "自己的 Discord / Slack 通知設定與伺服器提供的頻道", Response: notifySettingsView{}},
+	{Method: "PUT", Path: "/api/notifications", Tag: "tasks", Summary: "選擇要通知的頻道與事件", Request: NotifySetting{}, Response: notifySettingsView{}},
+
+	{Method: "GET", Path: "/api/export", Tag: "export", Summary: "匯出自己的任務與圖片 (zip)", Content: "application/zip",
+		Query: []apiParam{param("images", "boolean", "false 時只匯出任務紀錄")}},
+	{Method: "POST", Path: "/api/import", Tag: "export", Summary: "匯入 /api/export 產生的 zip", Request: apiForm{importForm{}}, Upload: []string{"application/zip"}, Response: importResult{}},
+
+	{Method: "GET", Path: "/api/prompts/recent", Tag: "prompts", Summary: "最近使用的 prompt", Response: []PromptHistory{},
+		Query: []apiParam{param("limit", "integer", "筆數 (預設 20，最多 100)"), param("q", "string", "prompt 前綴"), param("favorites", "boolean", "只列最愛")}},
+	{Method: "POST", Path: "/api/prompts/{id}/favorite", Tag: "prompts", Summary: "設定或切換最愛", Request: favoriteRequest{}, Response: PromptHistory{}},
+	{Method: "GET", Path: "/api/templates", Tag: "prompts", Summary: "Prompt 範本", Response: []PromptTemplate{}},
+	{Method: "POST", Path: "/api/templates", Tag: "prompts", Summary: "建立範本", Request: PromptTemplate{}, Response: PromptTemplate{}, Status: http.StatusCreated},
+	{Method: "GET", Path: "/api/templates/{id}", Tag: "prompts", Summary: "查詢範本", Response: PromptTemplate{}},
+	{Method: "PUT", Path: "/api/templates/{id}", Tag: "prompts", Summary: "修改範本", Request: PromptTemplate{}, Response: PromptTemplate{}},
+	{Method: "DELETE", Path: "/api/templates/{id}", Tag: "prompts", Summary: "刪除範本", Status: http.StatusNoContent},
+
+	{Method: "GET", Path: "/api/tags", Tag: "tags", Summary: "自己的標籤與使用中的任務數", Response: []Tag{}},
+	{Method: "POST", Path: "/api/tags", Tag: "tags", Summary: "建立標籤", Request: tagRequest{}, Response: Tag{}, Status: http.StatusCreated},
+	{Method: "PUT", Path: "/api/tags/{id}", Tag: "tags", Summary: "標籤改名", Request: tagRequest{}, Response: Tag{}},
+	{Method: "DELETE", Path: "/api/tags/{id}", Tag: "tags", Summary: "刪除標籤 (從所有任務移除)", Status: http.StatusNoContent},
+	{Method: "GET", Path: "/api/collections", Tag: "tags", Summary: "自己的收藏集", Response: []Collection{}},
+	{Method: "POST", Path: "/api/collections", Tag: "tags", Summary: "建立收藏集", Request: Collection{}, Response: Collection{}, Status: http.StatusCreated},
+	{Method: "GET", Path: "/api/collections/{id}", Tag: "tags", Summary: "查詢收藏集", Response: Collection{}},
+	{Method: "PUT", Path: "/api/collections/{id}", Tag: "tags", Summary: "修改收藏集", Request: Collection{}, Response: Collection{}},
+	{Method: "DELETE", Path: "/api/collections/{id}", Tag: "tags", Summary: "刪除收藏集 (任務不受影響)", Status: http.StatusNoContent},
+	{Method: "POST", Path: "/api/collections/{id}/tasks", Tag: "tags", Summary: "把任務加入收藏集", Request: collectionTasksRequest{}, Response: Collection{}},
+	{Method: "DELETE", Path: "/api/collections/{id}/tasks/{task_id}", Tag: "tags", Summary: "從收藏集移除任務", Status: http.StatusNoContent},
+
+	{Method: "GET", Path: "/api/workspaces", Tag: "workspaces", Summary: "自己所屬的工作區與角色", Response: []Workspace{}},
+	{Method: "POST", Path: "/api/workspaces", Tag: "workspaces", Summary: "建立工作區 (建立者為 owner)", Request: workspaceRequest{}, Response: Workspace{}, Status: http.StatusCreated},
+	{Method: "GET", Path: "/api/workspaces/{id}", Tag: "workspaces", Summary: "工作區的設定與成員", Response: Workspace{}},
+	{Method: "PUT", Path: "/api/workspaces/{id}", Tag: "workspaces", Summary: "修改工作區的名稱與設定 (owner)", Request: workspaceRequest{}, Response: Workspace{}},
+	{Method: "POST", Path: "/api/workspaces/{id}/members", Tag: "workspaces", Summary: "加入成員或修改角色 (owner)", Request: workspaceMemberRequest{}, Response: WorkspaceMember{}},
+	{Method: "DELETE", Path: "/api/workspaces/{id}/members/{user_id}", Tag: "workspaces", Summary: "移除成員 (owner)", Status: http.StatusNoContent},
+
+	{Method: "GET", Path: "/api/admin/stats", Tag: "admin", Summary: "佇列、worker、GPU 與生成時間統計", Auth: "admin", Response: map[string]interface{}{}},
+	{Method: "GET", Path: "/api/admin/usage", Tag: "admin", Summary: "各使用者的任務數", Auth: "admin", Response: []userUsage{},
+		Query: []apiParam{param("since", "string", "起始時間 (RFC3339)")}},
+	{Method: "GET", Path: "/api/admin/usage/daily", Tag: "admin", Summary: "各使用者每天的用量", Auth: "admin", Response: adminUsageReport{},
+		Query: []apiParam{param("from", "string", "起始日期 (預設 30 天前)"), param("to", "string", "結束日期 (含，預設今天)"), param("user_id", "integer", "只列出這個使用者")}},
+	{Method: "GET", Path: "/api/admin/clients", Tag: "admin", Summary: "目前的 WebSocket / SSE 連線", Auth: "admin", Response: []clientInfo{},
+		Query: []apiParam{param("user", "string", "只列出這個使用者")}},
+	{Method: "DELETE", Path: "/api/admin/clients/{client_id}", Tag: "admin", Summary: "中斷連線", Auth: "admin", Status: http.StatusNoContent,
+		Query: []apiParam{param("reason", "string", "告知 client 的原因")}},
+	{Method: "GET", Path: "/api/admin/audit", Tag: "admin", Summary: "稽核紀錄 (分頁)", Auth: "admin", Response: apiPage{AuditEvent{}},
+		Query: []apiParam{
+			param("action", "string", "動作，結尾為 . 時比對前綴"),
+			param("actor", "string", "執行者"),
+			param("user_id", "integer", "使用者 ID"),
+			param("ip", "string", "來源 IP"),
+			param("target_type", "string", "對象類型"),
+			param("target_id", "string", "對象 ID"),
+			param("from", "string", "時間下限"),
+			param("to", "string", "時間上限"),
+			param("page", "integer", "頁碼"),
+			param("page_size", "integer", "每頁筆數 (預設 100，最多 1000)"),
+		}},
+	{Method: "POST", Path: "/api/admin/tasks/recover", Tag: "admin", Summary: "把卡住的 Processing 任務放回佇列", Auth: "admin", Response: recoverResult{},
+		Query: []apiParam{param("older_than", "string", "最後更新超過此時間 (預設 1m)")}},
+	{Method: "POST", Path: "/api/admin/tasks/{id}/fail", Tag: "admin", Summary: "強制結束任務", Auth: "admin", Request: reasonRequest{}, Response: Task{}},
+	{Method: "POST", Path: "/api/admin/queue/pause", Tag: "admin", Summary: "暫停認領新任務", Auth: "admin", Request: reasonRequest{}, Response: QueueState{}},
+	{Method: "POST", Path: "/api/admin/queue/resume", Tag: "admin", Summary: "恢復認領新任務", Auth: "admin", Response: QueueState{}},
+	{Method: "GET", Path: "/api/admin/approvals", Tag: "admin", Summary: "等待審核的任務 (APPROVAL_MODE)", Auth: "admin", Response: []Task{},
+		Query: []apiParam{param("limit", "integer", "筆數 (預設 50，最多 200)")}},
+	{Method: "POST", Path: "/api/admin/tasks/{id}/approve", Tag: "admin", Summary: "核准任務，改為 Pending", Auth: "admin", Response: Task{}},
+	{Method: "POST", Path: "/api/admin/tasks/{id}/reject", Tag: "admin", Summary: "拒絕任務，標記為 Failed", Auth: "admin", Request: reasonRequest{}, Response: Task{}},
+	{Method: "GET", Path: "/api/admin/quarantine", Tag: "admin", Summary: "被內容安全檢查隔離的任務", Auth: "admin", Response: []Task{},
+		Query: []apiParam{param("limit", "integer", "筆數 (預設 50，最多 200)")}},
+	{Method: "GET", Path: "/api/admin/quarantine/{id}/file", Tag: "admin", Summary: "讀取隔離的圖片", Auth: "admin", Content: "image/*",
+		Query: []apiParam{param("size", "string", "thumb、full (預設) 或 upscaled"), param("index", "integer", "多張輸出的第幾張 (從 0 起算)")}},
+	{Method: "POST", Path: "/api/images/archive", Tag: "images", Summary: "打包下載選取任務的圖片與中繼資料 (zip)", Request: archiveRequest{}, Content: "application/zip"},
+	{Method: "POST", Path: "/api/admin/quarantine/{id}/release", Tag: "admin", Summary: "放行隔離的圖片", Auth: "admin", Response: Task{}},
+	{Method: "DELETE", Path: "/api/admin/quarantine/{id}", Tag: "admin", Summary: "永久刪除隔離的任務", Auth: "admin", Status: http.StatusNoContent},
+	{Method: "GET", Path: "/api/admin/moderation", Tag: "admin", Summary: "目前的禁用詞設定", Auth: "admin", Response: map[string]interface{}{}},
+	{Method: "GET", Path: "/api/admin/moderation/log", Tag: "admin", Summary: "被拒絕或標記的 prompt", Auth: "admin", Response: []ModerationLog{},
+		Query: []apiParam{param("action", "string", "rejected 或 flagged"), param("user_id", "integer", "使用者 ID"), param("limit", "integer", "筆數 (預設 100，最多 1000)")}},
+	{Method: "POST", Path: "/api/admin/moderation/reload", Tag: "admin", Summary: "重新載入禁用詞檔案", Auth: "admin", Response: map[string]interface{}{}},
+	{Method: "POST", Path: "/api/admin/config/reload", Tag: "admin", Summary: "重新載入設定 (與 SIGHUP 相同)", Auth: "admin", Response: reloadResult{}},
+	{Method: "GET", Path: "/api/admin/workspaces", Tag: "admin", Summary: "所有工作區與成員", Auth: "admin", Response: []Workspace{}},
+	{Method: "GET", Path: "/api/admin/users", Tag: "admin", Summary: "所有使用者與角色", Auth: "admin", Response: []User{}},
+	{Method: "PUT", Path: "/api/admin/users/{id}", Tag: "admin", Summary: "修改使用者的角色 (viewer、creator、admin)", Auth: "admin", Request: userRoleRequest{}, Response: User{}},
+}
+
+// --- schema 產生 ---
+
+type openAPIBuilder struct {
+	schemas map[string]interface{}
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+	binaryType    = reflect.TypeOf(apiBinary{})
+	pageType      = reflect.TypeOf(apiPage{})
+)
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// 元件名稱：型別名稱首字大寫 (createTaskRequest -> CreateTaskRequest)
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func (b *openAPIBuilder) schemaOf(v interface{}) map[string]interface{} {
+	if page, ok := v.(apiPage); ok {
+		return map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"items":     map[string]interface{}{"type": "array", "items": b.schema(reflect.TypeOf(page.Item))},
+				"page":      map[string]interface{}{"type": "integer"},
+				"page_size": map[string]interface{}{"type": "integer"},
+				"total":     map[string]interface{}{"type": "integer"},
+			},
+		}
+	}
+	return b.schema(reflect.TypeOf(v))
+}
+
+func (b *openAPIBuilder) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case deletedAtType:
+		return map[string]interface{}{"type": "string", "format": "date-time", "nullable": true}
+	case binaryType:
+		return map[string]interface{}{"type": "string", "format": "binary"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := b.schema(t.Elem())
+		if _, isRef := s["$ref"]; isRef {
+			// 3.0 的 $ref 旁邊不能加其他屬性
+			return map[string]interface{}{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name := schemaName(t)
+		if _, ok := b.schemas[name]; !ok {
+			b.schemas[name] = nil // 先佔位，避免遞迴型別無限展開
+			b.schemas[name] = b.structSchema(t)
+		}
+		return schemaRef(name)
+	}
+	return map[string]interface{}{}
+}
+
+// 依 encoding/json 的規則列出欄位：嵌入的 struct 攤平，json:"-" 省略
+func (b *openAPIBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	b.collectFields(t, properties, &required)
+	s := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		s["required"] = required
+	}
+	return s
+}
+
+func (b *openAPIBuilder) collectFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			b.collectFields(f.Type, properties, required)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = b.schema(f.Type)
+		// 回應中一定會出現的欄位；request 也用同一個 schema，omitempty 的欄位是選填
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// --- 文件 ---
+
+var pathParamPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+func (b *openAPIBuilder) operation(op apiOperation) map[string]interface{} {
+	var params []interface{}
+	for _, m := range pathParamPattern.FindAllStringSubmatch(op.Path, -1) {
+		schema := map[string]interface{}{"type": "integer", "minimum": 1}
+		if m[1] == "client_id" || m[1] == "token" || m[1] == "provider" {
+			// 連線的 client_id、分享連結的 token 與登入服務名稱是字串
+			schema = map[string]interface{}{"type": "string"}
+		}
+		params = append(params, map[string]interface{}{"name": m[1], "in": "path", "required": true, "schema": schema})
+	}
+	for _, p := range op.Query {
+		params = append(params, map[string]interface{}{"name": p.Name, "in": "query", "description": p.Description, "schema": map[string]interface{}{"type": p.Type}})
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	switch {
+	case op.Content != "":
+		success["content"] = map[string]interface{}{op.Content: map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}}}
+	case op.Response != nil:
+		success["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": b.schemaOf(op.Response)}}
+	}
+	result := map[string]interface{}{
+		"tags":        []string{op.Tag},
+		"summary":     op.Summary,
+		"operationId": operationID(op),
+		"responses": map[string]interface{}{
+			strconv.Itoa(status): success,
+			"default":            map[string]interface{}{"description": "錯誤", "content": map[string]interface{}{"application/json": map[string]interface{}{"schema": schemaRef("ErrorResponse")}}},
+		},
+	}
+	if len(params) > 0 {
+		result["parameters"] = params
+	}
+	switch op.Auth {
+	case "none":
+		result["security"] = []interface{}{}
+	case "admin":
+		result["security"] = []interface{}{map[string]interface{}{"adminToken": []string{}}}
+	}
+
+	if op.Request != nil {
+		content := map[string]interface{}{}
+		if form, ok := op.Request.(apiForm); ok {
+			content["multipart/form-data"] = map[string]interface{}{"schema": b.structSchema(reflect.TypeOf(form.Fields))}
+		} else {
+			content["application/json"] = map[string]interface{}{"schema": b.schemaOf(op.Request)}
+		}
+		for _, ct := range op.Upload {
+			content[ct] = map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}}
+		}
+		result["requestBody"] = map[string]interface{}{"content": content}
+	}
+	return result
+}
+
+// 例如 GET /api/tasks/{id}/cancel -> getTasksIdCancel
+func operationID(op apiOperation) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(op.Method))
+	for _, part := range strings.FieldsFunc(strings.TrimPrefix(op.Path, "/api"), func(r rune) bool {
+		return r == '/' || r == '{' || r == '}' || r == '_'
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// 產生完整的 OpenAPI 文件；publicURL 不為空時列在 servers
+func buildOpenAPI(publicURL string) map[string]interface{} {
+	b := &openAPIBuilder{schemas: map[string]interface{}{}}
+	b.schemas["ErrorResponse"] = b.structSchema(reflect.TypeOf(apiError{}))
+
+	paths := map[string]interface{}{}
+	for _, op := range apiOperations {
+		item, _ := paths[op.Path].(map[string]interface{})
+		if item == nil {
+			item = map[string]interface{}{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = b.operation(op)
+	}
+
+	doc := map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":       "mcpzimage",
+			"version":     "1",
+			"description": "Z-Image 生成佇列的 REST API。即時狀態另外透過 WebSocket (GET /ws) 或 Server-Sent Events (GET /api/events) 推播。",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey":     map[string]interface{}{"type": "http", "scheme": "bearer", "description": "API key (也可以用 X-API-Key header 或 ?token=)"},
+				"adminToken": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "ADMIN_TOKEN 或 admin 角色使用者的 API key"},
+				"session":    map[string]interface{}{"type": "apiKey", "in": "cookie", "name": sessionCookieName, "description": "瀏覽器登入 (/auth/{provider}/login) 後的 session"},
+			},
+		},
+		"security": []interface{}{map[string]interface{}{"apiKey": []string{}}, map[string]interface{}{"session": []string{}}},
+	}
+	if publicURL != "" {
+		doc["servers"] = []interface{}{map[string]interface{}{"url": publicURL}}
+	}
+	return doc
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  map[string]interface{}
+)
+
+// GET /api/openapi.json (不需驗證)
+func (a *App) serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		openAPIDoc = buildOpenAPI(a.Config.PublicURL)
+	})
+	doc := openAPIDoc
+	if a.Config.PublicURL == "" {
+		// 沒有 PUBLIC_URL 時以請求的對外網址 (反向代理的 X-Forwarded-*) 列在 servers
+		if base := externalURLFrom(r.Context()); base != "" {
+			doc = maps.Clone(openAPIDoc)
+			doc["servers"] = []interface{}{map[string]interface{}{"url": base}}
+		}
+	}
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, http.StatusOK, doc)
+}
@@ -0,0 +1,122 @@
+// params.go
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// 生成參數，嵌入在 Task 與各種請求格式中；零值代表使用 Z-Image 的預設值
+type GenerationParams struct {
+	NegativePrompt string  `json:"negative_prompt"`
+	Width          int     `json:"width"`
+	Height         int     `json:"height"`
+	Steps          int     `json:"steps"`
+	GuidanceScale  float64 `json:"guidance_scale"`
+	Seed           *int64  `json:"seed,omitempty"`                                      // nil 代表隨機
+	Strength       float64 `json:"strength,omitempty"`                                  // img2img 的變化程度 (0~1)，越大越不像原圖
+	ControlType    string  `json:"control_type,omitempty"`                              // ControlNet 條件類型：canny、depth、pose，需搭配條件圖，見 controlnet.go
+	ControlScale   float64 `json:"control_scale,omitempty"`                             // ControlNet 的強度 (0~2)，0 代表後端預設
+	Upscale        int     `json:"upscale,omitempty"`                                   // 生成後放大倍數：0 (不放大)、2、4
+	Model          string  `json:"model,omitempty"`                                     // GET /api/models 列出的名稱，空字串代表腳本預設模型
+	LoRAs          []LoRA  `json:"loras,omitempty" gorm:"column:loras;serializer:json"` // GET /api/loras 列出的 LoRA / embedding 與權重，見 lora.go
+	NumImages      int     `json:"num_images,omitempty"`                                // 一次生成的張數，0 視為 1，見 taskimages.go
+	Format         string  `json:"format,omitempty"`                                    // 輸出格式：png (預設)、jpeg、webp，見 imageformat.go
+	Quality        int     `json:"quality,omitempty"`                                   // jpeg 的品質 (1-100)，0 代表預設 90
+	Watermark      *bool   `json:"watermark,omitempty"`                                 // 加上伺服器設定的浮水印，nil 代表伺服器預設，見 watermark.go
+	StripMetadata  *bool   `json:"strip_metadata,omitempty"`                            // 移除圖片中的中繼資料，nil 代表伺服器預設 (STRIP_METADATA)
+}
+
+// 參數錯誤，REST 回 400；Field 為有問題的欄位 (放在錯誤回應的 details)
+type ValidationError struct {
+	Message string
+	Field   string
+	message message // 依語系翻譯的訊息，見 i18n.go
+}
+
+func (e *ValidationError) Error() string { return e.Message }
+
+func (e *ValidationError) localMessage() message {
+	if e.message.Format == "" {
+		return message{Format: e.Message}
+	}
+	return e.message
+}
+
+func invalidf(format string, args ...interface{}) error {
+	return &ValidationError{Message: fmt.Sprintf(format, args...), message: newMessage(format, args...)}
+}
+
+// 指定欄位的參數錯誤
+func invalidField(field, format string, args ...interface{}) error {
+	return &ValidationError{Message: fmt.Sprintf(format, args...), Field: field, message: newMessage(format, args...)}
+}
+
+// 檢查參數範圍，避免把明顯錯誤的值丟給 GPU
+func (p GenerationParams) Validate() error {
+	for _, v := range []struct {
+		name  string
+		value int
+	}{{"width", p.Width}, {"height", p.Height}} {
+		if v.value == 0 {
+			continue
+		}
+		if v.value < 64 || v.value > 2048 || v.value%8 != 0 {
+			return invalidField(v.name, "%s must be a multiple of 8 between 64 and 2048", v.name)
+		}
+	}
+	if p.Steps < 0 || p.Steps > 150 {
+		return invalidField("steps", "steps must be between 1 and 150")
+	}
+	if p.GuidanceScale < 0 || p.GuidanceScale > 30 {
+		return invalidField("guidance_scale", "guidance_scale must be between 0 and 30")
+	}
+	if p.Seed != nil && *p.Seed < 0 {
+		return invalidField("seed", "seed must not be negative")
+	}
+	if p.Strength < 0 || p.Strength > 1 {
+		return invalidField("strength", "strength must be between 0 and 1")
+	}
+	if p.Upscale != 0 && p.Upscale != 2 && p.Upscale != 4 {
+		return invalidField("upscale", "upscale must be 2 or 4")
+	}
+	if p.NumImages < 0 || p.NumImages > maxNumImages {
+		return invalidField("num_images", "num_images must be between 1 and %d", maxNumImages)
+	}
+	if err := p.validateControlNet(); err != nil {
+		return err
+	}
+	return p.validateFormat()
+}
+
+// 轉成 run_z_image.py 的 CLI 參數，只帶有設定的欄位
+// (Upscale 由 Upscaler 另外處理，Model 與 LoRAs 由 worker 換成實際路徑，Format、Watermark、StripMetadata 由 worker 在生成後處理)
+func (p GenerationParams) Args() []string {
+	var args []string
+	if p.NegativePrompt != "" {
+		args = append(args, "--negative-prompt", p.NegativePrompt)
+	}
+	if p.Width > 0 {
+		args = append(args, "--width", strconv.Itoa(p.Width))
+	}
+	if p.Height > 0 {
+		args = append(args, "--height", strconv.Itoa(p.Height))
+	}
+	if p.Steps > 0 {
+		args = append(args, "--steps", strconv.Itoa(p.Steps))
+	}
+	if p.GuidanceScale > 0 {
+		args = append(args, "--guidance-scale", strconv.FormatFloat(p.GuidanceScale, 'f', -1, 64))
+	}
+	if p.Seed != nil {
+		args = append(args, "--seed", strconv.FormatInt(*p.Seed, 10))
+	}
+	if p.Strength > 0 {
+		args = append(args, "--strength", strconv.FormatFloat(p.Strength, 'f', -1, 64))
+	}
+	if p.NumImages > 1 {
+		args = append(args, "--num-images", strconv.Itoa(p.NumImages))
+	}
+	args = append(args, p.controlNetArgs()...)
+	return args
+}
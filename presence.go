@@ -0,0 +1,156 @@
+// presence.go
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// --- 連線清單與 presence ---
+// 每個 WebSocket / SSE 連線都有 client_id (hello 訊息會告知)，連線時記錄使用者、來源 IP 與 User-Agent。
+// GET /api/admin/clients 列出目前的連線，DELETE /api/admin/clients/{client_id} 中斷某個連線。
+// PRESENCE_BROADCAST=true 時，連線加入或離開都會送 presence 訊息給所有連線
+
+// 連線的資訊，列在 GET /api/admin/clients
+type clientInfo struct {
+	ID            string    `json:"id"`
+	Kind          string    `json:"kind"` // ws、sse 或 grpc
+	User          string    `json:"user,omitempty"`
+	UserID        uint      `json:"user_id,omitempty"`
+	RemoteAddr    string    `json:"remote_addr,omitempty"`
+	UserAgent     string    `json:"user_agent,omitempty"`
+	Subscriptions int       `json:"subscriptions"` // 個別訂閱的任務數
+	All           bool      `json:"all"`           // 訂閱自己的所有任務
+	ConnectedAt   time.Time `json:"connected_at"`
+	LastActiveAt  time.Time `json:"last_active_at"` // 最後一次收到 client 訊息的時間
+}
+
+// presence 訊息：{"type":"presence","event":"join","client_id":"...","user":"alice","online":3,"users":["alice","bob"]}
+type WSPresence struct {
+	Type     string   `json:"type"`
+	Event    string   `json:"event"` // join 或 leave
+	ClientID string   `json:"client_id"`
+	User     string   `json:"user,omitempty"`
+	Online   int      `json:"online"` // 目前的連線數
+	Users    []string `json:"users"`  // 目前在線的使用者 (不重複)
+}
+
+// 記錄連線來源，在 register 之前呼叫
+func (c *wsClient) describe(r *http.Request, kind string) {
+	c.kind = kind
+	c.baseURL = externalURLFrom(r.Context())
+	c.locale = localeFrom(r.Context())
+	c.remoteAddr, _ = r.Context().Value(clientIPKey{}).(string)
+	c.userAgent = truncateHead(r.UserAgent(), 200)
+	c.connectedAt = time.Now()
+	c.touch()
+}
+
+// 收到 client 訊息時更新最後活動時間
+func (c *wsClient) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+// 呼叫者需持有 hub.mutex (訂閱清單)
+func (c *wsClient) info() clientInfo {
+	return clientInfo{
+		ID:            c.id,
+		Kind:          c.kind,
+		User:          c.identity.keyName(),
+		UserID:        c.identity.userID(),
+		RemoteAddr:    c.remoteAddr,
+		UserAgent:     c.userAgent,
+		Subscriptions: len(c.subs),
+		All:           c.all,
+		ConnectedAt:   c.connectedAt,
+		LastActiveAt:  time.Unix(0, c.lastActive.Load()),
+	}
+}
+
+// 目前的所有連線，依連線時間排序
+func (h *Hub) clientList() []clientInfo {
+	h.mutex.Lock()
+	list := make([]clientInfo, 0, len(h.clients))
+	for client := range h.clients {
+		list = append(list, client.info())
+	}
+	h.mutex.Unlock()
+	slices.SortFunc(list, func(a, b clientInfo) int { return a.ConnectedAt.Compare(b.ConnectedAt) })
+	return list
+}
+
+// 中斷指定的連線，找不到時回傳 false
+func (h *Hub) kick(id, reason string) (clientInfo, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for client := range h.clients {
+		if client.id != id {
+			continue
+		}
+		info := client.info()
+		if client.conn != nil {
+			// WriteControl 可以和 writePump 同時呼叫
+			msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+			client.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		}
+		client.close()
+		delete(h.clients, client)
+		return info, true
+	}
+	return clientInfo{}, false
+}
+
+// 連線加入或離開後由 run 呼叫；PRESENCE_BROADCAST 沒有開啟時不做任何事
+func (h *Hub) notifyPresence(event string, client *wsClient) {
+	if !h.presence {
+		return
+	}
+	h.mutex.Lock()
+	online := len(h.clients)
+	users := []string{}
+	for c := range h.clients {
+		if name := c.identity.keyName(); name != "" && !slices.Contains(users, name) {
+			users = append(users, name)
+		}
+	}
+	h.mutex.Unlock()
+	slices.Sort(users)
+	jsonResp, _ := json.Marshal(WSPresence{Type: "presence", Event: event, ClientID: client.id, User: client.identity.keyName(), Online: online, Users: users})
+	h.publish(wsEvent{Global: true, Payload: jsonResp})
+}
+
+// GET /api/admin/clients?user=alice
+func (a *App) apiAdminClients(w http.ResponseWriter, r *http.Request) {
+	list := a.Hub.clientList()
+	if user := r.URL.Query().Get("user"); user != "" {
+		list = slices.DeleteFunc(list, func(c clientInfo) bool { return c.User != user })
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// DELETE /api/admin/clients/{client_id}?reason=...：中斷連線 (WebSocket 以 1008 關閉)，client 仍可以重新連線
+func (a *App) apiAdminKickClient(w http.ResponseWriter, r *http.Request) {
+	reason := strings.TrimSpace(r.URL.Query().Get("reason"))
+	if reason == "" {
+		reason = "disconnected by administrator"
+	}
+	// close frame 的原因最多 123 bytes
+	closeReason := reason
+	if len(closeReason) > 120 {
+		closeReason = strings.ToValidUTF8(closeReason[:120], "")
+	}
+	info, ok := a.Hub.kick(r.PathValue("client_id"), closeReason)
+	if !ok {
+		writeError(w, http.StatusNotFound, "client not found")
+		return
+	}
+	slog.Warn("Admin disconnected client", "client_id", info.ID, "user", info.User, "remote_addr", info.RemoteAddr, "reason", reason)
+	a.audit(r.Context(), "client.kicked", "client", info.ID, strings.TrimSpace(info.User+" "+reason))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+// proc_unix.go
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// python 可能再產生子程序 (例如 DataLoader worker)，放在獨立的 process group，
+// 取消或逾時時整組一起 kill，避免留下佔用 GPU 的孤兒程序
+func configureProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+// proc_windows.go
+package main
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// Windows 沒有 process group 的 kill，改用 taskkill /T 結束整個程序樹
+func configureProcessGroup(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+	}
+}
@@ -0,0 +1,126 @@
+// progress.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"regexp"
+	"strconv"
+)
+
+// run_z_image.py 可輸出 JSON 進度行：{"progress": 42} 或 {"step": 3, "total": 30}；
+// 也可以輸出 {"seed": 12345} 回報實際使用的 seed (隨機 seed 時)。
+// 多張輸出時每寫完一張輸出 {"image": 1, "seed": 12346} (image 從 0 起算)
+type progressLine struct {
+	Progress *float64 `json:"progress"`
+	Step     int      `json:"step"`
+	Total    int      `json:"total"`
+	Seed     *int64   `json:"seed"`
+	Image    *int     `json:"image"`
+}
+
+// diffusers 預設使用 tqdm 進度條，例如 " 40%|████      | 12/30 [00:03<00:05]"
+var tqdmPattern = regexp.MustCompile(`(\d{1,3})%\|`)
+
+// 以 \n 或 \r 切行 (tqdm 用 \r 覆寫同一行)
+func scanLinesOrCR(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// 解析單行輸出的進度百分比，沒有進度資訊時回傳 -1
+func parseProgress(line []byte) int {
+	line = bytes.TrimSpace(line)
+	if len(line) > 0 && line[0] == '{' {
+		var p progressLine
+		if err := json.Unmarshal(line, &p); err == nil {
+			if p.Progress != nil {
+				return clampPercent(int(*p.Progress))
+			}
+			if p.Total > 0 {
+				return clampPercent(p.Step * 100 / p.Total)
+			}
+		}
+		return -1
+	}
+	if m := tqdmPattern.FindSubmatch(line); m != nil {
+		n, _ := strconv.Atoi(string(m[1]))
+		return clampPercent(n)
+	}
+	return -1
+}
+
+func clampPercent(n int) int {
+	if n < 0 {
+		return 0
+	}
+	if n > 100 {
+		return 100
+	}
+	return n
+}
+
+// 解析單行輸出的圖片完成通知，不是時回傳 false
+func parseImageReady(line []byte) (int, *int64, bool) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] != '{' || !bytes.Contains(line, []byte(`"image"`)) {
+		return 0, nil, false
+	}
+	var p progressLine
+	if json.Unmarshal(line, &p) != nil || p.Image == nil {
+		return 0, nil, false
+	}
+	return *p.Image, p.Seed, true
+}
+
+// 逐行讀取輸出，保留完整 log 並在百分比改變與每張圖片完成時回報；每行即時交給 req.Log，也以 debug 等級寫入 logger
+func scanProgress(r io.Reader, output *bytes.Buffer, req GenerateRequest, logger *slog.Logger) {
+	last := -1
+	scanner := bufio.NewScanner(r)
+	scanner.Split(scanLinesOrCR)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		output.Write(line)
+		output.WriteByte('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			logger.Debug("python output", "line", string(line))
+		}
+		req.reportLog(line)
+		if p := parseProgress(line); p >= 0 && p != last {
+			last = p
+			req.reportProgress(p)
+		}
+		if index, seed, ok := parseImageReady(line); ok {
+			req.reportImage(index, seed)
+		}
+	}
+	// 讀完剩下的資料，避免 python 因為 pipe 滿了而卡住
+	io.Copy(io.Discard, r)
+}
+
+// 從輸出中找出回報的 seed (最後一筆，不含第 1 張以後的圖片)，沒有回報時回傳 nil
+func parseSeed(output []byte) *int64 {
+	var seed *int64
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] != '{' || !bytes.Contains(line, []byte(`"seed"`)) {
+			continue
+		}
+		var p progressLine
+		if json.Unmarshal(line, &p) == nil && p.Seed != nil && (p.Image == nil || *p.Image == 0) {
+			seed = p.Seed
+		}
+	}
+	return seed
+}
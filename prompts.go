@@ -0,0 +1,142 @@
+// prompts.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// --- Prompt 歷史與最愛 ---
+
+// 每位使用者用過的不重複 prompt，供前端自動完成與一鍵重跑；
+// prompt 可能很長，唯一索引建在雜湊上
+type PromptHistory struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	UserID     uint      `gorm:"uniqueIndex:idx_prompt_user_hash;index:idx_prompt_user_used,priority:1" json:"user_id"`
+	PromptHash string    `gorm:"size:64;uniqueIndex:idx_prompt_user_hash" json:"-"`
+	Prompt     string    `json:"prompt"`
+	UseCount   int       `json:"use_count"`
+	Favorite   bool      `json:"favorite"`
+	LastUsedAt time.Time `gorm:"index:idx_prompt_user_used,priority:2" json:"last_used_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func promptHash(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+// 建立任務後記錄 prompt，已存在時累加使用次數；失敗只記 log，不影響任務
+func (a *App) recordPrompts(tasks []Task) error {
+	counts := make(map[uint]map[string]int)
+	for _, task := range tasks {
+		if counts[task.UserID] == nil {
+			counts[task.UserID] = make(map[string]int)
+		}
+		// 擴寫過的任務記錄使用者原本輸入的 prompt
+		prompt := task.Prompt
+		if task.OriginalPrompt != "" {
+			prompt = task.OriginalPrompt
+		}
+		counts[task.UserID][prompt]++
+	}
+	now := time.Now()
+	for userID, prompts := range counts {
+		for prompt, n := range prompts {
+			entry := PromptHistory{UserID: userID, PromptHash: promptHash(prompt), Prompt: prompt, UseCount: n, LastUsedAt: now}
+			err := a.DB.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "user_id"}, {Name: "prompt_hash"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"use_count":    gorm.Expr("prompt_histories.use_count + ?", n),
+					"last_used_at": now,
+				}),
+			}).Create(&entry).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 查詢條件：query 為前綴 (自動完成)，favorites 只列最愛
+func (a *App) recentPrompts(identity *Identity, query string, favorites bool, limit int) ([]PromptHistory, error) {
+	q := identity.scope(a.DB).Model(&PromptHistory{})
+	if query = strings.TrimSpace(query); query != "" {
+		// 跳脫 LIKE 的萬用字元 (用 ! 當跳脫字元，各資料庫的寫法都相同)
+		escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(query)
+		q = q.Where("prompt LIKE ? ESCAPE '!'", escaped+"%")
+	}
+	if favorites {
+		q = q.Where("favorite = ?", true)
+	}
+	prompts := []PromptHistory{}
+	err := q.Order("favorite desc, last_used_at desc").Limit(limit).Find(&prompts).Error
+	return prompts, err
+}
+
+// GET /api/prompts/recent?limit=20&q=<前綴>&favorites=true
+func (a *App) apiRecentPrompts(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(n, 100)
+	}
+	prompts, err := a.recentPrompts(identityFromRequest(r), r.URL.Query().Get("q"), r.URL.Query().Get("favorites") == "true", limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, prompts)
+}
+
+// POST /api/prompts/{id}/favorite
+// 沒有 body 時切換最愛狀態，也可用 {"favorite": true|false} 直接指定
+func (a *App) apiFavoritePrompt(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid prompt id")
+		return
+	}
+	var entry PromptHistory
+	if err := identityFromRequest(r).scope(a.DB).First(&entry, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, "prompt not found")
+		} else {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	favorite := !entry.Favorite
+	if r.ContentLength != 0 {
+		var req struct {
+			Favorite *bool `json:"favorite"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		if req.Favorite != nil {
+			favorite = *req.Favorite
+		}
+	}
+	if err := a.DB.Model(&entry).Update("favorite", favorite).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	entry.Favorite = favorite
+	writeJSON(w, http.StatusOK, entry)
+}
@@ -0,0 +1,135 @@
+// promptsyntax.go
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// --- Prompt 權重語法檢查 ---
+// 建立任務前檢查 Automatic1111 的權重語法，括號不成對或權重錯誤時直接回 400，不浪費一次生成：
+//
+//	(word)      權重 ×1.1，可以巢狀 ((word))
+//	(word:1.2)  指定權重，必須是 0 到 maxPromptWeight 之間的數字
+//	[word]      權重 ÷1.1 (也用於 [from:to:when] 與 [a|b]，只檢查括號是否成對)
+//	\( \) \[ \] 一般的括號字元
+//
+// 冒號後面不是數字的 (例如 "(style: anime)") 視為一般文字。PROMPT_SYNTAX_CHECK=false 時不檢查
+
+const maxPromptWeight = 3.0
+
+// 權重語法錯誤：Position 為從 0 起算的字元 (rune) 位置，放在錯誤回應的 details
+type PromptSyntaxError struct {
+	Field    string // prompt 或 negative_prompt
+	Reason   string // unbalanced_bracket、mismatched_bracket、invalid_weight
+	Position int
+	Message  string
+	message  message
+}
+
+func (e *PromptSyntaxError) Error() string { return e.Message }
+
+func (e *PromptSyntaxError) localMessage() message { return e.message }
+
+// 與其他參數錯誤相同 (invalid_request)
+func (e *PromptSyntaxError) Unwrap() error {
+	return &ValidationError{Message: e.Message, Field: e.Field}
+}
+
+// 從環境變數 PROMPT_SYNTAX_CHECK 取得，預設開啟
+func promptSyntaxCheck() bool {
+	return os.Getenv("PROMPT_SYNTAX_CHECK") != "false"
+}
+
+// 還沒關閉的括號
+type promptBracket struct {
+	char     rune
+	position int
+	start    int // 括號內文字的開頭 (runes 的索引)
+}
+
+// 檢查一段 prompt 的權重語法，field 用於錯誤訊息
+func checkPromptSyntax(field, prompt string) error {
+	runes := []rune(prompt)
+	var stack []promptBracket
+	fail := func(reason string, position int, format string, args ...interface{}) error {
+		m := newMessage("%s: %s at position %d", field, newMessage(format, args...), position)
+		return &PromptSyntaxError{Field: field, Reason: reason, Position: position, Message: m.String(), message: m}
+	}
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '\\':
+			// 跳過跳脫的字元
+			i++
+		case '(', '[':
+			stack = append(stack, promptBracket{char: c, position: i, start: i + 1})
+		case ')', ']':
+			open := '('
+			if c == ']' {
+				open = '['
+			}
+			if len(stack) == 0 {
+				return fail("unbalanced_bracket", i, "unexpected %q", c)
+			}
+			top := stack[len(stack)-1]
+			if top.char != open {
+				return fail("mismatched_bracket", i, "%q does not match %q (opened at position %d)", c, top.char, top.position)
+			}
+			stack = stack[:len(stack)-1]
+			if c == ')' {
+				if err := checkPromptWeight(runes[top.start:i], top.start, fail); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if len(stack) > 0 {
+		top := stack[len(stack)-1]
+		return fail("unbalanced_bracket", top.position, "unclosed %q", top.char)
+	}
+	return nil
+}
+
+// (text:weight) 的權重：最後一個冒號後面只有數字相關的字元時視為權重，必須是合法的數字
+func checkPromptWeight(inner []rune, offset int, fail func(string, int, string, ...interface{}) error) error {
+	colon := -1
+	for i := len(inner) - 1; i >= 0; i-- {
+		if inner[i] == ':' && (i == 0 || inner[i-1] != '\\') {
+			colon = i
+			break
+		}
+		if inner[i] == '(' || inner[i] == ')' || inner[i] == '[' || inner[i] == ']' {
+			// 冒號屬於巢狀的括號
+			return nil
+		}
+	}
+	if colon < 0 {
+		return nil
+	}
+	s := strings.TrimSpace(string(inner[colon+1:]))
+	if strings.Trim(s, "0123456789.+-") != "" {
+		// 一般文字，例如 (style: anime)
+		return nil
+	}
+	position := offset + colon
+	w, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fail("invalid_weight", position, "invalid weight %q", s)
+	}
+	if w < 0 || w > maxPromptWeight {
+		return fail("invalid_weight", position, "weight %s must be between 0 and %g", s, maxPromptWeight)
+	}
+	return nil
+}
+
+// 建立任務前檢查 prompt 與 negative prompt
+func (a *App) checkPromptsSyntax(task *Task) error {
+	if !a.Config.PromptSyntaxCheck {
+		return nil
+	}
+	if err := checkPromptSyntax("prompt", task.Prompt); err != nil {
+		return err
+	}
+	return checkPromptSyntax("negative_prompt", task.NegativePrompt)
+}
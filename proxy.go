@@ -0,0 +1,103 @@
+// proxy.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// --- 反向代理 ---
+// BASE_PATH=/zimage 時所有路由 (含靜態檔與 /ws) 都在 /zimage/ 之下；代理伺服器是否先去掉前綴都可以，
+// 沒有前綴的請求照常處理。前端以相對路徑存取 API，/zimage 會導向 /zimage/。
+// 需要完整網址 (webhook 的 image_url、WebSocket hello 的 base_url、OpenAPI 的 servers) 時，
+// 以 PUBLIC_URL 為準；沒有設定時由請求組出，TRUST_PROXY=true 時採用 X-Forwarded-Proto / X-Forwarded-Host
+
+type externalURLKey struct{}
+
+// 整理 BASE_PATH：開頭加上 /、去掉結尾的 /，"/" 視為沒有設定
+func normalizeBasePath(s string) string {
+	s = strings.Trim(strings.TrimSpace(s), "/")
+	if s == "" {
+		return ""
+	}
+	return "/" + s
+}
+
+func basePathProblems(basePath string) []string {
+	if strings.ContainsAny(basePath, "?#{} ") {
+		return []string{fmt.Sprintf("BASE_PATH=%q: must be a plain URL path such as /zimage", basePath)}
+	}
+	return nil
+}
+
+// 去掉 BASE_PATH 前綴後交給 router
+func withBasePath(basePath string, next http.Handler) http.Handler {
+	if basePath == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if path == basePath {
+			target := basePath + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(path, basePath+"/") {
+			// 代理伺服器已經去掉前綴
+			next.ServeHTTP(w, r)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = strings.TrimPrefix(path, basePath)
+		r2.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, basePath)
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// 把對外網址放進 request context (見 externalURLFrom)
+func withExternalURL(cfg *Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), externalURLKey{}, cfg.externalURL(r))))
+	})
+}
+
+// 服務的對外網址 (含 BASE_PATH，不含結尾的 /)
+func (c *Config) externalURL(r *http.Request) string {
+	if c.PublicURL != "" {
+		return c.PublicURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if c.TrustProxy {
+		if proto := forwardedValue(r, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwarded := forwardedValue(r, "X-Forwarded-Host"); forwarded != "" {
+			host = forwarded
+		}
+	}
+	if host == "" {
+		return ""
+	}
+	return scheme + "://" + host + c.BasePath
+}
+
+// 多層代理時 header 以逗號串接，第一個是最外層 (client 連線的那一層)
+func forwardedValue(r *http.Request, name string) string {
+	first, _, _ := strings.Cut(r.Header.Get(name), ",")
+	return strings.ToLower(strings.TrimSpace(first))
+}
+
+// 由 withExternalURL 放進 context 的對外網址，不是從 HTTP 請求來的 (例如 CLI、MCP stdio) 時為空字串
+func externalURLFrom(ctx context.Context) string {
+	s, _ := ctx.Value(externalURLKey{}).(string)
+	return s
+}
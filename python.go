@@ -0,0 +1,182 @@
+// python.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// --- python 執行環境 ---
+// python 生成後端 (python、sidecar)、UPSCALER=python 與 SAFETY_CHECK=script 都執行 python 腳本，
+// 共用設定之外可以用 GENERATOR_、UPSCALE_、SAFETY_ 前綴分別指定 (沒有設定的項目沿用共用設定)：
+//   PYTHON_PATH   直譯器；未設定時使用虛擬環境中的 python，再沒有時依序找 PATH 中的 python、python3
+//   PYTHON_VENV   virtualenv 或 conda 環境目錄，設定 VIRTUAL_ENV (conda 為 CONDA_PREFIX) 並把 bin 加到 PATH 最前面
+//   PYTHON_ENV    額外的環境變數，KEY=VALUE 以分號分隔 (例如 HF_HOME=/data/hf;CUDA_VISIBLE_DEVICES=0)，後端的設定覆蓋共用的同名變數
+//   ZIMAGE_DIR    工作目錄 (腳本所在目錄)，各後端的名稱為 GENERATOR_WORKDIR、UPSCALE_WORKDIR、SAFETY_WORKDIR
+// GPU 排程 (見 gpu.go) 指定的 CUDA_VISIBLE_DEVICES 優先於 PYTHON_ENV
+
+type pythonRuntime struct {
+	Prefix string // 設定前綴，錯誤訊息與設定列表使用
+	Python string // 實際使用的直譯器
+	Venv   string
+	Dir    string
+	Env    string // 合併後的 KEY=VALUE;KEY=VALUE
+}
+
+func pythonFromEnv(prefix string) pythonRuntime {
+	p := pythonRuntime{
+		Prefix: prefix,
+		Venv:   envString(prefix+"_PYTHON_VENV", os.Getenv("PYTHON_VENV")),
+		Dir:    envString(prefix+"_WORKDIR", envString("ZIMAGE_DIR", "./Z-Image")),
+		Env:    strings.Trim(os.Getenv("PYTHON_ENV")+";"+os.Getenv(prefix+"_PYTHON_ENV"), ";"),
+	}
+	// 越明確的設定越優先：後端的直譯器 > 後端的虛擬環境 > 共用的直譯器 > 共用的虛擬環境
+	switch {
+	case os.Getenv(prefix+"_PYTHON_PATH") != "":
+		p.Python = os.Getenv(prefix + "_PYTHON_PATH")
+	case os.Getenv(prefix+"_PYTHON_VENV") != "":
+		p.Python = venvPython(p.Venv)
+	case os.Getenv("PYTHON_PATH") != "":
+		p.Python = os.Getenv("PYTHON_PATH")
+	case p.Venv != "":
+		p.Python = venvPython(p.Venv)
+	default:
+		p.Python = defaultPython()
+	}
+	return p
+}
+
+// 虛擬環境中的 python (Windows 為 Scripts\python.exe，conda 為環境目錄下的 python.exe)
+func venvPython(venv string) string {
+	if runtime.GOOS == "windows" {
+		if path := filepath.Join(venv, "Scripts", "python.exe"); fileExists(path) {
+			return path
+		}
+		return filepath.Join(venv, "python.exe")
+	}
+	return filepath.Join(venv, "bin", "python")
+}
+
+func venvBin(venv string) string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(venv, "Scripts")
+	}
+	return filepath.Join(venv, "bin")
+}
+
+// 只有 python3 的系統 (例如新版 Debian / Ubuntu) 沒有 python 指令
+func defaultPython() string {
+	if _, err := exec.LookPath("python"); err != nil {
+		if _, err := exec.LookPath("python3"); err == nil {
+			return "python3"
+		}
+	}
+	return "python"
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// 解析 PYTHON_ENV，格式錯誤的項目回傳在 invalid
+func parsePythonEnv(s string) (env []string, invalid []string) {
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if key, _, ok := strings.Cut(item, "="); !ok || strings.TrimSpace(key) == "" || strings.ContainsAny(key, " \t") {
+			invalid = append(invalid, item)
+			continue
+		}
+		env = append(env, item)
+	}
+	return env, invalid
+}
+
+// 子 process 的環境變數；device 為 GPU 排程指定的 GPU，空字串代表不指定。
+// 重複的變數以後面的為準 (見 exec.Cmd.Env)
+func (p pythonRuntime) environ(device string) []string {
+	env := os.Environ()
+	if p.Venv != "" {
+		venv, _ := filepath.Abs(p.Venv)
+		if isDir(filepath.Join(venv, "conda-meta")) {
+			env = append(env, "CONDA_PREFIX="+venv)
+		} else {
+			env = append(env, "VIRTUAL_ENV="+venv)
+		}
+		env = append(env, "PATH="+venvBin(venv)+string(os.PathListSeparator)+os.Getenv("PATH"))
+	}
+	extra, _ := parsePythonEnv(p.Env)
+	env = append(env, extra...)
+	return append(env, gpuEnv(device)...)
+}
+
+// 在工作目錄執行 python <args>；ctx 取消時 kill 整個 process group
+func (p pythonRuntime) command(ctx context.Context, device string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, p.Python, args...)
+	cmd.Dir = p.Dir
+	cmd.Env = p.environ(device)
+	configureProcessGroup(cmd)
+	cmd.WaitDelay = 5 * time.Second // kill 之後最多再等 5 秒讓 pipe 關閉
+	return cmd
+}
+
+// 目前設定會用到的 python 執行環境
+func (c *Config) pythonRuntimes() []pythonRuntime {
+	var runtimes []pythonRuntime
+	if c.Generator == "python" || c.Generator == "sidecar" {
+		runtimes = append(runtimes, c.Python)
+	}
+	if c.Upscaler == "python" {
+		runtimes = append(runtimes, c.UpscalePython)
+	}
+	if c.SafetyCheck == "script" {
+		runtimes = append(runtimes, c.SafetyPython)
+	}
+	return runtimes
+}
+
+// 啟動時檢查直譯器、虛擬環境、工作目錄與環境變數格式
+func (p pythonRuntime) problems() []string {
+	var problems []string
+	if p.Venv != "" && !isDir(p.Venv) {
+		problems = append(problems, fmt.Sprintf("%s_PYTHON_VENV / PYTHON_VENV=%q: directory does not exist", p.Prefix, p.Venv))
+	}
+	if _, err := exec.LookPath(p.Python); err != nil {
+		problems = append(problems, fmt.Sprintf("%s_PYTHON_PATH / PYTHON_PATH=%q: %v", p.Prefix, p.Python, err))
+	}
+	if !isDir(p.Dir) {
+		problems = append(problems, fmt.Sprintf("%s_WORKDIR / ZIMAGE_DIR=%q: directory does not exist", p.Prefix, p.Dir))
+	}
+	if _, invalid := parsePythonEnv(p.Env); len(invalid) > 0 {
+		problems = append(problems, fmt.Sprintf("%s_PYTHON_ENV / PYTHON_ENV: invalid entries %q (expected KEY=VALUE separated by ;)", p.Prefix, invalid))
+	}
+	return problems
+}
+
+// 設定列表中顯示的值；環境變數只列名稱，避免印出 token 等內容
+func (p pythonRuntime) describe() string {
+	s := fmt.Sprintf("python=%s dir=%s", p.Python, p.Dir)
+	if p.Venv != "" {
+		s += " venv=" + p.Venv
+	}
+	if env, _ := parsePythonEnv(p.Env); len(env) > 0 {
+		var keys []string
+		for _, item := range env {
+			if key, _, _ := strings.Cut(item, "="); !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+		s += " env=" + strings.Join(keys, ",")
+	}
+	return s
+}
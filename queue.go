@@ -0,0 +1,172 @@
+// queue.go
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- 佇列位置與預估等待時間 ---
+
+// 以最近幾筆成功任務的生成時間估算 ETA
+const etaSampleSize = 20
+
+// 一次推播位置更新的任務數上限，避免佇列很長時每次認領都送出大量訊息
+const maxPositionUpdates = 200
+
+type durationStats struct {
+	mutex   sync.Mutex
+	samples []time.Duration
+}
+
+func (s *durationStats) add(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.samples = append(s.samples, d)
+	if len(s.samples) > etaSampleSize {
+		s.samples = s.samples[len(s.samples)-etaSampleSize:]
+	}
+}
+
+// 平均生成時間，還沒有樣本時回傳 0
+func (s *durationStats) average() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.samples) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range s.samples {
+		total += d
+	}
+	return total / time.Duration(len(s.samples))
+}
+
+// 排在第 position 位 (1 為下一個執行) 的任務預估多久後完成；沒有樣本時回傳 0
+func (a *App) estimateWait(position int) time.Duration {
+	avg := a.genDurations.average()
+	if avg == 0 || position < 1 {
+		return 0
+	}
+	rounds := (position - 1) / max(a.Config.WorkerCount, 1)
+	return time.Duration(rounds+1) * avg
+}
+
+// 排隊中 (Pending / Retrying) 任務在佇列中的位置，與 worker 認領順序 (created_at) 相同；
+// 尚未到排程時間的任務不算在佇列內，回傳 0
+func (a *App) queuePosition(task *Task) int {
+	now := time.Now()
+	if !task.Status.queued() || (task.ScheduledAt != nil && task.ScheduledAt.After(now)) {
+		return 0
+	}
+	var ahead int64
+	a.readyTasks(now).Model(&Task{}).
+		Where("created_at < ? OR (created_at = ? AND id < ?)", task.CreatedAt, task.CreatedAt, task.ID).
+		Count(&ahead)
+	return int(ahead) + 1
+}
+
+// 回傳前填入佇列位置與 ETA (只對 Pending 任務有意義)
+func (a *App) fillQueueInfo(task *Task) {
+	task.QueuePosition = a.queuePosition(task)
+	task.ETASeconds = etaSeconds(a.estimateWait(task.QueuePosition))
+}
+
+func etaSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// 佇列前進時 (任務被認領、取消) 推播目前的位置給訂閱者
+func (a *App) notifyQueuePositions() {
+	var tasks []Task
+	if err := a.readyTasks(time.Now()).Order("created_at asc, id asc").Limit(maxPositionUpdates).
+		Select("id", "user_id").Find(&tasks).Error; err != nil {
+		return
+	}
+	for i, task := range tasks {
+		a.Hub.notifyPosition(task, i+1, etaSeconds(a.estimateWait(i+1)))
+	}
+}
+
+// --- 暫停 / 恢復佇列 ---
+
+// 佇列狀態，暫停時 worker 不認領新任務，執行中的生成不受影響 (例如 GPU 維護)
+type QueueState struct {
+	Paused   bool       `json:"paused"`
+	Reason   string     `json:"reason,omitempty"`
+	Cause    string     `json:"cause,omitempty"` // 自動暫停的原因：disk (磁碟空間不足，見 diskguard.go)；管理員暫停時為空
+	PausedAt *time.Time `json:"paused_at,omitempty"`
+}
+
+func (a *App) queueStatus() QueueState {
+	a.queueMutex.Lock()
+	defer a.queueMutex.Unlock()
+	return a.queueState
+}
+
+// 切換暫停狀態並推播給所有 WebSocket 連線；狀態沒有改變時只更新原因
+func (a *App) setQueuePaused(paused bool, reason string) QueueState {
+	return a.setQueuePausedBy(paused, reason, "")
+}
+
+// 同 setQueuePaused，cause 記錄自動暫停的原因
+func (a *App) setQueuePausedBy(paused bool, reason, cause string) QueueState {
+	a.queueMutex.Lock()
+	changed := a.queueState.Paused != paused
+	if paused {
+		if changed {
+			now := time.Now()
+			a.queueState.PausedAt = &now
+		}
+		a.queueState.Reason, a.queueState.Cause = reason, cause
+	} else {
+		a.queueState = QueueState{}
+	}
+	a.queueState.Paused = paused
+	a.queuePaused.Store(paused)
+	state := a.queueState
+	a.queueMutex.Unlock()
+
+	if changed {
+		if paused {
+			slog.Warn("Queue paused", "reason", reason)
+		} else {
+			slog.Info("Queue resumed")
+			a.signalDispatch()
+		}
+	}
+	a.Hub.notifyQueueState(state)
+	return state
+}
+
+// POST /api/admin/queue/pause，body 可帶 {"reason": "..."}
+func (a *App) apiAdminPauseQueue(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+	reason := strings.TrimSpace(req.Reason)
+	a.audit(r.Context(), "queue.paused", "queue", "", reason)
+	writeJSON(w, http.StatusOK, a.setQueuePaused(true, reason))
+}
+
+// POST /api/admin/queue/resume
+func (a *App) apiAdminResumeQueue(w http.ResponseWriter, r *http.Request) {
+	a.audit(r.Context(), "queue.resumed", "queue", "", "")
+	writeJSON(w, http.StatusOK, a.setQueuePaused(false, ""))
+}
+
+// GET /api/queue：一般使用者也可查詢佇列是否暫停
+func (a *App) apiQueueState(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.queueStatus())
+}
@@ -0,0 +1,137 @@
+// quota.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- 使用者配額 ---
+
+// 超過配額時回傳的錯誤，REST 回 429，WebSocket 回 error 訊息
+type QuotaError struct {
+	Message string
+	Limit   int
+	message message
+}
+
+func (e *QuotaError) Error() string { return e.Message }
+
+func (e *QuotaError) localMessage() message { return e.message }
+
+// format 的唯一參數為上限
+func quotaExceeded(limit int, format string) *QuotaError {
+	return &QuotaError{Message: fmt.Sprintf(format, limit), Limit: limit, message: newMessage(format, limit)}
+}
+
+func envInt(name string) int {
+	n, _ := strconv.Atoi(os.Getenv(name))
+	return n
+}
+
+// 檢查使用者是否還能建立任務；設定值為 0 代表不限制，未登入 (user 0) 不受限制
+//
+//	MAX_QUEUED_PER_USER：同時等待審核、排隊或執行中的任務數
+//	MAX_TASKS_PER_HOUR：最近一小時內建立的任務數
+//
+// n 為這次要建立的任務數 (批次建立時大於 1)
+func (a *App) checkQuota(userID uint, n int) error {
+	if userID == 0 {
+		return nil
+	}
+	if limit := a.Config.MaxQueuedPerUser; limit > 0 {
+		var queued int64
+		if err := a.DB.Model(&Task{}).
+			Where("user_id = ? AND status IN ?", userID, activeStatuses).
+			Count(&queued).Error; err != nil {
+			return err
+		}
+		if queued+int64(n) > int64(limit) {
+			return quotaExceeded(limit, "quota exceeded: at most %d queued tasks per user")
+		}
+	}
+	if limit := a.Config.MaxTasksPerHour; limit > 0 {
+		var recent int64
+		if err := a.DB.Model(&Task{}).
+			Where("user_id = ? AND created_at > ?", userID, time.Now().Add(-time.Hour)).
+			Count(&recent).Error; err != nil {
+			return err
+		}
+		if recent+int64(n) > int64(limit) {
+			return quotaExceeded(limit, "quota exceeded: at most %d tasks per hour")
+		}
+	}
+	return nil
+}
+
+// 工作區的配額 (見 workspace.go)，計算所有成員在工作區中的任務；workspace 為 nil 時不檢查
+func (a *App) checkWorkspaceQuota(workspace *Workspace, n int) error {
+	if workspace == nil {
+		return nil
+	}
+	if limit := workspace.MaxQueued; limit > 0 {
+		var queued int64
+		if err := a.DB.Model(&Task{}).
+			Where("workspace_id = ? AND status IN ?", workspace.ID, activeStatuses).
+			Count(&queued).Error; err != nil {
+			return err
+		}
+		if queued+int64(n) > int64(limit) {
+			return quotaExceeded(limit, "quota exceeded: at most %d queued tasks per workspace")
+		}
+	}
+	if limit := workspace.MaxTasksPerHour; limit > 0 {
+		var recent int64
+		if err := a.DB.Model(&Task{}).
+			Where("workspace_id = ? AND created_at > ?", workspace.ID, time.Now().Add(-time.Hour)).
+			Count(&recent).Error; err != nil {
+			return err
+		}
+		if recent+int64(n) > int64(limit) {
+			return quotaExceeded(limit, "quota exceeded: at most %d tasks per hour in this workspace")
+		}
+	}
+	return nil
+}
+
+// 錯誤代碼，放在 REST 與 WebSocket 的錯誤回應中
+func errorCode(err error) string {
+	var quotaErr *QuotaError
+	var validationErr *ValidationError
+	var moderationErr *ModerationError
+	var coded *codedError
+	switch {
+	case errors.As(err, &coded):
+		return coded.Code
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return "not_found"
+	case errors.As(err, &quotaErr):
+		return "quota_exceeded"
+	case errors.As(err, &validationErr):
+		return "invalid_request"
+	case errors.As(err, &moderationErr):
+		return "prompt_rejected"
+	}
+	return "internal_error"
+}
+
+// 錯誤回應的 details，沒有補充資料時為 nil
+func errorDetails(err error) interface{} {
+	var quotaErr *QuotaError
+	var syntaxErr *PromptSyntaxError
+	var validationErr *ValidationError
+	switch {
+	case errors.As(err, &quotaErr):
+		return map[string]int{"limit": quotaErr.Limit}
+	case errors.As(err, &syntaxErr):
+		return map[string]interface{}{"field": syntaxErr.Field, "reason": syntaxErr.Reason, "position": syntaxErr.Position}
+	case errors.As(err, &validationErr) && validationErr.Field != "":
+		return map[string]string{"field": validationErr.Field}
+	}
+	return nil
+}
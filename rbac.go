@@ -0,0 +1,162 @@
+// rbac.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// --- 角色權限 ---
+// 驗證之後再依使用者的角色限制操作 (角色存在 users.role，修改後下一個請求就生效)：
+//
+//	viewer  瀏覽圖庫、歷史紀錄與任務 (唯讀)
+//	creator 另外可以建立、取消、刪除自己的任務，以及管理標籤、收藏集、範本等個人資料
+//	admin   另外可以使用 /api/admin/* (管理佇列與使用者)，與 ADMIN_TOKEN 相同
+//
+// 未啟用驗證 (沒有 Identity) 時不檢查角色。工作區的 owner / member 是另一層權限 (見 workspace.go)
+
+const (
+	roleViewer  = "viewer"
+	roleCreator = "creator"
+	roleAdmin   = "admin"
+)
+
+var roleRanks = map[string]int{roleViewer: 1, roleCreator: 2, roleAdmin: 3}
+
+func validRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// 角色不足時回傳 forbidden
+func (id *Identity) checkRole(role string) error {
+	if id == nil || roleRanks[id.Role] >= roleRanks[role] {
+		return nil
+	}
+	return newCodedError("forbidden", "%s role required", role)
+}
+
+// 從資料庫讀取使用者目前的角色，回傳加上角色的 Identity (API key 的 Identity 會被共用，不直接修改)；
+// 使用者不存在時沒有任何角色
+func (a *App) withUserRole(identity *Identity) (*Identity, error) {
+	var user User
+	err := a.DB.Select("role", "locale").First(&user, identity.UserID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	withRole := *identity
+	withRole.Role = user.Role
+	withRole.Locale = userLocale(user.Locale)
+	return &withRole, nil
+}
+
+// 驗證並要求至少具有指定角色的 middleware
+func (a *App) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return a.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if err := identityFromRequest(r).checkRole(role); err != nil {
+			writeTaskError(w, err)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// 需要 creator 角色的 WebSocket 訊息，其他訊息 (查詢、訂閱) viewer 即可
+var wsMessageRoles = map[string]string{
+	"create_task":  roleCreator,
+	"create_batch": roleCreator,
+	"rerun_task":   roleCreator,
+	"cancel_task":  roleCreator,
+}
+
+func (id *Identity) checkMessageRole(msgType string) error {
+	if role, ok := wsMessageRoles[msgType]; ok {
+		return id.checkRole(role)
+	}
+	return nil
+}
+
+// 設定使用者的角色；-set-role 與 PUT /api/admin/users/{id} 共用
+func (a *App) setUserRole(ctx context.Context, user *User, role string) error {
+	if !validRole(role) {
+		return invalidField("role", "role must be viewer, creator or admin")
+	}
+	if err := a.DB.Model(user).Update("role", role).Error; err != nil {
+		return err
+	}
+	a.audit(ctx, "user.role_changed", "user", strconv.FormatUint(uint64(user.ID), 10), user.Name+"="+role)
+	return nil
+}
+
+// GET /api/admin/users：所有使用者與角色
+func (a *App) apiAdminListUsers(w http.ResponseWriter, r *http.Request) {
+	users := []User{}
+	if err := a.DB.Order("name asc").Find(&users).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+type userRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// PUT /api/admin/users/{id}：修改角色 ({"role": "viewer"})；
+// 以 admin 角色登入時不能移除自己的 admin 角色，避免沒有設定 ADMIN_TOKEN 時失去管理權限
+func (a *App) apiAdminUpdateUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	var req userRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	req.Role = strings.ToLower(strings.TrimSpace(req.Role))
+	var user User
+	if err := a.DB.First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, "user not found")
+		} else {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if identityFromRequest(r).userID() == user.ID && req.Role != roleAdmin {
+		writeTaskError(w, &codedError{Code: "conflict", Message: "cannot remove your own admin role"})
+		return
+	}
+	if err := a.setUserRole(r.Context(), &user, req.Role); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	user.Role = req.Role
+	writeJSON(w, http.StatusOK, user)
+}
+
+// -set-role name:role：不需要 ADMIN_TOKEN 就能指定第一個管理員
+func (a *App) runSetRole(arg string) error {
+	name, role, ok := strings.Cut(arg, ":")
+	name, role = strings.TrimSpace(name), strings.ToLower(strings.TrimSpace(role))
+	if !ok || name == "" {
+		return errors.New("-set-role: expected name:role")
+	}
+	var user User
+	if err := a.DB.Where("name = ?", name).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("-set-role: unknown user %s", name)
+		}
+		return err
+	}
+	return a.setUserRole(context.Background(), &user, role)
+}
@@ -0,0 +1,266 @@
+// reload.go
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/joho/godotenv"
+)
+
+// --- 重新載入設定 ---
+// 收到 SIGHUP 或 POST /api/admin/config/reload 時重新讀取 envfile 與 YAML 設定檔 (-config)，
+// 只套用下列不需要重新啟動的設定，執行中的生成不受影響：
+//
+//	WORKER_COUNT                           增加時立即啟動 worker，減少時多出來的 worker 做完手上的任務後結束
+//	                                       (0 與非 0 之間的切換需要重新啟動)
+//	PROMPT_DENYLIST、PROMPT_DENYLIST_MODE   重新讀取禁用詞檔案
+//	PROMPT_SYNTAX_CHECK                    下一個請求
+//	MAX_QUEUED_PER_USER、MAX_TASKS_PER_HOUR 配額，下一個請求生效
+//	RETENTION_*、TRASH_DAYS                 自動清理，下一次檢查時生效
+//	TASK_EXPIRY                            之後建立的任務
+//	MODEL_CONCURRENCY                      下一次認領任務時生效
+//
+// 啟動前就存在的環境變數與命令列參數 (-workers) 優先於檔案，重新載入時不變。
+// 其他設定有變更時記錄在 log 與回應的 restart_required，重新啟動後才生效；設定有錯誤時全部不套用
+
+var reloadableSettings = []string{
+	"WORKER_COUNT", "PROMPT_DENYLIST", "PROMPT_DENYLIST_MODE", "PROMPT_SYNTAX_CHECK", "MAX_QUEUED_PER_USER", "MAX_TASKS_PER_HOUR",
+	"RETENTION_DAYS", "RETENTION_MAX_MB", "RETENTION_INTERVAL", "RETENTION_DRY_RUN", "TRASH_DAYS", "TASK_EXPIRY",
+	"MODEL_CONCURRENCY",
+}
+
+func reloadable(name string) bool {
+	for _, s := range reloadableSettings {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+type configChange struct {
+	Name string `json:"name"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+type reloadResult struct {
+	Changed         []configChange `json:"changed"`                    // 已經套用的變更
+	RestartRequired []configChange `json:"restart_required,omitempty"` // 有變更但要重新啟動才生效
+}
+
+// 目前所有環境變數的名稱
+func environNames() map[string]bool {
+	names := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		if name, _, ok := strings.Cut(kv, "="); ok {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// 由命令列參數或程式指定的設定，重新載入時不變
+func (c *Config) fixSetting(names ...string) {
+	if c.fixedEnv == nil {
+		c.fixedEnv = make(map[string]bool)
+	}
+	for _, name := range names {
+		c.fixedEnv[name] = true
+	}
+}
+
+// 有指定的命令列參數 (flags 為參數名稱 -> 設定名稱) 視為固定的設定
+func (c *Config) fixFlags(fs *flag.FlagSet, flags map[string]string) {
+	fs.Visit(func(f *flag.Flag) {
+		if name, ok := flags[f.Name]; ok {
+			c.fixSetting(name)
+		}
+	})
+}
+
+// envfile 與設定檔目前的內容 (envfile 優先)，不包含固定的設定
+func (c *Config) readSources() (map[string]string, error) {
+	values := make(map[string]string)
+	if c.ConfigFile != "" {
+		file, err := readConfigFile(c.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range file {
+			values[name] = value
+		}
+	}
+	env, err := godotenv.Read("envfile")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for name, value := range env {
+		values[name] = value
+	}
+	for name := range c.fixedEnv {
+		delete(values, name)
+	}
+	return values, nil
+}
+
+// SIGHUP 時重新載入，直到 ctx 結束
+func (a *App) watchReloadSignal(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				slog.Info("SIGHUP received, reloading configuration")
+				if _, err := a.reloadConfig(context.Background()); err != nil {
+					slog.Error("Reload configuration failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// 重新讀取設定並套用可以即時生效的部分
+func (a *App) reloadConfig(ctx context.Context) (*reloadResult, error) {
+	a.reloadMutex.Lock()
+	defer a.reloadMutex.Unlock()
+
+	values, err := a.Config.readSources()
+	if err != nil {
+		return nil, err
+	}
+	// 以新的環境變數讀取完整設定 (同時檢查所有設定)，之後只留下可以重新載入的設定
+	previous := make(map[string]*string)
+	setEnv := func(name string, value *string) {
+		if _, saved := previous[name]; !saved {
+			if old, ok := os.LookupEnv(name); ok {
+				previous[name] = &old
+			} else {
+				previous[name] = nil
+			}
+		}
+		if value != nil {
+			os.Setenv(name, *value)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+	restore := func(keep func(name string) bool) {
+		for name, value := range previous {
+			if !keep(name) {
+				if value != nil {
+					os.Setenv(name, *value)
+				} else {
+					os.Unsetenv(name)
+				}
+			}
+		}
+	}
+	for name, value := range values {
+		setEnv(name, &value)
+	}
+	for _, name := range reloadableSettings {
+		// 從檔案中移除的設定改回預設值
+		if _, ok := values[name]; !ok && !a.Config.fixedEnv[name] {
+			setEnv(name, nil)
+		}
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		restore(func(string) bool { return false })
+		return nil, err
+	}
+
+	result := &reloadResult{Changed: []configChange{}}
+	oldEntries := make(map[string]string)
+	for _, e := range a.Config.entries() {
+		oldEntries[e.Name] = e.Value
+	}
+	for _, e := range cfg.entries() {
+		old, ok := oldEntries[e.Name]
+		if !ok || old == e.Value || a.Config.fixedEnv[e.Name] {
+			continue
+		}
+		change := configChange{Name: e.Name, Old: old, New: e.Value}
+		if reloadable(e.Name) && (e.Name != "WORKER_COUNT" || (a.Config.WorkerCount == 0) == (cfg.WorkerCount == 0)) {
+			result.Changed = append(result.Changed, change)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, change)
+		}
+	}
+	// 禁用詞檔案有錯誤時全部不套用
+	moderation := a.Moderation
+	if cfg.PromptDenylist != a.Config.PromptDenylist || cfg.PromptDenylistMode != a.Config.PromptDenylistMode {
+		if moderation, err = newPromptFilter(cfg); err != nil {
+			restore(func(string) bool { return false })
+			return nil, err
+		}
+	}
+	// 需要重新啟動的設定維持原本的環境變數，執行期間直接讀取環境變數的地方才不會只套用一半
+	restore(reloadable)
+
+	for _, c := range result.Changed {
+		switch c.Name {
+		case "WORKER_COUNT":
+			a.Config.WorkerCount = cfg.WorkerCount
+			a.resizeWorkers(cfg.WorkerCount)
+		case "PROMPT_DENYLIST", "PROMPT_DENYLIST_MODE":
+			a.Config.PromptDenylist, a.Config.PromptDenylistMode = cfg.PromptDenylist, cfg.PromptDenylistMode
+			a.Moderation = moderation
+		case "PROMPT_SYNTAX_CHECK":
+			a.Config.PromptSyntaxCheck = cfg.PromptSyntaxCheck
+		case "MAX_QUEUED_PER_USER":
+			a.Config.MaxQueuedPerUser = cfg.MaxQueuedPerUser
+		case "MAX_TASKS_PER_HOUR":
+			a.Config.MaxTasksPerHour = cfg.MaxTasksPerHour
+		case "RETENTION_DAYS", "RETENTION_MAX_MB", "RETENTION_INTERVAL", "RETENTION_DRY_RUN":
+			a.Config.Retention = cfg.Retention
+		case "TRASH_DAYS":
+			a.Config.TrashDays = cfg.TrashDays
+		case "TASK_EXPIRY":
+			a.Config.TaskExpiry = cfg.TaskExpiry
+		case "MODEL_CONCURRENCY":
+			// 與認領任務互斥，上限提高時通知 worker 認領排隊中的任務
+			a.lanes.mutex.Lock()
+			a.Config.ModelConcurrency = cfg.ModelConcurrency
+			a.lanes.mutex.Unlock()
+			a.signalDispatch()
+		}
+		slog.Info("Configuration changed", "name", c.Name, "old", c.Old, "new", c.New)
+	}
+	for _, c := range result.RestartRequired {
+		slog.Warn("Configuration change requires restart", "name", c.Name, "old", c.Old, "new", c.New)
+	}
+	if len(result.Changed) == 0 && len(result.RestartRequired) == 0 {
+		slog.Info("Configuration reloaded, nothing changed")
+	}
+
+	names := make([]string, len(result.Changed))
+	for i, c := range result.Changed {
+		names[i] = c.Name + "=" + c.New
+	}
+	a.audit(ctx, "config.reloaded", "", "", strings.Join(names, ","))
+	return result, nil
+}
+
+// POST /api/admin/config/reload：與 SIGHUP 相同，回傳套用與需要重新啟動的變更；設定有錯誤時回 422
+func (a *App) apiAdminReloadConfig(w http.ResponseWriter, r *http.Request) {
+	result, err := a.reloadConfig(r.Context())
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
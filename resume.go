@@ -0,0 +1,80 @@
+// resume.go
+package main
+
+import (
+	"time"
+)
+
+// --- 斷線重連後補送更新 ---
+// Hub 推播的每則訊息都帶遞增的 seq (以微秒時間戳記為基礎，重新啟動後仍然遞增)，
+// client 記住收到的最大 seq，重連後送出 {"type":"resume","since_seq":N,"task_ids":[...]}：
+// 伺服器從資料庫找出之後有變更的任務，依序送出 update / deleted，最後回 {"type":"resumed","seq":..,"count":..}。
+// 進度與佇列位置是暫時的狀態，不會補送；已永久刪除的任務也無法補送。
+// 離線太久或變更太多時回 full_refresh，client 應重新讀取歷史紀錄
+
+const (
+	wsResumeWindow = 24 * time.Hour  // since_seq 早於此時間時要求重新讀取
+	wsResumeLimit  = 500             // 一次最多補送的任務數
+	wsResumeSlack  = 2 * time.Second // 容許推播與寫入資料庫的時間差 (多台伺服器的時鐘誤差)，重複的更新不影響結果
+)
+
+// 取得下一個序號：不小於目前時間的微秒數，且一定大於上一個
+func (h *Hub) nextSeq() int64 {
+	for {
+		last := h.seq.Load()
+		next := max(time.Now().UnixMicro(), last+1)
+		if h.seq.CompareAndSwap(last, next) {
+			return next
+		}
+	}
+}
+
+// 目前的序號，hello 時給 client 作為起點
+func (h *Hub) currentSeq() int64 {
+	return max(h.seq.Load(), time.Now().UnixMicro())
+}
+
+type WSResumed struct {
+	Type        string `json:"type"`
+	Seq         int64  `json:"seq"`
+	Count       int    `json:"count"`                  // 補送的任務數
+	FullRefresh bool   `json:"full_refresh,omitempty"` // 無法補送，client 應重新讀取 (get_history)
+}
+
+// 處理 resume：先訂閱 client 畫面上的任務，再補送 since_seq 之後有變更的自己的任務 (並訂閱)
+func (a *App) resumeClient(client *wsClient, sinceSeq int64, ids []uint, all bool) error {
+	client.subscribe(a.DB, ids, all)
+	seq := client.hub.currentSeq()
+	since := time.UnixMicro(sinceSeq)
+	if sinceSeq <= 0 || time.Since(since) > wsResumeWindow {
+		return client.writeJSON(WSResumed{Type: "resumed", Seq: seq, FullRefresh: true})
+	}
+
+	since = since.Add(-wsResumeSlack)
+	var tasks []Task
+	err := client.identity.taskScope(a.DB.Unscoped()).
+		Where("updated_at >= ? OR deleted_at >= ?", since, since).
+		Order("updated_at asc, id asc").Limit(wsResumeLimit + 1).Find(&tasks).Error
+	if err != nil {
+		return client.writeError(err)
+	}
+	if len(tasks) > wsResumeLimit {
+		return client.writeJSON(WSResumed{Type: "resumed", Seq: seq, FullRefresh: true})
+	}
+	a.loadTaskListImages(tasks)
+
+	var live []uint
+	for _, task := range tasks {
+		if task.DeletedAt.Valid {
+			client.writeJSON(WSResponse{Type: "deleted", Seq: task.DeletedAt.Time.UnixMicro(), Data: task})
+			continue
+		}
+		live = append(live, task.ID)
+		if task.Status.queued() {
+			a.fillQueueInfo(&task)
+		}
+		client.writeJSON(WSResponse{Type: "update", Seq: task.UpdatedAt.UnixMicro(), Data: task})
+	}
+	client.subscribe(a.DB, live, false)
+	return client.writeJSON(WSResumed{Type: "resumed", Seq: seq, Count: len(tasks)})
+}
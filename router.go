@@ -2,29 +2,156 @@
 package main
 
 import(
-   "fmt"
+   "log/slog"
    "net/http"
    "github.com/asccclass/sherryserver"
 )
 
-func NewRouter(srv *SherryServer.Server, documentRoot string)(*http.ServeMux) {
+func (a *App) NewRouter(srv *SherryServer.Server, documentRoot string)(*http.ServeMux) {
    router := http.NewServeMux()
 
-   // Static File server
-   staticfileserver := SherryServer.StaticFileServer{documentRoot, "index.html"}
-   staticfileserver.AddRouter(router)
+   // 前端網頁：預設使用嵌入執行檔的檔案，設定 DocumentRoot 時從磁碟讀取 (見 webui.go)
+   a.addWebUIRoutes(router, documentRoot)
 	
+   // Kubernetes probes (不需驗證)
+   router.HandleFunc("GET /healthz", a.serveHealthz)
+   router.HandleFunc("GET /readyz", a.serveReadyz)
+   router.HandleFunc("GET /api/openapi.json", a.serveOpenAPI) // 不需驗證，供產生 client SDK
+
+   // 瀏覽器登入 (OIDC / GitHub，不需驗證，見 oauth.go)
+   router.HandleFunc("GET /auth/providers", a.serveLoginProviders)
+   router.HandleFunc("GET /auth/{provider}/login", a.serveLogin)
+   router.HandleFunc("GET /auth/{provider}/callback", a.serveLoginCallback)
+   router.HandleFunc("POST /auth/logout", a.serveLogout)
+
+   // 公開分享連結 (不需驗證，以 token 存取)
+   router.HandleFunc("GET /share/{token}", a.serveSharePage)
+   router.HandleFunc("GET /share/{token}/image", a.serveShareImage)
+
 	// 設定 WebSocket 路由 (SherryServer 使用原生 Handler)
-	 router.HandleFunc("GET /ws", serveWs)
+	 router.HandleFunc("GET /ws", a.requireAuth(a.serveWs))
+	 router.HandleFunc("GET /api/events", a.requireAuth(a.serveEvents)) // 無法使用 WebSocket 時的 SSE 替代方案
+
+   // REST API (與 WebSocket 共用同一個 Task 資料表)，需要 API key；
+   // 查詢只需要 viewer 角色，建立與修改需要 creator (見 rbac.go)
+   router.HandleFunc("GET /api/me", a.requireAuth(a.apiMe))
+   router.HandleFunc("PUT /api/me/locale", a.requireAuth(a.apiUpdateLocale))
+   router.HandleFunc("GET /api/i18n", a.requireAuth(a.apiI18n)) // 目前的語系與任務狀態的顯示文字
+   router.HandleFunc("POST /api/tasks", a.requireRole(roleCreator, a.apiCreateTask))
+   router.HandleFunc("GET /api/tasks", a.requireAuth(a.apiListTasks))
+   router.HandleFunc("GET /api/tasks/{id}", a.requireAuth(a.apiGetTask))
+   router.HandleFunc("GET /api/tasks/{id}/wait", a.requireAuth(a.apiWaitTask)) // long polling，等到任務結束
+   router.HandleFunc("DELETE /api/tasks/{id}", a.requireRole(roleCreator, a.apiDeleteTask))
+   router.HandleFunc("POST /api/tasks/{id}/cancel", a.requireRole(roleCreator, a.apiCancelTask))
+   router.HandleFunc("GET /api/tasks/{id}/metadata", a.requireAuth(a.apiGetTaskMetadata))
+   router.HandleFunc("POST /api/tasks/{id}/variations", a.requireRole(roleCreator, a.apiCreateVariations))
+   router.HandleFunc("POST /api/tasks/{id}/rerun", a.requireRole(roleCreator, a.apiRerunTask))
+   router.HandleFunc("GET /api/tasks/{id}/lineage", a.requireAuth(a.apiTaskLineage))
+   router.HandleFunc("GET /api/tasks/{id}/logs", a.requireAuth(a.apiTaskLogs))
+   router.HandleFunc("POST /api/tasks/{id}/share", a.requireRole(roleCreator, a.apiCreateShare))
+   router.HandleFunc("GET /api/tasks/{id}/shares", a.requireAuth(a.apiListShares))
+   router.HandleFunc("DELETE /api/tasks/{id}/shares/{share}", a.requireRole(roleCreator, a.apiDeleteShare))
+   router.HandleFunc("GET /api/tasks/{id}/comments", a.requireAuth(a.apiListComments))
+   router.HandleFunc("POST /api/tasks/{id}/comments", a.requireRole(roleCreator, a.apiCreateComment))
+   router.HandleFunc("PUT /api/tasks/{id}/comments/{comment}", a.requireRole(roleCreator, a.apiUpdateComment))
+   router.HandleFunc("DELETE /api/tasks/{id}/comments/{comment}", a.requireRole(roleCreator, a.apiDeleteComment))
+   router.HandleFunc("POST /api/tasks/batch", a.requireRole(roleCreator, a.apiCreateBatch))
+   router.HandleFunc("POST /api/tasks/img2img", a.requireRole(roleCreator, a.apiCreateImg2ImgTask))
+   router.HandleFunc("POST /api/tasks/controlnet", a.requireRole(roleCreator, a.apiCreateControlNetTask))
+   router.HandleFunc("GET /api/batches/{id}", a.requireAuth(a.apiGetBatch))
+   router.HandleFunc("POST /api/compare", a.requireRole(roleCreator, a.apiCreateComparison))
+   router.HandleFunc("GET /api/compare/{id}", a.requireAuth(a.apiGetComparison))
+
+   // 垃圾桶 (DELETE /api/tasks/{id} 移到垃圾桶)
+   router.HandleFunc("GET /api/trash", a.requireAuth(a.apiListTrash))
+   router.HandleFunc("DELETE /api/trash", a.requireRole(roleCreator, a.apiEmptyTrash))
+   router.HandleFunc("DELETE /api/trash/{id}", a.requireRole(roleCreator, a.apiPurgeTask))
+   router.HandleFunc("POST /api/tasks/{id}/restore", a.requireRole(roleCreator, a.apiRestoreTask))
+
+   // 圖庫 (分頁 + 篩選)
+   router.HandleFunc("GET /api/images", a.requireAuth(a.apiListImages))
+   router.HandleFunc("GET /api/images/{id}/file", a.requireAuth(a.apiImageFile))
+   router.HandleFunc("POST /api/images/archive", a.requireAuth(a.apiImageArchive))
+   router.HandleFunc("GET /api/models", a.requireAuth(a.apiListModels))
+   router.HandleFunc("GET /api/loras", a.requireAuth(a.apiListLoRAs))
+   router.HandleFunc("GET /api/loras/{name}/preview", a.requireAuth(a.apiLoRAPreview))
+   router.HandleFunc("GET /api/queue", a.requireAuth(a.apiQueueState))
+   router.HandleFunc("GET /api/usage", a.requireAuth(a.apiUsage))
+   router.HandleFunc("GET /api/notifications", a.requireAuth(a.apiGetNotifySettings))
+   router.HandleFunc("PUT /api/notifications", a.requireRole(roleCreator, a.apiUpdateNotifySettings))
+
+   // 匯出 / 匯入任務紀錄
+   router.HandleFunc("GET /api/export", a.requireAuth(a.apiExport))
+   router.HandleFunc("POST /api/import", a.requireRole(roleCreator, a.apiImport))
+
+   // Prompt 歷史與最愛
+   router.HandleFunc("GET /api/prompts/recent", a.requireAuth(a.apiRecentPrompts))
+   router.HandleFunc("POST /api/prompts/{id}/favorite", a.requireRole(roleCreator, a.apiFavoritePrompt))
+
+   // Prompt 範本
+   router.HandleFunc("GET /api/templates", a.requireAuth(a.apiListTemplates))
+   router.HandleFunc("POST /api/templates", a.requireRole(roleCreator, a.apiCreateTemplate))
+   router.HandleFunc("GET /api/templates/{id}", a.requireAuth(a.apiGetTemplate))
+   router.HandleFunc("PUT /api/templates/{id}", a.requireRole(roleCreator, a.apiUpdateTemplate))
+   router.HandleFunc("DELETE /api/templates/{id}", a.requireRole(roleCreator, a.apiDeleteTemplate))
+   router.HandleFunc("GET /api/tags", a.requireAuth(a.apiListTags))
+   router.HandleFunc("POST /api/tags", a.requireRole(roleCreator, a.apiCreateTag))
+   router.HandleFunc("PUT /api/tags/{id}", a.requireRole(roleCreator, a.apiRenameTag))
+   router.HandleFunc("DELETE /api/tags/{id}", a.requireRole(roleCreator, a.apiDeleteTag))
+   router.HandleFunc("PUT /api/tasks/{id}/tags", a.requireRole(roleCreator, a.apiSetTaskTags))
+   router.HandleFunc("GET /api/collections", a.requireAuth(a.apiListCollections))
+   router.HandleFunc("POST /api/collections", a.requireRole(roleCreator, a.apiCreateCollection))
+   router.HandleFunc("GET /api/collections/{id}", a.requireAuth(a.apiGetCollection))
+   router.HandleFunc("PUT /api/collections/{id}", a.requireRole(roleCreator, a.apiUpdateCollection))
+   router.HandleFunc("DELETE /api/collections/{id}", a.requireRole(roleCreator, a.apiDeleteCollection))
+   router.HandleFunc("POST /api/collections/{id}/tasks", a.requireRole(roleCreator, a.apiAddCollectionTasks))
+   router.HandleFunc("DELETE /api/collections/{id}/tasks/{task_id}", a.requireRole(roleCreator, a.apiRemoveCollectionTask))
+
+   // 工作區 (X-Workspace 選擇，見 workspace.go)
+   router.HandleFunc("GET /api/workspaces", a.requireAuth(a.apiListWorkspaces))
+   router.HandleFunc("POST /api/workspaces", a.requireRole(roleCreator, a.apiCreateWorkspace))
+   router.HandleFunc("GET /api/workspaces/{id}", a.requireAuth(a.apiGetWorkspace))
+   router.HandleFunc("PUT /api/workspaces/{id}", a.requireRole(roleCreator, a.apiUpdateWorkspace))
+   router.HandleFunc("POST /api/workspaces/{id}/members", a.requireRole(roleCreator, a.apiSetWorkspaceMember))
+   router.HandleFunc("DELETE /api/workspaces/{id}/members/{user_id}", a.requireRole(roleCreator, a.apiRemoveWorkspaceMember))
+
+   // 管理 API (ADMIN_TOKEN 或 admin 角色)
+   router.HandleFunc("GET /api/admin/stats", a.requireAdmin(a.apiAdminStats))
+   router.HandleFunc("GET /api/admin/usage", a.requireAdmin(a.apiAdminUsage))
+   router.HandleFunc("GET /api/admin/usage/daily", a.requireAdmin(a.apiAdminUsageDaily))
+   router.HandleFunc("GET /api/admin/audit", a.requireAdmin(a.apiAdminAudit))
+   router.HandleFunc("GET /api/admin/clients", a.requireAdmin(a.apiAdminClients))
+   router.HandleFunc("DELETE /api/admin/clients/{client_id}", a.requireAdmin(a.apiAdminKickClient))
+   router.HandleFunc("POST /api/admin/tasks/recover", a.requireAdmin(a.apiAdminRecoverTasks))
+   router.HandleFunc("POST /api/admin/tasks/{id}/fail", a.requireAdmin(a.apiAdminFailTask))
+   router.HandleFunc("POST /api/admin/queue/pause", a.requireAdmin(a.apiAdminPauseQueue))
+   router.HandleFunc("POST /api/admin/queue/resume", a.requireAdmin(a.apiAdminResumeQueue))
+   router.HandleFunc("GET /api/admin/approvals", a.requireAdmin(a.apiAdminListApprovals))
+   router.HandleFunc("POST /api/admin/tasks/{id}/approve", a.requireAdmin(a.apiAdminApproveTask))
+   router.HandleFunc("POST /api/admin/tasks/{id}/reject", a.requireAdmin(a.apiAdminRejectTask))
+   router.HandleFunc("GET /api/admin/quarantine", a.requireAdmin(a.apiAdminListQuarantine))
+   router.HandleFunc("GET /api/admin/quarantine/{id}/file", a.requireAdmin(a.apiAdminQuarantineFile))
+   router.HandleFunc("POST /api/admin/quarantine/{id}/release", a.requireAdmin(a.apiAdminReleaseQuarantine))
+   router.HandleFunc("DELETE /api/admin/quarantine/{id}", a.requireAdmin(a.apiAdminDeleteQuarantine))
+   router.HandleFunc("GET /api/admin/moderation", a.requireAdmin(a.apiAdminModeration))
+   router.HandleFunc("GET /api/admin/moderation/log", a.requireAdmin(a.apiAdminModerationLog))
+   router.HandleFunc("POST /api/admin/moderation/reload", a.requireAdmin(a.apiAdminReloadModeration))
+   router.HandleFunc("POST /api/admin/config/reload", a.requireAdmin(a.apiAdminReloadConfig))
+   router.HandleFunc("GET /api/admin/workspaces", a.requireAdmin(a.apiAdminListWorkspaces))
+   router.HandleFunc("GET /api/admin/users", a.requireAdmin(a.apiAdminListUsers))
+   router.HandleFunc("PUT /api/admin/users/{id}", a.requireAdmin(a.apiAdminUpdateUser))
+
+   // MCP (SSE transport)
+   router.HandleFunc("GET /mcp/sse", a.requireAuth(a.serveMCPSSE))
+   router.HandleFunc("POST /mcp/message", a.requireAuth(a.serveMCPMessage))
+
+   // OpenAI Images API 相容層 (SDK 的 base URL 設為 <伺服器>/v1)
+   router.HandleFunc("POST /v1/images/generations", a.requireRole(roleCreator, a.apiOpenAIImageGenerations))
 
-/*
-   // App router
-   router.HandleFunc("GET /api/notes", GetAll)
-   router.HandleFunc("POST /api/notes", Post)
+   // Automatic1111 (stable-diffusion-webui) 相容 API
+   router.HandleFunc("POST /sdapi/v1/txt2img", a.requireRole(roleCreator, a.apiA1111Txt2Img))
+   router.HandleFunc("GET /sdapi/v1/sd-models", a.requireAuth(a.apiA1111Models))
 
-   router.Handle("/homepage", oauth.Protect(http.HandlerFunc(Home)))
-   router.Handle("/upload", oauth.Protect(http.HandlerFunc(Upload)))
-*/	
-   fmt.Println("Router initialized")
+   slog.Info("Router initialized")
    return router
 }
\ No newline at end of file
@@ -0,0 +1,269 @@
+// safety.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- 內容安全檢查 ---
+// 生成完成後、存進儲存後端之前檢查圖片：
+//   SAFETY_CHECK=script：執行 python <SAFETY_SCRIPT> --image <path> (在 ZIMAGE_DIR 下)，最後一行輸出 JSON
+//   SAFETY_CHECK=http：POST 圖片 (image/png) 到 SAFETY_URL，回應 JSON；SAFETY_API_KEY 以 Bearer 帶上
+// 結果格式：{"flagged": true, "score": 0.93, "labels": ["nudity"]}，flagged 或 score >= SAFETY_THRESHOLD 視為不安全。
+// SAFETY_MODE=warn (預設) 只記錄在任務上；block 時圖片存進隔離區 (quarantine/)，任務以 fail_reason=safety 失敗，
+// 由管理員檢視後放行或刪除。檢查本身失敗時 block 模式一律隔離
+
+const quarantinePrefix = "quarantine/"
+
+// 隔離區中的儲存 key
+func quarantineKey(key string) string {
+	return quarantinePrefix + key
+}
+
+type SafetyResult struct {
+	Flagged bool     `json:"flagged"`
+	Score   *float64 `json:"score"`
+	Labels  []string `json:"labels"`
+}
+
+type SafetyChecker interface {
+	Classify(ctx context.Context, imagePath string) (*SafetyResult, error)
+}
+
+// 依設定 SAFETY_CHECK 選擇檢查方式，未設定時回傳 nil (不檢查)
+func newSafetyChecker(cfg *Config) (SafetyChecker, error) {
+	switch cfg.SafetyCheck {
+	case "":
+		return nil, nil
+	case "script":
+		script := os.Getenv("SAFETY_SCRIPT")
+		if script == "" {
+			script = "safety_check.py"
+		}
+		return &scriptSafetyChecker{Runtime: cfg.SafetyPython, Script: script}, nil
+	case "http":
+		return &httpSafetyChecker{URL: os.Getenv("SAFETY_URL"), APIKey: os.Getenv("SAFETY_API_KEY"), Client: &http.Client{Timeout: 60 * time.Second}}, nil
+	}
+	return nil, fmt.Errorf("unknown safety check: %s", cfg.SafetyCheck)
+}
+
+func safetyThreshold() float64 {
+	f, err := strconv.ParseFloat(os.Getenv("SAFETY_THRESHOLD"), 64)
+	if err != nil || f <= 0 || f > 1 {
+		return 0.5
+	}
+	return f
+}
+
+// --- 分類腳本 ---
+type scriptSafetyChecker struct {
+	Runtime pythonRuntime
+	Script  string
+}
+
+func (c *scriptSafetyChecker) Classify(ctx context.Context, imagePath string) (*SafetyResult, error) {
+	cmd := c.Runtime.command(ctx, gpuFrom(ctx), c.Script, "--image", imagePath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &GenerationError{Message: fmt.Sprintf("safety check error: %v", err), Log: stderr.String()}
+	}
+	// 模型載入等 log 可能印在 stdout，只解析最後一行
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	var result SafetyResult
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &result); err != nil {
+		return nil, fmt.Errorf("safety check output: %w", err)
+	}
+	return &result, nil
+}
+
+// --- 外部 API ---
+type httpSafetyChecker struct {
+	URL    string
+	APIKey string
+	Client *http.Client
+}
+
+func (c *httpSafetyChecker) Classify(ctx context.Context, imagePath string) (*SafetyResult, error) {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, f)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "image/png")
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("safety api returned %d: %s", resp.StatusCode, truncateHead(string(body), 200))
+	}
+	var result SafetyResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("safety api response: %w", err)
+	}
+	return &result, nil
+}
+
+// 檢查生成結果並記錄在任務上；只有取消、逾時時回傳錯誤 (照生成失敗處理)。
+// 多張輸出時逐張檢查，任務記錄最嚴重的結果 (flagged > error > safe)、最高分數與所有標籤，
+// 任何一張不安全時整個任務隔離
+func (a *App) checkSafety(ctx context.Context, task *Task, imagePaths []string) error {
+	task.SafetyVerdict, task.SafetyScore, task.SafetyLabels, task.Quarantined = "", nil, "", false
+	logger := loggerFrom(ctx)
+	var labels []string
+	for index, imagePath := range imagePaths {
+		result, err := a.Safety.Classify(ctx, imagePath)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			if task.SafetyVerdict != "flagged" {
+				task.SafetyVerdict = "error"
+			}
+			task.Quarantined = task.Quarantined || a.Config.SafetyMode == "block"
+			logger.Warn("Safety check error", "index", index, "error", err, "quarantined", task.Quarantined)
+			continue
+		}
+		if result.Score != nil && (task.SafetyScore == nil || *result.Score > *task.SafetyScore) {
+			task.SafetyScore = result.Score
+		}
+		for _, label := range result.Labels {
+			if !slices.Contains(labels, label) {
+				labels = append(labels, label)
+			}
+		}
+		task.SafetyLabels = strings.Join(labels, ",")
+		if result.Flagged || (result.Score != nil && *result.Score >= a.Config.SafetyThreshold) {
+			task.SafetyVerdict = "flagged"
+			task.Quarantined = a.Config.SafetyMode == "block"
+			logger.Warn("Image flagged by content filter", "index", index, "score", result.Score, "labels", strings.Join(result.Labels, ","), "quarantined", task.Quarantined)
+			continue
+		}
+		if task.SafetyVerdict == "" {
+			task.SafetyVerdict = "safe"
+		}
+	}
+	return nil
+}
+
+// --- 管理 API：隔離區 ---
+
+// 解析路徑中的 id 並讀取隔離中的任務
+func (a *App) loadQuarantinedTask(w http.ResponseWriter, r *http.Request) (*Task, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid task id")
+		return nil, false
+	}
+	var task Task
+	if err := a.DB.Where("quarantined = ?", true).First(&task, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, "task not found in quarantine")
+		} else {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	return &task, true
+}
+
+// GET /api/admin/quarantine?limit=50
+func (a *App) apiAdminListQuarantine(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = min(n, 200)
+	}
+	var tasks []Task
+	if err := a.DB.Where("quarantined = ?", true).Order("updated_at desc").Limit(limit).Find(&tasks).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+// GET /api/admin/quarantine/{id}/file?size=thumb
+func (a *App) apiAdminQuarantineFile(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadQuarantinedTask(w, r)
+	if !ok {
+		return
+	}
+	a.serveTaskImage(w, r, task, quarantinePrefix)
+}
+
+// POST /api/admin/quarantine/{id}/release：移回一般位置，任務改為 Completed
+func (a *App) apiAdminReleaseQuarantine(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadQuarantinedTask(w, r)
+	if !ok {
+		return
+	}
+	if err := a.loadTaskImages(task); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, key := range task.imageKeys() {
+		if err := a.storageFor(task).Move(r.Context(), quarantineKey(key), key); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	// 保留原本的結束時間
+	fields := map[string]interface{}{"quarantined": false, "fail_reason": "", "error_message": "", "storage_key": task.ImagePath, "finished_at": task.FinishedAt}
+	ok, err := a.transitionTask(r.Context(), task.ID, task.Status, StatusCompleted, "released", fields, transitionGuard{Quarantined: true})
+	if err == nil && !ok {
+		err = &codedError{Code: "conflict", Message: "task was changed by another request"}
+	}
+	if err == nil {
+		err = a.DB.Model(&TaskImage{}).Where("task_id = ?", task.ID).Update("storage_key", gorm.Expr("image_path")).Error
+	}
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	task.Quarantined = false
+	task.Status, task.FailReason, task.ErrorMessage = StatusCompleted, "", ""
+	task.StorageKey = task.ImagePath
+	loggerFrom(r.Context()).Info("Quarantined image released", "task_id", task.ID)
+	a.auditTask(r.Context(), "quarantine.released", task, task.SafetyLabels)
+	a.publishTask(r.Context(), EventTaskFinished, *task)
+	writeJSON(w, http.StatusOK, task)
+}
+
+// DELETE /api/admin/quarantine/{id}：永久刪除任務與圖片
+func (a *App) apiAdminDeleteQuarantine(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadQuarantinedTask(w, r)
+	if !ok {
+		return
+	}
+	if err := a.purgeTask(r.Context(), task); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
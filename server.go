@@ -1,259 +1,476 @@
 package main
 
 import (
-	"os"
-	"fmt"
-	"log"
-	"sync"
-	"time"
-	"net/http"
-	"os/exec"
+	"context"
 	"encoding/json"
-	"path/filepath"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
 
-	"github.com/asccclass/sherryserver"
-	"github.com/gorilla/websocket"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"github.com/joho/godotenv"
-	"gorm.io/gorm/clause"
-	"gorm.io/gorm/logger"
 )
 
-// --- 1. 資料庫模型 (SQLite) ---
+// --- 1. 資料庫模型 ---
 type Task struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	Prompt    string    `json:"prompt"`
-	Status    string    `json:"status"` // Pending, Processing, Completed, Failed
+	OriginalPrompt string `json:"original_prompt,omitempty"` // 以 LLM 擴寫時使用者原本輸入的 prompt (Prompt 為擴寫後)，見 enhance.go
+	GenerationParams `gorm:"embedded"`
+	Status    TaskStatus `json:"status"` // AwaitingApproval, Pending, Retrying, Processing, Completed, Failed, Cancelled，見 taskstate.go
+	FailReason string   `json:"fail_reason,omitempty"` // Failed 的原因：timeout、error、rejected (審核未通過)、expired 等
+	ErrorMessage string `json:"error_message,omitempty"` // 失敗訊息
+	ErrorLog  string    `json:"error_log,omitempty"`   // 生成後端輸出的最後一段
+	InitImagePath string `json:"init_image_path,omitempty"` // img2img 的原始圖片 (UPLOAD_DIR 下的檔名)
+	MaskPath  string    `json:"mask_path,omitempty"`       // inpainting 遮罩，白色為要重畫的區域
+	ControlImagePath string `json:"control_image_path,omitempty"` // ControlNet 的條件圖 (UPLOAD_DIR 下的檔名)，見 controlnet.go
 	ImagePath string    `json:"image_path"`
+	ThumbnailPath string `json:"thumbnail_path"`
+	UpscaledPath string `json:"upscaled_path,omitempty"` // 放大後的圖片，原圖仍在 ImagePath
+	StorageKey string   `json:"storage_key,omitempty"` // 儲存後端的 object key
+	ImageURL  string    `json:"image_url,omitempty"`   // 儲存後端提供的公開網址
+	CreatedBy string    `json:"created_by"` // 建立任務的 API key 名稱
+	UserID    uint      `gorm:"index" json:"user_id"`
+	WorkspaceID *uint   `gorm:"index" json:"workspace_id,omitempty"` // 建立時選擇的工作區，nil 代表個人任務，見 workspace.go
+	ScheduledAt *time.Time `gorm:"index" json:"scheduled_at"` // 指定時間之後才會被執行，nil 代表立即
+	ExpiresAt *time.Time `gorm:"index" json:"expires_at,omitempty"` // 超過時間還沒開始執行就標記為 Failed (fail_reason=expired)，見 expiry.go
+	BatchID   *uint     `gorm:"index" json:"batch_id,omitempty"`
+	ComparisonID *uint  `gorm:"index" json:"comparison_id,omitempty"` // A/B 比較的兩個任務，見 compare.go
+	UsedSeed  *int64    `json:"used_seed,omitempty"` // 實際使用的 seed (隨機 seed 時由生成後端回報)
+	ParentTaskID *uint  `gorm:"index" json:"parent_task_id,omitempty"` // 由哪個任務衍生 (variations)，見 lineage.go
+	CallbackURL string  `json:"callback_url,omitempty"` // 完成或失敗時 POST 通知的網址
+	TelegramChatID *int64 `gorm:"index" json:"telegram_chat_id,omitempty"` // 由 Telegram bot 建立時的聊天室，完成時回覆，見 telegram.go
+	TelegramMessageID int `json:"-"` // 要回覆的 /imagine 訊息
+	OriginURL string    `json:"-"` // 建立任務時的對外網址，沒有 PUBLIC_URL 時用來組 webhook 與 Slack 通知的圖片連結，見 proxy.go
+	CacheKey  string    `gorm:"size:64;index" json:"-"`   // 生成結果快取的鍵，見 cache.go
+	CachedFrom *uint    `json:"cached_from,omitempty"`   // 沿用其他任務的圖片時為來源任務 ID
+	ClaimedBy string    `gorm:"index" json:"claimed_by,omitempty"` // 認領任務的 process (WORKER_ID)
+	LeaseExpiresAt *time.Time `gorm:"index" json:"lease_expires_at,omitempty"` // 執行中定期延長，過期代表 worker 已經不在
+	Attempts  int       `json:"attempts,omitempty"` // 被 worker 認領的次數，見 watchdog.go
+	GPUSeconds float64  `json:"gpu_seconds,omitempty"`  // 生成與放大的秒數 (多次認領時累加)，見 usage.go
+	PixelSteps int64    `json:"pixel_steps,omitempty"`  // 寬 × 高 × steps × 張數
+	WallSeconds float64 `json:"wall_seconds,omitempty"` // worker 處理的總秒數
+	QueuedAt  *time.Time `json:"queued_at,omitempty"`  // 進入佇列的時間 (排程任務為排程時間)，重新排隊時更新，見 timeline.go
+	StartedAt *time.Time `json:"started_at,omitempty"` // 被 worker 認領的時間
+	FinishedAt *time.Time `json:"finished_at,omitempty"` // 結束 (Completed、Failed、Cancelled) 的時間
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"` // 移到垃圾桶的時間 (soft delete)，見 trash.go
+	PromptFlags string   `json:"prompt_flags,omitempty"` // PROMPT_DENYLIST_MODE=flag 時符合的禁用詞規則，見 moderation.go
+	SafetyVerdict string `json:"safety_verdict,omitempty"` // 內容安全檢查結果：safe、flagged 或 error，見 safety.go
+	SafetyScore *float64 `json:"safety_score,omitempty"`
+	SafetyLabels string  `json:"safety_labels,omitempty"` // 分類器回傳的標籤，以逗號分隔
+	Quarantined bool     `gorm:"index" json:"quarantined,omitempty"` // 圖片在隔離區 (quarantine/)，只有管理員可以查看
+	ContentType string   `json:"content_type,omitempty"` // 圖片的 MIME type (依 format 轉換後)，見 imageformat.go
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
-}
-
-var db *gorm.DB
 
-// --- 2. WebSocket 管理 ---
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+	// 不存資料庫，回傳排隊中的任務時才計算
+	QueuePosition int `gorm:"-" json:"queue_position,omitempty"` // 1 代表下一個執行
+	ETASeconds    int `gorm:"-" json:"eta_seconds,omitempty"`    // 預估完成前的秒數，沒有生成紀錄時省略
+	Force         bool `gorm:"-" json:"-"`                      // 建立時略過生成結果快取
+	Enhance       bool `gorm:"-" json:"-"`                      // 建立時先以 LLM 擴寫 prompt
+	Timeline      *TaskTimeline `gorm:"-" json:"timeline,omitempty"` // 只有 GET /api/tasks/{id} 才帶
+	Images        []TaskImage `gorm:"-" json:"images,omitempty"`     // 所有生成的圖片 (task_images)，以 loadTaskImages 讀取
+	Tags          []string    `gorm:"-" json:"tags,omitempty"`       // 標籤名稱 (task_tags)，以 loadTaskTags 讀取，見 tags.go
 }
 
-// 用來管理所有連線的 Clients，以便廣播訊息
-var clients = make(map[*websocket.Conn]bool)
-var broadcast = make(chan []byte)
-var mutex = &sync.Mutex{}
+// --- 2. WebSocket 訊息 ---
 
 // 前端傳來的訊息格式
 type WSMessage struct {
-	Type   string `json:"type"`   // "create_task", "get_history"
-	Prompt string `json:"prompt"` // 用於 create_task
+	Type    string `json:"type"`     // "hello", "create_task", "create_batch", "get_history", "get_prompts", "cancel_task", "subscribe", "unsubscribe", "resume", "rerun_task"
+	Version int    `json:"version"`  // 用於 hello，要使用的協定版本
+	SinceSeq int64 `json:"since_seq"` // 用於 resume，最後收到的 seq
+	Overrides json.RawMessage `json:"overrides"` // 用於 rerun_task，要覆寫的欄位
+	Prompt  string `json:"prompt"`   // 用於 create_task
+	TaskID  uint   `json:"task_id"`  // 用於 cancel_task / rerun_task
+	TaskIDs []uint `json:"task_ids"` // 用於 subscribe / unsubscribe / resume
+	All     bool   `json:"all"`      // 用於 subscribe，訂閱自己的所有任務
+	ScheduledAt *time.Time `json:"scheduled_at"` // 用於 create_task，排程執行時間
+	ExpiresAt *time.Time `json:"expires_at"` // 用於 create_task / create_batch，逾期未執行就放棄
+	CallbackURL string `json:"callback_url"` // 用於 create_task / create_batch，完成時的 webhook
+	Tags    []string `json:"tags"`     // 用於 create_task / create_batch / get_history (篩選)
+	templateRef                             // 用於 create_task，以範本產生 prompt
+	Query     string `json:"query"`     // 用於 get_prompts，prompt 前綴
+	Favorites bool   `json:"favorites"` // 用於 get_prompts，只列最愛
+	Prompts []string `json:"prompts"`  // 用於 create_batch
+	Count   int      `json:"count"`    // 用於 create_batch，單一 prompt 產生的張數
+	Force   bool     `json:"force"`    // 用於 create_task / create_batch，略過生成結果快取
+	Enhance bool     `json:"enhance"`  // 用於 create_task / create_batch，先以 LLM 擴寫 prompt
+	GenerationParams                  // 用於 create_task
 }
 
-// 回傳給前端的訊息格式
-type WSResponse struct {
-	Type string      `json:"type"` // "history", "update", "new_task"
-	Data interface{} `json:"data"`
-}
-
-func handleMessages() {
-	for {
-		// 從 broadcast channel 收到訊息，推播給所有連線者
-		msg := <-broadcast
-		mutex.Lock()
-		for client := range clients {
-			err := client.WriteMessage(websocket.TextMessage, msg)
-			if err != nil {
-				client.Close()
-				delete(clients, client)
+// 任務在儲存後端的所有檔案 (原圖、縮圖、放大圖、中繼資料 JSON)；
+// 多張輸出的任務需要先以 loadTaskImages 讀取 Images，才會包含第 1 張以後的檔案
+func (t *Task) imageKeys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	cover, _ := t.image(0)
+	for _, img := range append([]TaskImage{cover}, t.Images...) {
+		names := []string{img.ImagePath, img.ThumbnailPath, img.UpscaledPath}
+		if img.ImagePath != "" {
+			names = append(names, metadataName(img.ImagePath))
+		}
+		for _, key := range names {
+			if key != "" && !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
 			}
 		}
-		mutex.Unlock()
 	}
+	return keys
 }
 
-// --- 背景 Worker (Message Queue Consumer) ---
-func taskWorker() {
-	for {
-		var task Task
-		found := false
+// 建立新任務 (寫入資料庫) 並通知所有前端，WebSocket 與 REST 共用
+// newTask 由呼叫端填入 Prompt、GenerationParams 與 CreatedBy
+func (a *App) createTask(ctx context.Context, newTask Task) (*Task, error) {
+	tasks, err := a.createTasks(ctx, []Task{newTask}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &tasks[0], nil
+}
 
-		// 修正點：接收 err 並在下方檢查
-		err := db.Transaction(func(tx *gorm.DB) error {
-			// 1. 嘗試鎖定並讀取一筆 "Pending" 的任務
-			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
-				Where("status = ?", "Pending").
-				Order("created_at asc").
-				First(&task).Error; err != nil {
-				return err
+// 一次建立多筆任務 (全部成功或全部失敗)；batch 不為 nil 時一併建立批次並連結
+func (a *App) createTasks(ctx context.Context, newTasks []Task, batch *Batch) ([]Task, error) {
+	if len(newTasks) == 0 {
+		return nil, invalidf("no tasks to create")
+	}
+	// 選擇工作區時任務屬於工作區，沿用工作區的預設模型與配額 (見 workspace.go)
+	workspace, err := a.currentWorkspace(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range newTasks {
+		if newTasks[i].Prompt == "" {
+			return nil, invalidField("prompt", "prompt is required")
+		}
+		if err := newTasks[i].GenerationParams.Validate(); err != nil {
+			return nil, err
+		}
+		if err := a.checkPromptsSyntax(&newTasks[i]); err != nil {
+			return nil, err
+		}
+		if err := a.Config.resolvePostProcess(&newTasks[i].GenerationParams); err != nil {
+			return nil, err
+		}
+		if err := a.resolveLoRAs(&newTasks[i].GenerationParams); err != nil {
+			return nil, err
+		}
+		if err := a.checkControlNet(&newTasks[i]); err != nil {
+			return nil, err
+		}
+		if err := validateCallbackURL(newTasks[i].CallbackURL); err != nil {
+			return nil, err
+		}
+		if err := validateExpiry(&newTasks[i], time.Now()); err != nil {
+			return nil, err
+		}
+		if newTasks[i].Upscale > 0 && a.Upscaler == nil {
+			return nil, invalidf("upscale is not available on this server")
+		}
+		if workspace != nil {
+			newTasks[i].WorkspaceID = &workspace.ID
+			if newTasks[i].Model == "" {
+				newTasks[i].Model = workspace.DefaultModel
 			}
-
-			// 2. 找到任務後，立即在交易內標記為 "Processing"
-			task.Status = "Processing"
-			if err := tx.Save(&task).Error; err != nil {
-				return err
+		}
+		if newTasks[i].Model == "" {
+			// 記錄實際使用的模型，方便之後重現
+			newTasks[i].Model = a.Config.Models.defaultModel()
+		}
+		if newTasks[i].Model != "" {
+			if _, err := a.Config.Models.Lookup(newTasks[i].Model); err != nil {
+				if errors.Is(err, errModelsNotConfigured) {
+					return nil, invalidf("model selection is not available on this server")
+				}
+				return nil, err
 			}
-			found = true
-			return nil
-		})
-
-		// 修正點：這裡加入對 err 的檢查 (雖然主要邏輯依賴 found，但印出錯誤有助於除錯)
-		if err != nil && err != gorm.ErrRecordNotFound {
-			log.Printf("Database transaction error: %v", err)
 		}
-
-		if found {
-			// --- 交易已提交，鎖已釋放 ---
-			
-			// 通知前端
-			notifyUpdate(task)
-
-			// 3. 執行 Python 生成
-			log.Printf("Processing Task ID %d: %s", task.ID, task.Prompt)
-			imagePath, genErr := runPythonZImage(task.Prompt, task.ID) // 注意變數名稱避免衝突
-
-			// 4. 更新最終結果
-			if genErr != nil {
-				task.Status = "Failed"
-				log.Printf("Task %d failed: %v", task.ID, genErr)
-			} else {
-				task.Status = "Completed"
-				task.ImagePath = imagePath
-				log.Printf("Task %d completed", task.ID)
+		if newTasks[i].NumImages == 1 {
+			// 與沒有設定相同，快取鍵才會一致
+			newTasks[i].NumImages = 0
+		}
+		if newTasks[i].Format == "png" {
+			newTasks[i].Format = ""
+		}
+		if len(newTasks[i].Tags) > 0 {
+			tags, err := normalizeTags(newTasks[i].Tags)
+			if err != nil {
+				return nil, err
 			}
-			db.Save(&task)
-			notifyUpdate(task)
-
+			newTasks[i].Tags = tags
+		}
+		if (newTasks[i].CallbackURL != "" || a.Config.Notify.configured()) && newTasks[i].OriginURL == "" {
+			newTasks[i].OriginURL = externalURLFrom(ctx)
+		}
+		newTasks[i].ID = 0
+		newTasks[i].Status = StatusPending
+	}
+	if err := a.enhancePrompts(ctx, newTasks); err != nil {
+		return nil, err
+	}
+	if err := a.moderatePrompts(ctx, newTasks); err != nil {
+		return nil, err
+	}
+	if err := a.applyResultCache(newTasks); err != nil {
+		return nil, err
+	}
+	pending := 0
+	now := time.Now()
+	for i := range newTasks {
+		task := &newTasks[i]
+		queuedAt := now
+		if task.ScheduledAt != nil && task.ScheduledAt.After(now) {
+			queuedAt = *task.ScheduledAt
+		}
+		if task.Status == StatusPending && a.needsApproval(task) {
+			// 等待管理員核准，見 approval.go
+			task.Status = StatusAwaitingApproval
+			a.applyDefaultExpiry(task, queuedAt)
+			pending++
+		} else if task.Status == StatusPending {
+			pending++
+			task.QueuedAt = &queuedAt
+			a.applyDefaultExpiry(task, queuedAt)
 		} else {
-			// 沒有任務，休息一下
-			time.Sleep(2 * time.Second)
+			// 快取命中，直接完成
+			task.FinishedAt = &now
 		}
 	}
-}
 
-func notifyUpdate(task Task) {
-	resp := WSResponse{Type: "update", Data: task}
-	jsonResp, _ := json.Marshal(resp)
-	broadcast <- jsonResp
-}
-
-// 呼叫 Python 腳本
-func runPythonZImage(prompt string, id uint) (string, error) {
-	// 定義輸出路徑
-	fileName := fmt.Sprintf("task_%d_%d.png", id, time.Now().Unix())
-	outputDir := os.Getenv("DocumentRoot") + "/images"
-	os.MkdirAll(outputDir, os.ModePerm)
-	
-	// 使用絕對路徑
-	absOutputDir, _ := filepath.Abs(outputDir)
-	absOutputPath := filepath.Join(absOutputDir, fileName)
-
-	// 設定 Z-Image 專案路徑 (請修改為您的實際路徑)
-	zImageProjectDir := "./Z-Image" 
-	scriptPath := filepath.Join(zImageProjectDir, "run_z_image.py")
-
-	cmd := exec.Command("python", scriptPath, "--prompt", prompt, "--output", absOutputPath)
-	cmd.Dir = zImageProjectDir // 設定工作目錄
-
-	output, err := cmd.CombinedOutput()
+	a.quotaMutex.Lock()
+	if err := a.checkQuota(newTasks[0].UserID, pending); err != nil {
+		a.quotaMutex.Unlock()
+		return nil, err
+	}
+	if err := a.checkWorkspaceQuota(workspace, pending); err != nil {
+		a.quotaMutex.Unlock()
+		return nil, err
+	}
+	err = a.Tasks.Create(ctx, newTasks, batch)
+	a.quotaMutex.Unlock()
 	if err != nil {
-		return "", fmt.Errorf("python error: %v, log: %s", err, string(output))
+		return nil, err
+	}
+	logger := loggerFrom(ctx)
+	if err := a.recordPrompts(newTasks); err != nil {
+		logger.Warn("Record prompt history failed", "error", err)
+	}
+	// 同一次建立的任務在佇列中相鄰，只需要查詢第一筆 Pending 任務的位置
+	first, n := -1, 0
+	for i := range newTasks {
+		if newTasks[i].Status != StatusPending {
+			continue
+		}
+		if first < 0 {
+			first = i
+			a.fillQueueInfo(&newTasks[i])
+		} else if pos := newTasks[first].QueuePosition; pos > 0 {
+			newTasks[i].QueuePosition = pos + n
+			newTasks[i].ETASeconds = etaSeconds(a.estimateWait(pos + n))
+		}
+		n++
+	}
+	for _, task := range newTasks {
+		if task.CachedFrom != nil {
+			logger.Info("Task served from result cache", "task_id", task.ID, "cached_from", *task.CachedFrom, "user", task.CreatedBy)
+		} else {
+			logger.Info("Task created", "task_id", task.ID, "user", task.CreatedBy)
+		}
+		a.publishTask(ctx, EventTaskCreated, task)
+		if task.CachedFrom != nil {
+			// 快取命中的任務建立時就已經結束
+			a.publishTask(ctx, EventTaskFinished, task)
+		}
+	}
+	// 排程的任務等時間到時由 worker 掃描認領
+	var ready []uint
+	for _, task := range newTasks {
+		if task.Status == StatusPending && (task.ScheduledAt == nil || !task.ScheduledAt.After(now)) {
+			ready = append(ready, task.ID)
+		}
 	}
-	return fileName, nil // 回傳檔案名稱給前端使用
+	a.signalDispatch(ready...)
+	return newTasks, nil
 }
 
-
 // --- WebSocket 處理邏輯 ---
-func serveWs(w http.ResponseWriter, r *http.Request) {
-	ws, err := upgrader.Upgrade(w, r, nil)
+func (a *App) serveWs(w http.ResponseWriter, r *http.Request) {
+	ws, err := a.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println(err)
+		loggerFrom(r.Context()).Warn("WebSocket upgrade failed", "error", err)
 		return
 	}
 	defer ws.Close()
 
-	// 註冊連線
-	mutex.Lock()
-	clients[ws] = true
-	mutex.Unlock()
+	identity := identityFromRequest(r)
+
+	// 註冊連線，每個連線有自己的 client_id 方便追蹤 log
+	client := newWSClient(a.Hub, ws, identity)
+	client.describe(r, "ws")
+	defer client.close()
+	logger := loggerFrom(r.Context()).With("client_id", client.id)
+	ctx := withLogger(r.Context(), logger)
+	logger.Info("WebSocket connected", "user", identity.keyName())
+	a.audit(ctx, "auth.login", "", "", "websocket")
+	defer logger.Info("WebSocket disconnected")
+	a.Hub.register(client)
+	defer a.Hub.unregister(client)
+	go client.writePump()
+	// 連線時先告知協定版本與佇列是否暫停
+	client.writeHello()
+	client.writeJSON(WSQueueState{Type: "queue_state", QueueState: a.queueStatus()})
+
+	// 收到 pong 或任何訊息就延長讀取期限，半開的連線會在 wsPongWait 後被移除
+	ws.SetReadLimit(wsMaxMessageSize)
+	ws.SetReadDeadline(time.Now().Add(wsPongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
 
 	for {
-		var msg WSMessage
-		// 讀取 JSON 訊息
-		err := ws.ReadJSON(&msg)
+		// 讀取 JSON 訊息；格式錯誤時回報錯誤並繼續讀取，不中斷連線
+		msg, err := client.readMessage()
+		var invalid *codedError
+		if errors.As(err, &invalid) {
+			ws.SetReadDeadline(time.Now().Add(wsPongWait))
+			client.writeError(err)
+			continue
+		}
 		if err != nil {
-			mutex.Lock()
-			delete(clients, ws)
-			mutex.Unlock()
 			break
 		}
+		ws.SetReadDeadline(time.Now().Add(wsPongWait))
+		// 建立與取消任務需要 creator 角色 (見 rbac.go)
+		if err := identity.checkMessageRole(msg.Type); err != nil {
+			client.writeError(err)
+			continue
+		}
+
+		if msg.Type == "hello" {
+			client.negotiate(msg.Version)
 
-		if msg.Type == "get_history" {
-			// 讀取最近 20 筆任務
+		} else if msg.Type == "get_history" {
+			// 讀取自己最近 20 筆任務，帶 tags 時只列出同時具有這些標籤的任務
+			q, err := whereTagged(identity.taskScope(a.DB), msg.Tags)
+			if err != nil {
+				client.writeError(err)
+				continue
+			}
 			var tasks []Task
-			db.Order("created_at desc").Limit(20).Find(&tasks)
+			q.Order("created_at desc").Limit(20).Find(&tasks)
+			a.loadTaskListImages(tasks)
+			a.loadTaskListTags(tasks)
 			resp := WSResponse{Type: "history", Data: tasks}
-			ws.WriteJSON(resp)
+			client.writeJSON(resp)
 
-		} else if msg.Type == "create_task" {
-			// 建立新任務 (寫入 SQLite)
-			newTask := Task{
-				Prompt: msg.Prompt,
-				Status: "Pending",
+		} else if msg.Type == "get_prompts" {
+			// 最近用過的 prompt (最愛優先)，供自動完成
+			prompts, err := a.recentPrompts(identity, msg.Query, msg.Favorites, 20)
+			if err != nil {
+				client.writeError(err)
+				continue
 			}
-			db.Create(&newTask)
+			client.writeJSON(WSResponse{Type: "prompts", Data: prompts})
 
-			// 通知所有前端有新任務
-			resp := WSResponse{Type: "new_task", Data: newTask}
-			jsonResp, _ := json.Marshal(resp)
-			broadcast <- jsonResp
-		}
-	}
-}
+		} else if msg.Type == "create_task" {
+			// 建立新任務，建立者自動訂閱該任務
+			prompt := strings.TrimSpace(msg.Prompt)
+			if err := a.applyTemplate(identity, msg.templateRef, &prompt, &msg.GenerationParams); err != nil {
+				client.writeError(err)
+				continue
+			}
+			task, err := a.createTask(ctx, Task{
+				Prompt:           prompt,
+				GenerationParams: msg.GenerationParams,
+				ScheduledAt:      msg.ScheduledAt,
+				ExpiresAt:        msg.ExpiresAt,
+				CallbackURL:      strings.TrimSpace(msg.CallbackURL),
+				CreatedBy:        identity.keyName(),
+				UserID:           identity.userID(),
+				Force:            msg.Force,
+				Enhance:          msg.Enhance,
+				Tags:             msg.Tags,
+			})
+			if err != nil {
+				client.writeError(err)
+				continue
+			}
+			client.subscribe(a.DB, []uint{task.ID}, false)
+			// 訂閱前 worker 可能已開始處理，重新讀取最新狀態再回傳
+			a.DB.First(task, task.ID)
+			client.writeJSON(WSResponse{Type: "new_task", Data: task})
+
+		} else if msg.Type == "create_batch" {
+			// 建立批次，建立者自動訂閱所有成員任務
+			_, tasks, err := a.createBatch(ctx, batchRequest{
+				Prompts:          msg.Prompts,
+				Prompt:           msg.Prompt,
+				Count:            msg.Count,
+				ScheduledAt:      msg.ScheduledAt,
+				ExpiresAt:        msg.ExpiresAt,
+				CallbackURL:      msg.CallbackURL,
+				Force:            msg.Force,
+				Enhance:          msg.Enhance,
+				Tags:             msg.Tags,
+				GenerationParams: msg.GenerationParams,
+			}, identity)
+			if err != nil {
+				client.writeError(err)
+				continue
+			}
+			ids := make([]uint, len(tasks))
+			for i, task := range tasks {
+				ids[i] = task.ID
+			}
+			client.subscribe(a.DB, ids, false)
+			var current []Task
+			a.DB.Where("id IN ?", ids).Order("id asc").Find(&current)
+			for _, task := range current {
+				client.writeJSON(WSResponse{Type: "new_task", Data: task})
+			}
 
-func main() {
-   if err := godotenv.Load("envfile"); err != nil {
-      fmt.Println(err.Error())
-      return
-   }
-	// 初始化 SQLite
-	var err error
-	db, err = gorm.Open(sqlite.Open(os.Getenv("DBPath") +"queue.db"), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Silent), // 設定為靜音模式
-	})
-	if err != nil {
-		log.Fatal("failed to connect database", err)
-	}
-	// 自動建立資料表
-	db.AutoMigrate(&Task{})
+		} else if msg.Type == "rerun_task" {
+			// 複製既有任務 (可覆寫部分欄位) 重新生成，建立者自動訂閱新任務
+			var src Task
+			if err := identity.taskScope(a.DB).First(&src, msg.TaskID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					err = &codedError{Code: "not_found", Message: "task not found"}
+				}
+				client.writeError(err)
+				continue
+			}
+			task, err := a.rerunTask(ctx, &src, msg.Overrides, msg.Force, identity)
+			if err != nil {
+				client.writeError(err)
+				continue
+			}
+			client.subscribe(a.DB, []uint{task.ID}, false)
+			a.DB.First(task, task.ID)
+			client.writeJSON(WSResponse{Type: "new_task", Data: task})
+
+		} else if msg.Type == "cancel_task" {
+			// 取消任務，狀態變更會透過 update 推播
+			if task, err := a.cancelTask(msg.TaskID, identity); errors.Is(err, gorm.ErrRecordNotFound) {
+				client.writeError(&codedError{Code: "not_found", Message: "task not found"})
+			} else if err != nil {
+				client.writeError(err)
+			} else {
+				a.auditTask(ctx, "task.cancelled", task, "")
+			}
 
-	// 啟動背景 Worker (處理佇列)
-	go taskWorker()
+		} else if msg.Type == "subscribe" {
+			client.subscribe(a.DB, msg.TaskIDs, msg.All)
 
-	// 啟動 WebSocket 廣播監聽器
-	go handleMessages()
+		} else if msg.Type == "unsubscribe" {
+			client.unsubscribe(msg.TaskIDs, msg.All)
 
-	// 初始化 Web Server
-   port := os.Getenv("PORT")
-   if port == "" {
-      port = "80"
-   }
-   documentRoot := os.Getenv("DocumentRoot")
-   if documentRoot == "" {
-      documentRoot = "www/html"
-   }
-   templateRoot := os.Getenv("TemplateRoot")
-   if templateRoot == "" {
-      templateRoot = "www/template"
-   }
+		} else if msg.Type == "resume" {
+			// 重連後補送離線期間的更新
+			a.resumeClient(client, msg.SinceSeq, msg.TaskIDs, msg.All)
 
-   server, err := SherryServer.NewServer(":" + port, documentRoot, templateRoot)
-   if err != nil {
-      panic(err)
-   }
-   router := NewRouter(server, documentRoot)
-   if router == nil {
-      fmt.Println("router return nil")
-      return
-   }
-   server.Server.Handler = router  // server.CheckCROS(router)  // 需要自行implement, overwrite 預設的
-   server.Start()
+		} else {
+			client.writeError(newCodedError("unknown_type", "unknown message type %q", msg.Type))
+		}
+	}
 }
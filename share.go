@@ -0,0 +1,282 @@
+// share.go
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- 公開分享連結 ---
+// POST /api/tasks/{id}/share 產生一個無法猜測的 token，GET /share/{token} 不需驗證即可看到圖片與簡單的參數頁面，
+// GET /share/{token}/image 回傳圖片本身 (與 /api/images/{id}/file 相同的 size、index 參數)。
+// 資料庫只存 token 的雜湊，網址只在建立時回傳一次；連結可以設定到期時間，也可以隨時撤銷。
+// 只有 Completed 且沒有被隔離的任務可以分享；任務移到垃圾桶後連結失效，還原後恢復，永久刪除時一併刪除
+
+type ShareLink struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	TaskID     uint       `gorm:"index" json:"task_id"`
+	UserID     uint       `gorm:"index" json:"user_id"`
+	CreatedBy  string     `json:"created_by"`
+	TokenHash  string     `gorm:"size:64;uniqueIndex" json:"-"`
+	HidePrompt bool       `json:"hide_prompt,omitempty"` // 分享頁面不顯示 prompt 與參數
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`  // nil 代表不會過期
+	Views      int        `json:"views"`                 // 分享頁面被開啟的次數
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// 建立分享連結的請求；expires_in (例如 72h) 與 expires_at 擇一，都沒有時不會過期
+type shareRequest struct {
+	ExpiresIn  string     `json:"expires_in,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	HidePrompt bool       `json:"hide_prompt,omitempty"`
+}
+
+// 建立分享連結的回應，url 只會出現這一次
+type shareCreated struct {
+	ShareLink
+	URL      string `json:"url"`
+	ImageURL string `json:"image_url"`
+}
+
+func (req shareRequest) expiresAt(now time.Time) (*time.Time, error) {
+	if req.ExpiresIn != "" && req.ExpiresAt != nil {
+		return nil, invalidf("expires_in and expires_at cannot be combined")
+	}
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			return nil, invalidField("expires_in", "expires_in must be a positive duration such as 72h")
+		}
+		t := now.Add(d)
+		return &t, nil
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		return nil, invalidField("expires_at", "expires_at must be in the future")
+	}
+	return req.ExpiresAt, nil
+}
+
+func newShareToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// 產生 token 並寫入連結，回傳 token (網址的最後一段)
+func (a *App) createShareLink(link *ShareLink) (string, error) {
+	token, err := newShareToken()
+	if err != nil {
+		return "", err
+	}
+	link.TokenHash = hashAPIKey(token)
+	if err := a.DB.Create(link).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// 依 token 找出連結與任務；不存在、已撤銷或任務無法分享時回 not_found，過期時回 gone
+func (a *App) resolveShare(token string) (*ShareLink, *Task, error) {
+	notFound := &codedError{Code: "not_found", Message: "share link not found"}
+	var link ShareLink
+	if err := a.DB.Where("token_hash = ?", hashAPIKey(token)).First(&link).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, notFound
+		}
+		return nil, nil, err
+	}
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		return nil, nil, &codedError{Code: "gone", Message: "share link has expired"}
+	}
+	var task Task
+	if err := a.DB.First(&task, link.TaskID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, notFound
+		}
+		return nil, nil, err
+	}
+	if task.Status != StatusCompleted || task.Quarantined {
+		return nil, nil, notFound
+	}
+	return &link, &task, nil
+}
+
+// 分享連結的錯誤以純文字回應 (瀏覽器直接開啟)
+func writeShareError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch errorCode(err) {
+	case "not_found":
+		status = http.StatusNotFound
+	case "gone":
+		status = http.StatusGone
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// POST /api/tasks/{id}/share
+func (a *App) apiCreateShare(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadTaskFromPath(w, r)
+	if !ok {
+		return
+	}
+	if task.Status != StatusCompleted {
+		writeTaskError(w, &codedError{Code: "conflict", Message: "only completed tasks can be shared"})
+		return
+	}
+	if task.Quarantined {
+		writeError(w, http.StatusForbidden, "image quarantined by content filter")
+		return
+	}
+	var req shareRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+	expiresAt, err := req.expiresAt(time.Now())
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	identity := identityFromRequest(r)
+	link := ShareLink{TaskID: task.ID, UserID: identity.userID(), CreatedBy: identity.keyName(), HidePrompt: req.HidePrompt, ExpiresAt: expiresAt}
+	token, err := a.createShareLink(&link)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.auditTask(r.Context(), "task.shared", task, "share_id="+strconv.FormatUint(uint64(link.ID), 10))
+	url := externalURLFrom(r.Context()) + "/share/" + token
+	writeJSON(w, http.StatusCreated, shareCreated{ShareLink: link, URL: url, ImageURL: url + "/image"})
+}
+
+// GET /api/tasks/{id}/shares
+func (a *App) apiListShares(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadTaskFromPath(w, r)
+	if !ok {
+		return
+	}
+	links := []ShareLink{}
+	if err := a.DB.Where("task_id = ?", task.ID).Order("id asc").Find(&links).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, links)
+}
+
+// DELETE /api/tasks/{id}/shares/{share}：撤銷連結
+func (a *App) apiDeleteShare(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadTaskFromPath(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseUint(r.PathValue("share"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid share id")
+		return
+	}
+	result := a.DB.Where("task_id = ?", task.ID).Delete(&ShareLink{}, id)
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		writeError(w, http.StatusNotFound, "share link not found")
+		return
+	}
+	a.auditTask(r.Context(), "task.unshared", task, "share_id="+r.PathValue("share"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var sharePage = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="zh-TW">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<meta name="robots" content="noindex">
+<title>{{if .Prompt}}{{.Prompt}}{{else}}Z-Image{{end}}</title>
+<meta property="og:type" content="website">
+<meta property="og:title" content="{{if .Prompt}}{{.Prompt}}{{else}}Z-Image{{end}}">
+<meta property="og:image" content="{{.ImageURL}}">
+<style>
+body { font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif; max-width: 900px; margin: 0 auto; padding: 20px; background-color: #f5f5f5; color: #333; }
+img { max-width: 100%; border-radius: 8px; box-shadow: 0 2px 5px rgba(0,0,0,0.1); }
+.images img { width: 24%; margin-right: 1%; }
+dl { display: grid; grid-template-columns: max-content auto; gap: 4px 12px; font-size: 14px; }
+dt { color: #888; }
+.prompt { font-size: 16px; word-break: break-all; }
+</style>
+</head>
+<body>
+<a href="{{.ImageURL}}"><img src="{{.ImageURL}}" alt="generated image"></a>
+{{if .Others}}<div class="images">{{range .Others}}<a href="{{$.ImageURL}}?index={{.}}"><img src="{{$.ImageURL}}?size=thumb&index={{.}}" alt="image {{.}}"></a>{{end}}</div>{{end}}
+{{if .Prompt}}<p class="prompt">{{.Prompt}}</p>
+<dl>
+{{range .Params}}<dt>{{index . 0}}</dt><dd>{{index . 1}}</dd>
+{{end}}</dl>{{end}}
+</body>
+</html>
+`))
+
+// GET /share/{token}：不需驗證的圖片與參數頁面
+func (a *App) serveSharePage(w http.ResponseWriter, r *http.Request) {
+	link, task, err := a.resolveShare(r.PathValue("token"))
+	if err != nil {
+		writeShareError(w, err)
+		return
+	}
+	a.DB.Model(link).UpdateColumn("views", gorm.Expr("views + 1"))
+	if err := a.loadTaskImages(task); err != nil {
+		writeShareError(w, err)
+		return
+	}
+	data := struct {
+		ImageURL string
+		Others   []int
+		Prompt   string
+		Params   [][2]string
+	}{ImageURL: externalURLFrom(r.Context()) + "/share/" + r.PathValue("token") + "/image"}
+	for _, img := range task.Images {
+		if img.Position > 0 {
+			data.Others = append(data.Others, img.Position)
+		}
+	}
+	if !link.HidePrompt {
+		data.Prompt = task.Prompt
+		meta := metadataFromTask(task, task.FinishedAt)
+		for _, c := range meta.textChunks(nil) {
+			// 與 PNG tEXt 相同的欄位，略過完整 JSON 與已經顯示的 prompt
+			if c[0] != "zimage:metadata" && c[0] != "prompt" && c[0] != "Software" {
+				data.Params = append(data.Params, c)
+			}
+		}
+		if task.Width > 0 && task.Height > 0 {
+			data.Params = append(data.Params, [2]string{"size", strconv.Itoa(task.Width) + "×" + strconv.Itoa(task.Height)})
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	sharePage.Execute(w, data)
+}
+
+// GET /share/{token}/image?size=thumb&index=1
+func (a *App) serveShareImage(w http.ResponseWriter, r *http.Request) {
+	_, task, err := a.resolveShare(r.PathValue("token"))
+	if err != nil {
+		writeShareError(w, err)
+		return
+	}
+	a.serveTaskImage(w, r, task, "")
+}
@@ -0,0 +1,86 @@
+// shutdown.go
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// --- Graceful Shutdown ---
+
+// 關機逾時強制中止 python 時使用的 cause，worker 會把任務放回 Pending
+var errShutdown = errors.New("server shutting down")
+
+// 等待執行中任務完成的時間，從環境變數 SHUTDOWN_TIMEOUT (秒) 取得，預設 60 秒
+func shutdownTimeout() time.Duration {
+	n, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil || n < 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(n) * time.Second
+}
+
+// 上次程式中斷時留在 Processing 的任務已經沒有 worker 在跑，改為 Retrying 放回佇列重新執行。
+// 只處理自己名下 (WORKER_ID) 與舊版沒有記錄 claimed_by 的任務，其他 process 的任務等租約過期再回收
+func (a *App) recoverOrphanedTasks() {
+	var ids []uint
+	err := a.DB.Model(&Task{}).
+		Where("status = ? AND (claimed_by IS NULL OR claimed_by IN ?)", StatusProcessing, []string{"", a.Config.WorkerID}).
+		Pluck("id", &ids).Error
+	if err != nil {
+		slog.Error("Recover orphaned tasks error", "error", err)
+		return
+	}
+	count := 0
+	for _, id := range ids {
+		ok, err := a.transitionTask(context.Background(), id, StatusProcessing, StatusRetrying, "orphaned", nil)
+		if err != nil {
+			slog.Error("Recover orphaned task error", "task_id", id, "error", err)
+			continue
+		}
+		if ok {
+			count++
+		}
+	}
+	if count > 0 {
+		slog.Info("Requeued orphaned tasks", "count", count)
+	}
+}
+
+// 停止認領新任務，等待執行中的任務結束；逾時則中止 python 並把任務放回佇列
+func (a *App) shutdownWorkers(timeout time.Duration) {
+	a.stopWorkers()
+	// 執行中的任務在結束前仍要延長租約，等 worker 都停止後才停止心跳
+	defer a.stopHeartbeat()
+
+	done := make(chan struct{})
+	go func() {
+		a.workerWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		slog.Info("All workers stopped")
+		return
+	case <-time.After(timeout):
+	}
+
+	a.runningMutex.Lock()
+	slog.Warn("Shutdown timeout, aborting running tasks", "count", len(a.runningTasks))
+	for _, cancel := range a.runningTasks {
+		cancel(errShutdown)
+	}
+	a.runningMutex.Unlock()
+
+	// 給 worker 一點時間把狀態寫回資料庫
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		slog.Warn("Workers did not stop in time")
+	}
+}
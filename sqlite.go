@@ -0,0 +1,100 @@
+// sqlite.go
+package main
+
+import (
+	"math/rand"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- SQLite 的並行設定 ---
+// 預設的 SQLite (rollback journal、沒有 busy timeout) 在 WebSocket 建立任務與 worker 認領同時寫入時
+// 很容易直接回傳 "database is locked"。連線時加上：
+//
+//	_journal_mode=WAL   讀取不會被寫入擋住
+//	_busy_timeout       等待其他連線釋放鎖 (SQLITE_BUSY_TIMEOUT，預設 5s)，而不是立即失敗
+//	_txlock=immediate   交易一開始就取得寫入鎖，避免兩個交易都從讀取升級成寫入而互相等待
+//	_synchronous=NORMAL WAL 模式下仍然安全，寫入較快
+//
+// DB_DSN 已經指定的參數不覆蓋。任務的寫入 (見 taskrepo.go) 另外經過 dbWriter：
+// SQLite 在同一個 process 內一次只寫一筆，仍然遇到 SQLITE_BUSY (例如其他 process 正在寫入) 時重試
+
+const (
+	defaultSQLiteBusyTimeout = 5 * time.Second
+	busyRetries              = 5
+	busyRetryDelay           = 50 * time.Millisecond
+)
+
+// 鎖定等待時間，從環境變數 SQLITE_BUSY_TIMEOUT 取得
+func sqliteBusyTimeout() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("SQLITE_BUSY_TIMEOUT")); err == nil && d > 0 {
+		return d
+	}
+	return defaultSQLiteBusyTimeout
+}
+
+// 在 DSN 加上並行所需的參數 (go-sqlite3 的格式，檔名後以 ? 帶參數)
+func sqliteDSN(dsn string) string {
+	path, query, _ := strings.Cut(dsn, "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return dsn
+	}
+	defaults := map[string]string{
+		"_journal_mode": "WAL",
+		"_busy_timeout": strconv.FormatInt(sqliteBusyTimeout().Milliseconds(), 10),
+		"_txlock":       "immediate",
+		"_synchronous":  "NORMAL",
+	}
+	for name, value := range defaults {
+		if params.Get(name) == "" {
+			params.Set(name, value)
+		}
+	}
+	return path + "?" + params.Encode()
+}
+
+// SQLITE_BUSY / SQLITE_LOCKED (busy timeout 用完仍拿不到鎖)
+func isBusyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "database table is locked") ||
+		strings.Contains(msg, "sqlite_busy")
+}
+
+// 任務寫入的序列化與重試；serialize 只在 SQLite 開啟 (Postgres / MySQL 可以並行寫入)
+type dbWriter struct {
+	mutex     sync.Mutex
+	serialize bool
+}
+
+func newDBWriter(driver string) *dbWriter {
+	return &dbWriter{serialize: driver == "" || driver == "sqlite"}
+}
+
+// 執行一次寫入，遇到 SQLITE_BUSY 時以遞增的間隔 (加上隨機值，避免多個 process 同時重試) 重試；
+// fn 必須可以重複執行 (交易失敗時已經 rollback)
+func (w *dbWriter) do(fn func() error) error {
+	if w == nil {
+		return fn()
+	}
+	if w.serialize {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+	}
+	delay := busyRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if !isBusyError(err) || attempt >= busyRetries {
+			return err
+		}
+		time.Sleep(delay + time.Duration(rand.Int63n(int64(delay))))
+		delay *= 2
+	}
+}
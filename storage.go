@@ -0,0 +1,225 @@
+// storage.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// --- 圖片儲存後端 ---
+
+// 生成後端一律先寫到本機 Config.ImageDir，完成後再交給 Storage 保存；
+// key 為檔名 (例如 task_1_1700000000.png)
+type Storage interface {
+	// 保存本機檔案，回傳公開網址 (沒有時為空字串)
+	Put(ctx context.Context, key, localPath string) (string, error)
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, time.Time, error)
+	Delete(ctx context.Context, key string) error
+	// 改名 (移到垃圾桶或還原)，來源不存在時不視為錯誤
+	Move(ctx context.Context, key, newKey string) error
+}
+
+// 依環境變數 STORAGE 選擇儲存後端：local (預設) 或 s3
+func newStorageFromEnv(imageDir string) (Storage, error) {
+	switch strings.ToLower(os.Getenv("STORAGE")) {
+	case "", "local":
+		return &localStorage{Dir: imageDir}, nil
+	case "s3":
+		return newS3Storage()
+	}
+	return nil, fmt.Errorf("unknown storage: %s", os.Getenv("STORAGE"))
+}
+
+// --- 本機磁碟 ---
+type localStorage struct {
+	Dir string
+}
+
+// 檔案已經在圖片目錄裡，不需要搬移；圖片一律透過 /api/images/{id}/file 存取。
+// 生成後端的輸出要放到子目錄 (工作區、隔離區) 時直接搬移，不留下副本
+func (s *localStorage) Put(ctx context.Context, key, localPath string) (string, error) {
+	target := s.path(key)
+	abs, err := filepath.Abs(target)
+	if err == nil && abs == localPath {
+		return "", nil
+	}
+	if dir, err := filepath.Abs(s.Dir); err == nil && filepath.Dir(localPath) == dir {
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return "", err
+		}
+		return "", os.Rename(localPath, target)
+	}
+	src, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return "", err
+	}
+	dst, err := os.Create(target)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return "", err
+	}
+	return "", dst.Close()
+}
+
+func (s *localStorage) Open(ctx context.Context, key string) (io.ReadSeekCloser, time.Time, error) {
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, err
+	}
+	return f, info.ModTime(), nil
+}
+
+func (s *localStorage) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *localStorage) Move(ctx context.Context, key, newKey string) error {
+	target := s.path(newKey)
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	err := os.Rename(s.path(key), target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *localStorage) path(key string) string {
+	return localKeyPath(s.Dir, key)
+}
+
+// key 對應到 dir 下的路徑：垃圾桶 (trash/...)、隔離區 (quarantine/...) 與工作區 (workspaces/<ID>/...)
+// 的檔案放在同名子目錄；先以 path.Clean 去掉 ..，避免路徑穿越
+func localKeyPath(dir, key string) string {
+	return filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(path.Clean("/"+key), "/")))
+}
+
+// --- S3 相容物件儲存 (AWS S3 / MinIO) ---
+type s3Storage struct {
+	Client    *minio.Client
+	Bucket    string
+	Prefix    string
+	PublicURL string // 例如 https://cdn.example.com/zimage，空字串代表 bucket 不公開
+	KeepLocal bool   // 上傳後是否保留本機檔案
+}
+
+// S3_ENDPOINT、S3_BUCKET、S3_ACCESS_KEY、S3_SECRET_KEY、S3_REGION、S3_USE_SSL、
+// S3_PREFIX、S3_PUBLIC_URL、S3_KEEP_LOCAL
+func newS3Storage() (*s3Storage, error) {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	bucket := os.Getenv("S3_BUCKET")
+	if endpoint == "" || bucket == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT and S3_BUCKET are required for s3 storage")
+	}
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(os.Getenv("S3_ACCESS_KEY"), os.Getenv("S3_SECRET_KEY"), ""),
+		Secure: os.Getenv("S3_USE_SSL") != "false",
+		Region: os.Getenv("S3_REGION"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3Storage{
+		Client:    client,
+		Bucket:    bucket,
+		Prefix:    strings.Trim(os.Getenv("S3_PREFIX"), "/"),
+		PublicURL: strings.TrimRight(os.Getenv("S3_PUBLIC_URL"), "/"),
+		KeepLocal: os.Getenv("S3_KEEP_LOCAL") == "true",
+	}, nil
+}
+
+func (s *s3Storage) objectName(key string) string {
+	if s.Prefix == "" {
+		return key
+	}
+	return s.Prefix + "/" + key
+}
+
+func (s *s3Storage) Put(ctx context.Context, key, localPath string) (string, error) {
+	contentType := mime.TypeByExtension(filepath.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	_, err := s.Client.FPutObject(ctx, s.Bucket, s.objectName(key), localPath, minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return "", err
+	}
+	if !s.KeepLocal {
+		os.Remove(localPath)
+	}
+	if s.PublicURL == "" {
+		return "", nil
+	}
+	return s.PublicURL + "/" + s.objectName(key), nil
+}
+
+func (s *s3Storage) Open(ctx context.Context, key string) (io.ReadSeekCloser, time.Time, error) {
+	obj, err := s.Client.GetObject(ctx, s.Bucket, s.objectName(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	// GetObject 不會實際發出請求，用 Stat 確認物件存在
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, time.Time{}, err
+	}
+	return obj, info.LastModified, nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	return s.Client.RemoveObject(ctx, s.Bucket, s.objectName(key), minio.RemoveObjectOptions{})
+}
+
+// S3 沒有改名，複製後刪除原物件
+func (s *s3Storage) Move(ctx context.Context, key, newKey string) error {
+	_, err := s.Client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: s.Bucket, Object: s.objectName(newKey)},
+		minio.CopySrcOptions{Bucket: s.Bucket, Object: s.objectName(key)})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil
+		}
+		return err
+	}
+	return s.Client.RemoveObject(ctx, s.Bucket, s.objectName(key), minio.RemoveObjectOptions{})
+}
+
+// 讓 /readyz 確認 bucket 可存取
+func (s *s3Storage) Check(ctx context.Context) error {
+	ok, err := s.Client.BucketExists(ctx, s.Bucket)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("bucket %s not found", s.Bucket)
+	}
+	return nil
+}
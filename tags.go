@@ -0,0 +1,610 @@
+// tags.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- 標籤與收藏集 ---
+// 標籤 (多對多，task_tags) 在建立任務時以 tags 指定，或之後以 PUT /api/tasks/{id}/tags 整筆取代；
+// 收藏集 (collection_tasks) 是使用者手動整理的任務清單。兩者的名稱都在同一使用者內不可重複，
+// 圖庫與歷史紀錄可以用 ?tag=a,b (同時具有所有標籤) 與 ?collection=ID 篩選
+
+const (
+	maxTagLen            = 50
+	maxTagsPerTask       = 20
+	maxCollectionNameLen = 100
+)
+
+// 標籤一律轉成小寫；Count 為使用中的任務數，只有 GET /api/tags 才帶
+type Tag struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"uniqueIndex:idx_tag_user_name" json:"user_id"`
+	Name      string    `gorm:"size:50;uniqueIndex:idx_tag_user_name" json:"name"`
+	Count     int64     `gorm:"-" json:"count"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TaskTag struct {
+	TaskID uint `gorm:"primaryKey"`
+	TagID  uint `gorm:"primaryKey;index"`
+}
+
+type Collection struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      uint      `gorm:"uniqueIndex:idx_collection_user_name" json:"user_id"`
+	Name        string    `gorm:"uniqueIndex:idx_collection_user_name" json:"name"`
+	Description string    `json:"description,omitempty"`
+	TaskCount   int64     `gorm:"-" json:"task_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type CollectionTask struct {
+	CollectionID uint      `gorm:"primaryKey"`
+	TaskID       uint      `gorm:"primaryKey;index"`
+	CreatedAt    time.Time // 加入收藏集的時間
+}
+
+type tagRequest struct {
+	Name string `json:"name"`
+}
+
+type taskTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+type collectionTasksRequest struct {
+	TaskIDs []uint `json:"task_ids"`
+}
+
+func normalizeTag(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || len(name) > maxTagLen || strings.Contains(name, ",") {
+		return "", invalidField("tags", "tag %q must be 1-%d characters without commas", name, maxTagLen)
+	}
+	return name, nil
+}
+
+// 整理標籤：轉小寫、去除重複
+func normalizeTags(names []string) ([]string, error) {
+	seen := make(map[string]bool)
+	tags := []string{}
+	for _, name := range names {
+		tag, err := normalizeTag(name)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > maxTagsPerTask {
+		return nil, invalidField("tags", "at most %d tags per task", maxTagsPerTask)
+	}
+	return tags, nil
+}
+
+// 以 names 取代任務的標籤 (在交易內呼叫)，不存在的標籤自動建立
+func setTaskTags(tx *gorm.DB, userID, taskID uint, names []string) error {
+	tags, err := normalizeTags(names)
+	if err != nil {
+		return err
+	}
+	if err := tx.Where("task_id = ?", taskID).Delete(&TaskTag{}).Error; err != nil {
+		return err
+	}
+	for _, name := range tags {
+		tag := Tag{UserID: userID, Name: name}
+		if err := tx.Where(&tag).FirstOrCreate(&tag).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&TaskTag{TaskID: taskID, TagID: tag.ID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 讀取任務的標籤 (依名稱排序) 填入 Tags；已經讀取過的任務略過
+func (a *App) loadTaskTags(tasks ...*Task) error {
+	return loadTagsFrom(a.DB, tasks...)
+}
+
+func loadTagsFrom(db *gorm.DB, tasks ...*Task) error {
+	ids := make([]uint, 0, len(tasks))
+	for _, task := range tasks {
+		if task.Tags == nil && task.ID != 0 {
+			ids = append(ids, task.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	var rows []struct {
+		TaskID uint
+		Name   string
+	}
+	err := db.Table("task_tags").Select("task_tags.task_id, tags.name").
+		Joins("JOIN tags ON tags.id = task_tags.tag_id").
+		Where("task_tags.task_id IN ?", ids).Order("tags.name asc").Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	byTask := make(map[uint][]string, len(ids))
+	for _, row := range rows {
+		byTask[row.TaskID] = append(byTask[row.TaskID], row.Name)
+	}
+	for _, task := range tasks {
+		if task.Tags == nil && task.ID != 0 {
+			task.Tags = byTask[task.ID]
+		}
+	}
+	return nil
+}
+
+// 同 loadTaskTags，用在查詢得到的任務列表
+func (a *App) loadTaskListTags(tasks []Task) error {
+	ptrs := make([]*Task, len(tasks))
+	for i := range tasks {
+		ptrs[i] = &tasks[i]
+	}
+	return a.loadTaskTags(ptrs...)
+}
+
+// 只保留同時具有所有標籤的任務
+func whereTagged(q *gorm.DB, names []string) (*gorm.DB, error) {
+	for _, name := range names {
+		tag, err := normalizeTag(name)
+		if err != nil {
+			return nil, err
+		}
+		q = q.Where("id IN (SELECT task_tags.task_id FROM task_tags JOIN tags ON tags.id = task_tags.tag_id WHERE tags.name = ?)", tag)
+	}
+	return q, nil
+}
+
+// 依 ?tag=a,b 與 ?collection=ID 篩選任務查詢
+func filterTasks(q *gorm.DB, r *http.Request) (*gorm.DB, error) {
+	tags, collectionID, err := taskFilterFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return whereTaskFilter(q, tags, collectionID)
+}
+
+// 解析 ?tag=a,b 與 ?collection=ID，collectionID 為 0 代表不限
+func taskFilterFromRequest(r *http.Request) (tags []string, collectionID uint, err error) {
+	query := r.URL.Query()
+	if s := query.Get("tag"); s != "" {
+		tags = strings.Split(s, ",")
+	}
+	if s := query.Get("collection"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || id == 0 {
+			return nil, 0, invalidField("collection", "invalid collection id")
+		}
+		collectionID = uint(id)
+	}
+	return tags, collectionID, nil
+}
+
+// 標籤與收藏集的篩選條件 (gRPC 的 ListTasks 也使用)，collectionID 為 0 代表不限
+func whereTaskFilter(q *gorm.DB, tags []string, collectionID uint) (*gorm.DB, error) {
+	if len(tags) > 0 {
+		var err error
+		if q, err = whereTagged(q, tags); err != nil {
+			return nil, err
+		}
+	}
+	if collectionID != 0 {
+		q = q.Where("id IN (SELECT task_id FROM collection_tasks WHERE collection_id = ?)", collectionID)
+	}
+	return q, nil
+}
+
+// 從任務與收藏集的關聯表移除，一併刪除留言與分享連結 (永久刪除任務時呼叫)
+func (a *App) removeTaskLinks(taskID uint) {
+	a.DB.Where("task_id = ?", taskID).Delete(&TaskTag{})
+	a.DB.Where("task_id = ?", taskID).Delete(&CollectionTask{})
+	a.DB.Where("task_id = ?", taskID).Delete(&Comment{})
+	a.DB.Where("task_id = ?", taskID).Delete(&ShareLink{})
+}
+
+// 名稱重複時回 409
+func writeNameConflict(w http.ResponseWriter, err error, message string) {
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "unique") || strings.Contains(msg, "duplicate") {
+		writeError(w, http.StatusConflict, message)
+		return
+	}
+	writeTaskError(w, err)
+}
+
+// --- 標籤 API ---
+
+// GET /api/tags
+func (a *App) apiListTags(w http.ResponseWriter, r *http.Request) {
+	var tags []Tag
+	if err := identityFromRequest(r).scope(a.DB).Order("name asc").Find(&tags).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var counts []struct {
+		TagID uint
+		Count int64
+	}
+	ids := make([]uint, len(tags))
+	for i := range tags {
+		ids[i] = tags[i].ID
+	}
+	// 垃圾桶中的任務不算
+	err := a.DB.Model(&TaskTag{}).Select("tag_id, COUNT(*) AS count").
+		Joins("JOIN tasks ON tasks.id = task_tags.task_id AND tasks.deleted_at IS NULL").
+		Where("tag_id IN ?", ids).Group("tag_id").Scan(&counts).Error
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	byTag := make(map[uint]int64, len(counts))
+	for _, c := range counts {
+		byTag[c.TagID] = c.Count
+	}
+	for i := range tags {
+		tags[i].Count = byTag[tags[i].ID]
+	}
+	if tags == nil {
+		tags = []Tag{}
+	}
+	writeJSON(w, http.StatusOK, tags)
+}
+
+// POST /api/tags {"name"}
+func (a *App) apiCreateTag(w http.ResponseWriter, r *http.Request) {
+	var req tagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	name, err := normalizeTag(req.Name)
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	tag := Tag{UserID: identityFromRequest(r).userID(), Name: name}
+	if err := a.DB.Create(&tag).Error; err != nil {
+		writeNameConflict(w, err, "tag already exists")
+		return
+	}
+	writeJSON(w, http.StatusCreated, tag)
+}
+
+// 從路徑 {id} 取出自己的標籤，找不到時直接寫出錯誤
+func (a *App) loadTagFromPath(w http.ResponseWriter, r *http.Request) (*Tag, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid tag id")
+		return nil, false
+	}
+	var tag Tag
+	if err := identityFromRequest(r).scope(a.DB).First(&tag, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, "tag not found")
+		} else {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	return &tag, true
+}
+
+// PUT /api/tags/{id} {"name"}：改名，所有任務跟著改變
+func (a *App) apiRenameTag(w http.ResponseWriter, r *http.Request) {
+	tag, ok := a.loadTagFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req tagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	name, err := normalizeTag(req.Name)
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	tag.Name = name
+	if err := a.DB.Save(tag).Error; err != nil {
+		writeNameConflict(w, err, "tag already exists")
+		return
+	}
+	writeJSON(w, http.StatusOK, tag)
+}
+
+// DELETE /api/tags/{id}：從所有任務移除
+func (a *App) apiDeleteTag(w http.ResponseWriter, r *http.Request) {
+	tag, ok := a.loadTagFromPath(w, r)
+	if !ok {
+		return
+	}
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("tag_id = ?", tag.ID).Delete(&TaskTag{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(tag).Error
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PUT /api/tasks/{id}/tags {"tags": [...]}：整筆取代任務的標籤，空陣列代表全部移除
+func (a *App) apiSetTaskTags(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadTaskFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req taskTagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		return setTaskTags(tx, task.UserID, task.ID, req.Tags)
+	})
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	if err := a.loadTaskTags(task); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+// --- 收藏集 API ---
+
+func (c *Collection) validate() error {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" || len(c.Name) > maxCollectionNameLen {
+		return invalidField("name", "name is required and must not exceed %d characters", maxCollectionNameLen)
+	}
+	return nil
+}
+
+// 填入收藏集的任務數
+func (a *App) countCollectionTasks(collections ...*Collection) error {
+	ids := make([]uint, len(collections))
+	for i, c := range collections {
+		ids[i] = c.ID
+	}
+	var counts []struct {
+		CollectionID uint
+		Count        int64
+	}
+	err := a.DB.Model(&CollectionTask{}).Select("collection_id, COUNT(*) AS count").
+		Joins("JOIN tasks ON tasks.id = collection_tasks.task_id AND tasks.deleted_at IS NULL").
+		Where("collection_id IN ?", ids).Group("collection_id").Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+	byID := make(map[uint]int64, len(counts))
+	for _, c := range counts {
+		byID[c.CollectionID] = c.Count
+	}
+	for _, c := range collections {
+		c.TaskCount = byID[c.ID]
+	}
+	return nil
+}
+
+// 從路徑 {id} 取出自己的收藏集，找不到時直接寫出錯誤
+func (a *App) loadCollectionFromPath(w http.ResponseWriter, r *http.Request) (*Collection, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid collection id")
+		return nil, false
+	}
+	var collection Collection
+	if err := identityFromRequest(r).scope(a.DB).First(&collection, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, "collection not found")
+		} else {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	return &collection, true
+}
+
+// GET /api/collections
+func (a *App) apiListCollections(w http.ResponseWriter, r *http.Request) {
+	var collections []Collection
+	if err := identityFromRequest(r).scope(a.DB).Order("name asc").Find(&collections).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ptrs := make([]*Collection, len(collections))
+	for i := range collections {
+		ptrs[i] = &collections[i]
+	}
+	if err := a.countCollectionTasks(ptrs...); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if collections == nil {
+		collections = []Collection{}
+	}
+	writeJSON(w, http.StatusOK, collections)
+}
+
+// POST /api/collections {"name","description"}
+func (a *App) apiCreateCollection(w http.ResponseWriter, r *http.Request) {
+	var collection Collection
+	if err := json.NewDecoder(r.Body).Decode(&collection); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	collection.ID = 0
+	collection.UserID = identityFromRequest(r).userID()
+	if err := collection.validate(); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	if err := a.DB.Create(&collection).Error; err != nil {
+		writeNameConflict(w, err, "collection name already exists")
+		return
+	}
+	writeJSON(w, http.StatusCreated, collection)
+}
+
+// GET /api/collections/{id}；收藏集中的任務以 GET /api/images?collection={id} 分頁查詢
+func (a *App) apiGetCollection(w http.ResponseWriter, r *http.Request) {
+	collection, ok := a.loadCollectionFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := a.countCollectionTasks(collection); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, collection)
+}
+
+// PUT /api/collections/{id}，整筆取代 name 與 description
+func (a *App) apiUpdateCollection(w http.ResponseWriter, r *http.Request) {
+	collection, ok := a.loadCollectionFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req Collection
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	collection.Name, collection.Description = req.Name, req.Description
+	if err := collection.validate(); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	if err := a.DB.Save(collection).Error; err != nil {
+		writeNameConflict(w, err, "collection name already exists")
+		return
+	}
+	if err := a.countCollectionTasks(collection); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, collection)
+}
+
+// DELETE /api/collections/{id}：只刪除收藏集，任務不受影響
+func (a *App) apiDeleteCollection(w http.ResponseWriter, r *http.Request) {
+	collection, ok := a.loadCollectionFromPath(w, r)
+	if !ok {
+		return
+	}
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("collection_id = ?", collection.ID).Delete(&CollectionTask{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(collection).Error
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /api/collections/{id}/tasks {"task_ids": [...]}：已經在收藏集中的任務略過
+func (a *App) apiAddCollectionTasks(w http.ResponseWriter, r *http.Request) {
+	collection, ok := a.loadCollectionFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req collectionTasksRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if len(req.TaskIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "task_ids is required")
+		return
+	}
+	// 只能加入自己的任務
+	var owned []uint
+	if err := identityFromRequest(r).taskScope(a.DB.Model(&Task{})).Where("id IN ?", req.TaskIDs).Pluck("id", &owned).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	found := make(map[uint]bool, len(owned))
+	for _, id := range owned {
+		found[id] = true
+	}
+	for _, id := range req.TaskIDs {
+		if !found[id] {
+			writeErrorf(w, http.StatusNotFound, "task %d not found", id)
+			return
+		}
+	}
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		for _, id := range owned {
+			var n int64
+			if err := tx.Model(&CollectionTask{}).Where("collection_id = ? AND task_id = ?", collection.ID, id).Count(&n).Error; err != nil {
+				return err
+			}
+			if n > 0 {
+				continue
+			}
+			if err := tx.Create(&CollectionTask{CollectionID: collection.ID, TaskID: id}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := a.countCollectionTasks(collection); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, collection)
+}
+
+// DELETE /api/collections/{id}/tasks/{task_id}
+func (a *App) apiRemoveCollectionTask(w http.ResponseWriter, r *http.Request) {
+	collection, ok := a.loadCollectionFromPath(w, r)
+	if !ok {
+		return
+	}
+	taskID, err := strconv.ParseUint(r.PathValue("task_id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid task id")
+		return
+	}
+	result := a.DB.Where("collection_id = ? AND task_id = ?", collection.ID, taskID).Delete(&CollectionTask{})
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		writeError(w, http.StatusNotFound, "task not in collection")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
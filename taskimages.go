@@ -0,0 +1,100 @@
+// taskimages.go
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- 一個任務多張圖片 ---
+// 任務帶 num_images (最多 8) 時，一次呼叫生成後端產生多張圖片 (run_z_image.py --num-images N)。
+// 第 i 張 (從 0 起算) 寫到 imageOutputPath(--output, i)：第 0 張就是 --output，其餘為 task_1_123_1.png、task_1_123_2.png …
+// 每寫完一張輸出一行 {"image": 1, "seed": 12346}，worker 推播 image 事件，前端可以先顯示已完成的張數。
+// 每張圖片各記錄一筆 TaskImage；任務本身的 image_path 等欄位為第 0 張 (封面)，與單張的任務相同
+
+const maxNumImages = 8
+
+type TaskImage struct {
+	ID            uint      `gorm:"primaryKey" json:"-"`
+	TaskID        uint      `gorm:"index" json:"-"`
+	Position      int       `json:"index"` // 從 0 起算，第 0 張與任務的 image_path 相同
+	ImagePath     string    `json:"image_path"`
+	ThumbnailPath string    `json:"thumbnail_path,omitempty"`
+	UpscaledPath  string    `json:"upscaled_path,omitempty"`
+	StorageKey    string    `json:"storage_key,omitempty"`
+	ImageURL      string    `json:"image_url,omitempty"`
+	Seed          *int64    `json:"seed,omitempty"` // 這張實際使用的 seed，生成後端沒有回報時省略
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// 生成的張數，num_images 沒有設定時為 1
+func (p GenerationParams) imageCount() int {
+	return max(p.NumImages, 1)
+}
+
+// 第 index 張的輸出路徑 (與 run_z_image.py 的命名規則相同)
+func imageOutputPath(outputPath string, index int) string {
+	if index == 0 {
+		return outputPath
+	}
+	ext := filepath.Ext(outputPath)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(outputPath, ext), index, ext)
+}
+
+// 讀取任務的所有圖片 (依 index 排序) 填入 Images；已經讀取過的任務略過
+func (a *App) loadTaskImages(tasks ...*Task) error {
+	return loadImagesFrom(a.DB, tasks...)
+}
+
+func loadImagesFrom(db *gorm.DB, tasks ...*Task) error {
+	ids := make([]uint, 0, len(tasks))
+	for _, task := range tasks {
+		if task.Images == nil && task.ID != 0 {
+			ids = append(ids, task.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	var images []TaskImage
+	if err := db.Where("task_id IN ?", ids).Order("task_id asc, position asc").Find(&images).Error; err != nil {
+		return err
+	}
+	byTask := make(map[uint][]TaskImage, len(ids))
+	for _, img := range images {
+		byTask[img.TaskID] = append(byTask[img.TaskID], img)
+	}
+	for _, task := range tasks {
+		if task.Images == nil && task.ID != 0 {
+			task.Images = byTask[task.ID]
+		}
+	}
+	return nil
+}
+
+// 同 loadTaskImages，用在查詢得到的任務列表
+func (a *App) loadTaskListImages(tasks []Task) error {
+	ptrs := make([]*Task, len(tasks))
+	for i := range tasks {
+		ptrs[i] = &tasks[i]
+	}
+	return a.loadTaskImages(ptrs...)
+}
+
+// 第 index 張圖片；index 0 一律回傳任務本身的欄位 (沒有 TaskImage 紀錄的舊任務也適用)
+func (t *Task) image(index int) (TaskImage, bool) {
+	if index == 0 {
+		return TaskImage{ImagePath: t.ImagePath, ThumbnailPath: t.ThumbnailPath, UpscaledPath: t.UpscaledPath,
+			StorageKey: t.StorageKey, ImageURL: t.ImageURL, Seed: t.UsedSeed}, true
+	}
+	for _, img := range t.Images {
+		if img.Position == index {
+			return img, true
+		}
+	}
+	return TaskImage{}, false
+}
@@ -0,0 +1,169 @@
+// tasklogs.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- 執行中任務的即時 log ---
+// 生成後端的輸出 (python 的 stdout / stderr、sidecar 的 log 行) 逐行記在記憶體中，
+// 同時以 {"type":"log","task_id":..,"n":..,"line":..} 推播給訂閱者，並可用 GET /api/tasks/{id}/logs 讀取。
+// 每個任務最多保留最後 taskLogMaxLines 行；結束後再保留最近 taskLogKeepFinished 個任務，
+// 之後只剩失敗任務的 error_log。log 只存在執行該任務的 process，不寫入資料庫
+
+const (
+	taskLogMaxLines     = 1000
+	taskLogMaxLineLen   = 2000 // 超過的部分截掉 (tqdm 以 \r 覆寫時可能很長)
+	taskLogKeepFinished = 50
+)
+
+type taskLogLine struct {
+	N    int       `json:"n"` // 從 0 起算的行號，被捨棄的舊行不會重新編號
+	Line string    `json:"line"`
+	At   time.Time `json:"at,omitzero"` // error_log 的行沒有時間
+}
+
+type taskLog struct {
+	lines    []taskLogLine
+	next     int // 下一行的行號
+	finished bool
+}
+
+// zero value 可直接使用
+type taskLogStore struct {
+	mutex    sync.Mutex
+	logs     map[uint]*taskLog
+	finished []uint // 已結束的任務，依結束順序
+}
+
+// 任務開始執行時呼叫，重新執行 (watchdog、關機後重跑) 時清掉上一次的 log
+func (s *taskLogStore) start(taskID uint) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.logs == nil {
+		s.logs = make(map[uint]*taskLog)
+	}
+	s.logs[taskID] = &taskLog{}
+}
+
+// 加入一行；任務不在執行中時回傳 false
+func (s *taskLogStore) append(taskID uint, line string) (taskLogLine, bool) {
+	if len(line) > taskLogMaxLineLen {
+		line = truncateHead(line, taskLogMaxLineLen)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	l := s.logs[taskID]
+	if l == nil || l.finished {
+		return taskLogLine{}, false
+	}
+	entry := taskLogLine{N: l.next, Line: line, At: time.Now()}
+	l.next++
+	l.lines = append(l.lines, entry)
+	if len(l.lines) > taskLogMaxLines {
+		l.lines = l.lines[len(l.lines)-taskLogMaxLines:]
+	}
+	return entry, true
+}
+
+// 任務結束時呼叫，只保留最近結束的幾個任務
+func (s *taskLogStore) finish(taskID uint) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	l := s.logs[taskID]
+	if l == nil {
+		return
+	}
+	l.finished = true
+	s.finished = append(s.finished, taskID)
+	for len(s.finished) > taskLogKeepFinished {
+		oldest := s.finished[0]
+		s.finished = s.finished[1:]
+		if old := s.logs[oldest]; old != nil && old.finished {
+			delete(s.logs, oldest)
+		}
+	}
+}
+
+// 行號 >= since 的行；ok 為 false 代表這個 process 沒有此任務的 log
+func (s *taskLogStore) read(taskID uint, since int) (lines []taskLogLine, next int, running, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	l := s.logs[taskID]
+	if l == nil {
+		return nil, 0, false, false
+	}
+	lines = []taskLogLine{}
+	for _, line := range l.lines {
+		if line.N >= since {
+			lines = append(lines, line)
+		}
+	}
+	return lines, l.next, !l.finished, true
+}
+
+// 生成後端回報一行輸出
+func (a *App) appendTaskLog(task *Task, line string) {
+	if entry, ok := a.taskLogs.append(task.ID, line); ok {
+		a.Hub.notifyLog(task, entry)
+	}
+}
+
+// WebSocket / SSE 推播的 log 行
+type WSLog struct {
+	Type   string `json:"type"`
+	Seq    int64  `json:"seq"`
+	TaskID uint   `json:"task_id"`
+	N      int    `json:"n"`
+	Line   string `json:"line"`
+}
+
+func (h *Hub) notifyLog(task *Task, entry taskLogLine) {
+	jsonResp, _ := json.Marshal(WSLog{Type: "log", Seq: h.nextSeq(), TaskID: task.ID, N: entry.N, Line: entry.Line})
+	h.publish(wsEvent{TaskID: task.ID, UserID: task.UserID, WorkspaceID: task.WorkspaceID, Payload: jsonResp})
+}
+
+type taskLogsResponse struct {
+	TaskID  uint          `json:"task_id"`
+	Status  TaskStatus    `json:"status"`
+	Running bool          `json:"running"` // 仍在本伺服器執行中，之後還會有新的行
+	Source  string        `json:"source"`  // live (記憶體中的輸出)、error_log (失敗任務保存的輸出) 或 none
+	Lines   []taskLogLine `json:"lines"`
+	Next    int           `json:"next"` // 下次以 ?since= 帶入，只取新的行
+}
+
+// GET /api/tasks/{id}/logs?since=0
+// 記憶體中已經沒有 (結束較久或在其他 process 執行) 時，失敗的任務改回傳 error_log
+func (a *App) apiTaskLogs(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadTaskFromPath(w, r)
+	if !ok {
+		return
+	}
+	since := 0
+	if s := r.URL.Query().Get("since"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid since")
+			return
+		}
+		since = n
+	}
+	resp := taskLogsResponse{TaskID: task.ID, Status: task.Status, Source: "none", Lines: []taskLogLine{}}
+	if lines, next, running, found := a.taskLogs.read(task.ID, since); found {
+		resp.Source, resp.Lines, resp.Next, resp.Running = "live", lines, next, running
+	} else if task.ErrorLog != "" {
+		resp.Source = "error_log"
+		for i, line := range strings.Split(strings.TrimRight(task.ErrorLog, "\n"), "\n") {
+			if i >= since {
+				resp.Lines = append(resp.Lines, taskLogLine{N: i, Line: line})
+			}
+			resp.Next = i + 1
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,172 @@
+// taskqueue.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// --- 任務佇列 (QUEUE_BACKEND) ---
+// 資料庫一直是任務狀態的唯一來源，佇列只負責把「可以執行的任務 ID」送到 worker：
+//   db (預設)：worker 收到本 process 的通知 (signalDispatch) 或定期掃描資料庫，以條件式 UPDATE 認領最舊的任務
+//   redis：任務建立、重新排隊或還原時 LPUSH 到 REDIS_QUEUE_KEY，所有 process 的 worker 以 BRPOP 等待，
+//          取出後同樣以條件式 UPDATE 認領 (已取消、已被認領或排程時間未到的 ID 直接略過)，不必輪詢資料庫。
+// BRPOP 逾時 (QUEUE_POLL_INTERVAL) 或 Redis 無法連線時仍會掃描資料庫，Redis 的資料遺失也不會讓任務卡住
+
+type TaskQueue interface {
+	// 推入可以執行的任務
+	Push(ctx context.Context, ids ...uint) error
+	// 等待下一筆任務 ID，timeout 內沒有時回傳 0
+	Pop(ctx context.Context, timeout time.Duration) (uint, error)
+	Close() error
+}
+
+func (c *Config) queueProblems() []string {
+	switch c.QueueBackend {
+	case "db":
+	case "redis":
+		if _, err := redis.ParseURL(c.RedisURL); err != nil {
+			return []string{fmt.Sprintf("REDIS_URL=%q: %v", redactedURL(c.RedisURL), err)}
+		}
+	default:
+		return []string{fmt.Sprintf("QUEUE_BACKEND=%q: must be db or redis", c.QueueBackend)}
+	}
+	return nil
+}
+
+// QUEUE_BACKEND=db 時回傳 nil
+func newTaskQueue(cfg *Config) (TaskQueue, error) {
+	if cfg.QueueBackend != "redis" {
+		return nil, nil
+	}
+	q, err := newRedisQueue(cfg.RedisURL, cfg.RedisQueueKey)
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// 網址中的密碼換成 xxxxx，用於 log 與設定列表
+func redactedURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// --- Redis ---
+type redisQueue struct {
+	client *redis.Client
+	key    string
+}
+
+func newRedisQueue(rawURL, key string) (*redisQueue, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	// worker 關機時 BRPOP 隨 context 結束
+	opts.ContextTimeoutEnabled = true
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connect redis %s: %w", redactedURL(rawURL), err)
+	}
+	slog.Info("Using Redis task queue", "url", redactedURL(rawURL), "key", key)
+	return &redisQueue{client: client, key: key}, nil
+}
+
+func (q *redisQueue) Push(ctx context.Context, ids ...uint) error {
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+	return q.client.LPush(ctx, q.key, values...).Err()
+}
+
+func (q *redisQueue) Pop(ctx context.Context, timeout time.Duration) (uint, error) {
+	// BRPOP 的逾時以秒為單位
+	result, err := q.client.BRPop(ctx, max(timeout, time.Second), q.key).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	// result 為 [key, value]
+	id, err := strconv.ParseUint(result[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid task id in queue: %q", result[1])
+	}
+	return uint(id), nil
+}
+
+// readyz 使用
+func (q *redisQueue) Check(ctx context.Context) error {
+	return q.client.Ping(ctx).Err()
+}
+
+func (q *redisQueue) Close() error {
+	return q.client.Close()
+}
+
+// --- Worker ---
+
+// 從佇列取得任務 ID 並認領；popped 為 false 代表等待逾時或佇列無法使用，需要掃描資料庫
+func (a *App) claimFromQueue(ctx context.Context) (task *Task, popped bool, err error) {
+	id, err := a.Queue.Pop(ctx, a.idleWait())
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, true, nil
+		}
+		// Redis 無法連線時改為定期掃描資料庫
+		slog.Warn("Pop from task queue error", "error", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(2 * time.Second):
+		}
+		return nil, false, nil
+	}
+	if id == 0 {
+		return nil, false, nil
+	}
+	if a.queuePaused.Load() {
+		// 等待期間佇列被暫停，放回佇列 (排到最後) 由恢復後的 worker 認領
+		a.signalDispatch(id)
+		return nil, true, nil
+	}
+	var candidate Task
+	if err := a.readyTasks(time.Now()).First(&candidate, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// 已取消、已被認領、已刪除或排程時間未到 (時間到時由掃描認領)
+			return nil, true, nil
+		}
+		return nil, true, err
+	}
+	task, err = a.claimInLane(context.Background(), candidate)
+	return task, true, err
+}
+
+// 佇列的連線狀態，列在 /readyz
+func (a *App) checkQueue(ctx context.Context) checkResult {
+	if a.Queue == nil {
+		return checkOK(map[string]string{"backend": "db"})
+	}
+	if checker, ok := a.Queue.(healthChecker); ok {
+		if err := checker.Check(ctx); err != nil {
+			return checkFail(err)
+		}
+	}
+	return checkOK(map[string]string{"backend": a.Config.QueueBackend})
+}
@@ -0,0 +1,176 @@
+// taskrepo.go
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- TaskRepository 的 GORM 實作 ---
+// 多個 process 共用同一個資料庫，狀態轉換與寫回結果都以帶條件的 UPDATE 完成 (見 worker.go 的說明)。
+// 不使用呼叫端的 ctx 查詢：worker 的 ctx 在任務取消後就結束，但結果仍要寫回。
+// 寫入經過 writer：SQLite 時同一個 process 一次只寫一筆，遇到 SQLITE_BUSY 時重試 (見 sqlite.go)
+
+type gormTaskRepository struct {
+	db     *gorm.DB
+	writer *dbWriter
+}
+
+var _ TaskRepository = (*gormTaskRepository)(nil)
+
+// 可執行的 Pending / Retrying 任務：沒有排程或排程時間已到，且還沒過期 (過期的任務由 expireTasks 標記為 Failed)
+func readyTaskQuery(db *gorm.DB, now time.Time) *gorm.DB {
+	return db.Where("status IN ? AND (scheduled_at IS NULL OR scheduled_at <= ?) AND (expires_at IS NULL OR expires_at > ?)",
+		queuedStatuses, now, now)
+}
+
+func (r *gormTaskRepository) Create(ctx context.Context, tasks []Task, batch *Batch) error {
+	return r.writer.do(func() error {
+		return r.create(tasks, batch)
+	})
+}
+
+func (r *gormTaskRepository) create(tasks []Task, batch *Batch) error {
+	// 重試前一次失敗的交易已經 rollback，清掉寫入時填入的 ID
+	for i := range tasks {
+		tasks[i].ID = 0
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if batch != nil {
+			batch.ID = 0
+			if err := tx.Create(batch).Error; err != nil {
+				return err
+			}
+			for i := range tasks {
+				tasks[i].BatchID = &batch.ID
+			}
+		}
+		if err := tx.Create(&tasks).Error; err != nil {
+			return err
+		}
+		// 快取命中的任務複製來源的圖片紀錄 (共用檔案)
+		for i := range tasks {
+			for j := range tasks[i].Images {
+				tasks[i].Images[j].ID, tasks[i].Images[j].TaskID = 0, tasks[i].ID
+			}
+			if len(tasks[i].Images) > 0 {
+				if err := tx.Create(&tasks[i].Images).Error; err != nil {
+					return err
+				}
+			}
+			if len(tasks[i].Tags) > 0 {
+				if err := setTaskTags(tx, tasks[i].UserID, tasks[i].ID, tasks[i].Tags); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (r *gormTaskRepository) Get(ctx context.Context, id uint) (*Task, error) {
+	var task Task
+	if err := r.db.First(&task, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errTaskNotFound
+		}
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (r *gormTaskRepository) NextReady(ctx context.Context, now time.Time, skipModels []string) (*Task, error) {
+	q := readyTaskQuery(r.db, now)
+	if len(skipModels) > 0 {
+		q = q.Where("model NOT IN ?", skipModels)
+	}
+	var task Task
+	err := q.Order("created_at asc, id asc").First(&task).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (r *gormTaskRepository) Transition(ctx context.Context, id uint, from TaskStatus, fields map[string]interface{}, guard transitionGuard) (bool, error) {
+	q := r.db.Model(&Task{}).Where("id = ? AND status = ?", id, from)
+	if guard.LeaseExpiredBefore != nil {
+		q = q.Where("lease_expires_at < ?", *guard.LeaseExpiredBefore)
+	}
+	if guard.StartedAt != nil {
+		q = q.Where("started_at = ?", *guard.StartedAt)
+	}
+	if guard.Quarantined {
+		q = q.Where("quarantined = ?", true)
+	}
+	// 重試時沿用同一組條件，不累加在上一次的 statement 上
+	q = q.Session(&gorm.Session{})
+	var updated bool
+	err := r.writer.do(func() error {
+		result := q.Updates(fields)
+		updated = result.RowsAffected > 0
+		return result.Error
+	})
+	return updated, err
+}
+
+func (r *gormTaskRepository) SaveResult(ctx context.Context, task *Task, workerID string) (bool, error) {
+	saved := false
+	err := r.writer.do(func() error {
+		saved = false
+		return r.db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Select("*").Where("claimed_by = ? AND status = ?", workerID, StatusProcessing).Save(task)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			saved = true
+			if len(task.Images) == 0 {
+				return nil
+			}
+			for i := range task.Images {
+				task.Images[i].ID, task.Images[i].TaskID = 0, task.ID
+			}
+			return tx.Create(&task.Images).Error
+		})
+	})
+	if err != nil {
+		return false, err
+	}
+	return saved, nil
+}
+
+func (r *gormTaskRepository) AddTransition(ctx context.Context, entry *TaskTransition) error {
+	return r.writer.do(func() error {
+		entry.ID = 0
+		return r.db.Create(entry).Error
+	})
+}
+
+func (r *gormTaskRepository) History(ctx context.Context, filter TaskHistoryFilter) ([]Task, error) {
+	q := (&Identity{UserID: filter.UserID, WorkspaceID: filter.WorkspaceID}).taskScope(r.db.Model(&Task{}))
+	q, err := whereTaskFilter(q, filter.Tags, filter.CollectionID)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []Task
+	if err := q.Order("created_at desc").Limit(filter.Limit).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	ptrs := make([]*Task, len(tasks))
+	for i := range tasks {
+		ptrs[i] = &tasks[i]
+	}
+	if err := loadImagesFrom(r.db, ptrs...); err != nil {
+		return nil, err
+	}
+	if err := loadTagsFrom(r.db, ptrs...); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
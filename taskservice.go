@@ -0,0 +1,209 @@
+// taskservice.go
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// --- 任務服務層 ---
+// 任務的核心操作 (建立、認領、狀態轉換、寫回結果、歷史紀錄) 集中在 TaskService，資料存取透過 TaskRepository：
+// 正式執行使用 gormTaskRepository (見 taskrepo.go)，memoryTaskRepository (見 memrepo.go) 把資料放在記憶體，
+// 不需要 SQLite 檔案就能執行 worker 與 handler 的流程 (搭配 GENERATOR=mock 也不需要 python)。
+// TaskService 只負責資料與狀態機 (見 taskstate.go)，推播、webhook 等事件由呼叫端發布 (見 eventbus.go)
+
+var errTaskNotFound = &codedError{Code: "not_found", Message: "task not found"}
+
+// 歷史紀錄的篩選條件
+type TaskHistoryFilter struct {
+	UserID       uint     // 0 代表所有使用者；不為 0 時只包含不屬於工作區的個人任務
+	WorkspaceID  uint     // 不為 0 時為工作區的所有任務 (不看 UserID)，見 workspace.go
+	Tags         []string // 同時具有所有標籤 (已正規化)
+	CollectionID uint     // 0 代表不限
+	Limit        int      // 預設 20
+}
+
+// 狀態轉換的額外條件，都是選填
+type transitionGuard struct {
+	LeaseExpiredBefore *time.Time // lease_expires_at 早於此時間 (回收過期的租約)
+	StartedAt          *time.Time // started_at 必須相同，避免處理到已經重新認領的任務
+	Quarantined        bool       // 只處理被隔離的任務
+}
+
+type TaskRepository interface {
+	// 在同一個交易中寫入批次 (可為 nil)、任務、圖片與標籤，寫入後任務與批次帶有 ID
+	Create(ctx context.Context, tasks []Task, batch *Batch) error
+	// 找不到時回傳 errTaskNotFound
+	Get(ctx context.Context, id uint) (*Task, error)
+	// 最舊的可執行任務 (Pending / Retrying，沒有排程或排程時間已到)，略過 skipModels 的任務；沒有時回傳 nil, nil
+	NextReady(ctx context.Context, now time.Time, skipModels []string) (*Task, error)
+	// 狀態仍為 from 且符合 guard 時以 fields (資料庫欄位名稱) 更新，回傳是否有更新
+	Transition(ctx context.Context, id uint, from TaskStatus, fields map[string]interface{}, guard transitionGuard) (bool, error)
+	// 寫回整個任務與圖片：只有仍為 Processing 且由 workerID 持有時才寫入
+	SaveResult(ctx context.Context, task *Task, workerID string) (bool, error)
+	AddTransition(ctx context.Context, entry *TaskTransition) error
+	// 依建立時間由新到舊，包含圖片與標籤
+	History(ctx context.Context, filter TaskHistoryFilter) ([]Task, error)
+}
+
+type TaskService struct {
+	repo     TaskRepository
+	workerID string        // 認領任務與記錄轉換的 process (WORKER_ID)
+	lease    time.Duration // 認領時的租約長度 (WORKER_LEASE)
+	now      func() time.Time
+}
+
+func newTaskService(repo TaskRepository, workerID string, lease time.Duration) *TaskService {
+	return &TaskService{repo: repo, workerID: workerID, lease: lease, now: time.Now}
+}
+
+// 記錄一筆狀態轉換；寫入失敗只記 log，不影響任務本身
+func (s *TaskService) record(ctx context.Context, taskID uint, from, to TaskStatus, reason string) {
+	entry := TaskTransition{TaskID: taskID, FromStatus: from, ToStatus: to, Reason: reason, Worker: s.workerID}
+	if err := s.repo.AddTransition(ctx, &entry); err != nil {
+		loggerFrom(ctx).Warn("Record task transition error", "task_id", taskID, "error", err)
+	}
+	loggerFrom(ctx).Debug("Task transition", "task_id", taskID, "from", string(from), "to", string(to), "reason", reason)
+}
+
+// 寫入新任務 (狀態已由呼叫端決定)，並記錄建立的轉換
+func (s *TaskService) Create(ctx context.Context, tasks []Task, batch *Batch) error {
+	for i := range tasks {
+		if err := checkTransition("", tasks[i].Status); err != nil {
+			return err
+		}
+	}
+	if err := s.repo.Create(ctx, tasks, batch); err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		reason := ""
+		if task.CachedFrom != nil {
+			reason = "cache"
+		}
+		s.record(ctx, task.ID, "", task.Status, reason)
+	}
+	return nil
+}
+
+// 寫入從匯出檔匯入的任務 (見 export.go)：只接受已完成的任務，建立時的轉換以 import 記錄
+func (s *TaskService) Import(ctx context.Context, tasks []Task) error {
+	for i := range tasks {
+		if tasks[i].Status != StatusCompleted {
+			return newCodedError("conflict", "task cannot be imported as %s", tasks[i].Status)
+		}
+		if err := checkTransition("", tasks[i].Status); err != nil {
+			return err
+		}
+	}
+	if err := s.repo.Create(ctx, tasks, nil); err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		s.record(ctx, task.ID, "", task.Status, "import")
+	}
+	return nil
+}
+
+func (s *TaskService) Get(ctx context.Context, id uint) (*Task, error) {
+	return s.repo.Get(ctx, id)
+}
+
+// 把任務從 from 轉換到 to：fields 為一起更新的欄位 (狀態、updated_at 與時間欄位會自動加上)。
+// 只有狀態仍為 from 時才會更新，被其他 process 搶先時回傳 false；成功時記錄轉換
+func (s *TaskService) Transition(ctx context.Context, id uint, from, to TaskStatus, reason string, fields map[string]interface{}, guard ...transitionGuard) (bool, error) {
+	if err := checkTransition(from, to); err != nil {
+		return false, err
+	}
+	now := s.now()
+	updates := lifecycleFields(to, now, map[string]interface{}{"status": to, "updated_at": now})
+	if to.queued() {
+		updates["claimed_by"], updates["lease_expires_at"] = "", nil
+	} else if to.finished() {
+		updates["lease_expires_at"] = nil
+	}
+	// 呼叫端的欄位優先 (例如核准排程任務時 queued_at 為排程時間)
+	for k, v := range fields {
+		updates[k] = v
+	}
+	var g transitionGuard
+	if len(guard) > 0 {
+		g = guard[0]
+	}
+	ok, err := s.repo.Transition(ctx, id, from, updates, g)
+	if err != nil || !ok {
+		return false, err
+	}
+	s.record(ctx, id, from, to, reason)
+	return true, nil
+}
+
+// 認領指定的任務並標記為 Processing，被其他 worker 搶先時回傳 nil
+func (s *TaskService) Claim(ctx context.Context, task Task) (*Task, error) {
+	// 條件式更新保證同一筆任務只有一個 worker 成功，attempts 不會重複計算
+	ok, err := s.Transition(ctx, task.ID, task.Status, StatusProcessing, "", map[string]interface{}{
+		"claimed_by":       s.workerID,
+		"lease_expires_at": s.now().Add(s.lease),
+		"attempts":         task.Attempts + 1,
+	})
+	if err != nil || !ok {
+		return nil, err
+	}
+	return s.repo.Get(ctx, task.ID)
+}
+
+// 認領最舊的可執行任務 (略過 skipModels 的任務，見 modellanes.go)，沒有任務時回傳 nil, nil
+func (s *TaskService) ClaimNext(ctx context.Context, skipModels ...string) (*Task, error) {
+	for {
+		task, err := s.repo.NextReady(ctx, s.now(), skipModels)
+		if err != nil || task == nil {
+			return nil, err
+		}
+		claimed, err := s.Claim(ctx, *task)
+		if err != nil {
+			return nil, err
+		}
+		if claimed != nil {
+			return claimed, nil
+		}
+		// 被其他 worker 搶走了，再找下一筆
+	}
+}
+
+// 寫回 worker 的結果 (Completed、Failed、Cancelled 或關機時的 Retrying) 與圖片；
+// 任務已經由其他 process 處理 (取消、強制失敗或回收) 時不寫入並回傳 false
+func (s *TaskService) Finish(ctx context.Context, task *Task, to TaskStatus, reason string) (bool, error) {
+	if err := task.transition(to, s.now()); err != nil {
+		return false, err
+	}
+	if to.queued() {
+		task.ClaimedBy = ""
+	}
+	task.LeaseExpiresAt = nil
+	ok, err := s.repo.SaveResult(ctx, task, s.workerID)
+	if err != nil || !ok {
+		return false, err
+	}
+	s.record(ctx, task.ID, StatusProcessing, to, reason)
+	return true, nil
+}
+
+// 標記為 Completed
+func (s *TaskService) Complete(ctx context.Context, task *Task) (bool, error) {
+	task.FailReason, task.ErrorMessage = "", ""
+	return s.Finish(ctx, task, StatusCompleted, "")
+}
+
+// 標記為 Failed，failReason 例如 error、timeout、storage
+func (s *TaskService) Fail(ctx context.Context, task *Task, failReason, message string) (bool, error) {
+	task.FailReason, task.ErrorMessage = failReason, truncateHead(message, maxErrorMessageLen)
+	return s.Finish(ctx, task, StatusFailed, failReason)
+}
+
+// 歷史紀錄
+func (s *TaskService) ListHistory(ctx context.Context, filter TaskHistoryFilter) ([]Task, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = 20
+	}
+	return s.repo.History(ctx, filter)
+}
@@ -0,0 +1,193 @@
+// taskservice_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// 以記憶體中的 repository 驗證 TaskService，不需要資料庫
+
+// 固定時間的 TaskService，回傳的函式讓時間前進
+func newTestTaskService(repo TaskRepository, workerID string) (*TaskService, func(time.Duration)) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := newTaskService(repo, workerID, time.Minute)
+	s.now = func() time.Time { return now }
+	return s, func(d time.Duration) { now = now.Add(d) }
+}
+
+func createTestTasks(t *testing.T, s *TaskService, tasks ...Task) []Task {
+	t.Helper()
+	for i := range tasks {
+		tasks[i].Status = StatusPending
+		tasks[i].CreatedAt = s.now().Add(time.Duration(i) * time.Second)
+	}
+	if err := s.Create(context.Background(), tasks, nil); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return tasks
+}
+
+func TestClaimNextLease(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryTaskRepository()
+	s, _ := newTestTaskService(repo, "worker-a")
+	tasks := createTestTasks(t, s, Task{Prompt: "first"}, Task{Prompt: "second", GenerationParams: GenerationParams{Model: "sdxl"}})
+
+	claimed, err := s.ClaimNext(ctx)
+	if err != nil || claimed == nil {
+		t.Fatalf("ClaimNext = %v, %v", claimed, err)
+	}
+	if claimed.ID != tasks[0].ID {
+		t.Errorf("claimed task %d, want the oldest %d", claimed.ID, tasks[0].ID)
+	}
+	if claimed.Status != StatusProcessing || claimed.ClaimedBy != "worker-a" || claimed.Attempts != 1 {
+		t.Errorf("claimed task = status %s, claimed_by %q, attempts %d", claimed.Status, claimed.ClaimedBy, claimed.Attempts)
+	}
+	if want := s.now().Add(time.Minute); claimed.LeaseExpiresAt == nil || !claimed.LeaseExpiresAt.Equal(want) {
+		t.Errorf("lease_expires_at = %v, want %v", claimed.LeaseExpiresAt, want)
+	}
+
+	// 已到達上限的模型略過
+	if next, err := s.ClaimNext(ctx, "sdxl"); err != nil || next != nil {
+		t.Errorf("ClaimNext(skip sdxl) = %v, %v, want nil", next, err)
+	}
+	if next, err := s.ClaimNext(ctx); err != nil || next == nil || next.ID != tasks[1].ID {
+		t.Errorf("ClaimNext = %v, %v, want task %d", next, err, tasks[1].ID)
+	}
+	if next, err := s.ClaimNext(ctx); err != nil || next != nil {
+		t.Errorf("ClaimNext on empty queue = %v, %v", next, err)
+	}
+
+	ok, err := s.Complete(ctx, claimed)
+	if err != nil || !ok {
+		t.Fatalf("Complete = %v, %v", ok, err)
+	}
+	stored, _ := s.Get(ctx, claimed.ID)
+	if stored.Status != StatusCompleted || stored.LeaseExpiresAt != nil || stored.FinishedAt == nil {
+		t.Errorf("completed task = status %s, lease %v, finished_at %v", stored.Status, stored.LeaseExpiresAt, stored.FinishedAt)
+	}
+}
+
+func TestFinishAfterLeaseReclaimed(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryTaskRepository()
+	a, advance := newTestTaskService(repo, "worker-a")
+	createTestTasks(t, a, Task{Prompt: "cat"})
+	claimed, err := a.ClaimNext(ctx)
+	if err != nil || claimed == nil {
+		t.Fatalf("ClaimNext = %v, %v", claimed, err)
+	}
+
+	// 租約還沒過期時不能回收
+	now := a.now()
+	if ok, err := a.Transition(ctx, claimed.ID, StatusProcessing, StatusRetrying, "lease", nil, transitionGuard{LeaseExpiredBefore: &now}); err != nil || ok {
+		t.Fatalf("reclaim before lease expiry = %v, %v, want false", ok, err)
+	}
+	advance(2 * time.Minute)
+	now = a.now()
+	if ok, err := a.Transition(ctx, claimed.ID, StatusProcessing, StatusRetrying, "lease", nil, transitionGuard{LeaseExpiredBefore: &now}); err != nil || !ok {
+		t.Fatalf("reclaim after lease expiry = %v, %v, want true", ok, err)
+	}
+
+	// 另一個 worker 重新認領後，原本的 worker 不能寫回結果
+	b, _ := newTestTaskService(repo, "worker-b")
+	b.now = a.now
+	reclaimed, err := b.ClaimNext(ctx)
+	if err != nil || reclaimed == nil || reclaimed.ClaimedBy != "worker-b" || reclaimed.Attempts != 2 {
+		t.Fatalf("ClaimNext by worker-b = %+v, %v", reclaimed, err)
+	}
+	if ok, err := a.Complete(ctx, claimed); err != nil || ok {
+		t.Errorf("Complete by the old worker = %v, %v, want false", ok, err)
+	}
+	if ok, err := b.Fail(ctx, reclaimed, "error", "boom"); err != nil || !ok {
+		t.Errorf("Fail by the current worker = %v, %v, want true", ok, err)
+	}
+	stored, _ := b.Get(ctx, claimed.ID)
+	if stored.Status != StatusFailed || stored.ErrorMessage != "boom" {
+		t.Errorf("stored task = status %s, error %q", stored.Status, stored.ErrorMessage)
+	}
+}
+
+func TestTransitionGuards(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryTaskRepository()
+	s, _ := newTestTaskService(repo, "worker-a")
+	tasks := createTestTasks(t, s, Task{Prompt: "dog"})
+	id := tasks[0].ID
+	claimed, err := s.ClaimNext(ctx)
+	if err != nil || claimed == nil {
+		t.Fatalf("ClaimNext = %v, %v", claimed, err)
+	}
+
+	other := claimed.StartedAt.Add(-time.Second)
+	tests := []struct {
+		name  string
+		from  TaskStatus
+		to    TaskStatus
+		guard transitionGuard
+		ok    bool
+		err   bool
+	}{
+		{name: "status changed", from: StatusPending, to: StatusCancelled},
+		{name: "invalid transition", from: StatusProcessing, to: StatusPending, err: true},
+		{name: "started_at mismatch", from: StatusProcessing, to: StatusFailed, guard: transitionGuard{StartedAt: &other}},
+		{name: "not quarantined", from: StatusProcessing, to: StatusFailed, guard: transitionGuard{Quarantined: true}},
+		{name: "started_at matches", from: StatusProcessing, to: StatusCancelled, guard: transitionGuard{StartedAt: claimed.StartedAt}, ok: true},
+		{name: "already finished", from: StatusProcessing, to: StatusFailed},
+	}
+	for _, tt := range tests {
+		ok, err := s.Transition(ctx, id, tt.from, tt.to, "", nil, tt.guard)
+		if (err != nil) != tt.err || ok != tt.ok {
+			t.Errorf("%s: Transition = %v, %v, want ok %v, error %v", tt.name, ok, err, tt.ok, tt.err)
+		}
+		if tt.err && errorCode(err) != "conflict" {
+			t.Errorf("%s: error code = %q, want conflict", tt.name, errorCode(err))
+		}
+	}
+
+	stored, _ := s.Get(ctx, id)
+	if stored.Status != StatusCancelled || stored.LeaseExpiresAt != nil {
+		t.Errorf("stored task = status %s, lease %v", stored.Status, stored.LeaseExpiresAt)
+	}
+	// 建立、認領、取消各一筆轉換紀錄，失敗的轉換不記錄
+	if got := len(repo.transitions); got != 3 {
+		t.Errorf("recorded %d transitions, want 3", got)
+	}
+	if _, err := s.Get(ctx, id+100); !errors.Is(err, errTaskNotFound) {
+		t.Errorf("Get(missing) error = %v, want errTaskNotFound", err)
+	}
+}
+
+func TestImportOnlyCompleted(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryTaskRepository()
+	s, _ := newTestTaskService(repo, "worker-a")
+
+	for _, status := range []TaskStatus{StatusFailed, StatusCancelled, StatusPending, StatusProcessing} {
+		err := s.Import(ctx, []Task{{Prompt: "done", Status: StatusCompleted}, {Prompt: "other", Status: status}})
+		if errorCode(err) != "conflict" {
+			t.Errorf("Import(%s) error = %v, want conflict", status, err)
+		}
+	}
+	if len(repo.tasks) != 0 || len(repo.transitions) != 0 {
+		t.Fatalf("rejected imports stored %d tasks, %d transitions", len(repo.tasks), len(repo.transitions))
+	}
+
+	tasks := []Task{{Prompt: "cat", Status: StatusCompleted, Images: []TaskImage{{ImagePath: "import_1_cat.png"}}}}
+	if err := s.Import(ctx, tasks); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	stored, err := s.Get(ctx, tasks[0].ID)
+	if err != nil || stored.Status != StatusCompleted || len(stored.Images) != 1 || stored.Images[0].TaskID != stored.ID {
+		t.Fatalf("imported task = %+v, %v", stored, err)
+	}
+	if len(repo.transitions) != 1 {
+		t.Fatalf("recorded %d transitions, want 1", len(repo.transitions))
+	}
+	if tr := repo.transitions[0]; tr.TaskID != stored.ID || tr.FromStatus != "" || tr.ToStatus != StatusCompleted || tr.Reason != "import" {
+		t.Errorf("transition = %+v, want creation as Completed with reason import", tr)
+	}
+}
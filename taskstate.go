@@ -0,0 +1,96 @@
+// taskstate.go
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// --- 任務狀態機 ---
+// 任務的狀態只能依下表轉換，所有改變狀態的地方都經過 TaskService.Transition (以帶條件的 UPDATE 寫入資料庫)
+// 或 Task.transition (以整個 struct 寫回的 worker 結果，見 TaskService.Finish)，確保不會出現不合法的狀態：
+//
+//	(建立)           → AwaitingApproval、Pending、Completed (快取命中)
+//	AwaitingApproval → Pending (核准)、Failed (拒絕)、Cancelled
+//	Pending          → Processing、Failed (管理員)、Cancelled
+//	Retrying         → Processing、Failed (管理員)、Cancelled
+//	Processing       → Completed、Failed、Cancelled、Retrying
+//	Failed           → Completed (放行被隔離的圖片)
+//
+// Retrying 是執行中被中斷 (watchdog、租約到期、關機、管理員回收) 後放回佇列的任務，與 Pending 一樣會被 worker 認領；
+// Completed、Failed、Cancelled 為結束狀態，除了放行隔離圖片之外不會再改變。轉換時一併更新時間欄位 (見 timeline.go) 並記錄一筆 TaskTransition
+
+type TaskStatus string
+
+const (
+	StatusAwaitingApproval TaskStatus = "AwaitingApproval"
+	StatusPending          TaskStatus = "Pending"
+	StatusRetrying         TaskStatus = "Retrying"
+	StatusProcessing       TaskStatus = "Processing"
+	StatusCompleted        TaskStatus = "Completed"
+	StatusFailed           TaskStatus = "Failed"
+	StatusCancelled        TaskStatus = "Cancelled"
+)
+
+// 合法的狀態轉換，key 為空字串代表建立任務
+var taskTransitions = map[TaskStatus][]TaskStatus{
+	"":                     {StatusAwaitingApproval, StatusPending, StatusCompleted},
+	StatusAwaitingApproval: {StatusPending, StatusFailed, StatusCancelled},
+	StatusPending:          {StatusProcessing, StatusFailed, StatusCancelled},
+	StatusRetrying:         {StatusProcessing, StatusFailed, StatusCancelled},
+	StatusProcessing:       {StatusCompleted, StatusFailed, StatusCancelled, StatusRetrying},
+	StatusFailed:           {StatusCompleted}, // 只用於管理員放行被隔離的圖片 (見 safety.go)
+}
+
+var (
+	// 已經結束的狀態
+	finishedStatuses = []TaskStatus{StatusCompleted, StatusFailed, StatusCancelled}
+	// 在佇列中等待 worker 認領的狀態
+	queuedStatuses = []TaskStatus{StatusPending, StatusRetrying}
+	// 還沒結束的狀態，計入 MAX_QUEUED_PER_USER
+	activeStatuses = []TaskStatus{StatusAwaitingApproval, StatusPending, StatusRetrying, StatusProcessing}
+)
+
+func (s TaskStatus) finished() bool {
+	return s == StatusCompleted || s == StatusFailed || s == StatusCancelled
+}
+
+// 在佇列中 (Pending 或 Retrying)
+func (s TaskStatus) queued() bool {
+	return s == StatusPending || s == StatusRetrying
+}
+
+func (s TaskStatus) canTransition(to TaskStatus) bool {
+	for _, next := range taskTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// 不合法的轉換；對 API 呼叫端而言是狀態衝突
+func checkTransition(from, to TaskStatus) error {
+	if from.canTransition(to) {
+		return nil
+	}
+	if from == "" {
+		return newCodedError("conflict", "task cannot be created as %s", to)
+	}
+	return newCodedError("conflict", "task cannot move from %s to %s", from, to)
+}
+
+// 狀態轉換，見 TaskService.Transition
+func (a *App) transitionTask(ctx context.Context, id uint, from, to TaskStatus, reason string, fields map[string]interface{}, guard ...transitionGuard) (bool, error) {
+	return a.Tasks.Transition(ctx, id, from, to, reason, fields, guard...)
+}
+
+// 在記憶體中轉換 (之後以 Save 寫回整個任務)：檢查並更新狀態與時間欄位
+func (t *Task) transition(to TaskStatus, now time.Time) error {
+	if err := checkTransition(t.Status, to); err != nil {
+		return err
+	}
+	t.Status = to
+	t.markLifecycle(now)
+	return nil
+}
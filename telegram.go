@@ -0,0 +1,319 @@
+// telegram.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Telegram bot ---
+// 設定 TELEGRAM_BOT_TOKEN 後，serve 以 long polling (getUpdates) 接收訊息，不需要對外開放的網址。
+// 聊天室中傳送 /imagine <prompt> 會建立一般的任務 (走相同的佇列、配額與 worker)，
+// 任務屬於 TELEGRAM_USER 的使用者，加上 telegram 與 telegram:<chat id> 標籤，並記錄聊天室與原本的訊息；
+// 完成時以圖片回覆該訊息，失敗時回覆錯誤。TELEGRAM_ALLOWED_CHATS 限制可以使用的聊天室 (空白代表不限制)。
+// 同一個 token 只能有一個 process 在 polling，多個 serve 時只在其中一個設定；
+// 回覆由執行任務的 process 送出，因此 worker 也需要 TELEGRAM_BOT_TOKEN
+
+const (
+	telegramPollTimeout = 50 * time.Second // getUpdates 的 long polling 時間
+	telegramMaxCaption  = 1024
+)
+
+type telegramConfig struct {
+	Token        string  // TELEGRAM_BOT_TOKEN
+	APIURL       string  // TELEGRAM_API_URL，預設 https://api.telegram.org
+	User         string  // 任務所屬的使用者名稱 (TELEGRAM_USER)
+	AllowedChats []int64 // TELEGRAM_ALLOWED_CHATS
+}
+
+func telegramFromEnv() telegramConfig {
+	t := telegramConfig{
+		Token:  os.Getenv("TELEGRAM_BOT_TOKEN"),
+		APIURL: strings.TrimRight(envString("TELEGRAM_API_URL", "https://api.telegram.org"), "/"),
+		User:   envString("TELEGRAM_USER", "telegram"),
+	}
+	for _, s := range strings.Split(os.Getenv("TELEGRAM_ALLOWED_CHATS"), ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+			t.AllowedChats = append(t.AllowedChats, id)
+		}
+	}
+	return t
+}
+
+func (t telegramConfig) enabled() bool {
+	return t.Token != ""
+}
+
+func (t telegramConfig) problems() []string {
+	var problems []string
+	for _, s := range strings.Split(os.Getenv("TELEGRAM_ALLOWED_CHATS"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+				problems = append(problems, fmt.Sprintf("TELEGRAM_ALLOWED_CHATS: %q is not a chat id", s))
+			}
+		}
+	}
+	if t.enabled() && validateCallbackURL(t.APIURL) != nil {
+		problems = append(problems, fmt.Sprintf("TELEGRAM_API_URL=%q: must be an http or https URL", t.APIURL))
+	}
+	return problems
+}
+
+func (t telegramConfig) allows(chatID int64) bool {
+	return len(t.AllowedChats) == 0 || slices.Contains(t.AllowedChats, chatID)
+}
+
+// Bot API 的回應與用到的欄位
+type telegramResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	Description string          `json:"description"`
+}
+
+type telegramUpdate struct {
+	UpdateID int64            `json:"update_id"`
+	Message  *telegramMessage `json:"message"`
+}
+
+type telegramMessage struct {
+	MessageID int    `json:"message_id"`
+	Text      string `json:"text"`
+	Chat      struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	From *struct {
+		ID       int64  `json:"id"`
+		Username string `json:"username"`
+	} `json:"from"`
+}
+
+type telegramBot struct {
+	app    *App
+	config telegramConfig
+	client *http.Client
+	user   *User
+}
+
+// 以 JSON 或 multipart 呼叫 Bot API 的 method
+func (b *telegramBot) call(ctx context.Context, method, contentType string, body io.Reader, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.config.APIURL+"/bot"+b.config.Token+"/"+method, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		// 錯誤訊息中的網址含有 token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	var r telegramResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 10<<20)).Decode(&r); err != nil {
+		return fmt.Errorf("telegram %s: HTTP %d", method, resp.StatusCode)
+	}
+	if !r.OK {
+		return fmt.Errorf("telegram %s: %s", method, r.Description)
+	}
+	if result != nil {
+		return json.Unmarshal(r.Result, result)
+	}
+	return nil
+}
+
+func (b *telegramBot) callJSON(ctx context.Context, method string, params interface{}, result interface{}) error {
+	body, _ := json.Marshal(params)
+	return b.call(ctx, method, "application/json", bytes.NewReader(body), result)
+}
+
+func (b *telegramBot) sendMessage(ctx context.Context, chatID int64, replyTo int, text string) error {
+	params := map[string]interface{}{"chat_id": chatID, "text": truncateHead(text, 4000)}
+	if replyTo != 0 {
+		params["reply_parameters"] = map[string]interface{}{"message_id": replyTo, "allow_sending_without_reply": true}
+	}
+	return b.callJSON(ctx, "sendMessage", params, nil)
+}
+
+func (b *telegramBot) sendPhoto(ctx context.Context, chatID int64, replyTo int, caption, name string, image []byte) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("chat_id", strconv.FormatInt(chatID, 10))
+	mw.WriteField("caption", truncateHead(caption, telegramMaxCaption-1))
+	if replyTo != 0 {
+		params, _ := json.Marshal(map[string]interface{}{"message_id": replyTo, "allow_sending_without_reply": true})
+		mw.WriteField("reply_parameters", string(params))
+	}
+	part, _ := mw.CreateFormFile("photo", name)
+	part.Write(image)
+	mw.Close()
+	return b.call(ctx, "sendPhoto", mw.FormDataContentType(), &body, nil)
+}
+
+// 啟動 long polling，ctx 結束時停止
+func (a *App) startTelegram(ctx context.Context) {
+	if !a.Config.Telegram.enabled() {
+		return
+	}
+	user, err := a.findOrCreateUser(a.Config.Telegram.User)
+	if err != nil {
+		slog.Error("Failed to start Telegram bot", "error", err)
+		return
+	}
+	bot := &telegramBot{app: a, config: a.Config.Telegram, client: &http.Client{Timeout: telegramPollTimeout + 10*time.Second}, user: user}
+	slog.Info("Telegram bot started", "user", user.Name, "allowed_chats", len(bot.config.AllowedChats))
+	go bot.poll(ctx)
+}
+
+func (b *telegramBot) poll(ctx context.Context) {
+	var offset int64
+	backoff := time.Second
+	for ctx.Err() == nil {
+		var updates []telegramUpdate
+		params := map[string]interface{}{"offset": offset, "timeout": int(telegramPollTimeout / time.Second), "allowed_updates": []string{"message"}}
+		if err := b.callJSON(ctx, "getUpdates", params, &updates); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("Telegram polling failed", "error", err, "retry_in", backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, time.Minute)
+			continue
+		}
+		backoff = time.Second
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message != nil {
+				b.handleMessage(ctx, u.Message)
+			}
+		}
+	}
+}
+
+// 取出指令與參數；群組中的指令可能帶 @bot 名稱 (/imagine@zimage_bot)
+func parseTelegramCommand(text string) (string, string) {
+	if !strings.HasPrefix(text, "/") {
+		return "", ""
+	}
+	cmd, args, _ := strings.Cut(text, " ")
+	cmd, _, _ = strings.Cut(cmd, "@")
+	return strings.ToLower(cmd), strings.TrimSpace(args)
+}
+
+func (b *telegramBot) handleMessage(ctx context.Context, msg *telegramMessage) {
+	cmd, args := parseTelegramCommand(msg.Text)
+	if cmd == "" {
+		return
+	}
+	logger := slog.With("chat_id", msg.Chat.ID)
+	if !b.config.allows(msg.Chat.ID) {
+		logger.Warn("Telegram message from a chat that is not allowed", "command", cmd)
+		return
+	}
+	var reply string
+	switch cmd {
+	case "/start", "/help":
+		reply = "Send /imagine <prompt> to generate an image."
+	case "/imagine":
+		reply = b.imagine(ctx, msg, args, logger)
+	default:
+		return
+	}
+	if err := b.sendMessage(ctx, msg.Chat.ID, msg.MessageID, reply); err != nil {
+		logger.Warn("Telegram reply failed", "error", err)
+	}
+}
+
+// 建立任務，回傳要回覆的文字
+func (b *telegramBot) imagine(ctx context.Context, msg *telegramMessage, prompt string, logger *slog.Logger) string {
+	if prompt == "" {
+		return "Usage: /imagine <prompt>"
+	}
+	name := "telegram"
+	if msg.From != nil && msg.From.Username != "" {
+		name = "telegram:@" + msg.From.Username
+	}
+	identity := &Identity{KeyName: name, UserID: b.user.ID}
+	chatID := msg.Chat.ID
+	task := Task{
+		Prompt:            prompt,
+		CreatedBy:         identity.KeyName,
+		UserID:            identity.UserID,
+		TelegramChatID:    &chatID,
+		TelegramMessageID: msg.MessageID,
+		Tags:              []string{"telegram", "telegram:" + strconv.FormatInt(chatID, 10)},
+	}
+	created, err := b.app.createTasks(context.WithValue(ctx, identityKey{}, identity), []Task{task}, nil)
+	if err != nil {
+		if errorCode(err) == "" {
+			logger.Error("Telegram task creation failed", "error", err)
+			return "Failed to create the task, please try again later."
+		}
+		return "Rejected: " + err.Error()
+	}
+	t := created[0]
+	switch {
+	case t.Status == StatusCompleted:
+		// 生成結果快取命中，圖片已經由 notifyTelegram 送出
+		return fmt.Sprintf("Task #%d served from cache.", t.ID)
+	case t.Status == StatusAwaitingApproval:
+		return fmt.Sprintf("Task #%d is waiting for approval.", t.ID)
+	case t.QueuePosition > 0:
+		return fmt.Sprintf("Task #%d queued (position %d).", t.ID, t.QueuePosition)
+	default:
+		return fmt.Sprintf("Task #%d queued.", t.ID)
+	}
+}
+
+// 任務結束時呼叫，回覆由 Telegram 建立的任務
+func (a *App) notifyTelegram(task Task) {
+	if !a.Config.Telegram.enabled() || task.TelegramChatID == nil || (task.Status != StatusCompleted && task.Status != StatusFailed) {
+		return
+	}
+	bot := &telegramBot{app: a, config: a.Config.Telegram, client: &http.Client{Timeout: webhookTimeout}}
+	logger := slog.With("task_id", task.ID, "chat_id", *task.TelegramChatID)
+	go func() {
+		ctx := context.Background()
+		chatID, replyTo := *task.TelegramChatID, task.TelegramMessageID
+		var err error
+		switch {
+		case task.Status == StatusFailed:
+			msg := newNotifyMessage(task)
+			err = bot.sendMessage(ctx, chatID, replyTo, fmt.Sprintf("Task #%d failed: %s", task.ID, msg.Error))
+		case !hasPublishableImage(task):
+			err = bot.sendMessage(ctx, chatID, replyTo, fmt.Sprintf("Task #%d completed, but the image is not available.", task.ID))
+		default:
+			image, name, readErr := a.notifyImage(task)
+			if readErr != nil {
+				logger.Warn("Failed to read image for Telegram", "error", readErr)
+				err = bot.sendMessage(ctx, chatID, replyTo, fmt.Sprintf("Task #%d completed, but the image could not be sent.", task.ID))
+				break
+			}
+			err = bot.sendPhoto(ctx, chatID, replyTo, task.Prompt, name, image)
+		}
+		if err != nil {
+			logger.Warn("Telegram reply failed", "error", err)
+			return
+		}
+		logger.Info("Telegram reply sent")
+	}()
+}
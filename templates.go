@@ -0,0 +1,240 @@
+// templates.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- Prompt 範本 ---
+
+// 具名的 prompt 範本，{name} 為變數，建立任務時以 variables 代入；名稱在同一使用者內不可重複
+type PromptTemplate struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	UserID         uint      `gorm:"uniqueIndex:idx_template_user_name" json:"user_id"`
+	Name           string    `gorm:"uniqueIndex:idx_template_user_name" json:"name"`
+	Description    string    `json:"description,omitempty"`
+	Template       string    `json:"template"`
+	NegativePrompt string    `json:"negative_prompt,omitempty"` // 也可以包含變數，請求沒有帶 negative_prompt 時使用
+	Variables      []string  `gorm:"-" json:"variables"`        // 範本中出現的變數，回傳時才計算
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// 變數名稱限英數字與底線，其他大括號 (例如 JSON 片段) 保持原樣
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+const (
+	maxTemplateNameLen = 100
+	maxTemplateLen     = 4000
+)
+
+// 範本中出現的變數 (依出現順序，不重複)
+func templateVariables(texts ...string) []string {
+	seen := make(map[string]bool)
+	vars := []string{}
+	for _, text := range texts {
+		for _, m := range placeholderPattern.FindAllStringSubmatch(text, -1) {
+			if !seen[m[1]] {
+				seen[m[1]] = true
+				vars = append(vars, m[1])
+			}
+		}
+	}
+	return vars
+}
+
+// 代入變數，缺少的變數回傳 ValidationError
+func expandTemplate(text string, vars map[string]string) (string, error) {
+	var missing []string
+	result := placeholderPattern.ReplaceAllStringFunc(text, func(m string) string {
+		name := m[1 : len(m)-1]
+		value, ok := vars[name]
+		if !ok {
+			missing = append(missing, name)
+			return m
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", invalidf("missing template variables: %s", strings.Join(missing, ", "))
+	}
+	return result, nil
+}
+
+func (t *PromptTemplate) validate() error {
+	t.Name = strings.TrimSpace(t.Name)
+	t.Template = strings.TrimSpace(t.Template)
+	if t.Name == "" || len(t.Name) > maxTemplateNameLen {
+		return invalidf("name is required and must not exceed %d characters", maxTemplateNameLen)
+	}
+	if t.Template == "" || len(t.Template) > maxTemplateLen || len(t.NegativePrompt) > maxTemplateLen {
+		return invalidf("template is required and must not exceed %d characters", maxTemplateLen)
+	}
+	return nil
+}
+
+// 建立任務時引用範本：以 template (名稱) 與 variables 取代 prompt
+type templateRef struct {
+	Template  string            `json:"template"`
+	Variables map[string]string `json:"variables"`
+}
+
+// 展開範本，回傳 prompt 與 negative prompt (範本沒有設定時為空字串)
+func (a *App) expandTemplateRef(identity *Identity, ref templateRef) (string, string, error) {
+	var tmpl PromptTemplate
+	if err := identity.scope(a.DB).Where("name = ?", strings.TrimSpace(ref.Template)).First(&tmpl).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", "", invalidf("template not found: %s", ref.Template)
+		}
+		return "", "", err
+	}
+	prompt, err := expandTemplate(tmpl.Template, ref.Variables)
+	if err != nil {
+		return "", "", err
+	}
+	negative, err := expandTemplate(tmpl.NegativePrompt, ref.Variables)
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(prompt), strings.TrimSpace(negative), nil
+}
+
+// 任務的 prompt 來自範本時代入，prompt 與 template 不可同時指定
+func (a *App) applyTemplate(identity *Identity, ref templateRef, prompt *string, params *GenerationParams) error {
+	if ref.Template == "" {
+		return nil
+	}
+	if *prompt != "" {
+		return invalidf("prompt and template cannot be used together")
+	}
+	expanded, negative, err := a.expandTemplateRef(identity, ref)
+	if err != nil {
+		return err
+	}
+	*prompt = expanded
+	if params.NegativePrompt == "" {
+		params.NegativePrompt = negative
+	}
+	return nil
+}
+
+// 從路徑 {id} 取出自己的範本，找不到時直接寫出錯誤
+func (a *App) loadTemplateFromPath(w http.ResponseWriter, r *http.Request) (*PromptTemplate, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid template id")
+		return nil, false
+	}
+	var tmpl PromptTemplate
+	if err := identityFromRequest(r).scope(a.DB).First(&tmpl, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, "template not found")
+		} else {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	tmpl.Variables = templateVariables(tmpl.Template, tmpl.NegativePrompt)
+	return &tmpl, true
+}
+
+// 名稱重複時回 409
+func writeTemplateError(w http.ResponseWriter, err error) {
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "unique") || strings.Contains(msg, "duplicate") {
+		writeError(w, http.StatusConflict, "template name already exists")
+		return
+	}
+	writeTaskError(w, err)
+}
+
+// GET /api/templates
+func (a *App) apiListTemplates(w http.ResponseWriter, r *http.Request) {
+	var templates []PromptTemplate
+	if err := identityFromRequest(r).scope(a.DB).Order("name asc").Find(&templates).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for i := range templates {
+		templates[i].Variables = templateVariables(templates[i].Template, templates[i].NegativePrompt)
+	}
+	if templates == nil {
+		templates = []PromptTemplate{}
+	}
+	writeJSON(w, http.StatusOK, templates)
+}
+
+// POST /api/templates {"name","template","negative_prompt","description"}
+func (a *App) apiCreateTemplate(w http.ResponseWriter, r *http.Request) {
+	var tmpl PromptTemplate
+	if err := json.NewDecoder(r.Body).Decode(&tmpl); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	tmpl.ID = 0
+	tmpl.UserID = identityFromRequest(r).userID()
+	if err := tmpl.validate(); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	if err := a.DB.Create(&tmpl).Error; err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	tmpl.Variables = templateVariables(tmpl.Template, tmpl.NegativePrompt)
+	writeJSON(w, http.StatusCreated, tmpl)
+}
+
+// GET /api/templates/{id}
+func (a *App) apiGetTemplate(w http.ResponseWriter, r *http.Request) {
+	tmpl, ok := a.loadTemplateFromPath(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, tmpl)
+}
+
+// PUT /api/templates/{id}，整筆取代 name、template、negative_prompt、description
+func (a *App) apiUpdateTemplate(w http.ResponseWriter, r *http.Request) {
+	tmpl, ok := a.loadTemplateFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req PromptTemplate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	tmpl.Name, tmpl.Template, tmpl.NegativePrompt, tmpl.Description = req.Name, req.Template, req.NegativePrompt, req.Description
+	if err := tmpl.validate(); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	if err := a.DB.Save(tmpl).Error; err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	tmpl.Variables = templateVariables(tmpl.Template, tmpl.NegativePrompt)
+	writeJSON(w, http.StatusOK, tmpl)
+}
+
+// DELETE /api/templates/{id}
+func (a *App) apiDeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	tmpl, ok := a.loadTemplateFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := a.DB.Delete(tmpl).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
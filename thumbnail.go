@@ -0,0 +1,67 @@
+// thumbnail.go
+package main
+
+import (
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// --- 縮圖 ---
+
+// 縮圖最長邊的像素，從環境變數 THUMBNAIL_SIZE 取得，預設 256
+func thumbnailSize() int {
+	n, err := strconv.Atoi(os.Getenv("THUMBNAIL_SIZE"))
+	if err != nil || n < 16 {
+		return 256
+	}
+	return n
+}
+
+// 縮圖檔名：task_1_123.png -> task_1_123_thumb.jpg (原圖為 JPEG / WebP 時相同)
+func thumbnailName(imageName string) string {
+	return strings.TrimSuffix(imageName, filepath.Ext(imageName)) + "_thumb.jpg"
+}
+
+// 依原圖產生 JPEG 縮圖，保持長寬比
+func createThumbnail(srcPath, dstPath string, maxSize int) error {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return err
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > maxSize || h > maxSize {
+		if w >= h {
+			h = h * maxSize / w
+			w = maxSize
+		} else {
+			w = w * maxSize / h
+			h = maxSize
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, max(w, 1), max(h, 1)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+
+	out, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(out, dst, &jpeg.Options{Quality: 80}); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,90 @@
+// timeline.go
+package main
+
+import "time"
+
+// --- 任務生命週期 ---
+// 任務記錄 queued_at (進入佇列，重新排隊時更新)、started_at (被 worker 認領) 與 finished_at (結束)，
+// 每次狀態轉換 (見 taskstate.go) 另外記錄一筆 TaskTransition (包含原因與執行轉換的 process)。
+// GET /api/tasks/{id} 回傳的任務帶 timeline：等待與執行時間以及完整的轉換紀錄
+
+type TaskTransition struct {
+	ID         uint       `gorm:"primaryKey" json:"-"`
+	TaskID     uint       `gorm:"index" json:"-"`
+	FromStatus TaskStatus `json:"from,omitempty"` // 建立任務時為空
+	ToStatus   TaskStatus `json:"to"`
+	Reason     string     `json:"reason,omitempty"` // 例如 cache、timeout、recovered、lease_expired、watchdog、shutdown
+	Worker     string     `json:"worker,omitempty"` // 執行轉換的 process (WORKER_ID)
+	CreatedAt  time.Time  `json:"at"`
+}
+
+// 任務的時間軸；還沒結束的階段算到現在
+type TaskTimeline struct {
+	WaitSeconds  *float64         `json:"wait_seconds,omitempty"`  // queued_at 到 started_at
+	RunSeconds   *float64         `json:"run_seconds,omitempty"`   // started_at 到 finished_at
+	TotalSeconds *float64         `json:"total_seconds,omitempty"` // created_at 到 finished_at
+	Transitions  []TaskTransition `json:"transitions"`
+}
+
+// 狀態轉換時要一起更新的時間欄位，併入 Updates 的欄位
+func lifecycleFields(to TaskStatus, now time.Time, fields map[string]interface{}) map[string]interface{} {
+	switch {
+	case to.queued():
+		fields["queued_at"], fields["started_at"], fields["finished_at"] = now, nil, nil
+	case to == StatusProcessing:
+		fields["started_at"] = now
+	case to.finished():
+		fields["finished_at"] = now
+	}
+	return fields
+}
+
+// 同 lifecycleFields，用在以整個 struct 寫回的地方
+func (t *Task) markLifecycle(now time.Time) {
+	switch {
+	case t.Status.queued():
+		t.QueuedAt, t.StartedAt, t.FinishedAt = &now, nil, nil
+	case t.Status == StatusProcessing:
+		t.StartedAt = &now
+	case t.Status.finished():
+		t.FinishedAt = &now
+	}
+}
+
+func secondsBetween(from, to *time.Time, now time.Time) *float64 {
+	if from == nil {
+		return nil
+	}
+	end := now
+	if to != nil {
+		end = *to
+	}
+	if end.Before(*from) {
+		// 排程時間還沒到就結束 (例如取消排程任務)
+		return nil
+	}
+	s := end.Sub(*from).Seconds()
+	return &s
+}
+
+// 讀取任務的時間軸
+func (a *App) taskTimeline(task *Task) (*TaskTimeline, error) {
+	timeline := &TaskTimeline{Transitions: []TaskTransition{}}
+	if err := a.DB.Where("task_id = ?", task.ID).Order("id asc").Find(&timeline.Transitions).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	// 沒有執行就結束的任務 (例如排隊中被取消) 等待時間算到結束
+	waitEnd := task.StartedAt
+	if waitEnd == nil {
+		waitEnd = task.FinishedAt
+	}
+	timeline.WaitSeconds = secondsBetween(task.QueuedAt, waitEnd, now)
+	if task.StartedAt != nil {
+		timeline.RunSeconds = secondsBetween(task.StartedAt, task.FinishedAt, now)
+	}
+	if task.FinishedAt != nil {
+		timeline.TotalSeconds = secondsBetween(&task.CreatedAt, task.FinishedAt, now)
+	}
+	return timeline, nil
+}
@@ -0,0 +1,190 @@
+// tls.go
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// --- HTTPS ---
+// 兩種方式 (都沒有設定時為一般 HTTP)：
+//   TLS_CERT / TLS_KEY：憑證檔 (例如 certbot 產生的 fullchain.pem / privkey.pem)，檔案更新後自動重新載入
+//   CERT_DOMAINS：以 Let's Encrypt 自動申請與更新憑證 (autocert)，存放在 CERT_CACHE_DIR (預設 certs)
+// 啟用 HTTPS 時另外在 HTTP_REDIRECT_PORT 提供 HTTP，把請求導向 https (autocert 也在這裡回應 HTTP-01 驗證)；
+// 憑證檔模式預設不開，autocert 預設 80。PORT 一般設為 443
+
+type tlsSettings struct {
+	CertFile     string   // TLS_CERT
+	KeyFile      string   // TLS_KEY
+	Domains      []string // CERT_DOMAINS，逗號分隔
+	CacheDir     string   // CERT_CACHE_DIR
+	Email        string   // CERT_EMAIL，Let's Encrypt 用來通知憑證問題
+	RedirectPort string   // HTTP_REDIRECT_PORT，空字串代表不提供
+}
+
+func tlsFromEnv() tlsSettings {
+	t := tlsSettings{
+		CertFile: os.Getenv("TLS_CERT"),
+		KeyFile:  os.Getenv("TLS_KEY"),
+		CacheDir: envString("CERT_CACHE_DIR", "certs"),
+		Email:    os.Getenv("CERT_EMAIL"),
+	}
+	for _, domain := range strings.Split(os.Getenv("CERT_DOMAINS"), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			t.Domains = append(t.Domains, domain)
+		}
+	}
+	t.RedirectPort = os.Getenv("HTTP_REDIRECT_PORT")
+	if _, set := os.LookupEnv("HTTP_REDIRECT_PORT"); !set && len(t.Domains) > 0 {
+		t.RedirectPort = "80"
+	}
+	return t
+}
+
+// off、files 或 autocert
+func (t tlsSettings) mode() string {
+	switch {
+	case len(t.Domains) > 0:
+		return "autocert"
+	case t.CertFile != "":
+		return "files"
+	}
+	return "off"
+}
+
+func (t tlsSettings) problems() []string {
+	var problems []string
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		problems = append(problems, "TLS_CERT and TLS_KEY must be set together")
+	}
+	if t.CertFile != "" && len(t.Domains) > 0 {
+		problems = append(problems, "TLS_CERT and CERT_DOMAINS cannot be used together")
+	}
+	if t.CertFile != "" && t.KeyFile != "" {
+		if _, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile); err != nil {
+			problems = append(problems, fmt.Sprintf("TLS_CERT=%q: %v", t.CertFile, err))
+		}
+	}
+	if t.RedirectPort != "" {
+		if n, err := strconv.Atoi(t.RedirectPort); err != nil || n < 1 || n > 65535 {
+			problems = append(problems, fmt.Sprintf("HTTP_REDIRECT_PORT=%q: must be a port number", t.RedirectPort))
+		}
+	}
+	return problems
+}
+
+// 設定 server 的 TLS 並開始接受連線 (不會阻塞)；回傳導向用 HTTP server 的關閉函式
+func (a *App) listen(server *http.Server, start func()) func() {
+	t := a.Config.TLS
+	switch t.mode() {
+	case "off":
+		go start()
+		return func() {}
+	case "files":
+		reloader := &certReloader{certFile: t.CertFile, keyFile: t.KeyFile}
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: reloader.getCertificate}
+		slog.Info("HTTPS enabled", "cert", t.CertFile, "port", a.Config.Port)
+		go serveTLS(server)
+		return a.listenRedirect(nil)
+	default:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(t.Domains...),
+			Cache:      autocert.DirCache(t.CacheDir),
+			Email:      t.Email,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		server.TLSConfig.MinVersion = tls.VersionTLS12
+		slog.Info("HTTPS enabled (Let's Encrypt)", "domains", t.Domains, "cache_dir", t.CacheDir, "port", a.Config.Port)
+		go serveTLS(server)
+		return a.listenRedirect(manager)
+	}
+}
+
+func serveTLS(server *http.Server) {
+	// 憑證由 TLSConfig 提供
+	if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+		slog.Error("HTTPS server error", "error", err)
+	}
+}
+
+// HTTP_REDIRECT_PORT 上的 HTTP：導向 https，autocert 時也回應 ACME 的 HTTP-01 驗證
+func (a *App) listenRedirect(manager *autocert.Manager) func() {
+	port := a.Config.TLS.RedirectPort
+	if port == "" {
+		return func() {}
+	}
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if a.Config.Port != "443" {
+			host = net.JoinHostPort(host, a.Config.Port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	if manager != nil {
+		handler = manager.HTTPHandler(handler)
+	}
+	redirect := &http.Server{Addr: ":" + port, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("HTTP redirect server error", "port", port, "error", err)
+		}
+	}()
+	slog.Info("Redirecting HTTP to HTTPS", "port", port)
+	return func() { redirect.Close() }
+}
+
+// 憑證檔更新 (例如 certbot renew) 後不需重新啟動：每分鐘最多檢查一次修改時間
+type certReloader struct {
+	certFile, keyFile string
+	mutex             sync.Mutex
+	cert              *tls.Certificate
+	modTime           time.Time
+	checkedAt         time.Time
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.cert != nil && time.Since(c.checkedAt) < time.Minute {
+		return c.cert, nil
+	}
+	c.checkedAt = time.Now()
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		if c.cert != nil {
+			return c.cert, nil
+		}
+		return nil, err
+	}
+	if c.cert != nil && info.ModTime().Equal(c.modTime) {
+		return c.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		// 更新到一半 (憑證與金鑰不一致) 時先沿用舊的
+		if c.cert != nil {
+			slog.Warn("Reload TLS certificate error", "cert", c.certFile, "error", err)
+			return c.cert, nil
+		}
+		return nil, err
+	}
+	if c.cert != nil {
+		slog.Info("TLS certificate reloaded", "cert", c.certFile)
+	}
+	c.cert, c.modTime = &cert, info.ModTime()
+	return c.cert, nil
+}
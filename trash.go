@@ -0,0 +1,251 @@
+// trash.go
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- 垃圾桶 ---
+// DELETE /api/tasks/{id} 把任務移到垃圾桶 (gorm soft delete，設定 deleted_at)，圖片移到儲存後端的 trash/ 下；
+// 垃圾桶中的任務不會出現在列表、佇列與匯出，也不會被 worker 認領。
+// POST /api/tasks/{id}/restore 還原，超過 TRASH_DAYS 天的項目由背景工作永久刪除
+
+const trashPrefix = "trash/"
+
+// 移到垃圾桶後的儲存 key
+func trashKey(key string) string {
+	return trashPrefix + key
+}
+
+var errTaskProcessing = &codedError{Code: "conflict", Message: "task is processing"}
+
+// 垃圾桶保留天數，從環境變數 TRASH_DAYS 取得，預設 30 天，0 代表不自動清除
+func trashDays() int {
+	n, err := strconv.Atoi(os.Getenv("TRASH_DAYS"))
+	if err != nil || n < 0 {
+		return 30
+	}
+	return n
+}
+
+// 移到垃圾桶；與其他任務共用的圖片 (生成結果快取) 留在原處
+func (a *App) trashTask(ctx context.Context, task *Task) error {
+	// 正在執行中的任務不能刪除，否則 worker 完成後會寫回已刪除的資料
+	result := a.DB.Where("status <> ?", StatusProcessing).Delete(task)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errTaskProcessing
+	}
+	inUse := a.imageKeysInUse(task)
+	for _, key := range task.imageKeys() {
+		// 被隔離的圖片留在 quarantine/，仍由管理員處理
+		if inUse[key] || task.Quarantined {
+			continue
+		}
+		if err := a.storageFor(task).Move(ctx, key, trashKey(key)); err != nil {
+			slog.Warn("Move image to trash error", "task_id", task.ID, "key", key, "error", err)
+		}
+	}
+	if task.Status.queued() {
+		a.notifyQueuePositions()
+	}
+	a.Hub.notify("deleted", *task)
+	a.auditTask(ctx, "task.deleted", task, "")
+	return nil
+}
+
+// 從垃圾桶還原；先把圖片移回再清除 deleted_at，避免還原後短暫讀不到圖片
+func (a *App) restoreTask(ctx context.Context, id uint, identity *Identity) (*Task, error) {
+	var task Task
+	if err := identity.taskScope(a.DB.Unscoped()).Where("deleted_at IS NOT NULL").First(&task, id).Error; err != nil {
+		return nil, err
+	}
+	if err := a.loadTaskImages(&task); err != nil {
+		return nil, err
+	}
+	for _, key := range task.imageKeys() {
+		if err := a.storageFor(&task).Move(ctx, trashKey(key), key); err != nil {
+			return nil, err
+		}
+	}
+	// 一併更新 updated_at，斷線重連的 client 以此補送還原 (見 resume.go)
+	now := time.Now()
+	if err := a.DB.Unscoped().Model(&task).UpdateColumns(map[string]interface{}{"deleted_at": nil, "updated_at": now}).Error; err != nil {
+		return nil, err
+	}
+	task.DeletedAt, task.UpdatedAt = gorm.DeletedAt{}, now
+	if task.Status.queued() {
+		a.signalDispatch(task.ID)
+		a.notifyQueuePositions()
+	}
+	a.Hub.notify("restored", task)
+	a.auditTask(ctx, "task.restored", &task, "")
+	return &task, nil
+}
+
+// 永久刪除任務 (不論是否在垃圾桶) 與圖片；已被其他 process 刪除時不做任何事
+func (a *App) purgeTask(ctx context.Context, task *Task) error {
+	inUse := a.imageKeysInUse(task)
+	result := a.DB.Unscoped().Delete(task)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	a.DB.Where("task_id = ?", task.ID).Delete(&TaskImage{})
+	a.removeTaskLinks(task.ID)
+	for _, key := range task.imageKeys() {
+		if inUse[key] {
+			continue
+		}
+		for _, k := range []string{key, trashKey(key), quarantineKey(key)} {
+			if err := a.storageFor(task).Delete(ctx, k); err != nil {
+				slog.Warn("Delete image error", "task_id", task.ID, "key", k, "error", err)
+			}
+		}
+		// S3_KEEP_LOCAL 留下的本機副本
+		os.Remove(filepath.Join(a.Config.ImageDir, filepath.Base(key)))
+	}
+	a.removeUploads(task)
+	if !task.DeletedAt.Valid {
+		a.Hub.notify("deleted", *task)
+	}
+	a.auditTask(ctx, "task.purged", task, "")
+	return nil
+}
+
+// 背景定期永久刪除超過保留天數的垃圾桶項目；TRASH_DAYS 為 0 時不刪除 (可以重新載入，見 reload.go)
+func (a *App) startTrashPurge(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			if days := a.Config.TrashDays; days > 0 {
+				a.purgeTrash(ctx, time.Now().AddDate(0, 0, -days))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (a *App) purgeTrash(ctx context.Context, before time.Time) {
+	var tasks []Task
+	if err := a.DB.Unscoped().Where("deleted_at < ?", before).Order("deleted_at asc").Find(&tasks).Error; err != nil {
+		slog.Error("Trash query error", "error", err)
+		return
+	}
+	purged := 0
+	for i := range tasks {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := a.purgeTask(ctx, &tasks[i]); err != nil {
+			slog.Error("Purge task error", "task_id", tasks[i].ID, "error", err)
+			continue
+		}
+		purged++
+	}
+	if purged > 0 {
+		slog.Info("Trash purged", "tasks", purged, "before", before)
+	}
+}
+
+// --- API ---
+
+// 解析路徑中的 id 並讀取自己垃圾桶中的任務
+func (a *App) loadTrashedTaskFromPath(w http.ResponseWriter, r *http.Request) (*Task, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid task id")
+		return nil, false
+	}
+	var task Task
+	if err := identityFromRequest(r).taskScope(a.DB.Unscoped()).Where("deleted_at IS NOT NULL").First(&task, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, "task not found in trash")
+		} else {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	return &task, true
+}
+
+// GET /api/trash?limit=50
+func (a *App) apiListTrash(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = min(n, 200)
+	}
+	var tasks []Task
+	err := identityFromRequest(r).taskScope(a.DB.Unscoped()).Where("deleted_at IS NOT NULL").
+		Order("deleted_at desc").Limit(limit).Find(&tasks).Error
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+// POST /api/tasks/{id}/restore
+func (a *App) apiRestoreTask(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid task id")
+		return
+	}
+	task, err := a.restoreTask(r.Context(), uint(id), identityFromRequest(r))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, "task not found in trash")
+		} else {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+// DELETE /api/trash/{id}：立即永久刪除
+func (a *App) apiPurgeTask(w http.ResponseWriter, r *http.Request) {
+	task, ok := a.loadTrashedTaskFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := a.purgeTask(r.Context(), task); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE /api/trash：清空自己的垃圾桶
+func (a *App) apiEmptyTrash(w http.ResponseWriter, r *http.Request) {
+	var tasks []Task
+	if err := identityFromRequest(r).taskScope(a.DB.Unscoped()).Where("deleted_at IS NOT NULL").Find(&tasks).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	purged := 0
+	for i := range tasks {
+		if err := a.purgeTask(r.Context(), &tasks[i]); err != nil {
+			slog.Error("Purge task error", "task_id", tasks[i].ID, "error", err)
+			continue
+		}
+		purged++
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"purged": purged})
+}
@@ -0,0 +1,359 @@
+// upload.go
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "golang.org/x/image/webp"
+)
+
+// --- 上傳圖片 (img2img / inpainting) ---
+
+// 上傳檔案存放目錄，從環境變數 UPLOAD_DIR 取得；與生成結果一樣不放在 DocumentRoot 下
+func uploadDir() string {
+	if dir := os.Getenv("UPLOAD_DIR"); dir != "" {
+		return dir
+	}
+	return "www/data/uploads"
+}
+
+// 單一上傳檔案大小上限 (MB)，從環境變數 MAX_UPLOAD_MB 取得，預設 20
+func maxUploadMB() int {
+	if n := envInt("MAX_UPLOAD_MB"); n > 0 {
+		return n
+	}
+	return 20
+}
+
+// 接受的圖片格式與存檔副檔名
+var uploadFormats = map[string]string{"png": ".png", "jpeg": ".jpg", "webp": ".webp"}
+
+// 儲存上傳的圖片，回傳 UploadDir 下的檔名；不是圖片或尺寸過大時回傳 ValidationError
+// field 為表單欄位名稱 (用於錯誤訊息)，prefix 為檔名前綴
+func (a *App) saveUpload(file io.ReadSeeker, field, prefix string) (string, error) {
+	cfg, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return "", invalidf("%s must be a PNG, JPEG or WebP image", field)
+	}
+	ext, ok := uploadFormats[format]
+	if !ok {
+		return "", invalidf("%s must be a PNG, JPEG or WebP image", field)
+	}
+	if cfg.Width > 4096 || cfg.Height > 4096 {
+		return "", invalidf("%s must not exceed 4096x4096", field)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 6)
+	rand.Read(b)
+	name := fmt.Sprintf("%s_%d_%s%s", prefix, time.Now().Unix(), hex.EncodeToString(b), ext)
+	if err := os.MkdirAll(a.Config.UploadDir, os.ModePerm); err != nil {
+		return "", err
+	}
+	dst, err := os.Create(filepath.Join(a.Config.UploadDir, name))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, file); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return name, dst.Close()
+}
+
+// 上傳檔案的絕對路徑，交給生成後端使用；name 為空時回傳空字串
+func (a *App) uploadPath(name string) string {
+	if name == "" {
+		return ""
+	}
+	path, _ := filepath.Abs(filepath.Join(a.Config.UploadDir, filepath.Base(name)))
+	return path
+}
+
+// 刪除任務用到的上傳檔案
+func (a *App) removeUploads(task *Task) {
+	for _, name := range []string{task.InitImagePath, task.MaskPath, task.ControlImagePath} {
+		if name != "" {
+			os.Remove(a.uploadPath(name))
+		}
+	}
+}
+
+// 複製來源任務的原始圖片、遮罩與條件圖給新任務 (刪除任務時會一併刪除上傳檔案，不能共用)
+func (a *App) copyUploads(src, dst *Task) error {
+	for _, f := range []struct {
+		name   string
+		target *string
+		prefix string
+	}{{src.InitImagePath, &dst.InitImagePath, "init"}, {src.MaskPath, &dst.MaskPath, "mask"}, {src.ControlImagePath, &dst.ControlImagePath, "control"}} {
+		if f.name == "" {
+			continue
+		}
+		file, err := os.Open(a.uploadPath(f.name))
+		if err != nil {
+			a.removeUploads(dst)
+			return err
+		}
+		name, err := a.saveUpload(file, f.prefix, f.prefix)
+		file.Close()
+		if err != nil {
+			a.removeUploads(dst)
+			return err
+		}
+		*f.target = name
+	}
+	return nil
+}
+
+// 讀取上傳檔案的尺寸
+func (a *App) uploadSize(name string) (int, int, error) {
+	f, err := os.Open(a.uploadPath(name))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// 原始圖片：上傳的 image 檔案，或以 source_task_id 指定自己已完成任務的結果
+func (a *App) initImageFromRequest(r *http.Request) (string, error) {
+	if s := r.FormValue("source_task_id"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return "", invalidf("invalid source_task_id")
+		}
+		var source Task
+		if err := identityFromRequest(r).taskScope(a.DB).First(&source, id).Error; err != nil || source.ImagePath == "" {
+			return "", invalidf("source task %d has no image", id)
+		}
+		f, _, err := a.storageFor(&source).Open(r.Context(), source.ImagePath)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		return a.saveUpload(f, "source image", "init")
+	}
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		return "", invalidf("image file or source_task_id is required")
+	}
+	defer file.Close()
+	return a.saveUpload(file, "image", "init")
+}
+
+// 選填的遮罩 (白色為要重畫的區域)，尺寸必須與原始圖片相同
+func (a *App) maskFromRequest(r *http.Request, initImage string) (string, error) {
+	file, _, err := r.FormFile("mask")
+	if errors.Is(err, http.ErrMissingFile) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	mask, err := a.saveUpload(file, "mask", "mask")
+	if err != nil {
+		return "", err
+	}
+	iw, ih, err1 := a.uploadSize(initImage)
+	mw, mh, err2 := a.uploadSize(mask)
+	if err1 != nil || err2 != nil || iw != mw || ih != mh {
+		os.Remove(a.uploadPath(mask))
+		return "", invalidf("mask must have the same size as the image")
+	}
+	return mask, nil
+}
+
+// 從 multipart 表單讀取生成參數，欄位名稱與 JSON 相同
+func paramsFromForm(r *http.Request) (GenerationParams, error) {
+	var p GenerationParams
+	p.NegativePrompt = strings.TrimSpace(r.FormValue("negative_prompt"))
+	p.Model = strings.TrimSpace(r.FormValue("model"))
+	p.Format = strings.ToLower(strings.TrimSpace(r.FormValue("format")))
+	p.ControlType = strings.ToLower(strings.TrimSpace(r.FormValue("control_type")))
+	// loras 與 JSON 相同，為 JSON 陣列字串
+	if s := strings.TrimSpace(r.FormValue("loras")); s != "" {
+		if err := json.Unmarshal([]byte(s), &p.LoRAs); err != nil {
+			return p, invalidf("invalid loras")
+		}
+	}
+	ints := []struct {
+		name  string
+		value *int
+	}{{"width", &p.Width}, {"height", &p.Height}, {"steps", &p.Steps}, {"upscale", &p.Upscale}, {"quality", &p.Quality}}
+	for _, f := range ints {
+		if s := r.FormValue(f.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return p, invalidf("invalid %s", f.name)
+			}
+			*f.value = n
+		}
+	}
+	floats := []struct {
+		name  string
+		value *float64
+	}{{"guidance_scale", &p.GuidanceScale}, {"strength", &p.Strength}, {"control_scale", &p.ControlScale}}
+	for _, f := range floats {
+		if s := r.FormValue(f.name); s != "" {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return p, invalidf("invalid %s", f.name)
+			}
+			*f.value = v
+		}
+	}
+	if s := r.FormValue("seed"); s != "" {
+		seed, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return p, invalidf("invalid seed")
+		}
+		p.Seed = &seed
+	}
+	bools := []struct {
+		name  string
+		value **bool
+	}{{"watermark", &p.Watermark}, {"strip_metadata", &p.StripMetadata}}
+	for _, f := range bools {
+		if s := r.FormValue(f.name); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return p, invalidf("invalid %s", f.name)
+			}
+			*f.value = &b
+		}
+	}
+	return p, nil
+}
+
+// POST /api/tasks/img2img (multipart/form-data)
+// image (或 source_task_id): 原始圖片，mask: 選填的 inpainting 遮罩，
+// prompt、strength 與其他生成參數為一般欄位
+func (a *App) apiCreateImg2ImgTask(w http.ResponseWriter, r *http.Request) {
+	a.createUploadTask(w, r, false)
+}
+
+// img2img 與 ControlNet 共用：controlNet 時必須有條件圖，原始圖片為選填
+func (a *App) createUploadTask(w http.ResponseWriter, r *http.Request, controlNet bool) {
+	limit := int64(a.Config.MaxUploadMB) << 20
+	r.Body = http.MaxBytesReader(w, r.Body, limit+1<<20)
+	if err := r.ParseMultipartForm(limit); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeErrorf(w, http.StatusRequestEntityTooLarge, "upload must not exceed %d MB", a.Config.MaxUploadMB)
+		} else {
+			writeError(w, http.StatusBadRequest, "invalid multipart form")
+		}
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	params, err := paramsFromForm(r)
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	// 與 JSON 的 "enhance" 相同，不屬於生成參數
+	var enhance bool
+	if s := r.FormValue("enhance"); s != "" {
+		if enhance, err = strconv.ParseBool(s); err != nil {
+			writeTaskError(w, invalidf("invalid enhance"))
+			return
+		}
+	}
+	var scheduledAt *time.Time
+	if s := r.FormValue("scheduled_at"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid scheduled_at")
+			return
+		}
+		scheduledAt = &t
+	}
+	var expiresAt *time.Time
+	if s := r.FormValue("expires_at"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid expires_at")
+			return
+		}
+		expiresAt = &t
+	}
+
+	// 已經存好的上傳檔案，失敗時一併刪除
+	uploads := &Task{}
+	if !controlNet || r.FormValue("source_task_id") != "" || len(r.MultipartForm.File["image"]) > 0 {
+		if uploads.InitImagePath, err = a.initImageFromRequest(r); err != nil {
+			writeTaskError(w, err)
+			return
+		}
+	}
+	if uploads.InitImagePath == "" && len(r.MultipartForm.File["mask"]) > 0 {
+		writeTaskError(w, invalidField("mask", "mask requires an image"))
+		return
+	}
+	if uploads.InitImagePath != "" {
+		if uploads.MaskPath, err = a.maskFromRequest(r, uploads.InitImagePath); err != nil {
+			a.removeUploads(uploads)
+			writeTaskError(w, err)
+			return
+		}
+	}
+	if controlNet {
+		if uploads.ControlImagePath, err = a.controlImageFromRequest(r); err != nil {
+			a.removeUploads(uploads)
+			writeTaskError(w, err)
+			return
+		}
+	}
+
+	identity := identityFromRequest(r)
+	var parentID *uint
+	if id, err := strconv.ParseUint(r.FormValue("source_task_id"), 10, 64); err == nil {
+		// 以自己的任務結果作為原始圖片，記錄來源 (見 lineage.go)
+		parent := uint(id)
+		parentID = &parent
+	}
+	task, err := a.createTask(r.Context(), Task{
+		Prompt:           strings.TrimSpace(r.FormValue("prompt")),
+		GenerationParams: params,
+		InitImagePath:    uploads.InitImagePath,
+		MaskPath:         uploads.MaskPath,
+		ControlImagePath: uploads.ControlImagePath,
+		ScheduledAt:      scheduledAt,
+		ExpiresAt:        expiresAt,
+		CallbackURL:      strings.TrimSpace(r.FormValue("callback_url")),
+		CreatedBy:        identity.keyName(),
+		UserID:           identity.userID(),
+		ParentTaskID:     parentID,
+		Enhance:          enhance,
+	})
+	if err != nil {
+		a.removeUploads(uploads)
+		writeTaskError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, task)
+}
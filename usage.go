@@ -0,0 +1,236 @@
+// usage.go
+package main
+
+import (
+	"cmp"
+	"image"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// --- 用量統計 ---
+// 每個任務記錄三種成本 (多次認領時累加)：
+//   gpu_seconds：生成與放大佔用 GPU 的秒數
+//   pixel_steps：寬 × 高 × steps × 張數，與 GPU 型號無關的計算量
+//   wall_seconds：worker 從開始處理到寫回結果的秒數
+// 同時累加到 usage_daily (使用者 × 日期)，任務被清除後用量仍然保留
+
+// steps、寬高未指定時以 Z-Image-Turbo 的預設值估算
+const (
+	usageDefaultSteps = 9
+	usageDefaultSize  = 1024
+)
+
+type UsageDaily struct {
+	ID          uint    `gorm:"primaryKey" json:"-"`
+	UserID      uint    `gorm:"uniqueIndex:idx_usage_user_day" json:"user_id"`
+	Day         string  `gorm:"size:10;uniqueIndex:idx_usage_user_day;index" json:"day"` // 2006-01-02 (伺服器時區)
+	Tasks       int64   `json:"tasks"`                                                   // 結束 (Completed、Failed、Cancelled) 的任務數
+	GPUSeconds  float64 `json:"gpu_seconds"`
+	PixelSteps  int64   `json:"pixel_steps"`
+	WallSeconds float64 `json:"wall_seconds"`
+}
+
+func (UsageDaily) TableName() string { return "usage_daily" }
+
+// 一次處理的成本
+type taskCost struct {
+	GPU        time.Duration
+	Wall       time.Duration
+	PixelSteps int64
+}
+
+// 生成成功後計算 pixel-steps：寬高以實際輸出的圖片為準 (參數未指定時由腳本決定)，讀不到時退回參數
+func (t *Task) pixelSteps(outputPath string) int64 {
+	width, height := t.Width, t.Height
+	if f, err := os.Open(outputPath); err == nil {
+		if cfg, _, err := image.DecodeConfig(f); err == nil {
+			width, height = cfg.Width, cfg.Height
+		}
+		f.Close()
+	}
+	if width <= 0 {
+		width = usageDefaultSize
+	}
+	if height <= 0 {
+		height = usageDefaultSize
+	}
+	steps := t.Steps
+	if steps <= 0 {
+		steps = usageDefaultSteps
+	}
+	return int64(width) * int64(height) * int64(steps) * int64(t.imageCount())
+}
+
+// 累加到任務上，寫回資料庫前呼叫
+func (t *Task) addCost(cost taskCost) {
+	t.GPUSeconds += cost.GPU.Seconds()
+	t.WallSeconds += cost.Wall.Seconds()
+	t.PixelSteps += cost.PixelSteps
+}
+
+// 累加到使用者當天的用量；finished 為 false 代表任務被放回佇列 (不計入任務數)。失敗只記 log
+func (a *App) recordUsage(userID uint, at time.Time, cost taskCost, finished bool) {
+	entry := UsageDaily{
+		UserID:      userID,
+		Day:         at.Format("2006-01-02"),
+		GPUSeconds:  cost.GPU.Seconds(),
+		PixelSteps:  cost.PixelSteps,
+		WallSeconds: cost.Wall.Seconds(),
+	}
+	if finished {
+		entry.Tasks = 1
+	}
+	err := a.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"tasks":        gorm.Expr("usage_daily.tasks + ?", entry.Tasks),
+			"gpu_seconds":  gorm.Expr("usage_daily.gpu_seconds + ?", entry.GPUSeconds),
+			"pixel_steps":  gorm.Expr("usage_daily.pixel_steps + ?", entry.PixelSteps),
+			"wall_seconds": gorm.Expr("usage_daily.wall_seconds + ?", entry.WallSeconds),
+		}),
+	}).Create(&entry).Error
+	if err != nil {
+		slog.Error("Record usage error", "user_id", userID, "error", err)
+	}
+}
+
+// 用量合計
+type usageTotal struct {
+	Tasks       int64   `json:"tasks"`
+	GPUSeconds  float64 `json:"gpu_seconds"`
+	PixelSteps  int64   `json:"pixel_steps"`
+	WallSeconds float64 `json:"wall_seconds"`
+}
+
+func (t *usageTotal) add(d UsageDaily) {
+	t.Tasks += d.Tasks
+	t.GPUSeconds += d.GPUSeconds
+	t.PixelSteps += d.PixelSteps
+	t.WallSeconds += d.WallSeconds
+}
+
+type usageReport struct {
+	From  string       `json:"from"`
+	To    string       `json:"to"`
+	Days  []UsageDaily `json:"days"`
+	Total usageTotal   `json:"total"`
+}
+
+// 使用者的用量，依使用者彙總
+type userCost struct {
+	UserID uint   `json:"user_id"`
+	Name   string `json:"name"`
+	usageTotal
+}
+
+type adminUsageReport struct {
+	From  string       `json:"from"`
+	To    string       `json:"to"`
+	Users []userCost   `json:"users"` // 依 gpu_seconds 由多到少
+	Days  []UsageDaily `json:"days"`
+	Total usageTotal   `json:"total"`
+}
+
+// from / to (含) 可用 2006-01-02 或 RFC3339，預設為最近 30 天
+func usageRange(r *http.Request) (from, to string, err error) {
+	now := time.Now()
+	to = now.Format("2006-01-02")
+	from = now.AddDate(0, 0, -29).Format("2006-01-02")
+	if s := r.URL.Query().Get("from"); s != "" {
+		t, err := parseDateParam(s)
+		if err != nil {
+			return "", "", invalidField("from", "invalid from")
+		}
+		from = t.Local().Format("2006-01-02")
+	}
+	if s := r.URL.Query().Get("to"); s != "" {
+		t, err := parseDateParam(s)
+		if err != nil {
+			return "", "", invalidField("to", "invalid to")
+		}
+		to = t.Local().Format("2006-01-02")
+	}
+	if from > to {
+		return "", "", invalidField("from", "from must not be after to")
+	}
+	return from, to, nil
+}
+
+func (a *App) usageDays(q *gorm.DB, from, to string) ([]UsageDaily, usageTotal, error) {
+	days := []UsageDaily{}
+	var total usageTotal
+	err := q.Where("day BETWEEN ? AND ?", from, to).Order("day asc, user_id asc").Find(&days).Error
+	for _, d := range days {
+		total.add(d)
+	}
+	return days, total, err
+}
+
+// GET /api/usage?from=2025-01-01&to=2025-01-31
+// 自己每天的用量與合計
+func (a *App) apiUsage(w http.ResponseWriter, r *http.Request) {
+	from, to, err := usageRange(r)
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	days, total, err := a.usageDays(identityFromRequest(r).scope(a.DB), from, to)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, usageReport{From: from, To: to, Days: days, Total: total})
+}
+
+// GET /api/admin/usage/daily?from=&to=&user_id=
+// 所有使用者每天的用量，另外依使用者彙總
+func (a *App) apiAdminUsageDaily(w http.ResponseWriter, r *http.Request) {
+	from, to, err := usageRange(r)
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	q := a.DB
+	if s := r.URL.Query().Get("user_id"); s != "" {
+		userID, ok := intParam(r, "user_id", 0)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid user_id")
+			return
+		}
+		q = q.Where("user_id = ?", userID)
+	}
+	days, total, err := a.usageDays(q, from, to)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	totals := make(map[uint]*usageTotal)
+	ids := []uint{}
+	for _, d := range days {
+		if totals[d.UserID] == nil {
+			totals[d.UserID] = &usageTotal{}
+			ids = append(ids, d.UserID)
+		}
+		totals[d.UserID].add(d)
+	}
+	var found []User
+	a.DB.Where("id IN ?", ids).Find(&found)
+	names := make(map[uint]string, len(found))
+	for _, u := range found {
+		names[u.ID] = u.Name
+	}
+	users := make([]userCost, 0, len(ids))
+	for _, id := range ids {
+		users = append(users, userCost{UserID: id, Name: names[id], usageTotal: *totals[id]})
+	}
+	slices.SortFunc(users, func(a, b userCost) int { return cmp.Compare(b.GPUSeconds, a.GPUSeconds) })
+	writeJSON(w, http.StatusOK, adminUsageReport{From: from, To: to, Users: users, Days: days, Total: total})
+}
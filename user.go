@@ -0,0 +1,130 @@
+// user.go
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- 使用者與任務擁有權 ---
+type User struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"uniqueIndex" json:"name"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`             // viewer、creator、admin，見 rbac.go
+	Locale    string    `json:"locale,omitempty"` // 錯誤訊息的語系 (見 i18n.go)，空字串代表依 Accept-Language
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 依名稱取得使用者，不存在時以 DEFAULT_USER_ROLE 的角色自動建立
+func (a *App) findOrCreateUser(name string) (*User, error) {
+	var user User
+	err := a.DB.Where(User{Name: name}).Attrs(User{Role: a.Config.DefaultUserRole}).FirstOrCreate(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// 從 request 解析使用者的方法，依序嘗試；API key 之外的登入方式
+// (例如瀏覽器登入的 session cookie，見 oauth.go) 可透過 registerUserResolver 加入
+type userResolver func(r *http.Request) (*Identity, bool)
+
+func (a *App) registerUserResolver(resolver userResolver) {
+	a.userResolvers = append(a.userResolvers, resolver)
+}
+
+func (a *App) resolveIdentity(r *http.Request) (*Identity, bool) {
+	if identity, ok := a.lookupAPIKey(tokenFromRequest(r)); ok {
+		return identity, true
+	}
+	for _, resolver := range a.userResolvers {
+		if identity, ok := resolver(r); ok {
+			return identity, true
+		}
+	}
+	return nil, false
+}
+
+// 限制查詢範圍為該使用者的資料 (標籤、範本等)；未啟用驗證 (identity 為 nil) 時不限制
+func (id *Identity) scope(q *gorm.DB) *gorm.DB {
+	if id == nil || id.UserID == 0 {
+		return q
+	}
+	return q.Where("user_id = ?", id.UserID)
+}
+
+// 限制查詢範圍為呼叫者看得到的任務：選擇工作區時為工作區的所有任務，否則為自己不屬於任何工作區的任務
+func (id *Identity) taskScope(q *gorm.DB) *gorm.DB {
+	if id != nil && id.WorkspaceID != 0 {
+		return q.Where("workspace_id = ?", id.WorkspaceID)
+	}
+	if id == nil || id.UserID == 0 {
+		return q
+	}
+	return q.Where("user_id = ? AND workspace_id IS NULL", id.UserID)
+}
+
+func (id *Identity) owns(task *Task) bool {
+	return id.sees(task.UserID, task.WorkspaceID)
+}
+
+// 與 taskScope 相同的條件
+func (id *Identity) sees(userID uint, workspaceID *uint) bool {
+	if id != nil && id.WorkspaceID != 0 {
+		return workspaceID != nil && *workspaceID == id.WorkspaceID
+	}
+	return id == nil || id.UserID == 0 || (userID == id.UserID && workspaceID == nil)
+}
+
+func (id *Identity) keyName() string {
+	if id == nil {
+		return ""
+	}
+	return id.KeyName
+}
+
+func (id *Identity) userID() uint {
+	if id == nil {
+		return 0
+	}
+	return id.UserID
+}
+
+func (id *Identity) workspaceID() uint {
+	if id == nil {
+		return 0
+	}
+	return id.WorkspaceID
+}
+
+// 目前的呼叫者 (網頁以此判斷是否已登入與顯示的功能)
+type meResponse struct {
+	AuthEnabled   bool   `json:"auth_enabled"`
+	UserID        uint   `json:"user_id,omitempty"`
+	Name          string `json:"name,omitempty"`
+	Role          string `json:"role,omitempty"`
+	WorkspaceID   uint   `json:"workspace_id,omitempty"`
+	WorkspaceRole string `json:"workspace_role,omitempty"`
+	Locale        string `json:"locale"` // 這個請求使用的語系
+}
+
+// GET /api/me
+func (a *App) apiMe(w http.ResponseWriter, r *http.Request) {
+	identity := identityFromRequest(r)
+	if identity == nil {
+		writeJSON(w, http.StatusOK, meResponse{Locale: localeFrom(r.Context())})
+		return
+	}
+	writeJSON(w, http.StatusOK, meResponse{
+		AuthEnabled:   true,
+		UserID:        identity.UserID,
+		Name:          identity.KeyName,
+		Role:          identity.Role,
+		WorkspaceID:   identity.WorkspaceID,
+		WorkspaceRole: identity.WorkspaceRole,
+		Locale:        localeFrom(r.Context()),
+	})
+}
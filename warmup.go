@@ -0,0 +1,158 @@
+// warmup.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// --- 啟動暖機 (WARMUP) ---
+// 第一次生成要從磁碟 (或 Hugging Face) 載入模型權重，常常比之後的任務慢上好幾倍。
+// WARMUP=true 時 worker 開始認領任務前，先以很小的解析度與 steps 生成一張丟棄的圖片，
+// 把權重讀進記憶體與檔案快取；有多張 GPU 時每張各生成一次。暖機完成之前 /readyz 回報 warmup 失敗。
+// 暖機失敗只記錄錯誤，worker 仍然啟動；之後第一個成功的任務會讓 /readyz 恢復正常
+
+type warmupConfig struct {
+	Enabled bool
+	Size    int // 寬高 (WARMUP_SIZE)
+	Steps   int // (WARMUP_STEPS)
+	Timeout time.Duration
+}
+
+func warmupFromEnv() warmupConfig {
+	w := warmupConfig{Enabled: os.Getenv("WARMUP") == "true", Size: 256, Steps: 1, Timeout: 10 * time.Minute}
+	if n := envInt("WARMUP_SIZE"); n > 0 {
+		w.Size = n
+	}
+	if n := envInt("WARMUP_STEPS"); n > 0 {
+		w.Steps = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("WARMUP_TIMEOUT")); err == nil && d > 0 {
+		w.Timeout = d
+	}
+	return w
+}
+
+func (w warmupConfig) problems() []string {
+	if err := (GenerationParams{Width: w.Size, Height: w.Size, Steps: w.Steps}).Validate(); err != nil {
+		return []string{fmt.Sprintf("WARMUP_SIZE / WARMUP_STEPS: %v", err)}
+	}
+	return nil
+}
+
+// 暖機狀態，readyz 與 worker 共用
+type warmupState struct {
+	mutex      sync.Mutex
+	status     string // off、running、ok、failed
+	err        string
+	duration   time.Duration
+	finishedAt time.Time
+}
+
+func (s *warmupState) set(status string, err error, duration time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status, s.err, s.duration = status, "", duration
+	if err != nil {
+		s.err = err.Error()
+	}
+	if status != "running" {
+		s.finishedAt = time.Now()
+	}
+}
+
+// 任務生成成功，代表模型已經可以使用
+func (s *warmupState) taskSucceeded() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.status == "failed" {
+		s.status, s.err = "ok", ""
+	}
+}
+
+func (a *App) checkWarmup() checkResult {
+	s := &a.warmup
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch s.status {
+	case "", "off":
+		return checkOK(map[string]string{"mode": "off"})
+	case "running":
+		return checkResult{Status: "fail", Error: "warm-up in progress"}
+	case "failed":
+		return checkResult{Status: "fail", Error: s.err, Detail: map[string]interface{}{"finished_at": s.finishedAt}}
+	}
+	return checkOK(map[string]interface{}{"duration_ms": s.duration.Milliseconds(), "finished_at": s.finishedAt})
+}
+
+// 暖機後才啟動 worker；關機時 (workerCtx 結束) 中止暖機，不再啟動 worker
+func (a *App) startWorkersAfterWarmup(n int) {
+	if !a.Config.Warmup.Enabled || n == 0 {
+		a.warmup.set("off", nil, 0)
+		a.startWorkers(n)
+		return
+	}
+	a.warmup.set("running", nil, 0)
+	// 暖機期間也算在 workerWG，shutdownWorkers 才會等待
+	a.workerWG.Add(1)
+	go func() {
+		defer a.workerWG.Done()
+		start := time.Now()
+		err := a.runWarmup(a.workerCtx)
+		if a.workerCtx.Err() != nil {
+			return
+		}
+		if err != nil {
+			a.warmup.set("failed", err, time.Since(start))
+			slog.Error("Warm-up failed, starting workers anyway", "error", err)
+		} else {
+			a.warmup.set("ok", nil, time.Since(start))
+			slog.Info("Warm-up completed", "duration", time.Since(start).Round(time.Millisecond))
+		}
+		// 暖機期間可能重新載入過 WORKER_COUNT
+		a.startWorkers(a.Config.WorkerCount)
+	}()
+}
+
+// 每張 GPU (沒有 GPU 排程時一次) 生成一張小圖，輸出寫到暫存目錄後刪除
+func (a *App) runWarmup(parent context.Context) error {
+	ctx, cancel := context.WithTimeout(parent, a.Config.Warmup.Timeout)
+	defer cancel()
+	dir, err := os.MkdirTemp("", "zimage-warmup-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	devices := a.GPUs.deviceIDs()
+	if len(devices) == 0 {
+		devices = []string{""}
+	}
+	for i, device := range devices {
+		logger := slog.Default()
+		if device != "" {
+			logger = logger.With("gpu", device)
+		}
+		logger.Info("Warm-up started", "size", a.Config.Warmup.Size, "steps", a.Config.Warmup.Steps)
+		seed := int64(0)
+		req := GenerateRequest{
+			Prompt:     "warm-up",
+			Params:     GenerationParams{Width: a.Config.Warmup.Size, Height: a.Config.Warmup.Size, Steps: a.Config.Warmup.Steps, Seed: &seed},
+			OutputPath: filepath.Join(dir, "warmup_"+strconv.Itoa(i)+".png"),
+			Log:        func(line string) { logger.Debug("Warm-up output", "line", line) },
+		}
+		if _, err := a.Generator.Generate(withLogger(withGPU(ctx, device), logger), req); err != nil {
+			if device != "" {
+				return fmt.Errorf("gpu %s: %w", device, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// watchdog.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// --- 卡住任務的 watchdog ---
+// 租約只能發現整個 process 消失；process 還活著但任務卡住 (例如開發時 worker 卡在上傳、python 不回應取消)
+// 時租約會一直被延長。watchdog 定期找出 Processing 超過 GENERATION_TIMEOUT × 1.5 的任務：
+// 還有重試次數 (MAX_TASK_ATTEMPTS，預設 3) 時放回佇列，用完則標記為 Failed (fail_reason=stuck)，
+// 兩者都以 reason=watchdog 記錄在任務的 timeline。沒有設定 GENERATION_TIMEOUT 時不啟動
+
+// 被 watchdog 回收時使用的 cause；本 process 的 worker 視同失去租約，不寫回結果
+var errTaskStuck = fmt.Errorf("%w: task recovered by watchdog", errLeaseLost)
+
+// 每個任務最多被認領的次數，從環境變數 MAX_TASK_ATTEMPTS 取得，預設 3
+func maxTaskAttempts() int {
+	if n := envInt("MAX_TASK_ATTEMPTS"); n > 0 {
+		return n
+	}
+	return 3
+}
+
+// 判定為卡住的時間，0 代表不檢查
+func (a *App) stuckTaskAfter() time.Duration {
+	return a.Config.GenerationTimeout * 3 / 2
+}
+
+// 關機 (workerCtx 結束) 時停止
+func (a *App) startWatchdog() {
+	after := a.stuckTaskAfter()
+	if after <= 0 {
+		return
+	}
+	interval := min(max(after/10, 5*time.Second), time.Minute)
+	slog.Info("Stuck task watchdog started", "stuck_after", after.String(), "max_attempts", a.Config.MaxTaskAttempts)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-a.workerCtx.Done():
+				return
+			case <-ticker.C:
+				a.recoverStuckTasks(time.Now())
+			}
+		}
+	}()
+}
+
+// 回收卡住的任務；多個 process 同時執行時以帶條件的 UPDATE 確保只處理一次
+func (a *App) recoverStuckTasks(now time.Time) {
+	after := a.stuckTaskAfter()
+	var stuck []Task
+	if err := a.DB.Where("status = ? AND started_at < ?", StatusProcessing, now.Add(-after)).Find(&stuck).Error; err != nil {
+		slog.Warn("Query stuck tasks error", "error", err)
+		return
+	}
+	var requeued []uint
+	for _, task := range stuck {
+		status := StatusRetrying
+		var fields map[string]interface{}
+		if task.Attempts >= a.Config.MaxTaskAttempts {
+			status = StatusFailed
+			fields = map[string]interface{}{
+				"fail_reason":   "stuck",
+				"error_message": fmt.Sprintf("task stuck in Processing for more than %s (attempt %d of %d)", after, task.Attempts, a.Config.MaxTaskAttempts),
+			}
+		}
+		ok, err := a.transitionTask(context.Background(), task.ID, StatusProcessing, status, "watchdog", fields, transitionGuard{StartedAt: task.StartedAt})
+		if err != nil || !ok {
+			continue
+		}
+		slog.Warn("Recovered stuck task", "task_id", task.ID, "claimed_by", task.ClaimedBy, "started_at", task.StartedAt,
+			"attempts", task.Attempts, "status", status)
+
+		// 在本 process 執行中時停止生成，worker 不會寫回結果
+		a.runningMutex.Lock()
+		if cancel, ok := a.runningTasks[task.ID]; ok {
+			cancel(errTaskStuck)
+		}
+		a.runningMutex.Unlock()
+
+		if err := a.DB.First(&task, task.ID).Error; err != nil {
+			continue
+		}
+		a.publishTaskChange(context.Background(), task)
+		if status == StatusRetrying {
+			requeued = append(requeued, task.ID)
+		}
+	}
+	if len(requeued) > 0 {
+		a.signalDispatch(requeued...)
+		a.notifyQueuePositions()
+	}
+}
@@ -0,0 +1,249 @@
+// watermark.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"slices"
+	"strconv"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// --- 浮水印與移除中繼資料 ---
+// 在內容安全檢查之後、轉換輸出格式之前處理 (放大後的圖片也一併處理)，縮圖由處理後的圖片產生。
+// 浮水印為 WATERMARK_IMAGE 的 logo PNG 或 WATERMARK_TEXT 的文字，依 WATERMARK_POSITION 放在角落或中央。
+// STRIP_METADATA=true (或任務的 strip_metadata) 時移除生成後端寫入的 PNG 文字 / EXIF chunk，
+// 也不寫入參數的 tEXt chunk 與旁邊的 JSON 檔；重現所需的資訊仍可由 GET /api/tasks/{id}/metadata 取得。
+// 任務的 watermark / strip_metadata 沒有指定時，建立任務時填入伺服器的預設值
+
+var watermarkPositions = []string{"bottom-right", "bottom-left", "top-right", "top-left", "center"}
+
+type watermarkConfig struct {
+	Text     string  // WATERMARK_TEXT，只支援 ASCII
+	Image    string  // WATERMARK_IMAGE，logo PNG 的路徑 (同時設定時優先於文字)
+	Position string  // WATERMARK_POSITION
+	Opacity  float64 // 0~1 (WATERMARK_OPACITY)
+	Scale    float64 // 浮水印寬度佔圖片寬度的比例 (WATERMARK_SCALE)
+	Enforce  bool    // true 時任務不能以 watermark=false 關閉 (WATERMARK_ENFORCE)
+}
+
+func watermarkFromEnv() watermarkConfig {
+	w := watermarkConfig{
+		Text:     os.Getenv("WATERMARK_TEXT"),
+		Image:    os.Getenv("WATERMARK_IMAGE"),
+		Position: envString("WATERMARK_POSITION", "bottom-right"),
+		Opacity:  0.5,
+		Scale:    0.2,
+		Enforce:  os.Getenv("WATERMARK_ENFORCE") == "true",
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("WATERMARK_OPACITY"), 64); err == nil && f > 0 && f <= 1 {
+		w.Opacity = f
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("WATERMARK_SCALE"), 64); err == nil && f > 0 && f <= 1 {
+		w.Scale = f
+	}
+	return w
+}
+
+func (w watermarkConfig) configured() bool {
+	return w.Text != "" || w.Image != ""
+}
+
+func (w watermarkConfig) problems() []string {
+	var problems []string
+	if !slices.Contains(watermarkPositions, w.Position) {
+		problems = append(problems, fmt.Sprintf("WATERMARK_POSITION=%q: must be bottom-right, bottom-left, top-right, top-left or center", w.Position))
+	}
+	for _, name := range []string{"WATERMARK_OPACITY", "WATERMARK_SCALE"} {
+		if s := os.Getenv(name); s != "" {
+			if f, err := strconv.ParseFloat(s, 64); err != nil || f <= 0 || f > 1 {
+				problems = append(problems, fmt.Sprintf("%s=%q: must be a number between 0 and 1", name, s))
+			}
+		}
+	}
+	if w.Text != "" && !isASCII(w.Text) {
+		problems = append(problems, fmt.Sprintf("WATERMARK_TEXT=%q: only ASCII text is supported (use WATERMARK_IMAGE for other scripts)", w.Text))
+	}
+	if w.Image != "" {
+		if _, err := w.mark(); err != nil {
+			problems = append(problems, fmt.Sprintf("WATERMARK_IMAGE=%q: %v", w.Image, err))
+		}
+	}
+	if w.Enforce && !w.configured() {
+		problems = append(problems, "WATERMARK_ENFORCE requires WATERMARK_TEXT or WATERMARK_IMAGE")
+	}
+	return problems
+}
+
+// 未縮放的浮水印：logo 每次重新讀取 (替換檔案不需要重新啟動)，文字以白字加黑色陰影繪製
+func (w watermarkConfig) mark() (image.Image, error) {
+	if w.Image != "" {
+		f, err := os.Open(w.Image)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return png.Decode(f)
+	}
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, w.Text).Ceil()
+	img := image.NewNRGBA(image.Rect(0, 0, width+1, face.Height+1))
+	for _, layer := range []struct {
+		color  color.Color
+		offset int
+	}{{color.Black, 1}, {color.White, 0}} {
+		d := font.Drawer{Dst: img, Src: image.NewUniform(layer.color), Face: face, Dot: fixed.P(layer.offset, face.Ascent+layer.offset)}
+		d.DrawString(w.Text)
+	}
+	return img, nil
+}
+
+// 建立任務時填入預設值並檢查任務的 watermark / strip_metadata
+func (c *Config) resolvePostProcess(p *GenerationParams) error {
+	if p.Watermark != nil {
+		if *p.Watermark && !c.Watermark.configured() {
+			return invalidField("watermark", "watermark is not configured on this server")
+		}
+		if !*p.Watermark && c.Watermark.Enforce {
+			return invalidField("watermark", "watermark cannot be disabled on this server")
+		}
+	} else if c.Watermark.configured() {
+		on := true
+		p.Watermark = &on
+	}
+	if p.StripMetadata == nil && c.StripMetadata {
+		on := true
+		p.StripMetadata = &on
+	}
+	return nil
+}
+
+func (p GenerationParams) watermarked() bool {
+	return p.Watermark != nil && *p.Watermark
+}
+
+func (p GenerationParams) stripsMetadata() bool {
+	return p.StripMetadata != nil && *p.StripMetadata
+}
+
+// 處理生成後端 (或放大) 寫出的 PNG；加上浮水印時整張重新編碼，原本的 chunk 也一併移除
+func (a *App) postProcess(task *Task, path string) error {
+	if task.watermarked() {
+		if !a.Config.Watermark.configured() {
+			// 任務建立之後伺服器才取消浮水印設定
+			return nil
+		}
+		if err := a.Config.Watermark.apply(path); err != nil {
+			return fmt.Errorf("watermark: %w", err)
+		}
+		return nil
+	}
+	if task.stripsMetadata() {
+		if err := stripPNGMetadata(path); err != nil {
+			return fmt.Errorf("strip metadata: %w", err)
+		}
+	}
+	return nil
+}
+
+func (w watermarkConfig) apply(path string) error {
+	mark, err := w.mark()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	src, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	b := src.Bounds()
+	dst := image.NewNRGBA(b)
+	draw.Draw(dst, b, src, b.Min, draw.Src)
+
+	// 依圖片寬度縮放 (放大後的圖片浮水印跟著變大)，高度最多為圖片的一半
+	mb := mark.Bounds()
+	width := max(int(float64(b.Dx())*w.Scale), 1)
+	height := max(mb.Dy()*width/mb.Dx(), 1)
+	if height > b.Dy()/2 {
+		height = max(b.Dy()/2, 1)
+		width = max(mb.Dx()*height/mb.Dy(), 1)
+	}
+	scaled := image.NewNRGBA(image.Rect(0, 0, width, height))
+	var scaler draw.Scaler = draw.CatmullRom
+	if w.Image == "" {
+		// 點陣字放大時保持銳利
+		scaler = draw.NearestNeighbor
+	}
+	scaler.Scale(scaled, scaled.Bounds(), mark, mb, draw.Src, nil)
+
+	margin := min(b.Dx(), b.Dy()) / 50
+	var at image.Point
+	switch w.Position {
+	case "top-left":
+		at = image.Pt(b.Min.X+margin, b.Min.Y+margin)
+	case "top-right":
+		at = image.Pt(b.Max.X-margin-width, b.Min.Y+margin)
+	case "bottom-left":
+		at = image.Pt(b.Min.X+margin, b.Max.Y-margin-height)
+	case "center":
+		at = image.Pt(b.Min.X+(b.Dx()-width)/2, b.Min.Y+(b.Dy()-height)/2)
+	default:
+		at = image.Pt(b.Max.X-margin-width, b.Max.Y-margin-height)
+	}
+	opacity := image.NewUniform(color.Alpha{A: uint8(w.Opacity * 255)})
+	draw.DrawMask(dst, image.Rectangle{Min: at, Max: at.Add(scaled.Bounds().Size())}, scaled, image.Point{}, opacity, image.Point{}, draw.Over)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return err
+	}
+	return replaceFile(path, buf.Bytes())
+}
+
+// PNG 中可能帶有生成參數、拍攝資訊或時間的 chunk
+var pngMetadataChunks = []string{"tEXt", "zTXt", "iTXt", "eXIf", "tIME"}
+
+func stripPNGMetadata(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, pngSignature) {
+		return errors.New("not a PNG file")
+	}
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	removed := false
+	for pos := len(pngSignature); pos+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if end > len(data) {
+			return errors.New("truncated PNG chunk")
+		}
+		if slices.Contains(pngMetadataChunks, string(data[pos+4:pos+8])) {
+			removed = true
+		} else {
+			buf.Write(data[pos:end])
+		}
+		pos = end
+	}
+	if !removed {
+		return nil
+	}
+	return replaceFile(path, buf.Bytes())
+}
@@ -0,0 +1,148 @@
+// webhook.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// --- Webhook 通知 ---
+// 建立任務時帶 callback_url，任務完成或失敗時 POST 一個 JSON 給該網址；
+// 設定 WEBHOOK_SECRET 時以 HMAC-SHA256 簽署整個 body，放在 X-Zimage-Signature: sha256=<hex>
+
+// 單次送出的逾時與重試設定
+const (
+	webhookTimeout    = 10 * time.Second
+	webhookMaxRetries = 5
+)
+
+type webhookPayload struct {
+	Event        string     `json:"event"` // task.completed、task.failed
+	TaskID       uint       `json:"task_id"`
+	Status       TaskStatus `json:"status"`
+	Prompt       string     `json:"prompt"`
+	ImageURL     string     `json:"image_url,omitempty"`
+	FailReason   string     `json:"fail_reason,omitempty"`
+	ErrorMessage string     `json:"error_message,omitempty"`
+	Timestamp    time.Time  `json:"timestamp"` // 接收端可用來拒絕重放的請求
+}
+
+// 建立任務時檢查 callback_url
+func validateCallbackURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return invalidf("callback_url must be an http or https URL")
+	}
+	return nil
+}
+
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// 訂閱 task.finished 事件 (見 eventbus.go)，只有 Completed / Failed 且設定 callback_url 的任務會送出；
+// 同時送出 Discord / Slack 通知 (見 notify.go) 與 Telegram 的回覆 (見 telegram.go)
+func (a *App) notifyWebhook(task Task) {
+	a.notifyChat(task)
+	a.notifyTelegram(task)
+	if task.CallbackURL == "" || (task.Status != StatusCompleted && task.Status != StatusFailed) {
+		return
+	}
+	event := "task.completed"
+	if task.Status == StatusFailed {
+		event = "task.failed"
+	}
+	payload := webhookPayload{
+		Event:        event,
+		TaskID:       task.ID,
+		Status:       task.Status,
+		Prompt:       task.Prompt,
+		ImageURL:     a.taskImageURL(task),
+		FailReason:   task.FailReason,
+		ErrorMessage: task.ErrorMessage,
+		Timestamp:    time.Now().UTC(),
+	}
+	body, _ := json.Marshal(payload)
+	go a.deliverWebhook(task.CallbackURL, body, slog.With("task_id", task.ID))
+}
+
+// 圖片網址：儲存後端有公開網址時直接使用，否則以 PUBLIC_URL (沒有設定時為建立任務時的對外網址) 組出 API 路徑 (需要 API key)
+func (a *App) taskImageURL(task Task) string {
+	if task.ImageURL != "" {
+		return task.ImageURL
+	}
+	base := a.Config.PublicURL
+	if base == "" {
+		base = task.OriginURL
+	}
+	if task.ImagePath == "" || base == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/api/images/%d/file", base, task.ID)
+}
+
+func (a *App) deliverWebhook(callbackURL string, body []byte, logger *slog.Logger) {
+	client := &http.Client{Timeout: webhookTimeout}
+	a.retryDelivery(logger, func() (int, error) {
+		return a.postWebhook(client, callbackURL, body)
+	})
+}
+
+// 送出失敗 (連線錯誤、5xx、429) 時以 1s、2s、4s… 的間隔重試；其他 4xx 代表接收端拒絕，不再重試。
+// 關機時放棄尚未送出的重試
+func (a *App) retryDelivery(logger *slog.Logger, post func() (int, error)) {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		status, err := post()
+		if err == nil && status < 300 {
+			logger.Info("Webhook delivered", "status", status, "attempt", attempt)
+			return
+		}
+		retryable := err != nil || status >= 500 || status == http.StatusTooManyRequests
+		if !retryable || attempt > webhookMaxRetries {
+			logger.Warn("Webhook delivery failed", "status", status, "error", err, "attempt", attempt)
+			return
+		}
+		select {
+		case <-a.workerCtx.Done():
+			logger.Warn("Webhook delivery aborted by shutdown", "attempt", attempt)
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (a *App) postWebhook(client *http.Client, callbackURL string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mcpzimage-webhook")
+	if a.Config.WebhookSecret != "" {
+		req.Header.Set("X-Zimage-Signature", signWebhook(a.Config.WebhookSecret, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
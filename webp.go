@@ -0,0 +1,419 @@
+// webp.go
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"slices"
+)
+
+// --- WebP 編碼 (VP8L 無失真) ---
+// golang.org/x/image/webp 只有解碼器，輸出 WebP 時使用這裡的簡易編碼器 (不需要 cgo 或外部程式)：
+// 先做 subtract green 與 predictor 兩種轉換 (每個 32×32 區塊選 L、T、Average2(L, T) 中殘差最小的)，
+// 再以一組 Huffman code 逐像素編碼，不使用 LZ77 與 color cache。檔案大小與 PNG 相近，規格見
+// https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification
+
+const (
+	webpMaxSize       = 16384
+	webpPredictorBits = 5 // 區塊大小 1<<5 = 32
+)
+
+// 依規格的順序寫出 code length code 的長度
+var webpCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// green (含 LZ77 長度)、red、blue、alpha、distance 的字母表大小
+var webpAlphabetSizes = [5]int{256 + 24, 256, 256, 256, 40}
+
+func encodeWebP(out io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > webpMaxSize || height > webpMaxSize {
+		return fmt.Errorf("webp: unsupported image size %dx%d", width, height)
+	}
+	nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+	pix := nrgba.Pix // 每個像素 R、G、B、A，Stride 為 4*width
+
+	alphaUsed := false
+	for i := 3; i < len(pix); i += 4 {
+		if pix[i] != 0xff {
+			alphaUsed = true
+			break
+		}
+	}
+
+	w := &webpBitWriter{}
+	// VP8L 標頭：signature、寬高減一 (各 14 bits)、alpha 提示、版本 0
+	w.write(0x2f, 8)
+	w.write(uint32(width-1), 14)
+	w.write(uint32(height-1), 14)
+	if alphaUsed {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+	w.write(0, 3)
+
+	// subtract green：red、blue 減掉 green
+	for i := 0; i < len(pix); i += 4 {
+		pix[i] -= pix[i+1]
+		pix[i+2] -= pix[i+1]
+	}
+	w.write(1, 1)
+	w.write(2, 2)
+
+	// predictor：解碼時先還原 predictor 再還原 subtract green，與寫入的順序相反
+	modes, residual := webpPredict(pix, width, height)
+	w.write(1, 1)
+	w.write(0, 2)
+	w.write(webpPredictorBits-2, 3)
+	writeWebPImage(w, modes, false)
+
+	w.write(0, 1) // 沒有其他轉換
+	writeWebPImage(w, residual, true)
+	data := w.bytes()
+
+	// RIFF 容器，chunk 長度為奇數時補一個 byte
+	chunkSize := len(data)
+	padded := chunkSize + chunkSize&1
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+8+padded))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(chunkSize))
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+	if chunkSize&1 == 1 {
+		data = append(data, 0)
+	}
+	_, err := out.Write(data)
+	return err
+}
+
+// 每個區塊選一種預測方式，回傳區塊的模式 (放在 green) 與殘差；
+// 第一列固定用 L、第一行固定用 T，左上角的預測值為不透明黑色
+func webpPredict(pix []byte, width, height int) (modes []byte, residual []byte) {
+	tiles := (width + 1<<webpPredictorBits - 1) >> webpPredictorBits
+	tileRows := (height + 1<<webpPredictorBits - 1) >> webpPredictorBits
+	modes = make([]byte, 4*tiles*tileRows)
+	residual = make([]byte, len(pix))
+
+	predict := func(mode byte, p, c int) byte {
+		left, top := pix[p-4+c], pix[p-4*width+c]
+		switch mode {
+		case 1:
+			return left
+		case 2:
+			return top
+		}
+		return byte((int(left) + int(top)) / 2)
+	}
+	candidates := []byte{1, 2, 7}
+	for ty := range tileRows {
+		for tx := range tiles {
+			best, bestCost := candidates[0], -1
+			for _, mode := range candidates {
+				cost := 0
+				for y := max(ty<<webpPredictorBits, 1); y < min((ty+1)<<webpPredictorBits, height); y++ {
+					for x := max(tx<<webpPredictorBits, 1); x < min((tx+1)<<webpPredictorBits, width); x++ {
+						p := 4 * (y*width + x)
+						for c := range 4 {
+							d := int8(pix[p+c] - predict(mode, p, c))
+							cost += int(d) * int(d)
+						}
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			q := 4 * (ty*tiles + tx)
+			modes[q+1], modes[q+3] = best, 0xff
+		}
+	}
+
+	for y := range height {
+		for x := range width {
+			p := 4 * (y*width + x)
+			for c := range 4 {
+				var pred byte
+				switch {
+				case x == 0 && y == 0:
+					if c == 3 {
+						pred = 0xff
+					}
+				case y == 0:
+					pred = pix[p-4+c]
+				case x == 0:
+					pred = pix[p-4*width+c]
+				default:
+					pred = predict(modes[4*((y>>webpPredictorBits)*tiles+x>>webpPredictorBits)+1], p, c)
+				}
+				residual[p+c] = pix[p+c] - pred
+			}
+		}
+	}
+	return modes, residual
+}
+
+// 寫出一張以 Huffman code 編碼的影像 (主影像或轉換用的子影像)，pix 為 R、G、B、A
+func writeWebPImage(w *webpBitWriter, pix []byte, topLevel bool) {
+	w.write(0, 1) // 不使用 color cache
+	if topLevel {
+		w.write(0, 1) // 整張影像共用一組 Huffman code
+	}
+	var counts [5][]int
+	for i, size := range webpAlphabetSizes {
+		counts[i] = make([]int, size)
+	}
+	// 每個像素依 green、red、blue、alpha 的順序編碼
+	channels := [4]int{1, 0, 2, 3}
+	for p := 0; p < len(pix); p += 4 {
+		for i, c := range channels {
+			counts[i][pix[p+c]]++
+		}
+	}
+	var codes [5]webpCode
+	for i := range codes {
+		codes[i] = writeWebPCode(w, counts[i])
+	}
+	for p := 0; p < len(pix); p += 4 {
+		for i, c := range channels {
+			codes[i].put(w, int(pix[p+c]))
+		}
+	}
+}
+
+// Huffman code；codes 已經反轉位元順序 (VP8L 由低位元開始讀取)。
+// 只有一個符號的 code 在解碼時不讀取位元，輸出時也不寫入
+type webpCode struct {
+	lengths []uint8
+	codes   []uint16
+	single  bool
+}
+
+func (c webpCode) put(w *webpBitWriter, symbol int) {
+	if !c.single && c.lengths[symbol] > 0 {
+		w.write(uint32(c.codes[symbol]), uint(c.lengths[symbol]))
+	}
+}
+
+// 依出現次數建立 Huffman code 並寫出定義；只有一兩個符號時使用 simple code length code
+func writeWebPCode(w *webpBitWriter, counts []int) webpCode {
+	var used []int
+	for symbol, n := range counts {
+		if n > 0 {
+			used = append(used, symbol)
+		}
+	}
+	code := webpCode{lengths: make([]uint8, len(counts)), codes: make([]uint16, len(counts))}
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		if len(used) == 0 {
+			used = []int{0}
+		}
+		code.single = len(used) == 1
+		w.write(1, 1)
+		w.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			w.write(0, 1)
+			w.write(uint32(used[0]), 1)
+		} else {
+			w.write(1, 1)
+			w.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			w.write(uint32(used[1]), 8)
+			code.lengths[used[0]], code.lengths[used[1]] = 1, 1
+			code.codes[used[1]] = 1
+		}
+		return code
+	}
+
+	lengths := webpHuffmanLengths(counts, 15)
+	// code length 以 0-15 與重複碼 16 (前一個長度 3-6 次)、17 (0 重複 3-10 次)、18 (0 重複 11-138 次) 表示
+	type clSymbol struct {
+		symbol, extraBits, extra int
+	}
+	var seq []clSymbol
+	for i := 0; i < len(lengths); {
+		l := int(lengths[i])
+		run := 1
+		for i+run < len(lengths) && int(lengths[i+run]) == l {
+			run++
+		}
+		i += run
+		if l == 0 {
+			for run >= 11 {
+				n := min(run, 138)
+				seq = append(seq, clSymbol{18, 7, n - 11})
+				run -= n
+			}
+			if run >= 3 {
+				seq = append(seq, clSymbol{17, 3, run - 3})
+				run = 0
+			}
+		} else {
+			seq = append(seq, clSymbol{l, 0, 0})
+			run--
+			for run >= 3 {
+				n := min(run, 6)
+				seq = append(seq, clSymbol{16, 2, n - 3})
+				run -= n
+			}
+		}
+		for ; run > 0; run-- {
+			seq = append(seq, clSymbol{l, 0, 0})
+		}
+	}
+	clCounts := make([]int, 19)
+	for _, s := range seq {
+		clCounts[s.symbol]++
+	}
+	clCode := webpCode{lengths: webpHuffmanLengths(clCounts, 7)}
+	clCode.codes, clCode.single = webpCanonicalCodes(clCode.lengths)
+	numCodes := len(webpCodeLengthOrder)
+	for numCodes > 4 && clCode.lengths[webpCodeLengthOrder[numCodes-1]] == 0 {
+		numCodes--
+	}
+	w.write(0, 1)
+	w.write(uint32(numCodes-4), 4)
+	for _, symbol := range webpCodeLengthOrder[:numCodes] {
+		w.write(uint32(clCode.lengths[symbol]), 3)
+	}
+	w.write(0, 1) // 寫出整個字母表的長度
+	for _, s := range seq {
+		clCode.put(w, s.symbol)
+		if s.extraBits > 0 {
+			w.write(uint32(s.extra), uint(s.extraBits))
+		}
+	}
+
+	code.lengths = lengths
+	code.codes, code.single = webpCanonicalCodes(lengths)
+	return code
+}
+
+// 依長度指定 canonical code；single 代表只有一個符號
+func webpCanonicalCodes(lengths []uint8) (codes []uint16, single bool) {
+	codes = make([]uint16, len(lengths))
+	var histogram [16]int
+	used := 0
+	for _, l := range lengths {
+		if l > 0 {
+			histogram[l]++
+			used++
+		}
+	}
+	if used == 1 {
+		return codes, true
+	}
+	var next [16]int
+	code := 0
+	for l := 1; l < 16; l++ {
+		code = (code + histogram[l-1]) << 1
+		next[l] = code
+	}
+	for symbol, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		// 反轉位元順序
+		reversed := 0
+		for i := 0; i < int(l); i++ {
+			reversed |= (c >> i & 1) << (int(l) - 1 - i)
+		}
+		codes[symbol] = uint16(reversed)
+	}
+	return codes, false
+}
+
+// 長度不超過 limit 的 Huffman code 長度；超過時把次數減半 (保留非零) 重建，直到符合限制
+func webpHuffmanLengths(counts []int, limit int) []uint8 {
+	lengths := make([]uint8, len(counts))
+	weights := slices.Clone(counts)
+	for {
+		var leaves []int
+		for symbol, n := range weights {
+			if n > 0 {
+				leaves = append(leaves, symbol)
+			}
+		}
+		if len(leaves) == 1 {
+			lengths[leaves[0]] = 1
+			return lengths
+		}
+		slices.SortStableFunc(leaves, func(a, b int) int { return weights[a] - weights[b] })
+
+		// 兩個佇列的 Huffman 建樹：葉節點依次數排序，內部節點依建立順序遞增
+		n := len(leaves)
+		weight := make([]int, n, 2*n-1)
+		parent := make([]int, 2*n-1)
+		for i, symbol := range leaves {
+			weight[i] = weights[symbol]
+		}
+		leaf, inner := 0, n
+		take := func() int {
+			if leaf < n && (inner >= len(weight) || weight[leaf] <= weight[inner]) {
+				leaf++
+				return leaf - 1
+			}
+			inner++
+			return inner - 1
+		}
+		for len(weight) < 2*n-1 {
+			a, b := take(), take()
+			parent[a], parent[b] = len(weight), len(weight)
+			weight = append(weight, weight[a]+weight[b])
+		}
+		depth := make([]int, 2*n-1)
+		maxDepth := 0
+		for i := 2*n - 3; i >= 0; i-- {
+			depth[i] = depth[parent[i]] + 1
+			if i < n {
+				maxDepth = max(maxDepth, depth[i])
+			}
+		}
+		if maxDepth <= limit {
+			for i, symbol := range leaves {
+				lengths[symbol] = uint8(depth[i])
+			}
+			return lengths
+		}
+		for i, w := range weights {
+			if w > 0 {
+				weights[i] = (w + 1) / 2
+			}
+		}
+	}
+}
+
+// 由低位元開始寫入
+type webpBitWriter struct {
+	buf  []byte
+	acc  uint64
+	bits uint
+}
+
+func (w *webpBitWriter) write(value uint32, n uint) {
+	w.acc |= uint64(value) << w.bits
+	w.bits += n
+	for w.bits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.bits -= 8
+	}
+}
+
+func (w *webpBitWriter) bytes() []byte {
+	if w.bits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.bits = 0, 0
+	}
+	return w.buf
+}
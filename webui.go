@@ -0,0 +1,66 @@
+// webui.go
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+
+	"github.com/asccclass/sherryserver"
+)
+
+// --- 前端網頁 ---
+// www/html 在編譯時嵌入執行檔，部署時只需要一個執行檔；開發時設定 DocumentRoot 直接讀取磁碟上的檔案，
+// 修改後重新整理即可，不需要重新編譯
+
+//go:embed www/html
+var embeddedWebUI embed.FS
+
+// 加入前端網頁的路由
+func (a *App) addWebUIRoutes(router *http.ServeMux, documentRoot string) {
+	if documentRoot != "" {
+		staticfileserver := SherryServer.StaticFileServer{StaticPath: documentRoot, IndexPath: "index.html"}
+		staticfileserver.AddRouter(router)
+		return
+	}
+	handler, err := embeddedWebUIHandler()
+	if err != nil {
+		// 只有嵌入的目錄結構錯誤 (編譯時就決定) 才會發生
+		panic(err)
+	}
+	router.Handle("/", handler)
+}
+
+// 嵌入的檔案沒有修改時間，改以所有檔案內容的雜湊作為 ETag：
+// 瀏覽器每次都重新驗證 (no-cache)，版本沒變時回 304
+func embeddedWebUIHandler() (http.Handler, error) {
+	root, err := fs.Sub(embeddedWebUI, "www/html")
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	err = fs.WalkDir(root, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(root, path)
+		if err != nil {
+			return err
+		}
+		h.Write([]byte(path))
+		h.Write(data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil))[:16] + `"`
+	files := http.FileServerFS(root)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		files.ServeHTTP(w, r)
+	}), nil
+}
@@ -0,0 +1,598 @@
+// worker.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- 背景 Worker Pool (Message Queue Consumer) ---
+
+var errTaskNotCancellable = &codedError{Code: "conflict", Message: "task is not pending or processing"}
+
+// 生成超過 GENERATION_TIMEOUT 時使用的 cause
+var errGenerationTimeout = errors.New("generation timeout")
+
+// 單一任務的生成時間上限，從環境變數 GENERATION_TIMEOUT 取得 (例如 10m)，0 或未設定代表不限制
+func generationTimeout() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("GENERATION_TIMEOUT"))
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// 沒有收到通知時，worker 仍會定期掃描一次資料庫作為保險
+const dispatchScanInterval = 30 * time.Second
+
+// 有新任務時通知 worker 立即醒來；ids 為變成可以執行的任務，使用 Redis 佇列時一併推入 (其他 process 的 worker 也會收到)
+func (a *App) signalDispatch(ids ...uint) {
+	if a.Queue != nil && len(ids) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		if err := a.Queue.Push(ctx, ids...); err != nil {
+			// worker 定期掃描資料庫時仍會認領
+			slog.Warn("Push to task queue error", "task_ids", ids, "error", err)
+		}
+		cancel()
+	}
+	select {
+	case a.dispatch <- struct{}{}:
+	default:
+	}
+}
+
+// 從環境變數 WORKER_COUNT 取得 worker 數量，預設 1
+func workerCount() int {
+	n, err := strconv.Atoi(os.Getenv("WORKER_COUNT"))
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+func (a *App) startWorkers(n int) {
+	a.workerPoolMutex.Lock()
+	a.workersStarted = true
+	a.resizeWorkersLocked(n)
+	a.workerPoolMutex.Unlock()
+	slog.Info("Started workers", "count", n)
+	// 啟動時資料庫裡可能已有 Pending 任務 (Redis 佇列時一併推入，重複的 ID 認領時會略過)
+	var ready []uint
+	if a.Queue != nil {
+		a.readyTasks(time.Now()).Model(&Task{}).Order("created_at asc").Pluck("id", &ready)
+	}
+	a.signalDispatch(ready...)
+}
+
+// 調整 worker 數量 (重新載入 WORKER_COUNT 時，見 reload.go)：增加時立即啟動，減少時編號最大的 worker 做完手上的任務後結束；
+// worker 還沒啟動 (暖機中) 時只記錄，啟動時依 WORKER_COUNT
+func (a *App) resizeWorkers(n int) {
+	a.workerPoolMutex.Lock()
+	defer a.workerPoolMutex.Unlock()
+	if a.workersStarted {
+		a.resizeWorkersLocked(n)
+	}
+}
+
+// 需持有 workerPoolMutex；新的 worker 使用最小的空閒編號 (GPU 依編號分配)，還在結束中的編號不重複使用
+func (a *App) resizeWorkersLocked(n int) {
+	for len(a.workerCancels) > n {
+		last := 0
+		for id := range a.workerCancels {
+			last = max(last, id)
+		}
+		a.workerCancels[last]()
+		delete(a.workerCancels, last)
+		a.workerStopping[last] = true
+	}
+	for id := 1; len(a.workerCancels) < n; id++ {
+		if _, ok := a.workerCancels[id]; ok || a.workerStopping[id] {
+			continue
+		}
+		ctx, cancel := context.WithCancel(a.workerCtx)
+		a.workerCancels[id] = cancel
+		a.workerWG.Add(1)
+		go a.taskWorker(ctx, id)
+	}
+}
+
+// worker 結束時從 pool 移除
+func (a *App) workerExited(workerID int) {
+	a.workerPoolMutex.Lock()
+	delete(a.workerStopping, workerID)
+	a.workerPoolMutex.Unlock()
+}
+
+// 可執行的 Pending / Retrying 任務：沒有排程或排程時間已到，且還沒過期
+func (a *App) readyTasks(now time.Time) *gorm.DB {
+	return readyTaskQuery(a.DB, now)
+}
+
+// 下一筆排程任務的執行時間，沒有時回傳 false
+func (a *App) nextScheduledAt() (time.Time, bool) {
+	var task Task
+	err := a.DB.Where("status IN ? AND scheduled_at > ?", queuedStatuses, time.Now()).
+		Order("scheduled_at asc").First(&task).Error
+	if err != nil || task.ScheduledAt == nil {
+		return time.Time{}, false
+	}
+	return *task.ScheduledAt, true
+}
+
+// 閒置時的等待時間：預設為定期掃描間隔，有更早到期的排程任務則提早醒來
+func (a *App) idleWait() time.Duration {
+	wait := a.Config.PollInterval
+	if next, ok := a.nextScheduledAt(); ok {
+		if d := time.Until(next); d < wait {
+			wait = max(d, 0) + 100*time.Millisecond
+		}
+	}
+	return wait
+}
+
+// 認領下一筆任務；使用 Redis 佇列時先以 BRPOP 等待，逾時後再掃描資料庫。
+// SQLite 不支援 SELECT ... FOR UPDATE，所以改用帶條件的 UPDATE 認領 (見 TaskService.Claim)，
+// 多個 worker 同時搶同一筆時只有一個會成功。
+// 回傳 nil, nil 代表這一輪沒有任務 (已等待過通知)，呼叫端回到迴圈開頭重新檢查暫停與 GPU
+func (a *App) nextTask(ctx context.Context) (*Task, error) {
+	if a.Queue != nil {
+		task, popped, err := a.claimFromQueue(ctx)
+		if task != nil || err != nil || popped {
+			return task, err
+		}
+	}
+	task, err := a.claimNextInLanes(context.Background())
+	if task == nil && err == nil {
+		if a.Queue == nil {
+			// 沒有任務，等待新任務通知或定期掃描
+			select {
+			case <-ctx.Done():
+			case <-a.dispatch:
+			case <-time.After(a.idleWait()):
+			}
+		}
+		// Redis 佇列時回到 BRPOP 等待
+		return nil, nil
+	}
+	return task, err
+}
+
+// ctx 在關機或 worker 數量減少時結束，執行中的任務仍會做完
+func (a *App) taskWorker(ctx context.Context, workerID int) {
+	defer a.workerWG.Done()
+	defer a.workerExited(workerID)
+	defer a.workerGone(workerID)
+	for {
+		a.workerBeat(workerID, 0)
+		// 關機中，不再認領新任務
+		if ctx.Err() != nil {
+			return
+		}
+		// 佇列暫停中，等待恢復 (恢復時會送出通知)；因磁碟空間不足而暫停時順便檢查是否已恢復
+		if a.queuePaused.Load() {
+			if a.queueStatus().Cause == "disk" && a.checkDiskGuard() {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+			case <-a.dispatch:
+			case <-time.After(a.Config.PollInterval):
+			}
+			continue
+		}
+		// 圖片目錄空間不足時自動暫停佇列，不認領任務
+		if !a.checkDiskGuard() {
+			continue
+		}
+		// 這個 worker 的 GPU 剩餘 VRAM 不足 (例如被其他程式佔用)，稍後再檢查；不搶通知，讓其他 GPU 的 worker 接手
+		release, ok := a.GPUs.reserve(workerID)
+		if !ok {
+			select {
+			case <-ctx.Done():
+			case <-time.After(gpuRetryInterval):
+			}
+			continue
+		}
+		task, err := a.nextTask(ctx)
+		if err != nil {
+			release()
+			// 資料庫錯誤時稍後重試，不必等到下次掃描
+			slog.Error("Claim task error", "worker_id", workerID, "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(2 * time.Second):
+			}
+			continue
+		}
+		if task == nil {
+			release()
+			continue
+		}
+		// 可能還有其他 Pending 任務，把通知傳給下一個閒置的 worker
+		a.signalDispatch()
+		a.notifyQueuePositions()
+		a.workerBeat(workerID, task.ID)
+		a.processTask(workerID, task)
+		a.releaseLane(task.Model)
+		release()
+	}
+}
+
+func (a *App) processTask(workerID int, task *Task) {
+	// 不從 workerCtx 衍生，關機時讓執行中的任務有機會跑完
+	logger := slog.With("task_id", task.ID, "worker_id", workerID)
+	ctx, cancel := context.WithCancelCause(withGPU(withLogger(context.Background(), logger), a.GPUs.deviceID(workerID)))
+	a.runningMutex.Lock()
+	a.runningTasks[task.ID] = cancel
+	a.runningMutex.Unlock()
+	a.taskLogs.start(task.ID)
+	defer func() {
+		a.runningMutex.Lock()
+		delete(a.runningTasks, task.ID)
+		a.runningMutex.Unlock()
+		a.taskLogs.finish(task.ID)
+		cancel(nil)
+	}()
+
+	// 逾時也是透過取消 ctx 來 kill python，以 cause 區分
+	genCtx := ctx
+	timeout := a.Config.GenerationTimeout
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		genCtx, cancelTimeout = context.WithTimeoutCause(ctx, timeout, errGenerationTimeout)
+		defer cancelTimeout()
+	}
+
+	a.publishTask(ctx, EventTaskStarted, *task)
+
+	// 交給生成後端執行
+	logger.Info("Processing task", "prompt", task.Prompt)
+	started := time.Now()
+	imagePath, outputPath := newOutputPath(a.Config.ImageDir, task.ID)
+	// 多張輸出時每張圖片實際使用的 seed，由生成後端在每張完成時回報 (見 taskimages.go)
+	count := task.imageCount()
+	seeds := make([]*int64, count)
+	// 模型與 LoRA 可能在排隊期間被移除，找不到時直接視為生成失敗
+	modelPath, genErr := a.modelPath(task.Model)
+	var loras []loraPath
+	if genErr == nil {
+		loras, genErr = a.loraPaths(task.LoRAs)
+	}
+	if genErr == nil {
+		var result *GenerateResult
+		result, genErr = a.Generator.Generate(genCtx, GenerateRequest{
+			TaskID:           task.ID,
+			Prompt:           task.Prompt,
+			Params:           task.GenerationParams,
+			OutputPath:       outputPath,
+			InitImagePath:    a.uploadPath(task.InitImagePath),
+			MaskPath:         a.uploadPath(task.MaskPath),
+			ControlImagePath: a.uploadPath(task.ControlImagePath),
+			ModelPath:        modelPath,
+			LoRAs:            loras,
+			Progress: func(percent int) {
+				a.Events.Publish(TaskEvent{Type: EventTaskProgress, Ctx: ctx, Task: *task, Percent: percent, ImageIndex: -1})
+			},
+			ImageReady: func(index int, seed *int64) {
+				if index < 0 || index >= count {
+					return
+				}
+				seeds[index] = seed
+				a.Events.Publish(TaskEvent{Type: EventTaskProgress, Ctx: ctx, Task: *task, ImageIndex: index, ImageCount: count, ImageSeed: seed})
+			},
+			Log: func(line string) {
+				a.appendTaskLog(task, line)
+			},
+		})
+		// 記錄實際使用的 seed；後端沒有回報時，指定的 seed 就是實際使用的
+		task.UsedSeed = task.Seed
+		if genErr == nil && result.Seed != nil {
+			task.UsedSeed = result.Seed
+		}
+		// 生成後端沒有寫出全部的圖片 (例如腳本不支援 --num-images)
+		for i := 1; i < count && genErr == nil; i++ {
+			if _, err := os.Stat(imageOutputPath(outputPath, i)); err != nil {
+				genErr = &GenerationError{Message: fmt.Sprintf("generator wrote %d of %d images", i, count)}
+			}
+		}
+	}
+	if seeds[0] == nil {
+		seeds[0] = task.UsedSeed
+	}
+	// 用量：生成成功才計算 pixel-steps，GPU 時間算到放大結束 (見 usage.go)
+	var cost taskCost
+	if genErr == nil {
+		cost.PixelSteps = task.pixelSteps(outputPath)
+		a.warmup.taskSucceeded()
+	}
+
+	// 放大後處理：取消、逾時與關機照生成失敗處理；其他錯誤保留原圖，任務標記為 Failed
+	upscaleNames := make([]string, count)
+	var upscaleErr error
+	if genErr == nil && task.Upscale > 0 {
+		for i := 0; i < count && upscaleErr == nil; i++ {
+			name := upscaledName(imageOutputPath(imagePath, i), task.Upscale)
+			upscaleErr = a.Upscaler.Upscale(genCtx, imageOutputPath(outputPath, i), filepath.Join(filepath.Dir(outputPath), name), task.Upscale)
+			if upscaleErr == nil {
+				upscaleNames[i] = name
+			}
+		}
+		if upscaleErr != nil && genCtx.Err() != nil {
+			genErr, upscaleErr = upscaleErr, nil
+		}
+	}
+	cost.GPU = time.Since(started)
+
+	// 內容安全檢查：結果記錄在任務上，block 模式下不安全的圖片存到隔離區
+	if genErr == nil && a.Safety != nil {
+		outputs := make([]string, count)
+		for i := range outputs {
+			outputs[i] = imageOutputPath(outputPath, i)
+		}
+		if err := a.checkSafety(genCtx, task, outputs); err != nil {
+			genErr = err
+		}
+	}
+
+	// 浮水印與移除中繼資料：在轉換格式之前處理 PNG (見 watermark.go)
+	if genErr == nil && (task.watermarked() || task.stripsMetadata()) {
+		for i := 0; i < count && genErr == nil; i++ {
+			genErr = a.postProcess(task, imageOutputPath(outputPath, i))
+			if genErr == nil && upscaleNames[i] != "" {
+				genErr = a.postProcess(task, filepath.Join(filepath.Dir(outputPath), upscaleNames[i]))
+			}
+		}
+	}
+
+	// 輸出格式：生成後端只寫 PNG，要求 JPEG / WebP 時在這裡轉換 (見 imageformat.go)
+	if genErr == nil && task.Format != "" {
+		for i := 0; i < count && genErr == nil; i++ {
+			if _, err := task.convertImage(imageOutputPath(outputPath, i)); err != nil {
+				genErr = err
+			} else if upscaleNames[i] != "" {
+				if _, err := task.convertImage(filepath.Join(filepath.Dir(outputPath), upscaleNames[i])); err != nil {
+					genErr = err
+				}
+				upscaleNames[i] = task.outputName(upscaleNames[i])
+			}
+		}
+	}
+
+	// 更新最終結果
+	var next TaskStatus
+	task.FailReason, task.ErrorMessage, task.ErrorLog = "", "", ""
+	if errors.Is(context.Cause(ctx), errLeaseLost) {
+		// 任務已經由其他 process 處理 (取消、強制失敗或回收)，不寫回結果
+		logger.Warn("Task abandoned after losing lease")
+		return
+	} else if errors.Is(context.Cause(ctx), errShutdown) {
+		// 關機逾時被中止，放回佇列等下次啟動再執行
+		next = StatusRetrying
+		logger.Warn("Task requeued due to shutdown")
+	} else if cause := context.Cause(ctx); errors.Is(cause, errAdminFailed) {
+		next = StatusFailed
+		task.FailReason = "admin"
+		task.ErrorMessage = truncateHead(cause.Error(), maxErrorMessageLen)
+		logger.Warn("Task failed by admin")
+	} else if errors.Is(context.Cause(genCtx), errGenerationTimeout) {
+		next = StatusFailed
+		task.FailReason = "timeout"
+		task.ErrorMessage = fmt.Sprintf("generation timed out after %s", timeout)
+		logger.Error("Task timed out", "timeout", timeout)
+	} else if errors.Is(genErr, context.Canceled) {
+		next = StatusCancelled
+		logger.Info("Task cancelled")
+	} else if genErr != nil {
+		next = StatusFailed
+		task.FailReason = "error"
+		task.ErrorMessage, task.ErrorLog = failureDetails(genErr)
+		logger.Error("Task failed", "error", genErr)
+	} else {
+		images := make([]TaskImage, count)
+		var storeErr error
+		for i := range images {
+			img := &images[i]
+			output := task.outputName(imageOutputPath(outputPath, i))
+			img.Position, img.ImagePath, img.UpscaledPath, img.Seed = i, task.outputName(imageOutputPath(imagePath, i)), upscaleNames[i], seeds[i]
+			// 縮圖失敗不影響任務結果，前端會退回使用原圖
+			img.ThumbnailPath = thumbnailName(img.ImagePath)
+			if err := createThumbnail(output, filepath.Join(filepath.Dir(output), img.ThumbnailPath), a.Config.ThumbnailSize); err != nil {
+				logger.Warn("Thumbnail error", "index", i, "error", err)
+				img.ThumbnailPath = ""
+			}
+			upscalePath := ""
+			if img.UpscaledPath != "" {
+				upscalePath = filepath.Join(filepath.Dir(output), img.UpscaledPath)
+			}
+			if err := a.writeImageMetadata(task, *img, output, upscalePath); err != nil {
+				logger.Warn("Write image metadata error", "index", i, "error", err)
+			}
+			// 上傳期間不受取消影響，避免只留下一半的檔案
+			if storeErr = a.storeImage(context.WithoutCancel(ctx), task, img, output); storeErr != nil {
+				break
+			}
+		}
+		if storeErr == nil {
+			// 任務本身的欄位為第 0 張 (封面)
+			cover := images[0]
+			task.ImagePath, task.ThumbnailPath, task.UpscaledPath = cover.ImagePath, cover.ThumbnailPath, cover.UpscaledPath
+			task.StorageKey, task.ImageURL = cover.StorageKey, cover.ImageURL
+			task.ContentType = task.outputFormat().ContentType
+			task.Images = images
+		}
+		if storeErr != nil {
+			next = StatusFailed
+			task.FailReason = "storage"
+			task.ErrorMessage = truncateHead(storeErr.Error(), maxErrorMessageLen)
+			logger.Error("Storage upload failed", "error", storeErr)
+		} else if upscaleErr != nil {
+			next = StatusFailed
+			task.FailReason = "upscale"
+			task.ErrorMessage, task.ErrorLog = failureDetails(upscaleErr)
+			logger.Error("Upscale failed", "error", upscaleErr)
+		} else if task.Quarantined {
+			next = StatusFailed
+			task.FailReason = "safety"
+			task.ErrorMessage = "image blocked by content filter"
+			logger.Warn("Task quarantined by content filter", "storage_key", task.StorageKey)
+		} else {
+			next = StatusCompleted
+			logger.Info("Task completed", "storage_key", task.StorageKey)
+		}
+	}
+	finished := time.Now()
+	cost.Wall = finished.Sub(started)
+	task.addCost(cost)
+	reason := task.FailReason
+	if next == StatusRetrying {
+		reason = "shutdown"
+	}
+	// 只有仍由自己持有時才寫回，避免覆蓋其他 process 已經做的變更
+	saved, err := a.Tasks.Finish(ctx, task, next, reason)
+	if err != nil {
+		logger.Error("Save task result error", "error", err)
+		return
+	}
+	if !saved {
+		logger.Warn("Task was changed by another process, result discarded", "status", next)
+		return
+	}
+	a.recordUsage(task.UserID, finished, cost, task.Status != StatusRetrying)
+	a.publishTaskChange(ctx, *task)
+}
+
+// 把一張生成結果交給儲存後端，成功後才記錄到 img 上；縮圖與放大圖與原圖放在同一個目錄。
+// img 已填入檔名 (沒有縮圖、放大圖時為空字串)，上傳縮圖失敗時清除 ThumbnailPath。
+// 被內容安全檢查隔離的任務存到 quarantine/ 下，不提供公開網址
+func (a *App) storeImage(ctx context.Context, task *Task, img *TaskImage, outputPath string) error {
+	storageKey := func(name string) string { return name }
+	dir := filepath.Dir(outputPath)
+	if task.Quarantined {
+		storageKey = quarantineKey
+		// 本機的原始輸出在上傳到隔離區後移除，避免留在一般圖片目錄
+		names := []string{img.ImagePath, img.ThumbnailPath, metadataName(img.ImagePath), img.UpscaledPath}
+		defer func() {
+			for _, name := range names {
+				if name != "" {
+					os.Remove(filepath.Join(dir, name))
+				}
+			}
+		}()
+	}
+	// 工作區的任務放在 workspaces/<ID>/ 下
+	storage := a.storageFor(task)
+	url, err := storage.Put(ctx, storageKey(img.ImagePath), outputPath)
+	if err != nil {
+		return err
+	}
+	img.StorageKey, img.ImageURL = storageKey(img.ImagePath), url
+	if task.Quarantined {
+		img.ImageURL = ""
+	}
+	if img.ThumbnailPath != "" {
+		if _, err := storage.Put(ctx, storageKey(img.ThumbnailPath), filepath.Join(dir, img.ThumbnailPath)); err != nil {
+			loggerFrom(ctx).Warn("Thumbnail upload failed", "error", err)
+			img.ThumbnailPath = ""
+		}
+	}
+	// 中繼資料 JSON 與原圖同名，不另外記錄在任務上
+	metaPath := filepath.Join(dir, metadataName(img.ImagePath))
+	if _, err := os.Stat(metaPath); err == nil {
+		if _, err := storage.Put(ctx, storageKey(metadataName(img.ImagePath)), metaPath); err != nil {
+			loggerFrom(ctx).Warn("Metadata upload failed", "error", err)
+		}
+	}
+	if img.UpscaledPath != "" {
+		if _, err := storage.Put(ctx, storageKey(img.UpscaledPath), filepath.Join(dir, img.UpscaledPath)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 錯誤訊息與 log 的長度上限，log 保留結尾 (通常是 traceback)
+const (
+	maxErrorMessageLen = 500
+	maxErrorLogLen     = 4000
+)
+
+// 拆出要記錄在任務上的錯誤訊息與後端輸出
+func failureDetails(err error) (string, string) {
+	var genErr *GenerationError
+	if errors.As(err, &genErr) {
+		return truncateHead(genErr.Message, maxErrorMessageLen), truncateTail(genErr.Log, maxErrorLogLen)
+	}
+	return truncateHead(err.Error(), maxErrorMessageLen), ""
+}
+
+// 保留開頭 n 個字元 (以 rune 計，避免切斷中文)
+func truncateHead(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
+
+// 保留結尾 n 個字元
+func truncateTail(s string, n int) string {
+	r := []rune(strings.TrimSpace(s))
+	if len(r) <= n {
+		return string(r)
+	}
+	return "…" + string(r[len(r)-n:])
+}
+
+// 取消任務：排隊中與等待審核的任務直接標記為 Cancelled；Processing 則 kill python process，
+// 由 worker 在 process 結束後把狀態改成 Cancelled
+func (a *App) cancelTask(id uint, identity *Identity) (*Task, error) {
+	var task Task
+	if err := identity.taskScope(a.DB).First(&task, id).Error; err != nil {
+		return nil, err
+	}
+
+	switch task.Status {
+	case StatusPending, StatusRetrying, StatusAwaitingApproval:
+		ok, err := a.transitionTask(context.Background(), task.ID, task.Status, StatusCancelled, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			// 剛好被 worker 認領或被核准，依新的狀態重新處理
+			return a.cancelTask(id, identity)
+		}
+		now := time.Now()
+		task.Status, task.FinishedAt = StatusCancelled, &now
+		a.publishTask(context.Background(), EventTaskFinished, task)
+		a.notifyQueuePositions()
+		return &task, nil
+
+	case StatusProcessing:
+		a.runningMutex.Lock()
+		cancel, ok := a.runningTasks[task.ID]
+		a.runningMutex.Unlock()
+		if !ok {
+			// 在其他 process 執行：直接標記，該 worker 下次延長租約時會停止生成
+			if task.ClaimedBy == "" || task.ClaimedBy == a.Config.WorkerID {
+				return nil, errTaskNotCancellable
+			}
+			if err := a.markRemoteTask(&task, StatusCancelled, "", nil); err != nil {
+				return nil, err
+			}
+			a.publishTask(context.Background(), EventTaskFinished, task)
+			return &task, nil
+		}
+		cancel(nil)
+		return &task, nil
+	}
+	return nil, errTaskNotCancellable
+}
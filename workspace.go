@@ -0,0 +1,471 @@
+// workspace.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- 工作區 (多個團隊共用一個部署) ---
+// 成員以 X-Workspace: <名稱> 選擇工作區 (WebSocket、SSE 與 <img> 的圖片網址用 ?workspace=，gRPC 用 metadata x-workspace)：
+// 選擇後建立的任務屬於該工作區，歷史紀錄、圖庫與推播只包含工作區的任務 (成員之間共用)；
+// 沒有選擇時只包含自己不屬於任何工作區的任務。工作區的圖片存放在儲存後端的 workspaces/<ID>/ 下 (見 storageFor)，
+// 配額、預設模型與保留天數可以各自設定，0 或空字串代表沿用全域設定。
+// 角色：owner 可以修改設定與管理成員，member 可以建立與查看任務
+
+const (
+	roleWorkspaceOwner  = "owner"
+	roleWorkspaceMember = "member"
+
+	maxWorkspaceNameLen = 40
+	workspacePrefix     = "workspaces/"
+)
+
+// 名稱用在 header 與網址中，限制為小寫英數字、- 與 _
+var workspaceNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+type Workspace struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	Name            string    `gorm:"size:40;uniqueIndex" json:"name"`
+	Description     string    `json:"description,omitempty"`
+	MaxQueued       int       `json:"max_queued"`              // 工作區同時等待審核、排隊或執行中的任務數，0 代表不限制
+	MaxTasksPerHour int       `json:"max_tasks_per_hour"`      // 工作區最近一小時內建立的任務數，0 代表不限制
+	DefaultModel    string    `json:"default_model,omitempty"` // 任務沒有指定模型時使用，空字串代表伺服器的預設模型
+	RetentionDays   int       `json:"retention_days"`          // 任務保留天數，0 代表沿用 RETENTION_DAYS
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+
+	Role    string            `gorm:"-" json:"role,omitempty"`    // 呼叫者的角色
+	Members []WorkspaceMember `gorm:"-" json:"members,omitempty"` // 只有 GET /api/workspaces/{id} 才帶
+}
+
+type WorkspaceMember struct {
+	WorkspaceID uint      `gorm:"primaryKey" json:"-"`
+	UserID      uint      `gorm:"primaryKey;index" json:"user_id"`
+	Role        string    `gorm:"size:20" json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+	UserName    string    `gorm:"-" json:"user"`
+}
+
+// 建立與修改共用，沒有帶的欄位不變
+type workspaceRequest struct {
+	Name            *string `json:"name"`
+	Description     *string `json:"description"`
+	MaxQueued       *int    `json:"max_queued"`
+	MaxTasksPerHour *int    `json:"max_tasks_per_hour"`
+	DefaultModel    *string `json:"default_model"`
+	RetentionDays   *int    `json:"retention_days"`
+}
+
+type workspaceMemberRequest struct {
+	User string `json:"user"` // 使用者名稱 (API key 名稱)
+	Role string `json:"role"` // owner 或 member，預設 member
+}
+
+func (req workspaceRequest) apply(ws *Workspace) {
+	if req.Name != nil {
+		ws.Name = strings.ToLower(strings.TrimSpace(*req.Name))
+	}
+	if req.Description != nil {
+		ws.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.MaxQueued != nil {
+		ws.MaxQueued = *req.MaxQueued
+	}
+	if req.MaxTasksPerHour != nil {
+		ws.MaxTasksPerHour = *req.MaxTasksPerHour
+	}
+	if req.DefaultModel != nil {
+		ws.DefaultModel = strings.TrimSpace(*req.DefaultModel)
+	}
+	if req.RetentionDays != nil {
+		ws.RetentionDays = *req.RetentionDays
+	}
+}
+
+func (a *App) validateWorkspace(ws *Workspace) error {
+	if ws.Name == "" || len(ws.Name) > maxWorkspaceNameLen || !workspaceNamePattern.MatchString(ws.Name) {
+		return invalidField("name", "name is required, must not exceed %d characters and may only contain a-z, 0-9, - and _", maxWorkspaceNameLen)
+	}
+	for _, v := range []struct {
+		field string
+		value int
+	}{{"max_queued", ws.MaxQueued}, {"max_tasks_per_hour", ws.MaxTasksPerHour}, {"retention_days", ws.RetentionDays}} {
+		if v.value < 0 {
+			return invalidField(v.field, "%s must not be negative", v.field)
+		}
+	}
+	if ws.DefaultModel != "" {
+		if _, err := a.Config.Models.Lookup(ws.DefaultModel); err != nil {
+			if errors.Is(err, errModelsNotConfigured) {
+				return invalidField("default_model", "model selection is not available on this server")
+			}
+			var validationErr *ValidationError
+			if errors.As(err, &validationErr) {
+				return invalidField("default_model", "unknown model: %s", ws.DefaultModel)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func validWorkspaceRole(role string) bool {
+	return role == roleWorkspaceOwner || role == roleWorkspaceMember
+}
+
+// --- 選擇工作區 ---
+
+// X-Workspace header，沒有時為 ?workspace=
+func workspaceFromRequest(r *http.Request) string {
+	if name := r.Header.Get("X-Workspace"); name != "" {
+		return name
+	}
+	return r.URL.Query().Get("workspace")
+}
+
+// 以名稱選擇工作區，回傳帶有工作區的 Identity 副本；不是成員時視為不存在
+func (a *App) selectWorkspace(identity *Identity, name string) (*Identity, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return identity, nil
+	}
+	if identity.userID() == 0 {
+		return nil, invalidField("workspace", "workspaces require authentication")
+	}
+	notFound := &codedError{Code: "not_found", Message: "workspace not found"}
+	var ws Workspace
+	if err := a.DB.Where("name = ?", name).First(&ws).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, notFound
+		}
+		return nil, err
+	}
+	var member WorkspaceMember
+	if err := a.DB.Where("workspace_id = ? AND user_id = ?", ws.ID, identity.UserID).First(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, notFound
+		}
+		return nil, err
+	}
+	selected := *identity
+	selected.WorkspaceID, selected.WorkspaceRole = ws.ID, member.Role
+	return &selected, nil
+}
+
+// 呼叫者目前選擇的工作區，沒有選擇時為 nil
+func (a *App) currentWorkspace(ctx context.Context) (*Workspace, error) {
+	id := identityFromContext(ctx).workspaceID()
+	if id == 0 {
+		return nil, nil
+	}
+	var ws Workspace
+	if err := a.DB.First(&ws, id).Error; err != nil {
+		return nil, err
+	}
+	return &ws, nil
+}
+
+// --- 圖片儲存 ---
+
+// 工作區任務的儲存 key 前綴，個人任務為空字串
+func (t *Task) storagePrefix() string {
+	if t.WorkspaceID == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s%d/", workspacePrefix, *t.WorkspaceID)
+}
+
+// 任務的圖片所在的儲存後端：工作區的任務在 workspaces/<ID>/ 下，垃圾桶與隔離區也在前綴之下
+func (a *App) storageFor(task *Task) Storage {
+	if prefix := task.storagePrefix(); prefix != "" {
+		return prefixedStorage{Storage: a.Storage, prefix: prefix}
+	}
+	return a.Storage
+}
+
+type prefixedStorage struct {
+	Storage
+	prefix string
+}
+
+func (s prefixedStorage) Put(ctx context.Context, key, localPath string) (string, error) {
+	return s.Storage.Put(ctx, s.prefix+key, localPath)
+}
+
+func (s prefixedStorage) Open(ctx context.Context, key string) (io.ReadSeekCloser, time.Time, error) {
+	return s.Storage.Open(ctx, s.prefix+key)
+}
+
+func (s prefixedStorage) Delete(ctx context.Context, key string) error {
+	return s.Storage.Delete(ctx, s.prefix+key)
+}
+
+func (s prefixedStorage) Move(ctx context.Context, key, newKey string) error {
+	return s.Storage.Move(ctx, s.prefix+key, s.prefix+newKey)
+}
+
+// --- 工作區 API ---
+
+// 填入成員與使用者名稱
+func (a *App) loadWorkspaceMembers(ws *Workspace) error {
+	var members []WorkspaceMember
+	if err := a.DB.Where("workspace_id = ?", ws.ID).Order("created_at asc").Find(&members).Error; err != nil {
+		return err
+	}
+	ids := make([]uint, len(members))
+	for i := range members {
+		ids[i] = members[i].UserID
+	}
+	var users []User
+	if err := a.DB.Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return err
+	}
+	names := make(map[uint]string, len(users))
+	for _, u := range users {
+		names[u.ID] = u.Name
+	}
+	for i := range members {
+		members[i].UserName = names[members[i].UserID]
+	}
+	ws.Members = members
+	return nil
+}
+
+// 從路徑 {id} 取出呼叫者所屬的工作區 (填入 Role)，找不到或不是成員時直接寫出錯誤；
+// owner 為 true 時只有 owner 可以操作
+func (a *App) loadWorkspaceFromPath(w http.ResponseWriter, r *http.Request, owner bool) (*Workspace, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid workspace id")
+		return nil, false
+	}
+	var ws Workspace
+	var member WorkspaceMember
+	err = a.DB.First(&ws, id).Error
+	if err == nil {
+		err = a.DB.Where("workspace_id = ? AND user_id = ?", ws.ID, identityFromRequest(r).userID()).First(&member).Error
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, http.StatusNotFound, "workspace not found")
+		} else {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	ws.Role = member.Role
+	if owner && ws.Role != roleWorkspaceOwner {
+		writeError(w, http.StatusForbidden, "only workspace owners can do this")
+		return nil, false
+	}
+	return &ws, true
+}
+
+// GET /api/workspaces：呼叫者所屬的工作區
+func (a *App) apiListWorkspaces(w http.ResponseWriter, r *http.Request) {
+	var members []WorkspaceMember
+	if err := a.DB.Where("user_id = ?", identityFromRequest(r).userID()).Find(&members).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	roles := make(map[uint]string, len(members))
+	ids := make([]uint, len(members))
+	for i, m := range members {
+		roles[m.WorkspaceID], ids[i] = m.Role, m.WorkspaceID
+	}
+	workspaces := []Workspace{}
+	if err := a.DB.Where("id IN ?", ids).Order("name asc").Find(&workspaces).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for i := range workspaces {
+		workspaces[i].Role = roles[workspaces[i].ID]
+	}
+	writeJSON(w, http.StatusOK, workspaces)
+}
+
+// POST /api/workspaces {"name","description","max_queued","max_tasks_per_hour","default_model","retention_days"}
+// 建立者成為 owner
+func (a *App) apiCreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	identity := identityFromRequest(r)
+	if identity.userID() == 0 {
+		writeError(w, http.StatusBadRequest, "workspaces require authentication")
+		return
+	}
+	var req workspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	var ws Workspace
+	req.apply(&ws)
+	if err := a.validateWorkspace(&ws); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&ws).Error; err != nil {
+			return err
+		}
+		return tx.Create(&WorkspaceMember{WorkspaceID: ws.ID, UserID: identity.UserID, Role: roleWorkspaceOwner}).Error
+	})
+	if err != nil {
+		writeNameConflict(w, err, "workspace name already exists")
+		return
+	}
+	ws.Role = roleWorkspaceOwner
+	a.audit(r.Context(), "workspace.created", "workspace", strconv.FormatUint(uint64(ws.ID), 10), ws.Name)
+	writeJSON(w, http.StatusCreated, ws)
+}
+
+// GET /api/workspaces/{id}：設定與成員
+func (a *App) apiGetWorkspace(w http.ResponseWriter, r *http.Request) {
+	ws, ok := a.loadWorkspaceFromPath(w, r, false)
+	if !ok {
+		return
+	}
+	if err := a.loadWorkspaceMembers(ws); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ws)
+}
+
+// PUT /api/workspaces/{id}：修改名稱與設定 (owner)，圖片前綴以 ID 命名，改名不影響既有圖片
+func (a *App) apiUpdateWorkspace(w http.ResponseWriter, r *http.Request) {
+	ws, ok := a.loadWorkspaceFromPath(w, r, true)
+	if !ok {
+		return
+	}
+	var req workspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	req.apply(ws)
+	if err := a.validateWorkspace(ws); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	if err := a.DB.Save(ws).Error; err != nil {
+		writeNameConflict(w, err, "workspace name already exists")
+		return
+	}
+	a.audit(r.Context(), "workspace.updated", "workspace", strconv.FormatUint(uint64(ws.ID), 10), ws.Name)
+	writeJSON(w, http.StatusOK, ws)
+}
+
+// POST /api/workspaces/{id}/members {"user","role"}：加入成員或修改角色 (owner)
+func (a *App) apiSetWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	ws, ok := a.loadWorkspaceFromPath(w, r, true)
+	if !ok {
+		return
+	}
+	var req workspaceMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Role == "" {
+		req.Role = roleWorkspaceMember
+	}
+	if !validWorkspaceRole(req.Role) {
+		writeTaskError(w, invalidField("role", "role must be owner or member"))
+		return
+	}
+	var user User
+	if err := a.DB.Where("name = ?", strings.TrimSpace(req.User)).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeTaskError(w, invalidField("user", "unknown user: %s", req.User))
+		} else {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if req.Role != roleWorkspaceOwner {
+		if err := a.keepWorkspaceOwner(ws.ID, user.ID); err != nil {
+			writeTaskError(w, err)
+			return
+		}
+	}
+	member := WorkspaceMember{WorkspaceID: ws.ID, UserID: user.ID, Role: req.Role}
+	err := a.DB.Where(WorkspaceMember{WorkspaceID: ws.ID, UserID: user.ID}).
+		Assign(WorkspaceMember{Role: req.Role}).FirstOrCreate(&member).Error
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	member.UserName = user.Name
+	a.audit(r.Context(), "workspace.member_set", "workspace", strconv.FormatUint(uint64(ws.ID), 10), user.Name+"="+req.Role)
+	writeJSON(w, http.StatusOK, member)
+}
+
+// DELETE /api/workspaces/{id}/members/{user_id} (owner)；成員建立的任務留在工作區
+func (a *App) apiRemoveWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	ws, ok := a.loadWorkspaceFromPath(w, r, true)
+	if !ok {
+		return
+	}
+	userID, err := strconv.ParseUint(r.PathValue("user_id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	if err := a.keepWorkspaceOwner(ws.ID, uint(userID)); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	result := a.DB.Where("workspace_id = ? AND user_id = ?", ws.ID, userID).Delete(&WorkspaceMember{})
+	if result.Error != nil {
+		writeError(w, http.StatusInternalServerError, result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		writeError(w, http.StatusNotFound, "member not found")
+		return
+	}
+	a.audit(r.Context(), "workspace.member_removed", "workspace", strconv.FormatUint(uint64(ws.ID), 10), strconv.FormatUint(userID, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 移除或降級 userID 之後工作區至少還要有一個 owner
+func (a *App) keepWorkspaceOwner(workspaceID, userID uint) error {
+	var owners int64
+	err := a.DB.Model(&WorkspaceMember{}).
+		Where("workspace_id = ? AND role = ? AND user_id <> ?", workspaceID, roleWorkspaceOwner, userID).
+		Count(&owners).Error
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return &codedError{Code: "conflict", Message: "workspace must keep at least one owner"}
+	}
+	return nil
+}
+
+// GET /api/admin/workspaces：所有工作區與成員
+func (a *App) apiAdminListWorkspaces(w http.ResponseWriter, r *http.Request) {
+	workspaces := []Workspace{}
+	if err := a.DB.Order("name asc").Find(&workspaces).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for i := range workspaces {
+		if err := a.loadWorkspaceMembers(&workspaces[i]); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, workspaces)
+}
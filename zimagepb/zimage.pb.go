@@ -0,0 +1,964 @@
+// zimage.proto：gRPC API (GRPC_PORT)，與 REST / WebSocket 共用同一套任務邏輯，見 grpc.go
+// 修改後以 go generate 重新產生 zimage.pb.go / zimage_grpc.pb.go
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v5.29.3
+// source: zimage.proto
+
+package zimagepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GenerationParams struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	NegativePrompt string                 `protobuf:"bytes,1,opt,name=negative_prompt,json=negativePrompt,proto3" json:"negative_prompt,omitempty"`
+	Width          int32                  `protobuf:"varint,2,opt,name=width,proto3" json:"width,omitempty"`
+	Height         int32                  `protobuf:"varint,3,opt,name=height,proto3" json:"height,omitempty"`
+	Steps          int32                  `protobuf:"varint,4,opt,name=steps,proto3" json:"steps,omitempty"`
+	GuidanceScale  float64                `protobuf:"fixed64,5,opt,name=guidance_scale,json=guidanceScale,proto3" json:"guidance_scale,omitempty"`
+	Seed           *int64                 `protobuf:"varint,6,opt,name=seed,proto3,oneof" json:"seed,omitempty"` // 沒有指定代表隨機
+	Strength       float64                `protobuf:"fixed64,7,opt,name=strength,proto3" json:"strength,omitempty"`
+	Upscale        int32                  `protobuf:"varint,8,opt,name=upscale,proto3" json:"upscale,omitempty"` // 0 (不放大)、2、4
+	Model          string                 `protobuf:"bytes,9,opt,name=model,proto3" json:"model,omitempty"`
+	NumImages      int32                  `protobuf:"varint,10,opt,name=num_images,json=numImages,proto3" json:"num_images,omitempty"`
+	Format         string                 `protobuf:"bytes,11,opt,name=format,proto3" json:"format,omitempty"`                                           // png (預設)、jpeg、webp
+	Quality        int32                  `protobuf:"varint,12,opt,name=quality,proto3" json:"quality,omitempty"`                                        // jpeg 品質 1-100，0 代表預設
+	Watermark      *bool                  `protobuf:"varint,13,opt,name=watermark,proto3,oneof" json:"watermark,omitempty"`                              // 沒有指定代表伺服器預設
+	StripMetadata  *bool                  `protobuf:"varint,14,opt,name=strip_metadata,json=stripMetadata,proto3,oneof" json:"strip_metadata,omitempty"` // 沒有指定代表伺服器預設 (STRIP_METADATA)
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *GenerationParams) Reset() {
+	*x = GenerationParams{}
+	mi := &file_zimage_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GenerationParams) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GenerationParams) ProtoMessage() {}
+
+func (x *GenerationParams) ProtoReflect() protoreflect.Message {
+	mi := &file_zimage_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GenerationParams.ProtoReflect.Descriptor instead.
+func (*GenerationParams) Descriptor() ([]byte, []int) {
+	return file_zimage_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GenerationParams) GetNegativePrompt() string {
+	if x != nil {
+		return x.NegativePrompt
+	}
+	return ""
+}
+
+func (x *GenerationParams) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *GenerationParams) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *GenerationParams) GetSteps() int32 {
+	if x != nil {
+		return x.Steps
+	}
+	return 0
+}
+
+func (x *GenerationParams) GetGuidanceScale() float64 {
+	if x != nil {
+		return x.GuidanceScale
+	}
+	return 0
+}
+
+func (x *GenerationParams) GetSeed() int64 {
+	if x != nil && x.Seed != nil {
+		return *x.Seed
+	}
+	return 0
+}
+
+func (x *GenerationParams) GetStrength() float64 {
+	if x != nil {
+		return x.Strength
+	}
+	return 0
+}
+
+func (x *GenerationParams) GetUpscale() int32 {
+	if x != nil {
+		return x.Upscale
+	}
+	return 0
+}
+
+func (x *GenerationParams) GetModel() string {
+	if x != nil {
+		return x.Model
+	}
+	return ""
+}
+
+func (x *GenerationParams) GetNumImages() int32 {
+	if x != nil {
+		return x.NumImages
+	}
+	return 0
+}
+
+func (x *GenerationParams) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *GenerationParams) GetQuality() int32 {
+	if x != nil {
+		return x.Quality
+	}
+	return 0
+}
+
+func (x *GenerationParams) GetWatermark() bool {
+	if x != nil && x.Watermark != nil {
+		return *x.Watermark
+	}
+	return false
+}
+
+func (x *GenerationParams) GetStripMetadata() bool {
+	if x != nil && x.StripMetadata != nil {
+		return *x.StripMetadata
+	}
+	return false
+}
+
+type CreateTaskRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Prompt        string                 `protobuf:"bytes,1,opt,name=prompt,proto3" json:"prompt,omitempty"`
+	Params        *GenerationParams      `protobuf:"bytes,2,opt,name=params,proto3" json:"params,omitempty"`
+	ScheduledAt   *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=scheduled_at,json=scheduledAt,proto3" json:"scheduled_at,omitempty"` // 指定後到時間才執行
+	CallbackUrl   string                 `protobuf:"bytes,4,opt,name=callback_url,json=callbackUrl,proto3" json:"callback_url,omitempty"`
+	Force         bool                   `protobuf:"varint,5,opt,name=force,proto3" json:"force,omitempty"` // 略過生成結果快取
+	Tags          []string               `protobuf:"bytes,6,rep,name=tags,proto3" json:"tags,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateTaskRequest) Reset() {
+	*x = CreateTaskRequest{}
+	mi := &file_zimage_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateTaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateTaskRequest) ProtoMessage() {}
+
+func (x *CreateTaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_zimage_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateTaskRequest.ProtoReflect.Descriptor instead.
+func (*CreateTaskRequest) Descriptor() ([]byte, []int) {
+	return file_zimage_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CreateTaskRequest) GetPrompt() string {
+	if x != nil {
+		return x.Prompt
+	}
+	return ""
+}
+
+func (x *CreateTaskRequest) GetParams() *GenerationParams {
+	if x != nil {
+		return x.Params
+	}
+	return nil
+}
+
+func (x *CreateTaskRequest) GetScheduledAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ScheduledAt
+	}
+	return nil
+}
+
+func (x *CreateTaskRequest) GetCallbackUrl() string {
+	if x != nil {
+		return x.CallbackUrl
+	}
+	return ""
+}
+
+func (x *CreateTaskRequest) GetForce() bool {
+	if x != nil {
+		return x.Force
+	}
+	return false
+}
+
+func (x *CreateTaskRequest) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+type GetTaskRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTaskRequest) Reset() {
+	*x = GetTaskRequest{}
+	mi := &file_zimage_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTaskRequest) ProtoMessage() {}
+
+func (x *GetTaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_zimage_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTaskRequest.ProtoReflect.Descriptor instead.
+func (*GetTaskRequest) Descriptor() ([]byte, []int) {
+	return file_zimage_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetTaskRequest) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type ListTasksRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Limit         int32                  `protobuf:"varint,1,opt,name=limit,proto3" json:"limit,omitempty"` // 預設 20，最多 100
+	Tags          []string               `protobuf:"bytes,2,rep,name=tags,proto3" json:"tags,omitempty"`    // 只列出同時具有這些標籤的任務
+	CollectionId  uint64                 `protobuf:"varint,3,opt,name=collection_id,json=collectionId,proto3" json:"collection_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTasksRequest) Reset() {
+	*x = ListTasksRequest{}
+	mi := &file_zimage_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTasksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTasksRequest) ProtoMessage() {}
+
+func (x *ListTasksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_zimage_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTasksRequest.ProtoReflect.Descriptor instead.
+func (*ListTasksRequest) Descriptor() ([]byte, []int) {
+	return file_zimage_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListTasksRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ListTasksRequest) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *ListTasksRequest) GetCollectionId() uint64 {
+	if x != nil {
+		return x.CollectionId
+	}
+	return 0
+}
+
+type ListTasksResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Tasks         []*Task                `protobuf:"bytes,1,rep,name=tasks,proto3" json:"tasks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTasksResponse) Reset() {
+	*x = ListTasksResponse{}
+	mi := &file_zimage_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTasksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTasksResponse) ProtoMessage() {}
+
+func (x *ListTasksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_zimage_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTasksResponse.ProtoReflect.Descriptor instead.
+func (*ListTasksResponse) Descriptor() ([]byte, []int) {
+	return file_zimage_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListTasksResponse) GetTasks() []*Task {
+	if x != nil {
+		return x.Tasks
+	}
+	return nil
+}
+
+type TaskImage struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Index         int32                  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	ImagePath     string                 `protobuf:"bytes,2,opt,name=image_path,json=imagePath,proto3" json:"image_path,omitempty"`
+	ImageUrl      string                 `protobuf:"bytes,3,opt,name=image_url,json=imageUrl,proto3" json:"image_url,omitempty"`
+	Seed          *int64                 `protobuf:"varint,4,opt,name=seed,proto3,oneof" json:"seed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TaskImage) Reset() {
+	*x = TaskImage{}
+	mi := &file_zimage_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskImage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskImage) ProtoMessage() {}
+
+func (x *TaskImage) ProtoReflect() protoreflect.Message {
+	mi := &file_zimage_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskImage.ProtoReflect.Descriptor instead.
+func (*TaskImage) Descriptor() ([]byte, []int) {
+	return file_zimage_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *TaskImage) GetIndex() int32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *TaskImage) GetImagePath() string {
+	if x != nil {
+		return x.ImagePath
+	}
+	return ""
+}
+
+func (x *TaskImage) GetImageUrl() string {
+	if x != nil {
+		return x.ImageUrl
+	}
+	return ""
+}
+
+func (x *TaskImage) GetSeed() int64 {
+	if x != nil && x.Seed != nil {
+		return *x.Seed
+	}
+	return 0
+}
+
+type Task struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Prompt        string                 `protobuf:"bytes,2,opt,name=prompt,proto3" json:"prompt,omitempty"`
+	Params        *GenerationParams      `protobuf:"bytes,3,opt,name=params,proto3" json:"params,omitempty"`
+	Status        string                 `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"` // AwaitingApproval, Pending, Retrying, Processing, Completed, Failed, Cancelled
+	FailReason    string                 `protobuf:"bytes,5,opt,name=fail_reason,json=failReason,proto3" json:"fail_reason,omitempty"`
+	ErrorMessage  string                 `protobuf:"bytes,6,opt,name=error_message,json=errorMessage,proto3" json:"error_message,omitempty"`
+	ImagePath     string                 `protobuf:"bytes,7,opt,name=image_path,json=imagePath,proto3" json:"image_path,omitempty"`
+	ImageUrl      string                 `protobuf:"bytes,8,opt,name=image_url,json=imageUrl,proto3" json:"image_url,omitempty"`
+	UsedSeed      *int64                 `protobuf:"varint,9,opt,name=used_seed,json=usedSeed,proto3,oneof" json:"used_seed,omitempty"`
+	CreatedBy     string                 `protobuf:"bytes,10,opt,name=created_by,json=createdBy,proto3" json:"created_by,omitempty"`
+	Tags          []string               `protobuf:"bytes,11,rep,name=tags,proto3" json:"tags,omitempty"`
+	Images        []*TaskImage           `protobuf:"bytes,12,rep,name=images,proto3" json:"images,omitempty"`
+	QueuePosition int32                  `protobuf:"varint,13,opt,name=queue_position,json=queuePosition,proto3" json:"queue_position,omitempty"` // Pending / Retrying 任務才有，1 代表下一個執行
+	EtaSeconds    int32                  `protobuf:"varint,14,opt,name=eta_seconds,json=etaSeconds,proto3" json:"eta_seconds,omitempty"`
+	CreatedAt     *timestamppb.Timestamp `protobuf:"bytes,15,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	StartedAt     *timestamppb.Timestamp `protobuf:"bytes,16,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	FinishedAt    *timestamppb.Timestamp `protobuf:"bytes,17,opt,name=finished_at,json=finishedAt,proto3" json:"finished_at,omitempty"`
+	ContentType   string                 `protobuf:"bytes,18,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"` // 圖片的 MIME type
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Task) Reset() {
+	*x = Task{}
+	mi := &file_zimage_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Task) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Task) ProtoMessage() {}
+
+func (x *Task) ProtoReflect() protoreflect.Message {
+	mi := &file_zimage_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Task.ProtoReflect.Descriptor instead.
+func (*Task) Descriptor() ([]byte, []int) {
+	return file_zimage_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Task) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Task) GetPrompt() string {
+	if x != nil {
+		return x.Prompt
+	}
+	return ""
+}
+
+func (x *Task) GetParams() *GenerationParams {
+	if x != nil {
+		return x.Params
+	}
+	return nil
+}
+
+func (x *Task) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Task) GetFailReason() string {
+	if x != nil {
+		return x.FailReason
+	}
+	return ""
+}
+
+func (x *Task) GetErrorMessage() string {
+	if x != nil {
+		return x.ErrorMessage
+	}
+	return ""
+}
+
+func (x *Task) GetImagePath() string {
+	if x != nil {
+		return x.ImagePath
+	}
+	return ""
+}
+
+func (x *Task) GetImageUrl() string {
+	if x != nil {
+		return x.ImageUrl
+	}
+	return ""
+}
+
+func (x *Task) GetUsedSeed() int64 {
+	if x != nil && x.UsedSeed != nil {
+		return *x.UsedSeed
+	}
+	return 0
+}
+
+func (x *Task) GetCreatedBy() string {
+	if x != nil {
+		return x.CreatedBy
+	}
+	return ""
+}
+
+func (x *Task) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Task) GetImages() []*TaskImage {
+	if x != nil {
+		return x.Images
+	}
+	return nil
+}
+
+func (x *Task) GetQueuePosition() int32 {
+	if x != nil {
+		return x.QueuePosition
+	}
+	return 0
+}
+
+func (x *Task) GetEtaSeconds() int32 {
+	if x != nil {
+		return x.EtaSeconds
+	}
+	return 0
+}
+
+func (x *Task) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Task) GetStartedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartedAt
+	}
+	return nil
+}
+
+func (x *Task) GetFinishedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.FinishedAt
+	}
+	return nil
+}
+
+func (x *Task) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+// 訂閱變更，可以隨時送出；連線後預設訂閱自己的所有任務
+type StreamRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Subscribe     []uint64               `protobuf:"varint,1,rep,packed,name=subscribe,proto3" json:"subscribe,omitempty"`
+	Unsubscribe   []uint64               `protobuf:"varint,2,rep,packed,name=unsubscribe,proto3" json:"unsubscribe,omitempty"`
+	All           *bool                  `protobuf:"varint,3,opt,name=all,proto3,oneof" json:"all,omitempty"` // 是否訂閱自己的所有任務
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamRequest) Reset() {
+	*x = StreamRequest{}
+	mi := &file_zimage_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamRequest) ProtoMessage() {}
+
+func (x *StreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_zimage_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamRequest.ProtoReflect.Descriptor instead.
+func (*StreamRequest) Descriptor() ([]byte, []int) {
+	return file_zimage_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *StreamRequest) GetSubscribe() []uint64 {
+	if x != nil {
+		return x.Subscribe
+	}
+	return nil
+}
+
+func (x *StreamRequest) GetUnsubscribe() []uint64 {
+	if x != nil {
+		return x.Unsubscribe
+	}
+	return nil
+}
+
+func (x *StreamRequest) GetAll() bool {
+	if x != nil && x.All != nil {
+		return *x.All
+	}
+	return false
+}
+
+type TaskEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"` // new_task、update、progress、position、image、queue_state 等，與 WebSocket 相同
+	Seq           int64                  `protobuf:"varint,2,opt,name=seq,proto3" json:"seq,omitempty"`
+	TaskId        uint64                 `protobuf:"varint,3,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	Task          *Task                  `protobuf:"bytes,4,opt,name=task,proto3" json:"task,omitempty"` // new_task 與 update 才有
+	Json          string                 `protobuf:"bytes,5,opt,name=json,proto3" json:"json,omitempty"` // 原始的 WebSocket 訊息 (JSON)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TaskEvent) Reset() {
+	*x = TaskEvent{}
+	mi := &file_zimage_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskEvent) ProtoMessage() {}
+
+func (x *TaskEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_zimage_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskEvent.ProtoReflect.Descriptor instead.
+func (*TaskEvent) Descriptor() ([]byte, []int) {
+	return file_zimage_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *TaskEvent) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *TaskEvent) GetSeq() int64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *TaskEvent) GetTaskId() uint64 {
+	if x != nil {
+		return x.TaskId
+	}
+	return 0
+}
+
+func (x *TaskEvent) GetTask() *Task {
+	if x != nil {
+		return x.Task
+	}
+	return nil
+}
+
+func (x *TaskEvent) GetJson() string {
+	if x != nil {
+		return x.Json
+	}
+	return ""
+}
+
+var File_zimage_proto protoreflect.FileDescriptor
+
+const file_zimage_proto_rawDesc = "" +
+	"\n" +
+	"\fzimage.proto\x12\tzimage.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xd5\x03\n" +
+	"\x10GenerationParams\x12'\n" +
+	"\x0fnegative_prompt\x18\x01 \x01(\tR\x0enegativePrompt\x12\x14\n" +
+	"\x05width\x18\x02 \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\x03 \x01(\x05R\x06height\x12\x14\n" +
+	"\x05steps\x18\x04 \x01(\x05R\x05steps\x12%\n" +
+	"\x0eguidance_scale\x18\x05 \x01(\x01R\rguidanceScale\x12\x17\n" +
+	"\x04seed\x18\x06 \x01(\x03H\x00R\x04seed\x88\x01\x01\x12\x1a\n" +
+	"\bstrength\x18\a \x01(\x01R\bstrength\x12\x18\n" +
+	"\aupscale\x18\b \x01(\x05R\aupscale\x12\x14\n" +
+	"\x05model\x18\t \x01(\tR\x05model\x12\x1d\n" +
+	"\n" +
+	"num_images\x18\n" +
+	" \x01(\x05R\tnumImages\x12\x16\n" +
+	"\x06format\x18\v \x01(\tR\x06format\x12\x18\n" +
+	"\aquality\x18\f \x01(\x05R\aquality\x12!\n" +
+	"\twatermark\x18\r \x01(\bH\x01R\twatermark\x88\x01\x01\x12*\n" +
+	"\x0estrip_metadata\x18\x0e \x01(\bH\x02R\rstripMetadata\x88\x01\x01B\a\n" +
+	"\x05_seedB\f\n" +
+	"\n" +
+	"_watermarkB\x11\n" +
+	"\x0f_strip_metadata\"\xec\x01\n" +
+	"\x11CreateTaskRequest\x12\x16\n" +
+	"\x06prompt\x18\x01 \x01(\tR\x06prompt\x123\n" +
+	"\x06params\x18\x02 \x01(\v2\x1b.zimage.v1.GenerationParamsR\x06params\x12=\n" +
+	"\fscheduled_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\vscheduledAt\x12!\n" +
+	"\fcallback_url\x18\x04 \x01(\tR\vcallbackUrl\x12\x14\n" +
+	"\x05force\x18\x05 \x01(\bR\x05force\x12\x12\n" +
+	"\x04tags\x18\x06 \x03(\tR\x04tags\" \n" +
+	"\x0eGetTaskRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\"a\n" +
+	"\x10ListTasksRequest\x12\x14\n" +
+	"\x05limit\x18\x01 \x01(\x05R\x05limit\x12\x12\n" +
+	"\x04tags\x18\x02 \x03(\tR\x04tags\x12#\n" +
+	"\rcollection_id\x18\x03 \x01(\x04R\fcollectionId\":\n" +
+	"\x11ListTasksResponse\x12%\n" +
+	"\x05tasks\x18\x01 \x03(\v2\x0f.zimage.v1.TaskR\x05tasks\"\x7f\n" +
+	"\tTaskImage\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x05R\x05index\x12\x1d\n" +
+	"\n" +
+	"image_path\x18\x02 \x01(\tR\timagePath\x12\x1b\n" +
+	"\timage_url\x18\x03 \x01(\tR\bimageUrl\x12\x17\n" +
+	"\x04seed\x18\x04 \x01(\x03H\x00R\x04seed\x88\x01\x01B\a\n" +
+	"\x05_seed\"\xac\x05\n" +
+	"\x04Task\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x16\n" +
+	"\x06prompt\x18\x02 \x01(\tR\x06prompt\x123\n" +
+	"\x06params\x18\x03 \x01(\v2\x1b.zimage.v1.GenerationParamsR\x06params\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\tR\x06status\x12\x1f\n" +
+	"\vfail_reason\x18\x05 \x01(\tR\n" +
+	"failReason\x12#\n" +
+	"\rerror_message\x18\x06 \x01(\tR\ferrorMessage\x12\x1d\n" +
+	"\n" +
+	"image_path\x18\a \x01(\tR\timagePath\x12\x1b\n" +
+	"\timage_url\x18\b \x01(\tR\bimageUrl\x12 \n" +
+	"\tused_seed\x18\t \x01(\x03H\x00R\busedSeed\x88\x01\x01\x12\x1d\n" +
+	"\n" +
+	"created_by\x18\n" +
+	" \x01(\tR\tcreatedBy\x12\x12\n" +
+	"\x04tags\x18\v \x03(\tR\x04tags\x12,\n" +
+	"\x06images\x18\f \x03(\v2\x14.zimage.v1.TaskImageR\x06images\x12%\n" +
+	"\x0equeue_position\x18\r \x01(\x05R\rqueuePosition\x12\x1f\n" +
+	"\veta_seconds\x18\x0e \x01(\x05R\n" +
+	"etaSeconds\x129\n" +
+	"\n" +
+	"created_at\x18\x0f \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x129\n" +
+	"\n" +
+	"started_at\x18\x10 \x01(\v2\x1a.google.protobuf.TimestampR\tstartedAt\x12;\n" +
+	"\vfinished_at\x18\x11 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"finishedAt\x12!\n" +
+	"\fcontent_type\x18\x12 \x01(\tR\vcontentTypeB\f\n" +
+	"\n" +
+	"_used_seed\"n\n" +
+	"\rStreamRequest\x12\x1c\n" +
+	"\tsubscribe\x18\x01 \x03(\x04R\tsubscribe\x12 \n" +
+	"\vunsubscribe\x18\x02 \x03(\x04R\vunsubscribe\x12\x15\n" +
+	"\x03all\x18\x03 \x01(\bH\x00R\x03all\x88\x01\x01B\x06\n" +
+	"\x04_all\"\x83\x01\n" +
+	"\tTaskEvent\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x10\n" +
+	"\x03seq\x18\x02 \x01(\x03R\x03seq\x12\x17\n" +
+	"\atask_id\x18\x03 \x01(\x04R\x06taskId\x12#\n" +
+	"\x04task\x18\x04 \x01(\v2\x0f.zimage.v1.TaskR\x04task\x12\x12\n" +
+	"\x04json\x18\x05 \x01(\tR\x04json2\x89\x02\n" +
+	"\x06ZImage\x12;\n" +
+	"\n" +
+	"CreateTask\x12\x1c.zimage.v1.CreateTaskRequest\x1a\x0f.zimage.v1.Task\x125\n" +
+	"\aGetTask\x12\x19.zimage.v1.GetTaskRequest\x1a\x0f.zimage.v1.Task\x12F\n" +
+	"\tListTasks\x12\x1b.zimage.v1.ListTasksRequest\x1a\x1c.zimage.v1.ListTasksResponse\x12C\n" +
+	"\rStreamUpdates\x12\x18.zimage.v1.StreamRequest\x1a\x14.zimage.v1.TaskEvent(\x010\x01B)Z'github.com/asccclass/mcpzimage/zimagepbb\x06proto3"
+
+var (
+	file_zimage_proto_rawDescOnce sync.Once
+	file_zimage_proto_rawDescData []byte
+)
+
+func file_zimage_proto_rawDescGZIP() []byte {
+	file_zimage_proto_rawDescOnce.Do(func() {
+		file_zimage_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_zimage_proto_rawDesc), len(file_zimage_proto_rawDesc)))
+	})
+	return file_zimage_proto_rawDescData
+}
+
+var file_zimage_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_zimage_proto_goTypes = []any{
+	(*GenerationParams)(nil),      // 0: zimage.v1.GenerationParams
+	(*CreateTaskRequest)(nil),     // 1: zimage.v1.CreateTaskRequest
+	(*GetTaskRequest)(nil),        // 2: zimage.v1.GetTaskRequest
+	(*ListTasksRequest)(nil),      // 3: zimage.v1.ListTasksRequest
+	(*ListTasksResponse)(nil),     // 4: zimage.v1.ListTasksResponse
+	(*TaskImage)(nil),             // 5: zimage.v1.TaskImage
+	(*Task)(nil),                  // 6: zimage.v1.Task
+	(*StreamRequest)(nil),         // 7: zimage.v1.StreamRequest
+	(*TaskEvent)(nil),             // 8: zimage.v1.TaskEvent
+	(*timestamppb.Timestamp)(nil), // 9: google.protobuf.Timestamp
+}
+var file_zimage_proto_depIdxs = []int32{
+	0,  // 0: zimage.v1.CreateTaskRequest.params:type_name -> zimage.v1.GenerationParams
+	9,  // 1: zimage.v1.CreateTaskRequest.scheduled_at:type_name -> google.protobuf.Timestamp
+	6,  // 2: zimage.v1.ListTasksResponse.tasks:type_name -> zimage.v1.Task
+	0,  // 3: zimage.v1.Task.params:type_name -> zimage.v1.GenerationParams
+	5,  // 4: zimage.v1.Task.images:type_name -> zimage.v1.TaskImage
+	9,  // 5: zimage.v1.Task.created_at:type_name -> google.protobuf.Timestamp
+	9,  // 6: zimage.v1.Task.started_at:type_name -> google.protobuf.Timestamp
+	9,  // 7: zimage.v1.Task.finished_at:type_name -> google.protobuf.Timestamp
+	6,  // 8: zimage.v1.TaskEvent.task:type_name -> zimage.v1.Task
+	1,  // 9: zimage.v1.ZImage.CreateTask:input_type -> zimage.v1.CreateTaskRequest
+	2,  // 10: zimage.v1.ZImage.GetTask:input_type -> zimage.v1.GetTaskRequest
+	3,  // 11: zimage.v1.ZImage.ListTasks:input_type -> zimage.v1.ListTasksRequest
+	7,  // 12: zimage.v1.ZImage.StreamUpdates:input_type -> zimage.v1.StreamRequest
+	6,  // 13: zimage.v1.ZImage.CreateTask:output_type -> zimage.v1.Task
+	6,  // 14: zimage.v1.ZImage.GetTask:output_type -> zimage.v1.Task
+	4,  // 15: zimage.v1.ZImage.ListTasks:output_type -> zimage.v1.ListTasksResponse
+	8,  // 16: zimage.v1.ZImage.StreamUpdates:output_type -> zimage.v1.TaskEvent
+	13, // [13:17] is the sub-list for method output_type
+	9,  // [9:13] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_zimage_proto_init() }
+func file_zimage_proto_init() {
+	if File_zimage_proto != nil {
+		return
+	}
+	file_zimage_proto_msgTypes[0].OneofWrappers = []any{}
+	file_zimage_proto_msgTypes[5].OneofWrappers = []any{}
+	file_zimage_proto_msgTypes[6].OneofWrappers = []any{}
+	file_zimage_proto_msgTypes[7].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_zimage_proto_rawDesc), len(file_zimage_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_zimage_proto_goTypes,
+		DependencyIndexes: file_zimage_proto_depIdxs,
+		MessageInfos:      file_zimage_proto_msgTypes,
+	}.Build()
+	File_zimage_proto = out.File
+	file_zimage_proto_goTypes = nil
+	file_zimage_proto_depIdxs = nil
+}
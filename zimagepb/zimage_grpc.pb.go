@@ -0,0 +1,245 @@
+// zimage.proto：gRPC API (GRPC_PORT)，與 REST / WebSocket 共用同一套任務邏輯，見 grpc.go
+// 修改後以 go generate 重新產生 zimage.pb.go / zimage_grpc.pb.go
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: zimage.proto
+
+package zimagepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ZImage_CreateTask_FullMethodName    = "/zimage.v1.ZImage/CreateTask"
+	ZImage_GetTask_FullMethodName       = "/zimage.v1.ZImage/GetTask"
+	ZImage_ListTasks_FullMethodName     = "/zimage.v1.ZImage/ListTasks"
+	ZImage_StreamUpdates_FullMethodName = "/zimage.v1.ZImage/StreamUpdates"
+)
+
+// ZImageClient is the client API for ZImage service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// 驗證與 REST 相同：metadata 帶 authorization: Bearer <API key> 或 x-api-key: <API key>
+type ZImageClient interface {
+	// 建立任務 (對應 POST /api/tasks)
+	CreateTask(ctx context.Context, in *CreateTaskRequest, opts ...grpc.CallOption) (*Task, error)
+	// 取得任務 (對應 GET /api/tasks/{id})，別人的任務回 NOT_FOUND
+	GetTask(ctx context.Context, in *GetTaskRequest, opts ...grpc.CallOption) (*Task, error)
+	// 最近的任務 (對應 GET /api/tasks)
+	ListTasks(ctx context.Context, in *ListTasksRequest, opts ...grpc.CallOption) (*ListTasksResponse, error)
+	// 雙向串流：client 送出訂閱變更，伺服器推播與 WebSocket 相同的事件
+	StreamUpdates(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[StreamRequest, TaskEvent], error)
+}
+
+type zImageClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewZImageClient(cc grpc.ClientConnInterface) ZImageClient {
+	return &zImageClient{cc}
+}
+
+func (c *zImageClient) CreateTask(ctx context.Context, in *CreateTaskRequest, opts ...grpc.CallOption) (*Task, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Task)
+	err := c.cc.Invoke(ctx, ZImage_CreateTask_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zImageClient) GetTask(ctx context.Context, in *GetTaskRequest, opts ...grpc.CallOption) (*Task, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Task)
+	err := c.cc.Invoke(ctx, ZImage_GetTask_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zImageClient) ListTasks(ctx context.Context, in *ListTasksRequest, opts ...grpc.CallOption) (*ListTasksResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListTasksResponse)
+	err := c.cc.Invoke(ctx, ZImage_ListTasks_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zImageClient) StreamUpdates(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[StreamRequest, TaskEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ZImage_ServiceDesc.Streams[0], ZImage_StreamUpdates_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamRequest, TaskEvent]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ZImage_StreamUpdatesClient = grpc.BidiStreamingClient[StreamRequest, TaskEvent]
+
+// ZImageServer is the server API for ZImage service.
+// All implementations must embed UnimplementedZImageServer
+// for forward compatibility.
+//
+// 驗證與 REST 相同：metadata 帶 authorization: Bearer <API key> 或 x-api-key: <API key>
+type ZImageServer interface {
+	// 建立任務 (對應 POST /api/tasks)
+	CreateTask(context.Context, *CreateTaskRequest) (*Task, error)
+	// 取得任務 (對應 GET /api/tasks/{id})，別人的任務回 NOT_FOUND
+	GetTask(context.Context, *GetTaskRequest) (*Task, error)
+	// 最近的任務 (對應 GET /api/tasks)
+	ListTasks(context.Context, *ListTasksRequest) (*ListTasksResponse, error)
+	// 雙向串流：client 送出訂閱變更，伺服器推播與 WebSocket 相同的事件
+	StreamUpdates(grpc.BidiStreamingServer[StreamRequest, TaskEvent]) error
+	mustEmbedUnimplementedZImageServer()
+}
+
+// UnimplementedZImageServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedZImageServer struct{}
+
+func (UnimplementedZImageServer) CreateTask(context.Context, *CreateTaskRequest) (*Task, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateTask not implemented")
+}
+func (UnimplementedZImageServer) GetTask(context.Context, *GetTaskRequest) (*Task, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTask not implemented")
+}
+func (UnimplementedZImageServer) ListTasks(context.Context, *ListTasksRequest) (*ListTasksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTasks not implemented")
+}
+func (UnimplementedZImageServer) StreamUpdates(grpc.BidiStreamingServer[StreamRequest, TaskEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamUpdates not implemented")
+}
+func (UnimplementedZImageServer) mustEmbedUnimplementedZImageServer() {}
+func (UnimplementedZImageServer) testEmbeddedByValue()                {}
+
+// UnsafeZImageServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ZImageServer will
+// result in compilation errors.
+type UnsafeZImageServer interface {
+	mustEmbedUnimplementedZImageServer()
+}
+
+func RegisterZImageServer(s grpc.ServiceRegistrar, srv ZImageServer) {
+	// If the following call pancis, it indicates UnimplementedZImageServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ZImage_ServiceDesc, srv)
+}
+
+func _ZImage_CreateTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateTaskRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZImageServer).CreateTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ZImage_CreateTask_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZImageServer).CreateTask(ctx, req.(*CreateTaskRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ZImage_GetTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTaskRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZImageServer).GetTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ZImage_GetTask_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZImageServer).GetTask(ctx, req.(*GetTaskRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ZImage_ListTasks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTasksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZImageServer).ListTasks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ZImage_ListTasks_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZImageServer).ListTasks(ctx, req.(*ListTasksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ZImage_StreamUpdates_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ZImageServer).StreamUpdates(&grpc.GenericServerStream[StreamRequest, TaskEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ZImage_StreamUpdatesServer = grpc.BidiStreamingServer[StreamRequest, TaskEvent]
+
+// ZImage_ServiceDesc is the grpc.ServiceDesc for ZImage service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ZImage_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "zimage.v1.ZImage",
+	HandlerType: (*ZImageServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateTask",
+			Handler:    _ZImage_CreateTask_Handler,
+		},
+		{
+			MethodName: "GetTask",
+			Handler:    _ZImage_GetTask_Handler,
+		},
+		{
+			MethodName: "ListTasks",
+			Handler:    _ZImage_ListTasks_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamUpdates",
+			Handler:       _ZImage_StreamUpdates_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "zimage.proto",
+}